@@ -2,22 +2,47 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
 	"github.com/pseudoerr/auth-service/config"
+	"github.com/pseudoerr/auth-service/internal/denylist"
+	"github.com/pseudoerr/auth-service/internal/events"
 	"github.com/pseudoerr/auth-service/internal/handlers"
+	"github.com/pseudoerr/auth-service/internal/hashing"
+	"github.com/pseudoerr/auth-service/internal/keys"
+	"github.com/pseudoerr/auth-service/internal/lockout"
+	"github.com/pseudoerr/auth-service/internal/mailer"
 	"github.com/pseudoerr/auth-service/internal/middleware"
+	"github.com/pseudoerr/auth-service/internal/missions"
+	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/pseudoerr/auth-service/internal/oidc"
+	"github.com/pseudoerr/auth-service/internal/passwordpolicy"
 	"github.com/pseudoerr/auth-service/internal/postgres"
 	"github.com/pseudoerr/auth-service/internal/repository"
+	"github.com/pseudoerr/auth-service/internal/secretbox"
 	"github.com/pseudoerr/auth-service/internal/service"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
 )
 
+// keyReloadInterval bounds how long a replica takes to publish a key that
+// was rotated elsewhere
+const keyReloadInterval = time.Minute
+
+// cleanupInterval is how often expired refresh tokens and denylist entries are purged
+const cleanupInterval = time.Hour
+
+// relayInterval is how often pending outbox events are published
+const relayInterval = 5 * time.Second
+
 func main() {
 	// Load configuration
 	cfg := config.Load()
@@ -28,6 +53,19 @@ func main() {
 	}))
 	slog.SetDefault(logger)
 
+	if err := cfg.Validate(); err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	if cfg.DevMode {
+		slog.Warn("DEV_MODE is on, unset secrets use insecure development values")
+	}
+
+	if cfg.JWTKeyOverlap <= cfg.JWTAccessTTL {
+		slog.Warn("JWT_KEY_OVERLAP should exceed JWT_ACCESS_TTL, tokens signed just before a rotation may be rejected",
+			"overlap", cfg.JWTKeyOverlap, "access_ttl", cfg.JWTAccessTTL)
+	}
+
 	// Connect to database
 	db, err := postgres.Connect(cfg.DatabaseURL)
 	if err != nil {
@@ -38,30 +76,310 @@ func main() {
 
 	// Initialize repositories
 	userRepo := repository.NewUserRepository(db)
-	tokenRepo := repository.NewTokenRepository(db)
+	tokenRepo := repository.NewTokenRepository(db, cfg.RefreshTokenPepper)
+	signingKeyRepo := repository.NewSigningKeyRepository(db)
+	actionTokenRepo := repository.NewActionTokenRepository(db, cfg.ActionTokenPepper)
+	mfaRepo := repository.NewMFARepository(db, cfg.ActionTokenPepper)
+	roleRepo := repository.NewRoleRepository(db)
+	outboxRepo := repository.NewOutboxRepository(db)
+	auditRepo := repository.NewAuditRepository(db)
+	authEventRepo := repository.NewAuthEventRepository(db, cfg.AuthEventRetention)
+	oauthClientRepo := repository.NewOAuthClientRepository(db, cfg.ActionTokenPepper)
+	oauthCodeRepo := repository.NewOAuthCodeRepository(db, cfg.ActionTokenPepper)
+	oauthTokenRepo := repository.NewOAuthTokenRepository(db, cfg.RefreshTokenPepper)
+	oauthDeviceRepo := repository.NewOAuthDeviceRepository(db, cfg.ActionTokenPepper)
+	patRepo := repository.NewPersonalAccessTokenRepository(db, cfg.ActionTokenPepper)
+	identityRepo := repository.NewIdentityRepository(db)
+	oidcStateRepo := repository.NewOIDCStateRepository(db, cfg.ActionTokenPepper)
+
+	if cfg.JWTKeyPublishDelay < handlers.JWKSMaxAge+keyReloadInterval {
+		slog.Warn("JWT_KEY_PUBLISH_DELAY should exceed the JWKS cache lifetime plus the key reload interval, "+
+			"tokens signed with a rotated key may be rejected by services with a cached key set",
+			"publish_delay", cfg.JWTKeyPublishDelay, "minimum", handlers.JWKSMaxAge+keyReloadInterval)
+	}
+
+	// Signing keys are encrypted at rest
+	signingKeySecrets, err := secretbox.New(cfg.SigningKeySecret)
+	if err != nil {
+		slog.Error("Failed to initialize signing key encryption", "error", err)
+		os.Exit(1)
+	}
+
+	// Load the signing keyring, seeding it from JWT_PRIVATE_KEY_FILE on first start
+	keyService := service.NewKeyService(signingKeyRepo, signingKeySecrets, cfg.JWTKeyOverlap, cfg.JWTKeyPublishDelay)
+	if err := keyService.Bootstrap(func() (*keys.SigningKey, error) {
+		return loadSigningKey(cfg.JWTPrivateKeyFile)
+	}); err != nil {
+		slog.Error("Failed to load JWT signing keys", "error", err)
+		os.Exit(1)
+	}
+
+	// Administrative subcommands run against the same database and exit
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:], keyService, userRepo, roleRepo, oauthClientRepo); err != nil {
+			slog.Error("Command failed", "command", os.Args[1], "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	go reloadKeysPeriodically(keyService, keyReloadInterval)
+
+	// Redis is shared by every store configured to use it
+	var redisClient *redis.Client
+	if cfg.DenylistBackend == "redis" || cfg.LockoutBackend == "redis" || cfg.EventsBackend == "redis" {
+		redisClient, err = newRedisClient(cfg.RedisURL)
+		if err != nil {
+			slog.Error("Failed to connect to redis", "error", err)
+			os.Exit(1)
+		}
+		defer redisClient.Close()
+	}
+
+	// Access token denylist
+	tokenDenylist, err := newDenylistStore(cfg.DenylistBackend, db, redisClient)
+	if err != nil {
+		slog.Error("Failed to initialize token denylist", "error", err)
+		os.Exit(1)
+	}
+
+	// Failed login tracking
+	lockoutStore, err := newLockoutStore(cfg.LockoutBackend, db, redisClient)
+	if err != nil {
+		slog.Error("Failed to initialize login lockout", "error", err)
+		os.Exit(1)
+	}
+	loginGuard := lockout.NewGuard(lockoutStore,
+		lockout.Policy{
+			FreeAttempts: cfg.LoginMaxAttempts,
+			BaseDelay:    cfg.LoginLockoutBase,
+			MaxDelay:     cfg.LoginLockoutMax,
+			Window:       cfg.LoginAttemptWindow,
+		},
+		lockout.Policy{
+			FreeAttempts: cfg.LoginMaxAttemptsIP,
+			BaseDelay:    cfg.LoginLockoutBase,
+			MaxDelay:     cfg.LoginLockoutMax,
+			Window:       cfg.LoginAttemptWindow,
+		})
+
+	go cleanupPeriodically(cleanupInterval, map[string]func() error{
+		"refresh tokens":  tokenRepo.CleanupExpired,
+		"action tokens":   actionTokenRepo.CleanupExpired,
+		"token denylist":  tokenDenylist.PurgeExpired,
+		"login attempts":  lockoutStore.PurgeExpired,
+		"outbox events":   outboxRepo.CleanupPublished,
+		"auth events":     authEventRepo.PurgeExpired,
+		"personal tokens": patRepo.CleanupExpired,
+		"oauth codes":     oauthCodeRepo.CleanupExpired,
+		"oauth tokens":    oauthTokenRepo.CleanupExpired,
+		"device codes":    oauthDeviceRepo.CleanupExpired,
+		"oidc states":     oidcStateRepo.CleanupExpired,
+	})
+
+	// Events such as user.deleted are relayed from the outbox to other services
+	eventPublisher, err := newEventPublisher(cfg, redisClient)
+	if err != nil {
+		slog.Error("Failed to initialize event publisher", "error", err)
+		os.Exit(1)
+	}
+	go relayEventsPeriodically(events.NewRelay(outboxRepo, eventPublisher), relayInterval)
+
+	// Outgoing email
+	mail, err := newMailer(cfg)
+	if err != nil {
+		slog.Error("Failed to initialize mailer", "error", err)
+		os.Exit(1)
+	}
+
+	// TOTP secrets are encrypted at rest
+	mfaSecrets, err := secretbox.New(cfg.MFAEncryptionKey)
+	if err != nil {
+		slog.Error("Failed to initialize MFA secret encryption", "error", err)
+		os.Exit(1)
+	}
+
+	// Password hashing; hashes of other algorithms or parameters are upgraded on login
+	argon2Params := hashing.DefaultArgon2Params
+	argon2Params.Memory = uint32(cfg.Argon2Memory)
+	argon2Params.Iterations = uint32(cfg.Argon2Iterations)
+	argon2Params.Parallelism = uint8(cfg.Argon2Parallelism)
+	passwordHasher, err := hashing.New(cfg.PasswordHashAlgo, cfg.BcryptCost, argon2Params)
+	if err != nil {
+		slog.Error("Failed to initialize password hashing", "error", err)
+		os.Exit(1)
+	}
+
+	// Password policy, optionally backed by an offline breached password corpus
+	var breachedPasswords passwordpolicy.BreachedList
+	if cfg.BreachedPasswords != "" {
+		breachedPasswords, err = passwordpolicy.NewPrefixDir(cfg.BreachedPasswords)
+		if err != nil {
+			slog.Error("Failed to load breached password list", "error", err)
+			os.Exit(1)
+		}
+	}
+	passwordPolicy := passwordpolicy.New(passwordpolicy.Policy{
+		MinLength:            cfg.PasswordMinLength,
+		MaxLength:            cfg.PasswordMaxLength,
+		RequireUpper:         cfg.PasswordUpper,
+		RequireLower:         cfg.PasswordLower,
+		RequireDigit:         cfg.PasswordDigit,
+		RequireSymbol:        cfg.PasswordSymbol,
+		DisallowPersonalInfo: cfg.PasswordNoPersonal,
+	}, breachedPasswords)
+
+	// External sign-in providers; their endpoints are discovered on first use
+	var oidcProviders []*oidc.Provider
+	for _, provider := range cfg.OIDCProviders {
+		if provider.Issuer == "" || provider.ClientID == "" {
+			slog.Error("OIDC provider is missing its issuer or client ID", "provider", provider.Name)
+			os.Exit(1)
+		}
+		oidcProviders = append(oidcProviders, oidc.NewProvider(oidc.Config{
+			Name:         provider.Name,
+			Issuer:       provider.Issuer,
+			ClientID:     provider.ClientID,
+			ClientSecret: provider.ClientSecret,
+			RedirectURL:  provider.RedirectURL,
+		}))
+	}
+
+	// Convert refresh tokens stored before hashing was introduced
+	rehashed, err := tokenRepo.RehashLegacyTokens(500)
+	if err != nil {
+		slog.Error("Failed to rehash legacy refresh tokens", "error", err)
+	} else if rehashed > 0 {
+		slog.Info("Rehashed legacy refresh tokens", "count", rehashed)
+	}
 
 	// Initialize services
-	authService := service.NewAuthService(userRepo, tokenRepo, cfg.JWTSecret, cfg.JWTAccessTTL, cfg.JWTRefreshTTL)
+	authService := service.NewAuthService(userRepo, tokenRepo, actionTokenRepo, mfaRepo, roleRepo, auditRepo, authEventRepo, oauthClientRepo,
+		oauthCodeRepo, oauthTokenRepo, oauthDeviceRepo, patRepo, identityRepo, oidcStateRepo, oidcProviders, mfaSecrets,
+		keyService.Keyring(), tokenDenylist, loginGuard, passwordHasher, passwordPolicy, mail,
+		missions.NewClient(cfg.MissionsURL, cfg.MissionsTimeout),
+		service.AuthSettings{
+			AccessTokenTTL:       cfg.JWTAccessTTL,
+			RefreshTokenTTL:      cfg.JWTRefreshTTL,
+			VerificationTokenTTL: cfg.VerificationTTL,
+			PasswordResetTTL:     cfg.PasswordResetTTL,
+			MagicLinkTTL:         cfg.MagicLinkTTL,
+			UnverifiedPolicy:     cfg.UnverifiedPolicy,
+			AppBaseURL:           cfg.AppBaseURL,
+			MFAIssuer:            cfg.MFAIssuer,
+			OIDCStateTTL:         cfg.OIDCStateTTL,
+			DeviceCodeTTL:        cfg.DeviceCodeTTL,
+			MissionsClientID:     cfg.MissionsClientID,
+		})
+
+	// Browsers may keep the refresh token in an HttpOnly cookie instead
+	cookieSameSite, err := parseSameSite(cfg.CookieSameSite)
+	if err != nil {
+		slog.Error("Invalid cookie configuration", "error", err)
+		os.Exit(1)
+	}
+	if cookieSameSite == http.SameSiteNoneMode && !cfg.CookieSecure {
+		slog.Warn("Browsers reject SameSite=None cookies without the Secure attribute, set COOKIE_SECURE=true")
+	}
 
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(authService)
+	authHandler := handlers.NewAuthHandler(authService, handlers.CookieSettings{
+		Domain:   cfg.CookieDomain,
+		Secure:   cfg.CookieSecure,
+		SameSite: cookieSameSite,
+		MaxAge:   cfg.JWTRefreshTTL,
+		CSRFKey:  []byte(cfg.CSRFKey),
+	})
+	jwksHandler := handlers.NewJWKSHandler(keyService.Keyring())
 
 	// Setup router with middleware
 	router := mux.NewRouter()
 	router.Use(middleware.LoggingMiddleware)
-	router.Use(middleware.CORSMiddleware)
+	router.Use(middleware.CORSMiddleware(cfg.AppBaseURL))
 	router.Use(middleware.PanicRecoveryMiddleware)
 
-	// Public routes
-	router.HandleFunc("/auth/register", authHandler.Register).Methods("POST")
-	router.HandleFunc("/auth/login", authHandler.Login).Methods("POST")
-	router.HandleFunc("/auth/refresh", authHandler.RefreshToken).Methods("POST")
+	// Public routes, rate limited per client IP
+	limit := middleware.RateLimitMiddleware(cfg.RateLimitRPS, cfg.RateLimitBurst)
+	router.Handle("/auth/register", limit(http.HandlerFunc(authHandler.Register))).Methods("POST")
+	router.Handle("/auth/login", limit(http.HandlerFunc(authHandler.Login))).Methods("POST")
+	router.Handle("/auth/refresh", limit(http.HandlerFunc(authHandler.RefreshToken))).Methods("POST")
+	router.Handle("/auth/verify-email", limit(http.HandlerFunc(authHandler.VerifyEmail))).Methods("POST")
+	router.Handle("/auth/resend-verification", limit(http.HandlerFunc(authHandler.ResendVerification))).Methods("POST")
+	router.Handle("/auth/password/forgot", limit(http.HandlerFunc(authHandler.ForgotPassword))).Methods("POST")
+	router.Handle("/auth/password/reset", limit(http.HandlerFunc(authHandler.ResetPassword))).Methods("POST")
+	router.Handle("/auth/magic-link", limit(http.HandlerFunc(authHandler.RequestMagicLink))).Methods("POST")
+	router.Handle("/auth/magic-link/consume", limit(http.HandlerFunc(authHandler.ConsumeMagicLink))).Methods("POST")
+	router.Handle("/auth/email/confirm", limit(http.HandlerFunc(authHandler.ConfirmEmailChange))).Methods("POST")
+	router.Handle("/auth/2fa/verify", limit(http.HandlerFunc(authHandler.VerifyMFA))).Methods("POST")
+	router.HandleFunc("/auth/oidc/providers", authHandler.ListOIDCProviders).Methods("GET")
+	router.Handle("/auth/oidc/{provider}/authorize", limit(http.HandlerFunc(authHandler.StartOIDCLogin))).Methods("POST")
+	router.Handle("/auth/oidc/{provider}/callback", limit(http.HandlerFunc(authHandler.CompleteOIDCLogin))).Methods("POST")
+	router.Handle("/oauth/authorize", limit(http.HandlerFunc(authHandler.Authorize))).Methods("GET")
+	router.HandleFunc("/.well-known/jwks.json", jwksHandler.GetJWKS).Methods("GET")
+
+	// Public clients send no secret, so the token endpoint is rate limited like a login.
+	// Introspection needs the client secret and is called per request by other services.
+	router.Handle("/oauth/token", limit(http.HandlerFunc(authHandler.Token))).Methods("POST")
+	router.HandleFunc("/oauth/introspect", authHandler.Introspect).Methods("POST")
+	router.Handle("/oauth/device/code", limit(http.HandlerFunc(authHandler.DeviceAuthorization))).Methods("POST")
 
-	// Protected routes
+	// Protected routes, open to access tokens, personal access tokens, service tokens and delegated tokens
 	protected := router.PathPrefix("/auth").Subrouter()
-	protected.Use(middleware.JWTMiddleware(cfg.JWTSecret))
-	protected.HandleFunc("/me", authHandler.GetProfile).Methods("GET")
-	protected.HandleFunc("/logout", authHandler.Logout).Methods("POST")
+	protected.Use(middleware.JWTMiddleware(keyService.Keyring(), tokenDenylist, userRepo, authService))
+	protected.Handle("/users/{id:[0-9]+}", middleware.RequirePermission(models.PermissionUsersRead)(
+		http.HandlerFunc(authHandler.LookupUser))).Methods("GET")
+
+	// Routes acting for a user, open to the tokens the user holds themselves
+	users := protected.NewRoute().Subrouter()
+	users.Use(middleware.RequireUserToken)
+	users.HandleFunc("/me", authHandler.GetProfile).Methods("GET")
+
+	// Account management needs a signed-in session, a personal access token is not enough
+	account := users.NewRoute().Subrouter()
+	account.Use(middleware.RequireSessionToken)
+	account.HandleFunc("/me", authHandler.UpdateProfile).Methods("PATCH")
+	account.Handle("/me", limit(http.HandlerFunc(authHandler.DeleteAccount))).Methods("DELETE")
+	account.HandleFunc("/me/export", authHandler.ExportAccount).Methods("GET")
+	account.HandleFunc("/me/activity", authHandler.ListActivity).Methods("GET")
+	account.Handle("/me/password", limit(http.HandlerFunc(authHandler.ChangePassword))).Methods("POST")
+	account.Handle("/me/password", limit(http.HandlerFunc(authHandler.RemovePassword))).Methods("DELETE")
+	account.Handle("/me/email", limit(http.HandlerFunc(authHandler.ChangeEmail))).Methods("POST")
+	account.HandleFunc("/logout", authHandler.Logout).Methods("POST")
+	account.HandleFunc("/sessions", authHandler.ListSessions).Methods("GET")
+	account.HandleFunc("/sessions/{id}", authHandler.RevokeSession).Methods("DELETE")
+	account.HandleFunc("/tokens", authHandler.CreatePersonalAccessToken).Methods("POST")
+	account.HandleFunc("/tokens", authHandler.ListPersonalAccessTokens).Methods("GET")
+	account.HandleFunc("/tokens/{id:[0-9]+}", authHandler.RevokePersonalAccessToken).Methods("DELETE")
+	account.HandleFunc("/oauth/authorize", authHandler.GetAuthorizationConsent).Methods("GET")
+	account.HandleFunc("/oauth/authorize", authHandler.DecideAuthorization).Methods("POST")
+	// User codes are short enough to guess, so entering them is rate limited
+	account.Handle("/oauth/device", limit(http.HandlerFunc(authHandler.GetDeviceConsent))).Methods("GET")
+	account.Handle("/oauth/device", limit(http.HandlerFunc(authHandler.DecideDeviceAuthorization))).Methods("POST")
+	account.HandleFunc("/oauth/grants", authHandler.ListOAuthGrants).Methods("GET")
+	account.HandleFunc("/oauth/grants/{id}", authHandler.RevokeOAuthGrant).Methods("DELETE")
+	account.HandleFunc("/me/identities", authHandler.ListIdentities).Methods("GET")
+	account.HandleFunc("/me/identities/{provider}/authorize", authHandler.StartIdentityLink).Methods("POST")
+	account.HandleFunc("/me/identities/{provider}", authHandler.LinkIdentity).Methods("POST")
+	account.HandleFunc("/me/identities/{id:[0-9]+}", authHandler.UnlinkIdentity).Methods("DELETE")
+	account.HandleFunc("/2fa/setup", authHandler.SetupMFA).Methods("POST")
+	account.HandleFunc("/2fa/confirm", authHandler.ConfirmMFA).Methods("POST")
+	account.Handle("/2fa/disable", limit(http.HandlerFunc(authHandler.DisableMFA))).Methods("POST")
+
+	// Admin routes, like account management only open to a signed-in session
+	admin := account.PathPrefix("/admin").Subrouter()
+	admin.Use(middleware.RequirePermission(models.PermissionUsersManage))
+	admin.HandleFunc("/roles", authHandler.ListRoles).Methods("GET")
+	admin.HandleFunc("/users", authHandler.SearchUsers).Methods("GET")
+	admin.HandleFunc("/users/{id:[0-9]+}", authHandler.GetUserDetails).Methods("GET")
+	admin.HandleFunc("/users/{id:[0-9]+}/disable", authHandler.DisableUser).Methods("POST")
+	admin.HandleFunc("/users/{id:[0-9]+}/enable", authHandler.EnableUser).Methods("POST")
+	admin.HandleFunc("/users/{id:[0-9]+}/logout", authHandler.ForceLogout).Methods("POST")
+	admin.HandleFunc("/users/{id:[0-9]+}/roles", authHandler.SetUserRoles).Methods("PUT")
+	admin.HandleFunc("/clients", authHandler.RegisterClient).Methods("POST")
+	admin.HandleFunc("/clients", authHandler.ListClients).Methods("GET")
+	admin.HandleFunc("/clients/{client_id}", authHandler.RevokeClient).Methods("DELETE")
+	admin.HandleFunc("/audit", authHandler.ListAuditLog).Methods("GET")
+	admin.HandleFunc("/events", authHandler.ListAuthEvents).Methods("GET")
 
 	// Health check
 	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -97,3 +415,180 @@ func main() {
 	}
 	slog.Info("Server exited")
 }
+
+// loadSigningKey reads the configured private key, falling back to a freshly
+// generated one when no file is configured
+func loadSigningKey(path string) (*keys.SigningKey, error) {
+	if path != "" {
+		return keys.LoadPrivateKey(path)
+	}
+
+	slog.Warn("JWT_PRIVATE_KEY_FILE is not set, generating an initial Ed25519 signing key")
+	return keys.GenerateEd25519()
+}
+
+func runCommand(args []string, keyService *service.KeyService, userRepo *repository.UserRepository,
+	roleRepo *repository.RoleRepository, oauthClientRepo *repository.OAuthClientRepository) error {
+	switch args[0] {
+	case "rotate-keys":
+		key, err := keyService.Rotate()
+		if err != nil {
+			return err
+		}
+		slog.Info("Signing key rotated", "kid", key.KeyID, "activates_at", key.ActivatesAt)
+		return nil
+	case "grant-role":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: grant-role <email> <role>")
+		}
+		user, err := userRepo.GetByEmail(args[1])
+		if err != nil {
+			return err
+		}
+		if err := roleRepo.AssignRole(user.ID, args[2]); err != nil {
+			return err
+		}
+		slog.Info("Role granted", "user_id", user.ID, "role", args[2])
+		return nil
+	case "create-client":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: create-client <client_id> <name> [scope ...]")
+		}
+		client := &models.OAuthClient{ClientID: args[1], Name: args[2], Scopes: args[3:]}
+		secret, err := service.NewClientSecret()
+		if err != nil {
+			return err
+		}
+		if err := oauthClientRepo.Create(client, secret); err != nil {
+			return err
+		}
+		// The secret is not stored in a recoverable form, so this is the only time it is shown
+		fmt.Printf("client_id=%s\nclient_secret=%s\n", client.ClientID, secret)
+		return nil
+	case "revoke-client":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: revoke-client <client_id>")
+		}
+		if err := oauthClientRepo.Revoke(args[1]); err != nil {
+			return err
+		}
+		slog.Info("Client revoked", "client_id", args[1])
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func reloadKeysPeriodically(keyService *service.KeyService, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := keyService.Reload(); err != nil {
+			slog.Error("Failed to reload signing keys", "error", err)
+		}
+	}
+}
+
+func newRedisClient(url string) (*redis.Client, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to ping redis: %w", err)
+	}
+	return client, nil
+}
+
+func newDenylistStore(backend string, db *sql.DB, redisClient *redis.Client) (denylist.Store, error) {
+	switch backend {
+	case "postgres":
+		return denylist.NewPostgresStore(db), nil
+	case "redis":
+		return denylist.NewRedisStore(redisClient), nil
+	default:
+		return nil, fmt.Errorf("unknown DENYLIST_BACKEND %q", backend)
+	}
+}
+
+func newLockoutStore(backend string, db *sql.DB, redisClient *redis.Client) (lockout.Store, error) {
+	switch backend {
+	case "postgres":
+		return lockout.NewPostgresStore(db), nil
+	case "redis":
+		return lockout.NewRedisStore(redisClient), nil
+	default:
+		return nil, fmt.Errorf("unknown LOCKOUT_BACKEND %q", backend)
+	}
+}
+
+func cleanupPeriodically(interval time.Duration, tasks map[string]func() error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for name, cleanup := range tasks {
+			if err := cleanup(); err != nil {
+				slog.Error("Periodic cleanup failed", "task", name, "error", err)
+			}
+		}
+	}
+}
+
+func newEventPublisher(cfg *config.Config, redisClient *redis.Client) (events.Publisher, error) {
+	switch cfg.EventsBackend {
+	case "redis":
+		return events.NewRedisPublisher(redisClient, cfg.EventsStream), nil
+	case "log":
+		return events.LogPublisher{}, nil
+	default:
+		return nil, fmt.Errorf("unknown EVENTS_BACKEND %q", cfg.EventsBackend)
+	}
+}
+
+func relayEventsPeriodically(relay *events.Relay, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := relay.PublishPending(); err != nil {
+			slog.Error("Failed to publish outbox events", "error", err)
+		}
+	}
+}
+
+func newMailer(cfg *config.Config) (mailer.Mailer, error) {
+	switch cfg.MailDriver {
+	case "smtp":
+		return mailer.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom), nil
+	case "log":
+		if cfg.MailLogFile == "" {
+			return mailer.NewLogMailer(os.Stdout), nil
+		}
+		file, err := os.OpenFile(cfg.MailLogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open MAIL_LOG_FILE: %w", err)
+		}
+		return mailer.NewLogMailer(file), nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_DRIVER %q", cfg.MailDriver)
+	}
+}
+
+func parseSameSite(mode string) (http.SameSite, error) {
+	switch strings.ToLower(mode) {
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("unknown COOKIE_SAMESITE %q", mode)
+	}
+}
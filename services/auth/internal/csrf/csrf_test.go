@@ -0,0 +1,40 @@
+package csrf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testKey = []byte("test-key")
+
+func TestTokenIsBoundToRefreshToken(t *testing.T) {
+	token := Token(testKey, "refresh-1")
+
+	assert.Equal(t, token, Token(testKey, "refresh-1"))
+	assert.NotEqual(t, token, Token(testKey, "refresh-2"))
+	assert.NotEqual(t, token, Token([]byte("other-key"), "refresh-1"))
+}
+
+func TestValid(t *testing.T) {
+	token := Token(testKey, "refresh-1")
+	assert.True(t, Valid(testKey, "refresh-1", token, token))
+
+	tests := []struct {
+		name         string
+		refreshToken string
+		cookie       string
+		header       string
+	}{
+		{"missing header", "refresh-1", token, ""},
+		{"missing cookie", "refresh-1", "", token},
+		{"header differs from cookie", "refresh-1", token, token + "x"},
+		{"token of another refresh token", "refresh-1", Token(testKey, "refresh-2"), Token(testKey, "refresh-2")},
+		{"missing refresh token", "", token, token},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.False(t, Valid(testKey, tt.refreshToken, tt.cookie, tt.header))
+		})
+	}
+}
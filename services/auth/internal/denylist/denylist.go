@@ -0,0 +1,38 @@
+package denylist
+
+import (
+	"time"
+)
+
+// Store tracks access tokens that were revoked before they expired. Entries
+// only need to outlive the tokens they refer to, so every write carries the
+// time after which it may be forgotten.
+type Store interface {
+	// RevokeToken denies the access token with the given jti
+	RevokeToken(jti string, expiresAt time.Time) error
+	// IsTokenRevoked reports whether the jti was revoked
+	IsTokenRevoked(jti string) (bool, error)
+	// RevokeUserTokens denies every access token of the user issued before issuedBefore
+	RevokeUserTokens(userID int, issuedBefore, expiresAt time.Time) error
+	// UserTokensRevokedBefore returns the user's cutoff, or the zero time if there is none
+	UserTokensRevokedBefore(userID int) (time.Time, error)
+	// PurgeExpired removes entries that no longer affect any valid token
+	PurgeExpired() error
+}
+
+// IsRevoked checks both the token itself and the user-wide cutoff. The
+// cutoff is compared at millisecond precision because that is what iat
+// carries, so only a token issued in the same millisecond as the cutoff
+// survives it.
+func IsRevoked(store Store, jti string, userID int, issuedAt time.Time) (bool, error) {
+	tokenRevoked, err := store.IsTokenRevoked(jti)
+	if err != nil || tokenRevoked {
+		return tokenRevoked, err
+	}
+
+	cutoff, err := store.UserTokensRevokedBefore(userID)
+	if err != nil {
+		return false, err
+	}
+	return issuedAt.Truncate(time.Millisecond).Before(cutoff.Truncate(time.Millisecond)), nil
+}
@@ -0,0 +1,41 @@
+package denylist
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsRevoked(t *testing.T) {
+	store, _ := newTestRedisStore(t)
+	now := time.Now()
+
+	require.NoError(t, store.RevokeToken("revoked", now.Add(time.Minute)))
+	require.NoError(t, store.RevokeUserTokens(7, now, now.Add(time.Minute)))
+
+	tests := []struct {
+		name     string
+		jti      string
+		userID   int
+		issuedAt time.Time
+		revoked  bool
+	}{
+		{"revoked token", "revoked", 1, now, true},
+		{"unrelated token", "other", 1, now.Add(-time.Hour), false},
+		{"issued before the user cutoff", "other", 7, now.Add(-time.Minute), true},
+		{"issued earlier in the cutoff's second", "other", 7, now.Add(-10 * time.Millisecond), true},
+		// iat has millisecond precision, so a token issued within the cutoff's millisecond survives
+		{"issued in the cutoff's millisecond", "other", 7, now.Truncate(time.Millisecond), false},
+		{"issued after the user cutoff", "other", 7, now.Add(time.Millisecond), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			revoked, err := IsRevoked(store, tt.jti, tt.userID, tt.issuedAt)
+			require.NoError(t, err)
+			assert.Equal(t, tt.revoked, revoked)
+		})
+	}
+}
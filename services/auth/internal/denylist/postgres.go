@@ -0,0 +1,79 @@
+package denylist
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) RevokeToken(jti string, expiresAt time.Time) error {
+	query := `
+		INSERT INTO revoked_access_tokens (jti, expires_at)
+		VALUES ($1, $2)
+		ON CONFLICT (jti) DO NOTHING`
+
+	if _, err := s.db.Exec(query, jti, expiresAt); err != nil {
+		return fmt.Errorf("failed to revoke access token: %w", err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) IsTokenRevoked(jti string) (bool, error) {
+	var revoked bool
+	query := `SELECT EXISTS(SELECT 1 FROM revoked_access_tokens WHERE jti = $1)`
+
+	if err := s.db.QueryRow(query, jti).Scan(&revoked); err != nil {
+		return false, fmt.Errorf("failed to check access token revocation: %w", err)
+	}
+
+	return revoked, nil
+}
+
+func (s *PostgresStore) RevokeUserTokens(userID int, issuedBefore, expiresAt time.Time) error {
+	query := `
+		INSERT INTO user_token_revocations (user_id, revoked_before, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET revoked_before = GREATEST(user_token_revocations.revoked_before, EXCLUDED.revoked_before),
+			expires_at = GREATEST(user_token_revocations.expires_at, EXCLUDED.expires_at)`
+
+	if _, err := s.db.Exec(query, userID, issuedBefore, expiresAt); err != nil {
+		return fmt.Errorf("failed to revoke user access tokens: %w", err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) UserTokensRevokedBefore(userID int) (time.Time, error) {
+	var revokedBefore time.Time
+	query := `SELECT revoked_before FROM user_token_revocations WHERE user_id = $1`
+
+	err := s.db.QueryRow(query, userID).Scan(&revokedBefore)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("failed to get user token revocation: %w", err)
+	}
+
+	return revokedBefore, nil
+}
+
+func (s *PostgresStore) PurgeExpired() error {
+	if _, err := s.db.Exec(`DELETE FROM revoked_access_tokens WHERE expires_at <= NOW()`); err != nil {
+		return fmt.Errorf("failed to purge revoked access tokens: %w", err)
+	}
+	if _, err := s.db.Exec(`DELETE FROM user_token_revocations WHERE expires_at <= NOW()`); err != nil {
+		return fmt.Errorf("failed to purge user token revocations: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package denylist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	tokenKeyPrefix = "denylist:jti:"
+	userKeyPrefix  = "denylist:user:"
+)
+
+// revokeUserTokensScript keeps the later of the stored and the new cutoff and
+// the longer of the two lifetimes, like the GREATEST of the Postgres store.
+// The cutoffs are compared as numbers but stored as given, since Lua numbers
+// can't hold nanoseconds exactly.
+var revokeUserTokensScript = redis.NewScript(`
+local cutoff = ARGV[1]
+local ttl = tonumber(ARGV[2])
+local current = redis.call("GET", KEYS[1])
+if current and tonumber(current) > tonumber(cutoff) then
+	cutoff = current
+end
+local remaining = redis.call("PTTL", KEYS[1])
+if remaining > ttl then
+	ttl = remaining
+end
+redis.call("SET", KEYS[1], cutoff, "PX", ttl)
+return 1
+`)
+
+// RedisStore keeps the denylist in Redis and lets key expiry do the cleanup
+type RedisStore struct {
+	client  *redis.Client
+	timeout time.Duration
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client, timeout: 2 * time.Second}
+}
+
+func (s *RedisStore) RevokeToken(jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	if err := s.client.Set(ctx, tokenKeyPrefix+jti, 1, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to revoke access token: %w", err)
+	}
+
+	return nil
+}
+
+func (s *RedisStore) IsTokenRevoked(jti string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	n, err := s.client.Exists(ctx, tokenKeyPrefix+jti).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check access token revocation: %w", err)
+	}
+
+	return n > 0, nil
+}
+
+func (s *RedisStore) RevokeUserTokens(userID int, issuedBefore, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl < time.Millisecond {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	key := userKeyPrefix + strconv.Itoa(userID)
+	err := revokeUserTokensScript.Run(ctx, s.client, []string{key}, issuedBefore.UnixNano(), ttl.Milliseconds()).Err()
+	if err != nil {
+		return fmt.Errorf("failed to revoke user access tokens: %w", err)
+	}
+
+	return nil
+}
+
+func (s *RedisStore) UserTokensRevokedBefore(userID int) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	value, err := s.client.Get(ctx, userKeyPrefix+strconv.Itoa(userID)).Int64()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("failed to get user token revocation: %w", err)
+	}
+
+	return time.Unix(0, value), nil
+}
+
+func (s *RedisStore) PurgeExpired() error {
+	return nil
+}
@@ -0,0 +1,120 @@
+package denylist
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRedisStore(t *testing.T) (*RedisStore, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewRedisStore(client), mr
+}
+
+func TestRedisStoreRevokeToken(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+
+	revoked, err := store.IsTokenRevoked("abc")
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	require.NoError(t, store.RevokeToken("abc", time.Now().Add(time.Minute)))
+
+	revoked, err = store.IsTokenRevoked("abc")
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	// The entry disappears once the token would have expired anyway
+	mr.FastForward(2 * time.Minute)
+	revoked, err = store.IsTokenRevoked("abc")
+	require.NoError(t, err)
+	assert.False(t, revoked)
+}
+
+func TestRedisStoreRevokeTokenAlreadyExpired(t *testing.T) {
+	store, _ := newTestRedisStore(t)
+
+	require.NoError(t, store.RevokeToken("old", time.Now().Add(-time.Minute)))
+
+	revoked, err := store.IsTokenRevoked("old")
+	require.NoError(t, err)
+	assert.False(t, revoked)
+}
+
+func TestRedisStoreRevokeUserTokens(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+
+	cutoff, err := store.UserTokensRevokedBefore(7)
+	require.NoError(t, err)
+	assert.True(t, cutoff.IsZero())
+
+	issuedBefore := time.Now()
+	require.NoError(t, store.RevokeUserTokens(7, issuedBefore, issuedBefore.Add(15*time.Minute)))
+
+	cutoff, err = store.UserTokensRevokedBefore(7)
+	require.NoError(t, err)
+	assert.True(t, cutoff.Equal(issuedBefore))
+
+	other, err := store.UserTokensRevokedBefore(8)
+	require.NoError(t, err)
+	assert.True(t, other.IsZero())
+
+	mr.FastForward(16 * time.Minute)
+	cutoff, err = store.UserTokensRevokedBefore(7)
+	require.NoError(t, err)
+	assert.True(t, cutoff.IsZero())
+}
+
+func TestRedisStoreRevokeUserTokensKeepsLatestCutoff(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		first       time.Time
+		firstTTL    time.Duration
+		second      time.Time
+		secondTTL   time.Duration
+		wantCutoff  time.Time
+		aliveAfter  time.Duration
+		expireAfter time.Duration
+	}{
+		{"later cutoff replaces", now, time.Minute, now.Add(time.Second), time.Minute,
+			now.Add(time.Second), 30 * time.Second, 2 * time.Minute},
+		{"earlier cutoff is ignored", now.Add(time.Second), time.Minute, now, time.Minute,
+			now.Add(time.Second), 30 * time.Second, 2 * time.Minute},
+		{"longer lifetime is kept", now.Add(time.Second), 10 * time.Minute, now, time.Minute,
+			now.Add(time.Second), 5 * time.Minute, 11 * time.Minute},
+		{"lifetime is extended", now, time.Minute, now.Add(time.Second), 10 * time.Minute,
+			now.Add(time.Second), 5 * time.Minute, 11 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, mr := newTestRedisStore(t)
+
+			require.NoError(t, store.RevokeUserTokens(7, tt.first, now.Add(tt.firstTTL)))
+			require.NoError(t, store.RevokeUserTokens(7, tt.second, now.Add(tt.secondTTL)))
+
+			cutoff, err := store.UserTokensRevokedBefore(7)
+			require.NoError(t, err)
+			assert.True(t, cutoff.Equal(tt.wantCutoff), "cutoff %s, want %s", cutoff, tt.wantCutoff)
+
+			mr.FastForward(tt.aliveAfter)
+			cutoff, err = store.UserTokensRevokedBefore(7)
+			require.NoError(t, err)
+			assert.False(t, cutoff.IsZero())
+
+			mr.FastForward(tt.expireAfter - tt.aliveAfter)
+			cutoff, err = store.UserTokensRevokedBefore(7)
+			require.NoError(t, err)
+			assert.True(t, cutoff.IsZero())
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+// Publisher delivers outbox events to other services
+type Publisher interface {
+	Publish(event models.OutboxEvent) error
+}
+
+// RedisPublisher appends events to a Redis stream. Consumers read it with a
+// consumer group and must tolerate an event being delivered more than once.
+type RedisPublisher struct {
+	client  *redis.Client
+	stream  string
+	timeout time.Duration
+}
+
+func NewRedisPublisher(client *redis.Client, stream string) *RedisPublisher {
+	return &RedisPublisher{client: client, stream: stream, timeout: 2 * time.Second}
+}
+
+func (p *RedisPublisher) Publish(event models.OutboxEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	err := p.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: p.stream,
+		Values: map[string]interface{}{
+			"event_id":   strconv.FormatInt(event.ID, 10),
+			"type":       event.Type,
+			"payload":    string(event.Payload),
+			"created_at": event.CreatedAt.UTC().Format(time.RFC3339),
+		},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("failed to publish event: %w", err)
+	}
+
+	return nil
+}
+
+// LogPublisher only logs events, for running without other services
+type LogPublisher struct{}
+
+func (LogPublisher) Publish(event models.OutboxEvent) error {
+	slog.Info("Event published to log", "event_id", event.ID, "type", event.Type, "payload", string(event.Payload))
+	return nil
+}
+
+// Outbox is the store events are relayed from
+type Outbox interface {
+	ListPending(limit int) ([]models.OutboxEvent, error)
+	MarkPublished(id int64) error
+}
+
+// Relay moves events from the outbox to a publisher
+type Relay struct {
+	outbox    Outbox
+	publisher Publisher
+	batchSize int
+}
+
+func NewRelay(outbox Outbox, publisher Publisher) *Relay {
+	return &Relay{outbox: outbox, publisher: publisher, batchSize: 100}
+}
+
+// PublishPending publishes pending events in the order they were written and
+// returns how many were published. It stops at the first failure so that a
+// later event never overtakes an earlier one; the failed event is retried on
+// the next call.
+func (r *Relay) PublishPending() (int, error) {
+	published := 0
+	for {
+		events, err := r.outbox.ListPending(r.batchSize)
+		if err != nil {
+			return published, err
+		}
+
+		for _, event := range events {
+			if err := r.publisher.Publish(event); err != nil {
+				return published, err
+			}
+			if err := r.outbox.MarkPublished(event.ID); err != nil {
+				return published, err
+			}
+			published++
+		}
+
+		if len(events) < r.batchSize {
+			return published, nil
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryOutbox struct {
+	events    []models.OutboxEvent
+	published map[int64]bool
+}
+
+func newMemoryOutbox(n int) *memoryOutbox {
+	outbox := &memoryOutbox{published: map[int64]bool{}}
+	for i := 1; i <= n; i++ {
+		outbox.events = append(outbox.events, models.OutboxEvent{
+			ID:        int64(i),
+			Type:      models.EventUserDeleted,
+			Payload:   json.RawMessage(`{"user_id":1}`),
+			CreatedAt: time.Now(),
+		})
+	}
+	return outbox
+}
+
+func (o *memoryOutbox) ListPending(limit int) ([]models.OutboxEvent, error) {
+	var pending []models.OutboxEvent
+	for _, event := range o.events {
+		if !o.published[event.ID] && len(pending) < limit {
+			pending = append(pending, event)
+		}
+	}
+	return pending, nil
+}
+
+func (o *memoryOutbox) MarkPublished(id int64) error {
+	o.published[id] = true
+	return nil
+}
+
+type recordingPublisher struct {
+	ids    []int64
+	failAt int64
+}
+
+func (p *recordingPublisher) Publish(event models.OutboxEvent) error {
+	if event.ID == p.failAt {
+		return errors.New("broker down")
+	}
+	p.ids = append(p.ids, event.ID)
+	return nil
+}
+
+func TestRelayPublishesInOrder(t *testing.T) {
+	outbox := newMemoryOutbox(5)
+	publisher := &recordingPublisher{}
+	relay := NewRelay(outbox, publisher)
+	relay.batchSize = 2
+
+	n, err := relay.PublishPending()
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, []int64{1, 2, 3, 4, 5}, publisher.ids)
+
+	n, err = relay.PublishPending()
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestRelayStopsAtFailure(t *testing.T) {
+	outbox := newMemoryOutbox(3)
+	publisher := &recordingPublisher{failAt: 2}
+	relay := NewRelay(outbox, publisher)
+
+	n, err := relay.PublishPending()
+	require.Error(t, err)
+	assert.Equal(t, 1, n)
+	assert.False(t, outbox.published[3], "later events must wait for the failed one")
+
+	// The failed event goes out first once the publisher recovers
+	publisher.failAt = 0
+	n, err = relay.PublishPending()
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []int64{1, 2, 3}, publisher.ids)
+}
+
+func TestRedisPublisher(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	publisher := NewRedisPublisher(client, "auth.events")
+	require.NoError(t, publisher.Publish(models.OutboxEvent{
+		ID:        7,
+		Type:      models.EventUserDeleted,
+		Payload:   json.RawMessage(`{"user_id":42}`),
+		CreatedAt: time.Now(),
+	}))
+
+	messages, err := client.XRange(context.Background(), "auth.events", "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "7", messages[0].Values["event_id"])
+	assert.Equal(t, models.EventUserDeleted, messages[0].Values["type"])
+	assert.JSONEq(t, `{"user_id":42}`, messages[0].Values["payload"].(string))
+}
@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/pseudoerr/auth-service/internal/lockout"
+	"github.com/pseudoerr/auth-service/internal/middleware"
+	"github.com/pseudoerr/auth-service/internal/missions"
+	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/pseudoerr/auth-service/internal/repository"
+	"github.com/pseudoerr/auth-service/internal/service"
+	"github.com/pseudoerr/auth-service/internal/validation"
+)
+
+func (h *AuthHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.UpdateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	// Validate request
+	if err := validation.ValidateStruct(&req); err != nil {
+		h.writeValidationError(w, err)
+		return
+	}
+
+	user, err := h.authService.UpdateUsername(userID, &req)
+	if errors.Is(err, repository.ErrUsernameTaken) {
+		h.writeError(w, http.StatusConflict, "Username already taken")
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to update profile", "error", err, "user_id", userID)
+		h.writeError(w, http.StatusInternalServerError, "Failed to update profile")
+		return
+	}
+
+	slog.Info("Profile updated", "user_id", userID)
+	h.writeJSON(w, http.StatusOK, user)
+}
+
+func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	// Validate request
+	if err := validation.ValidateStruct(&req); err != nil {
+		h.writeValidationError(w, err)
+		return
+	}
+
+	authResponse, err := h.authService.ChangePassword(userID, middleware.SessionID(r.Context()), &req, clientInfo(r))
+	var validationErr *validation.Error
+	var locked *lockout.LockedError
+	switch {
+	case errors.As(err, &locked):
+		h.writeLockedOut(w, locked)
+		return
+	case errors.Is(err, service.ErrInvalidPassword):
+		h.writeError(w, http.StatusUnauthorized, "Invalid password")
+		return
+	case errors.Is(err, service.ErrPasswordNotSet):
+		h.writeError(w, http.StatusConflict, "The account has no password, set one with a password reset")
+		return
+	case errors.As(err, &validationErr):
+		h.writeValidationError(w, err)
+		return
+	case err != nil:
+		slog.Error("Failed to change password", "error", err, "user_id", userID)
+		h.writeError(w, http.StatusInternalServerError, "Failed to change password")
+		return
+	}
+
+	slog.Info("Password changed", "user_id", userID)
+	h.writeJSON(w, http.StatusOK, authResponse)
+}
+
+// RemovePassword switches the account to magic link sign-in
+func (h *AuthHandler) RemovePassword(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.RemovePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	// Validate request
+	if err := validation.ValidateStruct(&req); err != nil {
+		h.writeValidationError(w, err)
+		return
+	}
+
+	authResponse, err := h.authService.RemovePassword(userID, middleware.SessionID(r.Context()), req.Password, clientInfo(r))
+	var locked *lockout.LockedError
+	switch {
+	case errors.As(err, &locked):
+		h.writeLockedOut(w, locked)
+		return
+	case errors.Is(err, service.ErrInvalidPassword):
+		h.writeError(w, http.StatusUnauthorized, "Invalid password")
+		return
+	case errors.Is(err, service.ErrPasswordNotSet):
+		h.writeError(w, http.StatusConflict, "The account has no password")
+		return
+	case err != nil:
+		slog.Error("Failed to remove password", "error", err, "user_id", userID)
+		h.writeError(w, http.StatusInternalServerError, "Failed to remove password")
+		return
+	}
+
+	slog.Info("Password removed", "user_id", userID)
+	h.writeJSON(w, http.StatusOK, authResponse)
+}
+
+func (h *AuthHandler) ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.ChangeEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	// Validate request
+	if err := validation.ValidateStruct(&req); err != nil {
+		h.writeValidationError(w, err)
+		return
+	}
+
+	err = h.authService.RequestEmailChange(userID, &req, clientInfo(r))
+	var locked *lockout.LockedError
+	switch {
+	case errors.As(err, &locked):
+		h.writeLockedOut(w, locked)
+		return
+	case errors.Is(err, service.ErrInvalidPassword):
+		h.writeError(w, http.StatusUnauthorized, "Invalid password")
+		return
+	case errors.Is(err, service.ErrPasswordNotSet):
+		h.writeError(w, http.StatusConflict, "The account has no password, set one with a password reset")
+		return
+	case errors.Is(err, service.ErrSameEmail):
+		h.writeError(w, http.StatusBadRequest, "New email is the current email")
+		return
+	case errors.Is(err, repository.ErrEmailTaken):
+		h.writeError(w, http.StatusConflict, "Email already registered")
+		return
+	case err != nil:
+		slog.Error("Failed to request email change", "error", err, "user_id", userID)
+		h.writeError(w, http.StatusInternalServerError, "Failed to request email change")
+		return
+	}
+
+	h.writeJSON(w, http.StatusAccepted, map[string]string{"message": "A confirmation link has been sent to the new address"})
+}
+
+func (h *AuthHandler) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	var req models.ConfirmEmailChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	// Validate request
+	if err := validation.ValidateStruct(&req); err != nil {
+		h.writeValidationError(w, err)
+		return
+	}
+
+	err := h.authService.ConfirmEmailChange(req.Token, clientInfo(r))
+	switch {
+	case errors.Is(err, repository.ErrActionTokenInvalid):
+		h.writeError(w, http.StatusBadRequest, "Invalid or expired confirmation token")
+		return
+	case errors.Is(err, repository.ErrEmailTaken):
+		h.writeError(w, http.StatusConflict, "Email already registered")
+		return
+	case err != nil:
+		slog.Error("Email change failed", "error", err)
+		h.writeError(w, http.StatusInternalServerError, "Email change failed")
+		return
+	}
+
+	slog.Info("Email changed successfully")
+	h.writeJSON(w, http.StatusOK, map[string]string{"message": "Email address has been changed"})
+}
+
+func (h *AuthHandler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.DeleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	// Validate request
+	if err := validation.ValidateStruct(&req); err != nil {
+		h.writeValidationError(w, err)
+		return
+	}
+
+	err = h.authService.DeleteAccount(userID, req.Password, clientInfo(r))
+	var locked *lockout.LockedError
+	switch {
+	case errors.As(err, &locked):
+		h.writeLockedOut(w, locked)
+		return
+	case errors.Is(err, service.ErrInvalidPassword):
+		h.writeError(w, http.StatusUnauthorized, "Invalid password")
+		return
+	case errors.Is(err, service.ErrPasswordNotSet):
+		h.writeError(w, http.StatusConflict, "The account has no password, set one with a password reset")
+		return
+	case err != nil:
+		slog.Error("Failed to delete account", "error", err, "user_id", userID)
+		h.writeError(w, http.StatusInternalServerError, "Failed to delete account")
+		return
+	}
+
+	slog.Info("Account deleted", "user_id", userID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AuthHandler) ExportAccount(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	export, err := h.authService.ExportAccount(userID)
+	if errors.Is(err, missions.ErrUnavailable) {
+		slog.Error("Failed to fetch missions data for export", "error", err, "user_id", userID)
+		h.writeError(w, http.StatusBadGateway, "Missions data is unavailable, try again later")
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to export account", "error", err, "user_id", userID)
+		h.writeError(w, http.StatusInternalServerError, "Failed to export account")
+		return
+	}
+
+	slog.Info("Account exported", "user_id", userID)
+	w.Header().Set("Content-Disposition", `attachment; filename="account-export.json"`)
+	h.writeJSON(w, http.StatusOK, export)
+}
+
+// ListActivity returns the security events of the current user, newest first
+func (h *AuthHandler) ListActivity(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	page, perPage := pagination(r)
+	activity, err := h.authService.ListActivity(userID, page, perPage)
+	if err != nil {
+		slog.Error("Failed to list activity", "error", err, "user_id", userID)
+		h.writeError(w, http.StatusInternalServerError, "Failed to list activity")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, activity)
+}
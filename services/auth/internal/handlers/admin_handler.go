@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/pseudoerr/auth-service/internal/repository"
+	"github.com/pseudoerr/auth-service/internal/service"
+	"github.com/pseudoerr/auth-service/internal/validation"
+)
+
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// ListRoles returns the roles users can be given and their permissions
+func (h *AuthHandler) ListRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := h.authService.ListRoles()
+	if err != nil {
+		slog.Error("Failed to list roles", "error", err)
+		h.writeError(w, http.StatusInternalServerError, "Failed to list roles")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, roles)
+}
+
+// SearchUsers lists users whose email or username contains the q parameter
+func (h *AuthHandler) SearchUsers(w http.ResponseWriter, r *http.Request) {
+	actor, err := adminActor(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	page, perPage := pagination(r)
+	users, err := h.authService.SearchUsers(actor, r.URL.Query().Get("q"), page, perPage)
+	if err != nil {
+		slog.Error("Failed to search users", "error", err)
+		h.writeError(w, http.StatusInternalServerError, "Failed to search users")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, users)
+}
+
+func (h *AuthHandler) GetUserDetails(w http.ResponseWriter, r *http.Request) {
+	actor, err := adminActor(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	userID, _ := strconv.Atoi(mux.Vars(r)["id"])
+	user, err := h.authService.GetUserDetails(actor, userID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		h.writeError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to get user", "error", err, "user_id", userID)
+		h.writeError(w, http.StatusInternalServerError, "Failed to get user")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, user)
+}
+
+func (h *AuthHandler) DisableUser(w http.ResponseWriter, r *http.Request) {
+	h.setUserDisabled(w, r, true)
+}
+
+func (h *AuthHandler) EnableUser(w http.ResponseWriter, r *http.Request) {
+	h.setUserDisabled(w, r, false)
+}
+
+func (h *AuthHandler) setUserDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	actor, err := adminActor(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	userID, _ := strconv.Atoi(mux.Vars(r)["id"])
+	err = h.authService.SetUserDisabled(actor, userID, disabled)
+	switch {
+	case errors.Is(err, repository.ErrUserNotFound):
+		h.writeError(w, http.StatusNotFound, "User not found")
+		return
+	case errors.Is(err, service.ErrSelfAdminAction):
+		h.writeError(w, http.StatusBadRequest, "You can't disable your own account")
+		return
+	case err != nil:
+		slog.Error("Failed to change account status", "error", err, "user_id", userID)
+		h.writeError(w, http.StatusInternalServerError, "Failed to change account status")
+		return
+	}
+
+	slog.Info("Account status changed by admin", "user_id", userID, "disabled", disabled, "admin_id", actor.UserID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ForceLogout ends every session of a user
+func (h *AuthHandler) ForceLogout(w http.ResponseWriter, r *http.Request) {
+	actor, err := adminActor(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	userID, _ := strconv.Atoi(mux.Vars(r)["id"])
+	err = h.authService.ForceLogout(actor, userID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		h.writeError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to log out user", "error", err, "user_id", userID)
+		h.writeError(w, http.StatusInternalServerError, "Failed to log out user")
+		return
+	}
+
+	slog.Info("User logged out by admin", "user_id", userID, "admin_id", actor.UserID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetUserRoles replaces the roles of a user
+func (h *AuthHandler) SetUserRoles(w http.ResponseWriter, r *http.Request) {
+	actor, err := adminActor(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.SetRolesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	// Validate request
+	if err := validation.ValidateStruct(&req); err != nil {
+		h.writeValidationError(w, err)
+		return
+	}
+
+	userID, _ := strconv.Atoi(mux.Vars(r)["id"])
+	user, err := h.authService.SetUserRoles(actor, userID, req.Roles)
+	switch {
+	case errors.Is(err, repository.ErrUserNotFound):
+		h.writeError(w, http.StatusNotFound, "User not found")
+		return
+	case errors.Is(err, repository.ErrRoleNotFound):
+		h.writeError(w, http.StatusBadRequest, "Unknown role")
+		return
+	case errors.Is(err, service.ErrSelfAdminAction):
+		h.writeError(w, http.StatusBadRequest, "You can't change your own roles")
+		return
+	case err != nil:
+		slog.Error("Failed to set roles", "error", err, "user_id", userID)
+		h.writeError(w, http.StatusInternalServerError, "Failed to set roles")
+		return
+	}
+
+	slog.Info("Roles changed by admin", "user_id", userID, "roles", user.Roles, "admin_id", actor.UserID)
+	h.writeJSON(w, http.StatusOK, user)
+}
+
+// ListAuditLog returns administrative actions, newest first, optionally
+// limited to actions on the user given by the user_id parameter
+func (h *AuthHandler) ListAuditLog(w http.ResponseWriter, r *http.Request) {
+	var targetUserID int
+	if raw := r.URL.Query().Get("user_id"); raw != "" {
+		id, err := strconv.Atoi(raw)
+		if err != nil || id <= 0 {
+			h.writeError(w, http.StatusBadRequest, "Invalid user_id")
+			return
+		}
+		targetUserID = id
+	}
+
+	page, perPage := pagination(r)
+	auditLog, err := h.authService.ListAuditLog(targetUserID, page, perPage)
+	if err != nil {
+		slog.Error("Failed to list audit log", "error", err)
+		h.writeError(w, http.StatusInternalServerError, "Failed to list audit log")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, auditLog)
+}
+
+// ListAuthEvents queries the security log. It filters by the user_id, type,
+// outcome and ip parameters and the RFC 3339 timestamps since and until.
+func (h *AuthHandler) ListAuthEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := models.AuthEventFilter{
+		Type:      query.Get("type"),
+		Outcome:   query.Get("outcome"),
+		IPAddress: query.Get("ip"),
+	}
+
+	if raw := query.Get("user_id"); raw != "" {
+		id, err := strconv.Atoi(raw)
+		if err != nil || id <= 0 {
+			h.writeError(w, http.StatusBadRequest, "Invalid user_id")
+			return
+		}
+		filter.UserID = id
+	}
+	for name, target := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if raw := query.Get(name); raw != "" {
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				h.writeError(w, http.StatusBadRequest, "Invalid "+name+", expected an RFC 3339 timestamp")
+				return
+			}
+			*target = t
+		}
+	}
+
+	page, perPage := pagination(r)
+	events, err := h.authService.ListAuthEvents(filter, page, perPage)
+	if err != nil {
+		slog.Error("Failed to list auth events", "error", err)
+		h.writeError(w, http.StatusInternalServerError, "Failed to list auth events")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, events)
+}
+
+// RegisterClient registers an OAuth client. The secret of a confidential
+// client is only part of this response.
+func (h *AuthHandler) RegisterClient(w http.ResponseWriter, r *http.Request) {
+	actor, err := adminActor(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.RegisterClientRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	// Validate request
+	if err := validation.ValidateStruct(&req); err != nil {
+		h.writeValidationError(w, err)
+		return
+	}
+
+	client, err := h.authService.RegisterClient(actor, &req)
+	var validationErr *validation.Error
+	switch {
+	case errors.As(err, &validationErr):
+		h.writeValidationError(w, err)
+		return
+	case errors.Is(err, repository.ErrClientIDTaken):
+		h.writeError(w, http.StatusConflict, "Client ID already exists")
+		return
+	case err != nil:
+		slog.Error("Failed to register client", "error", err, "client_id", req.ClientID)
+		h.writeError(w, http.StatusInternalServerError, "Failed to register client")
+		return
+	}
+
+	slog.Info("Client registered by admin", "client_id", client.ClientID, "admin_id", actor.UserID)
+	w.Header().Set("Cache-Control", "no-store")
+	h.writeJSON(w, http.StatusCreated, client)
+}
+
+func (h *AuthHandler) ListClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := h.authService.ListClients()
+	if err != nil {
+		slog.Error("Failed to list clients", "error", err)
+		h.writeError(w, http.StatusInternalServerError, "Failed to list clients")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, clients)
+}
+
+func (h *AuthHandler) RevokeClient(w http.ResponseWriter, r *http.Request) {
+	actor, err := adminActor(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	clientID := mux.Vars(r)["client_id"]
+	err = h.authService.RevokeClient(actor, clientID)
+	if errors.Is(err, repository.ErrInvalidClient) {
+		h.writeError(w, http.StatusNotFound, "Client not found")
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to revoke client", "error", err, "client_id", clientID)
+		h.writeError(w, http.StatusInternalServerError, "Failed to revoke client")
+		return
+	}
+
+	slog.Info("Client revoked by admin", "client_id", clientID, "admin_id", actor.UserID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func adminActor(r *http.Request) (service.Actor, error) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		return service.Actor{}, err
+	}
+	return service.Actor{UserID: userID, Client: clientInfo(r)}, nil
+}
+
+// pagination reads the page and per_page parameters, falling back to the
+// first page and the default size for missing or invalid values
+func pagination(r *http.Request) (int, int) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil || perPage < 1 {
+		perPage = defaultPerPage
+	}
+
+	return page, min(perPage, maxPerPage)
+}
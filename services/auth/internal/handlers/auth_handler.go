@@ -2,11 +2,20 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/gorilla/mux"
+	"github.com/pseudoerr/auth-service/internal/lockout"
+	"github.com/pseudoerr/auth-service/internal/middleware"
 	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/pseudoerr/auth-service/internal/redact"
+	"github.com/pseudoerr/auth-service/internal/repository"
 	"github.com/pseudoerr/auth-service/internal/service"
 	"github.com/pseudoerr/auth-service/internal/validation"
 
@@ -15,11 +24,13 @@ import (
 
 type AuthHandler struct {
 	authService *service.AuthService
+	cookies     CookieSettings
 }
 
-func NewAuthHandler(authService *service.AuthService) *AuthHandler {
+func NewAuthHandler(authService *service.AuthService, cookies CookieSettings) *AuthHandler {
 	return &AuthHandler{
 		authService: authService,
+		cookies:     cookies,
 	}
 }
 
@@ -32,20 +43,32 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 
 	// Validate request
 	if err := validation.ValidateStruct(&req); err != nil {
-		h.writeError(w, http.StatusBadRequest, err.Error())
+		h.writeValidationError(w, err)
 		return
 	}
 
 	// Register user
-	authResponse, err := h.authService.Register(&req)
-	if err != nil {
-		slog.Error("Registration failed", "error", err, "email", req.Email)
-		h.writeError(w, http.StatusBadRequest, err.Error())
+	authResponse, err := h.authService.Register(&req, clientInfo(r))
+	var validationErr *validation.Error
+	switch {
+	case errors.As(err, &validationErr):
+		// The password policy, including the breached password check
+		h.writeValidationError(w, err)
+		return
+	case errors.Is(err, repository.ErrEmailTaken):
+		h.writeError(w, http.StatusConflict, "Email already registered")
+		return
+	case errors.Is(err, repository.ErrUsernameTaken):
+		h.writeError(w, http.StatusConflict, "Username already taken")
+		return
+	case err != nil:
+		slog.Error("Registration failed", "error", err, "email", redact.Email(req.Email))
+		h.writeError(w, http.StatusInternalServerError, "Registration failed")
 		return
 	}
 
-	slog.Info("User registered successfully", "user_id", authResponse.User.ID, "email", authResponse.User.Email)
-	h.writeJSON(w, http.StatusCreated, authResponse)
+	slog.Info("User registered successfully", "user_id", authResponse.User.ID, "email", redact.Email(authResponse.User.Email))
+	h.writeAuthResponse(w, r, http.StatusCreated, authResponse)
 }
 
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
@@ -57,24 +80,94 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 
 	// Validate request
 	if err := validation.ValidateStruct(&req); err != nil {
-		h.writeError(w, http.StatusBadRequest, err.Error())
+		h.writeValidationError(w, err)
 		return
 	}
 
 	// Login user
-	authResponse, err := h.authService.Login(&req)
+	authResponse, challenge, err := h.authService.Login(&req, clientInfo(r))
+	var locked *lockout.LockedError
+	if errors.As(err, &locked) {
+		slog.Warn("Login locked out", "email", redact.Email(req.Email), "retry_after", locked.RetryAfter)
+		setRetryAfter(w, locked)
+		h.writeError(w, http.StatusUnauthorized, "Invalid credentials")
+		return
+	}
+	if errors.Is(err, service.ErrEmailNotVerified) {
+		h.writeError(w, http.StatusForbidden, "Email address is not verified")
+		return
+	}
+	if errors.Is(err, service.ErrAccountDisabled) {
+		h.writeError(w, http.StatusForbidden, "Account is disabled")
+		return
+	}
 	if err != nil {
-		slog.Error("Login failed", "error", err, "email", req.Email)
+		slog.Error("Login failed", "error", err, "email", redact.Email(req.Email))
 		h.writeError(w, http.StatusUnauthorized, "Invalid credentials")
 		return
 	}
 
-	slog.Info("User logged in successfully", "user_id", authResponse.User.ID, "email", authResponse.User.Email)
-	h.writeJSON(w, http.StatusOK, authResponse)
+	// The password was right but a second factor is still required
+	if challenge != nil {
+		h.writeJSON(w, http.StatusOK, challenge)
+		return
+	}
+
+	slog.Info("User logged in successfully", "user_id", authResponse.User.ID, "email", redact.Email(authResponse.User.Email))
+	h.writeAuthResponse(w, r, http.StatusOK, authResponse)
 }
 
+// RefreshToken rotates the refresh token from the body or, in cookie mode,
+// from the cookie, which also takes the double-submitted CSRF token
 func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 	var req models.RefreshRequest
+	if cookieMode(r) {
+		refreshToken, csrfValid := h.refreshTokenFromCookie(r)
+		if refreshToken == "" {
+			h.writeError(w, http.StatusUnauthorized, "Invalid refresh token")
+			return
+		}
+		if !csrfValid {
+			slog.Warn("Cookie refresh with missing or invalid CSRF token", "ip_address", clientInfo(r).IPAddress)
+			h.writeError(w, http.StatusForbidden, "Missing or invalid CSRF token")
+			return
+		}
+		req.RefreshToken = refreshToken
+	} else {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.writeError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+
+		// Validate request
+		if err := validation.ValidateStruct(&req); err != nil {
+			h.writeValidationError(w, err)
+			return
+		}
+	}
+
+	// Refresh token
+	authResponse, err := h.authService.RefreshToken(&req, clientInfo(r))
+	if err != nil && cookieMode(r) {
+		// The cookie is of no use any more, whatever went wrong
+		h.clearSessionCookies(w)
+	}
+	if errors.Is(err, service.ErrAccountDisabled) {
+		h.writeError(w, http.StatusForbidden, "Account is disabled")
+		return
+	}
+	if err != nil {
+		slog.Error("Token refresh failed", "error", err)
+		h.writeError(w, http.StatusUnauthorized, "Invalid refresh token")
+		return
+	}
+
+	slog.Info("Token refreshed successfully", "user_id", authResponse.User.ID)
+	h.writeAuthResponse(w, r, http.StatusOK, authResponse)
+}
+
+func (h *AuthHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req models.VerifyEmailRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		h.writeError(w, http.StatusBadRequest, "Invalid JSON")
 		return
@@ -82,20 +175,91 @@ func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 
 	// Validate request
 	if err := validation.ValidateStruct(&req); err != nil {
-		h.writeError(w, http.StatusBadRequest, err.Error())
+		h.writeValidationError(w, err)
 		return
 	}
 
-	// Refresh token
-	authResponse, err := h.authService.RefreshToken(&req)
-	if err != nil {
-		slog.Error("Token refresh failed", "error", err)
-		h.writeError(w, http.StatusUnauthorized, "Invalid refresh token")
+	if err := h.authService.VerifyEmail(req.Token); err != nil {
+		slog.Error("Email verification failed", "error", err)
+		h.writeError(w, http.StatusBadRequest, "Invalid or expired verification token")
 		return
 	}
 
-	slog.Info("Token refreshed successfully", "user_id", authResponse.User.ID)
-	h.writeJSON(w, http.StatusOK, authResponse)
+	h.writeJSON(w, http.StatusOK, map[string]string{"message": "Email verified successfully"})
+}
+
+func (h *AuthHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	var req models.ResendVerificationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	// Validate request
+	if err := validation.ValidateStruct(&req); err != nil {
+		h.writeValidationError(w, err)
+		return
+	}
+
+	// Same response whether or not the address is registered
+	if err := h.authService.ResendVerification(req.Email); err != nil {
+		slog.Error("Failed to resend verification email", "error", err)
+	}
+
+	h.writeJSON(w, http.StatusAccepted, map[string]string{"message": "If the address belongs to an unverified account, a new link has been sent"})
+}
+
+func (h *AuthHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req models.ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	// Validate request
+	if err := validation.ValidateStruct(&req); err != nil {
+		h.writeValidationError(w, err)
+		return
+	}
+
+	// Same response whether or not the address is registered
+	if err := h.authService.ForgotPassword(req.Email); err != nil {
+		slog.Error("Failed to send password reset email", "error", err)
+	}
+
+	h.writeJSON(w, http.StatusAccepted, map[string]string{"message": "If the address is registered, a reset link has been sent"})
+}
+
+func (h *AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req models.ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	// Validate request
+	if err := validation.ValidateStruct(&req); err != nil {
+		h.writeValidationError(w, err)
+		return
+	}
+
+	if err := h.authService.ResetPassword(&req, clientInfo(r)); err != nil {
+		if errors.Is(err, repository.ErrActionTokenInvalid) {
+			h.writeError(w, http.StatusBadRequest, "Invalid or expired reset token")
+			return
+		}
+		var validationErr *validation.Error
+		if errors.As(err, &validationErr) {
+			h.writeValidationError(w, err)
+			return
+		}
+		slog.Error("Password reset failed", "error", err)
+		h.writeError(w, http.StatusInternalServerError, "Password reset failed")
+		return
+	}
+
+	slog.Info("Password reset successfully")
+	h.writeJSON(w, http.StatusOK, map[string]string{"message": "Password has been reset"})
 }
 
 func (h *AuthHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
@@ -117,6 +281,24 @@ func (h *AuthHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
 	h.writeJSON(w, http.StatusOK, user)
 }
 
+// LookupUser returns the profile of any user, for services holding the
+// users:read scope
+func (h *AuthHandler) LookupUser(w http.ResponseWriter, r *http.Request) {
+	userID, _ := strconv.Atoi(mux.Vars(r)["id"])
+	user, err := h.authService.GetUserByID(userID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		h.writeError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to look up user", "error", err, "user_id", userID)
+		h.writeError(w, http.StatusInternalServerError, "Failed to look up user")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, user)
+}
+
 func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
 	// Get user ID from JWT middleware context
 	userID, err := getUserIDFromContext(r)
@@ -125,14 +307,31 @@ func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	tokenID, tokenExpiresAt, err := getAccessTokenFromContext(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
 	// Optional: get refresh token from request body to logout specific session
 	var req struct {
 		RefreshToken string `json:"refresh_token,omitempty"`
 	}
 	json.NewDecoder(r.Body).Decode(&req)
 
+	// In cookie mode the refresh token isn't sent here, the session of the access token ends instead
+	var sessionID string
+	if cookieMode(r) {
+		sessionID = middleware.SessionID(r.Context())
+		if sessionID == "" {
+			h.writeError(w, http.StatusBadRequest, "The access token belongs to no session")
+			return
+		}
+		h.clearSessionCookies(w)
+	}
+
 	// Logout user
-	if err := h.authService.Logout(userID, req.RefreshToken); err != nil {
+	if err := h.authService.Logout(userID, req.RefreshToken, sessionID, tokenID, tokenExpiresAt, clientInfo(r)); err != nil {
 		slog.Error("Logout failed", "error", err, "user_id", userID)
 		h.writeError(w, http.StatusInternalServerError, "Logout failed")
 		return
@@ -152,6 +351,21 @@ func (h *AuthHandler) writeError(w http.ResponseWriter, status int, message stri
 	h.writeJSON(w, status, map[string]string{"error": message})
 }
 
+// writeValidationError responds with 400, listing the offending fields if err
+// is a *validation.Error
+func (h *AuthHandler) writeValidationError(w http.ResponseWriter, err error) {
+	var validationErr *validation.Error
+	if !errors.As(err, &validationErr) {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	h.writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+		"error":  validationErr.Error(),
+		"fields": validationErr.Fields,
+	})
+}
+
 func getUserIDFromContext(r *http.Request) (int, error) {
 	userIDStr := r.Header.Get("X-User-ID")
 	if userIDStr == "" {
@@ -159,3 +373,42 @@ func getUserIDFromContext(r *http.Request) (int, error) {
 	}
 	return strconv.Atoi(userIDStr)
 }
+
+func getAccessTokenFromContext(r *http.Request) (string, time.Time, error) {
+	tokenID := r.Header.Get("X-Token-ID")
+	if tokenID == "" {
+		return "", time.Time{}, fmt.Errorf("token ID not found in context")
+	}
+	expiresAt, err := strconv.ParseInt(r.Header.Get("X-Token-Expires-At"), 10, 64)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("token expiry not found in context")
+	}
+	return tokenID, time.Unix(expiresAt, 0), nil
+}
+
+// setRetryAfter tells the client how long a lockout lasts
+func setRetryAfter(w http.ResponseWriter, locked *lockout.LockedError) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+}
+
+// writeLockedOut answers a password re-check while the account or IP is locked out
+func (h *AuthHandler) writeLockedOut(w http.ResponseWriter, locked *lockout.LockedError) {
+	slog.Warn("Password check locked out", "retry_after", locked.RetryAfter)
+	setRetryAfter(w, locked)
+	h.writeError(w, http.StatusTooManyRequests, "Too many failed attempts, try again later")
+}
+
+// clientInfo describes the device a request came from for session listings
+func clientInfo(r *http.Request) models.ClientInfo {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	userAgent := r.UserAgent()
+	if len(userAgent) > 512 {
+		userAgent = userAgent[:512]
+	}
+
+	return models.ClientInfo{UserAgent: userAgent, IPAddress: ip}
+}
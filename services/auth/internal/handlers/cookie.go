@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pseudoerr/auth-service/internal/csrf"
+	"github.com/pseudoerr/auth-service/internal/models"
+)
+
+const (
+	// tokenTransportHeader opts a request into the cookie mode with the value "cookie"
+	tokenTransportHeader = "X-Token-Transport"
+	// csrfTokenHeader carries the double-submitted CSRF token
+	csrfTokenHeader = "X-CSRF-Token"
+
+	refreshTokenCookie = "refresh_token"
+	csrfTokenCookie    = "csrf_token"
+	// refreshCookiePath keeps the refresh token cookie away from every
+	// endpoint but the one that needs it
+	refreshCookiePath = "/auth/refresh"
+
+	// oidcBindingCookie holds the secret binding a sign-in with an identity
+	// provider to the browser that started it
+	oidcBindingCookie = "oidc_binding"
+	oidcBindingPath   = "/auth"
+)
+
+// CookieSettings configure the cookie mode, in which browsers keep the
+// refresh token in an HttpOnly cookie out of reach of scripts
+type CookieSettings struct {
+	Domain   string
+	Secure   bool
+	SameSite http.SameSite
+	// MaxAge is how long the browser keeps the cookies, the refresh token TTL
+	MaxAge time.Duration
+	// CSRFKey derives the CSRF tokens issued with refresh tokens
+	CSRFKey []byte
+}
+
+// cookieMode reports whether the client asked for the refresh token in a cookie
+func cookieMode(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get(tokenTransportHeader), "cookie")
+}
+
+// writeAuthResponse responds with issued tokens. In cookie mode the refresh
+// token is moved from the body into an HttpOnly cookie, and the CSRF token
+// to send along with it is set as a cookie the frontend can read and
+// returned in the body.
+func (h *AuthHandler) writeAuthResponse(w http.ResponseWriter, r *http.Request, status int, authResponse *models.AuthResponse) {
+	if cookieMode(r) && authResponse.RefreshToken != "" {
+		csrfToken := csrf.Token(h.cookies.CSRFKey, authResponse.RefreshToken)
+		h.setCookie(w, refreshTokenCookie, authResponse.RefreshToken, refreshCookiePath, true, h.cookies.MaxAge)
+		h.setCookie(w, csrfTokenCookie, csrfToken, "/", false, h.cookies.MaxAge)
+
+		response := *authResponse
+		response.RefreshToken = ""
+		response.CSRFToken = csrfToken
+		authResponse = &response
+	}
+
+	h.writeJSON(w, status, authResponse)
+}
+
+// clearSessionCookies makes the browser forget the cookies of the cookie mode
+func (h *AuthHandler) clearSessionCookies(w http.ResponseWriter) {
+	h.setCookie(w, refreshTokenCookie, "", refreshCookiePath, true, -time.Second)
+	h.setCookie(w, csrfTokenCookie, "", "/", false, -time.Second)
+}
+
+func (h *AuthHandler) setCookie(w http.ResponseWriter, name, value, path string, httpOnly bool, maxAge time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   h.cookies.Domain,
+		MaxAge:   int(maxAge.Seconds()),
+		Secure:   h.cookies.Secure,
+		HttpOnly: httpOnly,
+		SameSite: h.cookies.SameSite,
+	})
+}
+
+// setOIDCBinding hands the browser starting a sign-in with a provider its
+// binding. The cookie is Lax at the least, so it comes along when the
+// provider sends the user back.
+func (h *AuthHandler) setOIDCBinding(w http.ResponseWriter, binding string) {
+	sameSite := http.SameSiteLaxMode
+	if h.cookies.SameSite == http.SameSiteNoneMode {
+		sameSite = http.SameSiteNoneMode
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcBindingCookie,
+		Value:    binding,
+		Path:     oidcBindingPath,
+		Domain:   h.cookies.Domain,
+		Secure:   h.cookies.Secure,
+		HttpOnly: true,
+		SameSite: sameSite,
+	})
+}
+
+// oidcBinding returns the binding the browser was handed and makes it forget
+// it, as a state can only be redeemed once
+func (h *AuthHandler) oidcBinding(w http.ResponseWriter, r *http.Request) string {
+	cookie, err := r.Cookie(oidcBindingCookie)
+	if err != nil {
+		return ""
+	}
+	h.setCookie(w, oidcBindingCookie, "", oidcBindingPath, true, -time.Second)
+	return cookie.Value
+}
+
+// refreshTokenFromCookie returns the refresh token cookie and whether the
+// request double-submitted the CSRF token issued with it. The browser sends
+// the cookie on its own, so only the token proves the frontend made the
+// request.
+func (h *AuthHandler) refreshTokenFromCookie(r *http.Request) (string, bool) {
+	refreshToken, err := r.Cookie(refreshTokenCookie)
+	if err != nil {
+		return "", false
+	}
+
+	var csrfCookie string
+	if cookie, err := r.Cookie(csrfTokenCookie); err == nil {
+		csrfCookie = cookie.Value
+	}
+
+	return refreshToken.Value, csrf.Valid(h.cookies.CSRFKey, refreshToken.Value, csrfCookie, r.Header.Get(csrfTokenHeader))
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pseudoerr/auth-service/internal/csrf"
+	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testCSRFKey = []byte("test-csrf-key")
+
+// newCookieTestHandler returns a handler without a service, for requests
+// that must be answered before the service is reached
+func newCookieTestHandler() *AuthHandler {
+	return NewAuthHandler(nil, CookieSettings{
+		Domain:   "example.com",
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   24 * time.Hour,
+		CSRFKey:  testCSRFKey,
+	})
+}
+
+func responseCookies(rec *httptest.ResponseRecorder) map[string]*http.Cookie {
+	cookies := make(map[string]*http.Cookie)
+	for _, cookie := range rec.Result().Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+	return cookies
+}
+
+func TestWriteAuthResponse(t *testing.T) {
+	tests := []struct {
+		name      string
+		transport string
+		cookies   bool
+	}{
+		{"bearer mode", "", false},
+		{"cookie mode", "cookie", true},
+		{"cookie mode is case insensitive", "Cookie", true},
+		{"unknown transport", "header", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newCookieTestHandler()
+			req := httptest.NewRequest(http.MethodPost, "/auth/login", nil)
+			if tt.transport != "" {
+				req.Header.Set(tokenTransportHeader, tt.transport)
+			}
+			rec := httptest.NewRecorder()
+
+			h.writeAuthResponse(rec, req, http.StatusOK, &models.AuthResponse{
+				AccessToken:  "access",
+				RefreshToken: "refresh",
+				User:         models.User{ID: 7},
+			})
+
+			require.Equal(t, http.StatusOK, rec.Code)
+			var body models.AuthResponse
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+			assert.Equal(t, "access", body.AccessToken)
+			cookies := responseCookies(rec)
+
+			if !tt.cookies {
+				assert.Equal(t, "refresh", body.RefreshToken)
+				assert.Empty(t, body.CSRFToken)
+				assert.Empty(t, cookies)
+				return
+			}
+
+			assert.Empty(t, body.RefreshToken, "the refresh token must stay out of reach of scripts")
+			assert.Equal(t, csrf.Token(testCSRFKey, "refresh"), body.CSRFToken)
+
+			refresh := cookies[refreshTokenCookie]
+			require.NotNil(t, refresh)
+			assert.Equal(t, "refresh", refresh.Value)
+			assert.Equal(t, refreshCookiePath, refresh.Path)
+			assert.True(t, refresh.HttpOnly)
+			assert.True(t, refresh.Secure)
+			assert.Equal(t, http.SameSiteLaxMode, refresh.SameSite)
+			assert.Equal(t, "example.com", refresh.Domain)
+			assert.Equal(t, int((24 * time.Hour).Seconds()), refresh.MaxAge)
+
+			token := cookies[csrfTokenCookie]
+			require.NotNil(t, token)
+			assert.Equal(t, body.CSRFToken, token.Value)
+			assert.Equal(t, "/", token.Path)
+			assert.False(t, token.HttpOnly, "the frontend must be able to read the CSRF token")
+		})
+	}
+}
+
+func TestClearSessionCookies(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newCookieTestHandler().clearSessionCookies(rec)
+
+	cookies := responseCookies(rec)
+	for name, path := range map[string]string{refreshTokenCookie: refreshCookiePath, csrfTokenCookie: "/"} {
+		cookie := cookies[name]
+		require.NotNil(t, cookie, name)
+		assert.Empty(t, cookie.Value)
+		assert.Equal(t, path, cookie.Path)
+		assert.Negative(t, cookie.MaxAge)
+	}
+}
+
+func TestRefreshTokenFromCookie(t *testing.T) {
+	valid := csrf.Token(testCSRFKey, "refresh")
+
+	tests := []struct {
+		name         string
+		refresh      string
+		csrfCookie   string
+		csrfHeader   string
+		refreshToken string
+		csrfValid    bool
+	}{
+		{"valid", "refresh", valid, valid, "refresh", true},
+		{"no refresh cookie", "", valid, valid, "", false},
+		{"no CSRF header", "refresh", valid, "", "refresh", false},
+		{"no CSRF cookie", "refresh", "", valid, "refresh", false},
+		{"header differs from cookie", "refresh", valid, csrf.Token(testCSRFKey, "other"), "refresh", false},
+		{"token of another refresh token", "refresh", csrf.Token(testCSRFKey, "other"),
+			csrf.Token(testCSRFKey, "other"), "refresh", false},
+		{"token of another key", "refresh", csrf.Token([]byte("other-key"), "refresh"),
+			csrf.Token([]byte("other-key"), "refresh"), "refresh", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/auth/refresh", nil)
+			if tt.refresh != "" {
+				req.AddCookie(&http.Cookie{Name: refreshTokenCookie, Value: tt.refresh})
+			}
+			if tt.csrfCookie != "" {
+				req.AddCookie(&http.Cookie{Name: csrfTokenCookie, Value: tt.csrfCookie})
+			}
+			if tt.csrfHeader != "" {
+				req.Header.Set(csrfTokenHeader, tt.csrfHeader)
+			}
+
+			refreshToken, csrfValid := newCookieTestHandler().refreshTokenFromCookie(req)
+			assert.Equal(t, tt.refreshToken, refreshToken)
+			assert.Equal(t, tt.csrfValid, csrfValid)
+		})
+	}
+}
+
+// The handler has no service, so these requests must be answered before the
+// refresh token is used
+func TestRefreshTokenCookieModeRejectsRequests(t *testing.T) {
+	tests := []struct {
+		name       string
+		refresh    string
+		csrfHeader string
+		status     int
+	}{
+		{"no refresh cookie", "", csrf.Token(testCSRFKey, "refresh"), http.StatusUnauthorized},
+		{"no CSRF token", "refresh", "", http.StatusForbidden},
+		{"wrong CSRF token", "refresh", csrf.Token(testCSRFKey, "other"), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/auth/refresh", nil)
+			req.Header.Set(tokenTransportHeader, "cookie")
+			if tt.refresh != "" {
+				req.AddCookie(&http.Cookie{Name: refreshTokenCookie, Value: tt.refresh})
+			}
+			if tt.csrfHeader != "" {
+				req.AddCookie(&http.Cookie{Name: csrfTokenCookie, Value: tt.csrfHeader})
+				req.Header.Set(csrfTokenHeader, tt.csrfHeader)
+			}
+			rec := httptest.NewRecorder()
+
+			newCookieTestHandler().RefreshToken(rec, req)
+			assert.Equal(t, tt.status, rec.Code)
+		})
+	}
+}
+
+func TestLogoutCookieModeRequiresSession(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/auth/logout", nil)
+	req.Header.Set(tokenTransportHeader, "cookie")
+	req.Header.Set("X-User-ID", "7")
+	req.Header.Set("X-Token-ID", "jti-1")
+	req.Header.Set("X-Token-Expires-At", strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10))
+	// A session header set by the client is not the session of the token
+	req.Header.Set("X-Session-ID", "session-1")
+	rec := httptest.NewRecorder()
+
+	newCookieTestHandler().Logout(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Empty(t, responseCookies(rec), "cookies are only cleared once the session ends")
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pseudoerr/auth-service/internal/keys"
+)
+
+// JWKSMaxAge is how long verifiers may cache the key set
+const JWKSMaxAge = 5 * time.Minute
+
+// JWKSHandler publishes the public keys downstream services use to verify
+// access tokens
+type JWKSHandler struct {
+	keyring *keys.Keyring
+}
+
+func NewJWKSHandler(keyring *keys.Keyring) *JWKSHandler {
+	return &JWKSHandler{
+		keyring: keyring,
+	}
+}
+
+func (h *JWKSHandler) GetJWKS(w http.ResponseWriter, r *http.Request) {
+	jwks := h.keyring.JWKS()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(JWKSMaxAge.Seconds())))
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(jwks)
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/pseudoerr/auth-service/internal/redact"
+	"github.com/pseudoerr/auth-service/internal/repository"
+	"github.com/pseudoerr/auth-service/internal/service"
+	"github.com/pseudoerr/auth-service/internal/validation"
+)
+
+func (h *AuthHandler) RequestMagicLink(w http.ResponseWriter, r *http.Request) {
+	var req models.MagicLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	// Validate request
+	if err := validation.ValidateStruct(&req); err != nil {
+		h.writeValidationError(w, err)
+		return
+	}
+
+	// Same response whether or not the address is registered
+	if err := h.authService.RequestMagicLink(req.Email, clientInfo(r)); err != nil {
+		slog.Error("Failed to send magic link", "error", err, "email", redact.Email(req.Email))
+	}
+
+	h.writeJSON(w, http.StatusAccepted, map[string]string{"message": "If the address is registered, a sign-in link has been sent"})
+}
+
+func (h *AuthHandler) ConsumeMagicLink(w http.ResponseWriter, r *http.Request) {
+	var req models.ConsumeMagicLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	// Validate request
+	if err := validation.ValidateStruct(&req); err != nil {
+		h.writeValidationError(w, err)
+		return
+	}
+
+	authResponse, challenge, err := h.authService.ConsumeMagicLink(req.Token, clientInfo(r))
+	switch {
+	case errors.Is(err, repository.ErrActionTokenInvalid), errors.Is(err, repository.ErrUserNotFound):
+		h.writeError(w, http.StatusBadRequest, "Invalid or expired sign-in link")
+		return
+	case errors.Is(err, service.ErrAccountDisabled):
+		h.writeError(w, http.StatusForbidden, "Account is disabled")
+		return
+	case err != nil:
+		slog.Error("Magic link sign-in failed", "error", err)
+		h.writeError(w, http.StatusInternalServerError, "Sign-in failed")
+		return
+	}
+
+	// The link was valid but a second factor is still required
+	if challenge != nil {
+		h.writeJSON(w, http.StatusOK, challenge)
+		return
+	}
+
+	slog.Info("User logged in with magic link", "user_id", authResponse.User.ID)
+	h.writeAuthResponse(w, r, http.StatusOK, authResponse)
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/pseudoerr/auth-service/internal/lockout"
+	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/pseudoerr/auth-service/internal/redact"
+	"github.com/pseudoerr/auth-service/internal/repository"
+	"github.com/pseudoerr/auth-service/internal/service"
+	"github.com/pseudoerr/auth-service/internal/validation"
+)
+
+// VerifyMFA exchanges a login challenge and a second factor for tokens
+func (h *AuthHandler) VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	var req models.MFAVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	// Validate request
+	if err := validation.ValidateStruct(&req); err != nil {
+		h.writeValidationError(w, err)
+		return
+	}
+
+	authResponse, err := h.authService.VerifyMFA(&req, clientInfo(r))
+	var locked *lockout.LockedError
+	if errors.As(err, &locked) {
+		slog.Warn("Two-factor verification locked out", "retry_after", locked.RetryAfter)
+		setRetryAfter(w, locked)
+		h.writeError(w, http.StatusUnauthorized, "Invalid or expired two-factor challenge")
+		return
+	}
+	if errors.Is(err, service.ErrAccountDisabled) {
+		h.writeError(w, http.StatusForbidden, "Account is disabled")
+		return
+	}
+	if err != nil {
+		slog.Error("Two-factor verification failed", "error", err)
+		h.writeError(w, http.StatusUnauthorized, "Invalid or expired two-factor challenge")
+		return
+	}
+
+	slog.Info("User logged in successfully", "user_id", authResponse.User.ID, "email", redact.Email(authResponse.User.Email), "mfa", true)
+	h.writeAuthResponse(w, r, http.StatusOK, authResponse)
+}
+
+func (h *AuthHandler) SetupMFA(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	setup, err := h.authService.SetupMFA(userID)
+	if errors.Is(err, service.ErrMFAAlreadyEnabled) {
+		h.writeError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to set up two-factor authentication", "error", err, "user_id", userID)
+		h.writeError(w, http.StatusInternalServerError, "Failed to set up two-factor authentication")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, setup)
+}
+
+func (h *AuthHandler) ConfirmMFA(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.MFAConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	// Validate request
+	if err := validation.ValidateStruct(&req); err != nil {
+		h.writeValidationError(w, err)
+		return
+	}
+
+	recoveryCodes, err := h.authService.ConfirmMFA(userID, req.Code)
+	switch {
+	case errors.Is(err, service.ErrMFAInvalidCode):
+		h.writeError(w, http.StatusBadRequest, "Invalid code")
+		return
+	case errors.Is(err, repository.ErrMFANotFound):
+		h.writeError(w, http.StatusBadRequest, "Two-factor authentication setup has not been started")
+		return
+	case errors.Is(err, service.ErrMFAAlreadyEnabled):
+		h.writeError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	case err != nil:
+		slog.Error("Failed to confirm two-factor authentication", "error", err, "user_id", userID)
+		h.writeError(w, http.StatusInternalServerError, "Failed to confirm two-factor authentication")
+		return
+	}
+
+	slog.Info("Two-factor authentication enabled", "user_id", userID)
+	h.writeJSON(w, http.StatusOK, models.MFAConfirmResponse{RecoveryCodes: recoveryCodes})
+}
+
+func (h *AuthHandler) DisableMFA(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.MFADisableRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	// Validate request
+	if err := validation.ValidateStruct(&req); err != nil {
+		h.writeValidationError(w, err)
+		return
+	}
+
+	err = h.authService.DisableMFA(userID, req.Password, clientInfo(r))
+	var locked *lockout.LockedError
+	switch {
+	case errors.As(err, &locked):
+		h.writeLockedOut(w, locked)
+		return
+	case errors.Is(err, service.ErrInvalidPassword):
+		h.writeError(w, http.StatusUnauthorized, "Invalid password")
+		return
+	case errors.Is(err, service.ErrPasswordNotSet):
+		h.writeError(w, http.StatusConflict, "The account has no password, set one with a password reset")
+		return
+	case errors.Is(err, repository.ErrMFANotFound):
+		h.writeError(w, http.StatusBadRequest, "Two-factor authentication is not enabled")
+		return
+	case err != nil:
+		slog.Error("Failed to disable two-factor authentication", "error", err, "user_id", userID)
+		h.writeError(w, http.StatusInternalServerError, "Failed to disable two-factor authentication")
+		return
+	}
+
+	slog.Info("Two-factor authentication disabled", "user_id", userID)
+	h.writeJSON(w, http.StatusOK, map[string]string{"message": "Two-factor authentication disabled"})
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/pseudoerr/auth-service/internal/oauth"
+	"github.com/pseudoerr/auth-service/internal/repository"
+	"github.com/pseudoerr/auth-service/internal/service"
+)
+
+// Authorize is the OAuth 2.0 authorization endpoint. A valid request is
+// redirected to the consent screen of the frontend, which signs the user in
+// if needed. Errors about the request are redirected back to the client,
+// except for an unknown client or redirect URI, which can't be trusted with
+// a redirect.
+func (h *AuthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
+	req := oauth.ParseAuthorizeRequest(r.URL.Query())
+
+	consentURL, err := h.authService.StartAuthorization(req)
+	if h.writeAuthorizeError(w, r, req, err) {
+		return
+	}
+
+	http.Redirect(w, r, consentURL, http.StatusFound)
+}
+
+// GetAuthorizationConsent describes an authorization request to the consent
+// screen. The query carries the parameters of the request.
+func (h *AuthHandler) GetAuthorizationConsent(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	req := oauth.ParseAuthorizeRequest(r.URL.Query())
+	consent, err := h.authService.AuthorizationConsent(userID, req)
+	var oauthErr *oauth.Error
+	if errors.As(err, &oauthErr) {
+		// The frontend shows the problem, then sends the user back to the client
+		h.writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error":             oauthErr.Code,
+			"error_description": oauthErr.Description,
+			"redirect_to":       oauth.RedirectWithError(req.RedirectURI, oauthErr, req.State),
+		})
+		return
+	}
+	if h.writeConsentError(w, err) {
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, consent)
+}
+
+// DecideAuthorization records the user's decision on the consent screen and
+// tells the frontend where to send the user: back to the client, with an
+// authorization code or an error.
+func (h *AuthHandler) DecideAuthorization(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var decision models.AuthorizeDecision
+	if err := json.NewDecoder(r.Body).Decode(&decision); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	redirectTo, err := h.authService.Authorize(userID, &decision, clientInfo(r))
+	var oauthErr *oauth.Error
+	switch {
+	case errors.As(err, &oauthErr):
+		redirectTo = oauth.RedirectWithError(decision.RedirectURI, oauthErr, decision.State)
+	case h.writeConsentError(w, err):
+		return
+	default:
+		slog.Info("Application authorized", "user_id", userID, "client_id", decision.ClientID)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	h.writeJSON(w, http.StatusOK, models.AuthorizeRedirect{RedirectTo: redirectTo})
+}
+
+// ListOAuthGrants lists the applications the user authorized
+func (h *AuthHandler) ListOAuthGrants(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	grants, err := h.authService.ListOAuthGrants(userID)
+	if err != nil {
+		slog.Error("Failed to list oauth grants", "error", err, "user_id", userID)
+		h.writeError(w, http.StatusInternalServerError, "Failed to list authorized applications")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, grants)
+}
+
+// RevokeOAuthGrant withdraws an application's access to the account
+func (h *AuthHandler) RevokeOAuthGrant(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	grantID := mux.Vars(r)["id"]
+	err = h.authService.RevokeOAuthGrant(userID, grantID, clientInfo(r))
+	if errors.Is(err, repository.ErrRefreshTokenNotFound) {
+		h.writeError(w, http.StatusNotFound, "Authorized application not found")
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to revoke oauth grant", "error", err, "user_id", userID)
+		h.writeError(w, http.StatusInternalServerError, "Failed to revoke authorized application")
+		return
+	}
+
+	slog.Info("OAuth grant revoked", "user_id", userID, "grant_id", grantID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeAuthorizeError responds to a failed authorization request and reports
+// whether it did
+func (h *AuthHandler) writeAuthorizeError(w http.ResponseWriter, r *http.Request, req *oauth.AuthorizeRequest, err error) bool {
+	var oauthErr *oauth.Error
+	switch {
+	case err == nil:
+		return false
+	case errors.As(err, &oauthErr):
+		http.Redirect(w, r, oauth.RedirectWithError(req.RedirectURI, oauthErr, req.State), http.StatusFound)
+	case errors.Is(err, repository.ErrInvalidClient):
+		h.writeOAuthError(w, http.StatusBadRequest, oauth.ErrorInvalidRequest, "Unknown client")
+	case errors.Is(err, service.ErrInvalidRedirectURI):
+		h.writeOAuthError(w, http.StatusBadRequest, oauth.ErrorInvalidRequest, "redirect_uri is not registered for the client")
+	default:
+		slog.Error("Authorization request failed", "error", err, "client_id", req.ClientID)
+		h.writeOAuthError(w, http.StatusInternalServerError, "server_error", "Authorization failed")
+	}
+	return true
+}
+
+// writeConsentError responds to a consent screen request that failed with
+// anything but an *oauth.Error and reports whether it did
+func (h *AuthHandler) writeConsentError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, repository.ErrInvalidClient):
+		h.writeError(w, http.StatusBadRequest, "Unknown client")
+	case errors.Is(err, service.ErrInvalidRedirectURI):
+		h.writeError(w, http.StatusBadRequest, "redirect_uri is not registered for the client")
+	default:
+		slog.Error("Failed to process authorization consent", "error", err)
+		h.writeError(w, http.StatusInternalServerError, "Authorization failed")
+	}
+	return true
+}
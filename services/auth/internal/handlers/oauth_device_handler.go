@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/pseudoerr/auth-service/internal/oauth"
+	"github.com/pseudoerr/auth-service/internal/repository"
+	"github.com/pseudoerr/auth-service/internal/validation"
+)
+
+// DeviceAuthorization is the device authorization endpoint of RFC 8628. A
+// device like a CLI gets a user code to show the user and a device code to
+// poll the token endpoint with.
+func (h *AuthHandler) DeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.writeOAuthError(w, http.StatusBadRequest, oauth.ErrorInvalidRequest, "Malformed form body")
+		return
+	}
+
+	client, ok := h.authenticateTokenClient(w, r)
+	if !ok {
+		return
+	}
+
+	response, err := h.authService.StartDeviceAuthorization(client, r.PostForm.Get("scope"))
+	if h.writeGrantError(w, client, err) {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	h.writeJSON(w, http.StatusOK, response)
+}
+
+func (h *AuthHandler) deviceCodeGrant(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.authenticateTokenClient(w, r)
+	if !ok {
+		return
+	}
+
+	deviceCode := r.PostForm.Get("device_code")
+	if deviceCode == "" {
+		h.writeOAuthError(w, http.StatusBadRequest, oauth.ErrorInvalidRequest, "device_code is required")
+		return
+	}
+
+	response, err := h.authService.ExchangeDeviceCode(client, deviceCode, clientInfo(r))
+	if h.writeGrantError(w, client, err) {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	h.writeJSON(w, http.StatusOK, response)
+}
+
+// GetDeviceConsent describes the device authorization with the user_code in
+// the query to the verification page
+func (h *AuthHandler) GetDeviceConsent(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	consent, err := h.authService.DeviceConsent(userID, r.URL.Query().Get("user_code"))
+	if h.writeDeviceError(w, err) {
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, consent)
+}
+
+// DecideDeviceAuthorization records the user's decision on the verification
+// page. The device picks it up with its next poll.
+func (h *AuthHandler) DecideDeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var decision models.DeviceDecision
+	if err := json.NewDecoder(r.Body).Decode(&decision); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	// Validate request
+	if err := validation.ValidateStruct(&decision); err != nil {
+		h.writeValidationError(w, err)
+		return
+	}
+
+	err = h.authService.DecideDeviceAuthorization(userID, &decision, clientInfo(r))
+	if h.writeDeviceError(w, err) {
+		return
+	}
+
+	slog.Info("Device authorization decided", "user_id", userID, "approved", decision.Approve)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeDeviceError responds to a failed verification page request and
+// reports whether it did
+func (h *AuthHandler) writeDeviceError(w http.ResponseWriter, err error) bool {
+	var oauthErr *oauth.Error
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, repository.ErrDeviceCodeInvalid):
+		h.writeError(w, http.StatusBadRequest, "Invalid or expired code")
+	case errors.As(err, &oauthErr):
+		h.writeError(w, http.StatusBadRequest, oauthErr.Description)
+	default:
+		slog.Error("Failed to process device authorization", "error", err)
+		h.writeError(w, http.StatusInternalServerError, "Authorization failed")
+	}
+	return true
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/pseudoerr/auth-service/internal/oauth"
+	"github.com/pseudoerr/auth-service/internal/repository"
+	"github.com/pseudoerr/auth-service/internal/service"
+)
+
+// Introspect implements RFC 7662 token introspection for registered clients
+func (h *AuthHandler) Introspect(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.writeOAuthError(w, http.StatusBadRequest, "invalid_request", "Malformed form body")
+		return
+	}
+
+	client, ok := h.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		h.writeOAuthError(w, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+
+	response, err := h.authService.Introspect(token)
+	if err != nil {
+		slog.Error("Token introspection failed", "error", err, "client_id", client.ClientID)
+		h.writeOAuthError(w, http.StatusServiceUnavailable, "temporarily_unavailable", "Unable to validate token")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	h.writeJSON(w, http.StatusOK, response)
+}
+
+// Token is the OAuth 2.0 token endpoint. It supports the client_credentials
+// grant, which gives a service a token of its own, and the authorization_code,
+// device_code and refresh_token grants, which give an application a token
+// acting for a user.
+func (h *AuthHandler) Token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.writeOAuthError(w, http.StatusBadRequest, "invalid_request", "Malformed form body")
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "client_credentials":
+		h.clientCredentialsGrant(w, r)
+	case "authorization_code":
+		h.authorizationCodeGrant(w, r)
+	case "refresh_token":
+		h.refreshTokenGrant(w, r)
+	case oauth.GrantTypeDeviceCode:
+		h.deviceCodeGrant(w, r)
+	case "":
+		h.writeOAuthError(w, http.StatusBadRequest, "invalid_request", "grant_type is required")
+	default:
+		h.writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "Unsupported grant_type")
+	}
+}
+
+func (h *AuthHandler) clientCredentialsGrant(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	response, err := h.authService.IssueClientCredentialsToken(client, r.PostForm.Get("scope"))
+	if errors.Is(err, service.ErrInvalidScope) {
+		h.writeOAuthError(w, http.StatusBadRequest, "invalid_scope", "Requested scope is not granted to the client")
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to issue client credentials token", "error", err, "client_id", client.ClientID)
+		h.writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to issue token")
+		return
+	}
+
+	slog.Info("Service token issued", "client_id", client.ClientID, "scope", response.Scope)
+	w.Header().Set("Cache-Control", "no-store")
+	h.writeJSON(w, http.StatusOK, response)
+}
+
+func (h *AuthHandler) authorizationCodeGrant(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.authenticateTokenClient(w, r)
+	if !ok {
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	if code == "" {
+		h.writeOAuthError(w, http.StatusBadRequest, oauth.ErrorInvalidRequest, "code is required")
+		return
+	}
+
+	response, err := h.authService.ExchangeAuthorizationCode(client, code, r.PostForm.Get("redirect_uri"),
+		r.PostForm.Get("code_verifier"), clientInfo(r))
+	if h.writeGrantError(w, client, err) {
+		return
+	}
+
+	slog.Info("Authorization code exchanged", "client_id", client.ClientID, "scope", response.Scope)
+	w.Header().Set("Cache-Control", "no-store")
+	h.writeJSON(w, http.StatusOK, response)
+}
+
+func (h *AuthHandler) refreshTokenGrant(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.authenticateTokenClient(w, r)
+	if !ok {
+		return
+	}
+
+	refreshToken := r.PostForm.Get("refresh_token")
+	if refreshToken == "" {
+		h.writeOAuthError(w, http.StatusBadRequest, oauth.ErrorInvalidRequest, "refresh_token is required")
+		return
+	}
+
+	response, err := h.authService.RefreshDelegatedToken(client, refreshToken, r.PostForm.Get("scope"), clientInfo(r))
+	if h.writeGrantError(w, client, err) {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	h.writeJSON(w, http.StatusOK, response)
+}
+
+// writeGrantError responds to a failed grant and reports whether it did
+func (h *AuthHandler) writeGrantError(w http.ResponseWriter, client *models.OAuthClient, err error) bool {
+	var oauthErr *oauth.Error
+	switch {
+	case err == nil:
+		return false
+	case errors.As(err, &oauthErr):
+		h.writeOAuthError(w, http.StatusBadRequest, oauthErr.Code, oauthErr.Description)
+	default:
+		slog.Error("Failed to issue token", "error", err, "client_id", client.ClientID)
+		h.writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to issue token")
+	}
+	return true
+}
+
+// authenticateTokenClient authenticates the client of a grant acting for a
+// user. Public clients identify themselves by client_id alone; PKCE stands
+// in for the secret they can't keep.
+func (h *AuthHandler) authenticateTokenClient(w http.ResponseWriter, r *http.Request) (*models.OAuthClient, bool) {
+	_, _, basic := r.BasicAuth()
+	clientID := r.PostForm.Get("client_id")
+	if basic || r.PostForm.Get("client_secret") != "" || clientID == "" {
+		return h.authenticateClient(w, r)
+	}
+
+	client, err := h.authService.PublicClient(clientID)
+	if errors.Is(err, repository.ErrInvalidClient) {
+		slog.Warn("Client authentication failed", "client_id", clientID)
+		h.writeInvalidClient(w, false)
+		return nil, false
+	}
+	if err != nil {
+		slog.Error("Failed to authenticate client", "error", err, "client_id", clientID)
+		h.writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to authenticate client")
+		return nil, false
+	}
+
+	return client, true
+}
+
+// authenticateClient reads client credentials from HTTP Basic auth or, failing
+// that, the client_id and client_secret form fields. It writes the error
+// response itself when the client can't be authenticated.
+func (h *AuthHandler) authenticateClient(w http.ResponseWriter, r *http.Request) (*models.OAuthClient, bool) {
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		// RFC 6749 form-encodes both parts before they are joined
+		var errID, errSecret error
+		clientID, errID = url.QueryUnescape(clientID)
+		secret, errSecret = url.QueryUnescape(secret)
+		if errID != nil || errSecret != nil {
+			h.writeInvalidClient(w, basic)
+			return nil, false
+		}
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	if clientID == "" || secret == "" {
+		h.writeInvalidClient(w, basic)
+		return nil, false
+	}
+
+	client, err := h.authService.AuthenticateClient(clientID, secret)
+	if errors.Is(err, repository.ErrInvalidClient) {
+		slog.Warn("Client authentication failed", "client_id", clientID)
+		h.writeInvalidClient(w, basic)
+		return nil, false
+	}
+	if err != nil {
+		slog.Error("Failed to authenticate client", "error", err, "client_id", clientID)
+		h.writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to authenticate client")
+		return nil, false
+	}
+
+	return client, true
+}
+
+func (h *AuthHandler) writeInvalidClient(w http.ResponseWriter, basic bool) {
+	if basic {
+		w.Header().Set("WWW-Authenticate", `Basic realm="auth-service"`)
+	}
+	h.writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+}
+
+// writeOAuthError responds in the error format of RFC 6749
+func (h *AuthHandler) writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Cache-Control", "no-store")
+	h.writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
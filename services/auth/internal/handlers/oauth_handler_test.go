@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Requests the token endpoint answers before authenticating the client
+func TestTokenRejectsGrantTypes(t *testing.T) {
+	tests := []struct {
+		name      string
+		grantType string
+		code      string
+	}{
+		{"missing grant type", "", "invalid_request"},
+		{"implicit", "implicit", "unsupported_grant_type"},
+		{"password", "password", "unsupported_grant_type"},
+		{"case sensitive", "Client_Credentials", "unsupported_grant_type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			if tt.grantType != "" {
+				form.Set("grant_type", tt.grantType)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := httptest.NewRecorder()
+
+			NewAuthHandler(nil, CookieSettings{}).Token(rec, req)
+
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+			var body map[string]string
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+			assert.Equal(t, tt.code, body["error"])
+		})
+	}
+}
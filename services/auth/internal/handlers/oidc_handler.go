@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/pseudoerr/auth-service/internal/oidc"
+	"github.com/pseudoerr/auth-service/internal/repository"
+	"github.com/pseudoerr/auth-service/internal/service"
+	"github.com/pseudoerr/auth-service/internal/validation"
+)
+
+// ListOIDCProviders lists the providers users can sign in with
+func (h *AuthHandler) ListOIDCProviders(w http.ResponseWriter, r *http.Request) {
+	h.writeJSON(w, http.StatusOK, map[string][]string{"providers": h.authService.OIDCProviders()})
+}
+
+// StartOIDCLogin tells the frontend where to send the user to sign in with
+// the provider
+func (h *AuthHandler) StartOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	provider := mux.Vars(r)["provider"]
+	authorization, err := h.authService.StartOIDCLogin(r.Context(), provider)
+	if h.writeOIDCError(w, provider, err) {
+		return
+	}
+
+	h.setOIDCBinding(w, authorization.Binding)
+	w.Header().Set("Cache-Control", "no-store")
+	h.writeJSON(w, http.StatusOK, authorization)
+}
+
+// CompleteOIDCLogin signs the user in with the code and state the provider
+// redirected the frontend back with
+func (h *AuthHandler) CompleteOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	req, ok := h.decodeOIDCCallback(w, r)
+	if !ok {
+		return
+	}
+
+	provider := mux.Vars(r)["provider"]
+	authResponse, challenge, err := h.authService.CompleteOIDCLogin(r.Context(), provider, req, clientInfo(r))
+	if h.writeOIDCError(w, provider, err) {
+		return
+	}
+
+	// The provider vouched for the user but a second factor is still required
+	if challenge != nil {
+		h.writeJSON(w, http.StatusOK, challenge)
+		return
+	}
+
+	slog.Info("User logged in with identity provider", "user_id", authResponse.User.ID, "provider", provider)
+	h.writeAuthResponse(w, r, http.StatusOK, authResponse)
+}
+
+// ListIdentities lists the provider identities linked to the account
+func (h *AuthHandler) ListIdentities(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	identities, err := h.authService.ListIdentities(userID)
+	if err != nil {
+		slog.Error("Failed to list identities", "error", err, "user_id", userID)
+		h.writeError(w, http.StatusInternalServerError, "Failed to list linked identities")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, identities)
+}
+
+// StartIdentityLink tells the frontend where to send the user to link their
+// identity at the provider
+func (h *AuthHandler) StartIdentityLink(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	provider := mux.Vars(r)["provider"]
+	authorization, err := h.authService.StartIdentityLink(r.Context(), userID, provider)
+	if h.writeOIDCError(w, provider, err) {
+		return
+	}
+
+	h.setOIDCBinding(w, authorization.Binding)
+	w.Header().Set("Cache-Control", "no-store")
+	h.writeJSON(w, http.StatusOK, authorization)
+}
+
+// LinkIdentity links the identity the provider redirected the frontend back
+// with to the account
+func (h *AuthHandler) LinkIdentity(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	req, ok := h.decodeOIDCCallback(w, r)
+	if !ok {
+		return
+	}
+
+	provider := mux.Vars(r)["provider"]
+	identity, err := h.authService.LinkIdentity(r.Context(), userID, provider, req, clientInfo(r))
+	switch {
+	case errors.Is(err, repository.ErrIdentityTaken):
+		h.writeError(w, http.StatusConflict, "This identity is already linked to another account")
+		return
+	case errors.Is(err, repository.ErrProviderLinked):
+		h.writeError(w, http.StatusConflict, "An identity at this provider is already linked to the account")
+		return
+	case h.writeOIDCError(w, provider, err):
+		return
+	}
+
+	slog.Info("Identity linked", "user_id", userID, "provider", provider)
+	h.writeJSON(w, http.StatusCreated, identity)
+}
+
+// UnlinkIdentity removes a linked identity from the account
+func (h *AuthHandler) UnlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	identityID, _ := strconv.Atoi(mux.Vars(r)["id"])
+	err = h.authService.UnlinkIdentity(userID, identityID, clientInfo(r))
+	if errors.Is(err, repository.ErrIdentityNotFound) {
+		h.writeError(w, http.StatusNotFound, "Linked identity not found")
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to unlink identity", "error", err, "user_id", userID)
+		h.writeError(w, http.StatusInternalServerError, "Failed to unlink identity")
+		return
+	}
+
+	slog.Info("Identity unlinked", "user_id", userID, "identity_id", identityID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AuthHandler) decodeOIDCCallback(w http.ResponseWriter, r *http.Request) (*models.OIDCCallbackRequest, bool) {
+	var req models.OIDCCallbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return nil, false
+	}
+
+	// Validate request
+	if err := validation.ValidateStruct(&req); err != nil {
+		h.writeValidationError(w, err)
+		return nil, false
+	}
+
+	req.Binding = h.oidcBinding(w, r)
+	return &req, true
+}
+
+// writeOIDCError responds to a failed sign-in with a provider and reports
+// whether it did
+func (h *AuthHandler) writeOIDCError(w http.ResponseWriter, provider string, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, service.ErrUnknownProvider):
+		h.writeError(w, http.StatusNotFound, "Unknown identity provider")
+	case errors.Is(err, repository.ErrOIDCStateInvalid):
+		h.writeError(w, http.StatusBadRequest, "Invalid or expired sign-in, please start over")
+	case errors.Is(err, oidc.ErrExchangeFailed), errors.Is(err, oidc.ErrInvalidIDToken):
+		slog.Warn("Identity provider sign-in rejected", "error", err, "provider", provider)
+		h.writeError(w, http.StatusBadRequest, "Sign-in with the identity provider failed, please start over")
+	case errors.Is(err, oidc.ErrProviderUnavailable):
+		slog.Error("Identity provider unavailable", "error", err, "provider", provider)
+		h.writeError(w, http.StatusBadGateway, "Identity provider is unavailable")
+	case errors.Is(err, service.ErrIdentityNoEmail):
+		h.writeError(w, http.StatusBadRequest, "The identity provider did not share a verified email address")
+	case errors.Is(err, service.ErrIdentityConflict):
+		h.writeError(w, http.StatusConflict, "An account with this email already exists, sign in and link the provider from the account settings")
+	case errors.Is(err, service.ErrAccountDisabled):
+		h.writeError(w, http.StatusForbidden, "Account is disabled")
+	case errors.Is(err, service.ErrEmailNotVerified):
+		h.writeError(w, http.StatusForbidden, "Email address is not verified")
+	default:
+		slog.Error("Identity provider sign-in failed", "error", err, "provider", provider)
+		h.writeError(w, http.StatusInternalServerError, "Sign-in failed")
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetOIDCBinding(t *testing.T) {
+	tests := []struct {
+		name     string
+		sameSite http.SameSite
+		want     http.SameSite
+	}{
+		// Strict would keep the cookie back when the provider sends the user back
+		{"strict", http.SameSiteStrictMode, http.SameSiteLaxMode},
+		{"lax", http.SameSiteLaxMode, http.SameSiteLaxMode},
+		{"frontend on another site", http.SameSiteNoneMode, http.SameSiteNoneMode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewAuthHandler(nil, CookieSettings{Secure: true, SameSite: tt.sameSite})
+			rec := httptest.NewRecorder()
+			h.setOIDCBinding(rec, "binding")
+
+			cookie := responseCookies(rec)[oidcBindingCookie]
+			require.NotNil(t, cookie)
+			assert.Equal(t, "binding", cookie.Value)
+			assert.Equal(t, oidcBindingPath, cookie.Path)
+			assert.True(t, cookie.HttpOnly)
+			assert.True(t, cookie.Secure)
+			assert.Equal(t, tt.want, cookie.SameSite)
+		})
+	}
+}
+
+func TestDecodeOIDCCallbackBinding(t *testing.T) {
+	tests := []struct {
+		name    string
+		cookie  string
+		binding string
+	}{
+		{"bound browser", "binding", "binding"},
+		// The state is rejected without a binding
+		{"missing cookie", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newCookieTestHandler()
+			req := httptest.NewRequest(http.MethodPost, "/auth/oidc/google/callback",
+				strings.NewReader(`{"code": "code", "state": "state"}`))
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: oidcBindingCookie, Value: tt.cookie})
+			}
+			rec := httptest.NewRecorder()
+
+			callback, ok := h.decodeOIDCCallback(rec, req)
+			require.True(t, ok)
+			assert.Equal(t, tt.binding, callback.Binding)
+
+			if tt.cookie != "" {
+				cleared := responseCookies(rec)[oidcBindingCookie]
+				require.NotNil(t, cleared)
+				assert.Negative(t, cleared.MaxAge)
+			}
+		})
+	}
+}
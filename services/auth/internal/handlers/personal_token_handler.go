@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/pseudoerr/auth-service/internal/repository"
+	"github.com/pseudoerr/auth-service/internal/validation"
+)
+
+// CreatePersonalAccessToken issues a personal access token. The plaintext is
+// only part of this response.
+func (h *AuthHandler) CreatePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req models.CreatePersonalAccessTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	// Validate request
+	if err := validation.ValidateStruct(&req); err != nil {
+		h.writeValidationError(w, err)
+		return
+	}
+
+	token, err := h.authService.CreatePersonalAccessToken(userID, &req, clientInfo(r))
+	var validationErr *validation.Error
+	if errors.As(err, &validationErr) {
+		h.writeValidationError(w, err)
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to create personal access token", "error", err, "user_id", userID)
+		h.writeError(w, http.StatusInternalServerError, "Failed to create personal access token")
+		return
+	}
+
+	slog.Info("Personal access token created", "user_id", userID, "token_id", token.ID)
+	w.Header().Set("Cache-Control", "no-store")
+	h.writeJSON(w, http.StatusCreated, token)
+}
+
+func (h *AuthHandler) ListPersonalAccessTokens(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	tokens, err := h.authService.ListPersonalAccessTokens(userID)
+	if err != nil {
+		slog.Error("Failed to list personal access tokens", "error", err, "user_id", userID)
+		h.writeError(w, http.StatusInternalServerError, "Failed to list personal access tokens")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, tokens)
+}
+
+func (h *AuthHandler) RevokePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	tokenID, _ := strconv.Atoi(mux.Vars(r)["id"])
+	err = h.authService.RevokePersonalAccessToken(userID, tokenID, clientInfo(r))
+	if errors.Is(err, repository.ErrPersonalAccessTokenNotFound) {
+		h.writeError(w, http.StatusNotFound, "Personal access token not found")
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to revoke personal access token", "error", err, "user_id", userID)
+		h.writeError(w, http.StatusInternalServerError, "Failed to revoke personal access token")
+		return
+	}
+
+	slog.Info("Personal access token revoked", "user_id", userID, "token_id", tokenID)
+	w.WriteHeader(http.StatusNoContent)
+}
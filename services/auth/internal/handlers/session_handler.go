@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/pseudoerr/auth-service/internal/middleware"
+	"github.com/pseudoerr/auth-service/internal/repository"
+)
+
+func (h *AuthHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	sessions, err := h.authService.ListSessions(userID, middleware.SessionID(r.Context()))
+	if err != nil {
+		slog.Error("Failed to list sessions", "error", err, "user_id", userID)
+		h.writeError(w, http.StatusInternalServerError, "Failed to list sessions")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, sessions)
+}
+
+func (h *AuthHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	tokenID, tokenExpiresAt, err := getAccessTokenFromContext(r)
+	if err != nil {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	sessionID := mux.Vars(r)["id"]
+	err = h.authService.RevokeSession(userID, sessionID, middleware.SessionID(r.Context()), tokenID, tokenExpiresAt, clientInfo(r))
+	if errors.Is(err, repository.ErrRefreshTokenNotFound) {
+		h.writeError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to revoke session", "error", err, "user_id", userID)
+		h.writeError(w, http.StatusInternalServerError, "Failed to revoke session")
+		return
+	}
+
+	slog.Info("Session revoked", "user_id", userID, "session_id", sessionID)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,44 @@
+package hashing
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrUnknownFormat = errors.New("unknown password hash format")
+
+// PasswordHasher hashes passwords with the configured algorithm. Verify
+// accepts hashes of every supported algorithm, so the algorithm or its
+// parameters can change while old hashes keep working until NeedsRehash
+// reports them for an upgrade.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(encoded, password string) (bool, error)
+	// NeedsRehash reports whether encoded was produced by another algorithm
+	// or with other parameters than the ones currently configured
+	NeedsRehash(encoded string) bool
+}
+
+// New returns the hasher for the named algorithm: "argon2id" or "bcrypt"
+func New(algorithm string, bcryptCost int, argon2Params Argon2Params) (PasswordHasher, error) {
+	switch algorithm {
+	case "argon2id":
+		return NewArgon2id(argon2Params), nil
+	case "bcrypt":
+		return NewBcrypt(bcryptCost)
+	default:
+		return nil, errors.New("unknown password hash algorithm " + algorithm)
+	}
+}
+
+// verify checks password against a hash of any supported format
+func verify(encoded, password string) (bool, error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return verifyArgon2id(encoded, password)
+	case isBcrypt(encoded):
+		return verifyBcrypt(encoded, password)
+	default:
+		return false, ErrUnknownFormat
+	}
+}
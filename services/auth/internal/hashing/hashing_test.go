@@ -0,0 +1,99 @@
+package hashing
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Cheap parameters keep the tests fast
+var testArgon2Params = Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestArgon2idHashAndVerify(t *testing.T) {
+	hasher := NewArgon2id(testArgon2Params)
+
+	encoded, err := hasher.Hash("SecurePass123")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$"))
+
+	ok, err := hasher.Verify(encoded, "SecurePass123")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = hasher.Verify(encoded, "WrongPass123")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.False(t, hasher.NeedsRehash(encoded))
+}
+
+func TestArgon2idKnownHash(t *testing.T) {
+	// Produced by the reference argon2 CLI: echo -n password | argon2 somesalt -id -t 2 -m 16 -p 4 -l 32
+	encoded := "$argon2id$v=19$m=65536,t=2,p=4$c29tZXNhbHQ$GpZ3sK/oH9p7VIiV56G/64Zo/8GaUw434IimaPqxwCo"
+
+	ok, err := NewArgon2id(testArgon2Params).Verify(encoded, "password")
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestBcryptHashAndVerify(t *testing.T) {
+	hasher, err := NewBcrypt(4)
+	require.NoError(t, err)
+
+	encoded, err := hasher.Hash("SecurePass123")
+	require.NoError(t, err)
+
+	ok, err := hasher.Verify(encoded, "SecurePass123")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = hasher.Verify(encoded, "WrongPass123")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.False(t, hasher.NeedsRehash(encoded))
+}
+
+func TestVerifyAcrossAlgorithms(t *testing.T) {
+	bcryptHasher, err := NewBcrypt(4)
+	require.NoError(t, err)
+	argon2Hasher := NewArgon2id(testArgon2Params)
+
+	bcryptHash, err := bcryptHasher.Hash("SecurePass123")
+	require.NoError(t, err)
+	argon2Hash, err := argon2Hasher.Hash("SecurePass123")
+	require.NoError(t, err)
+
+	ok, err := argon2Hasher.Verify(bcryptHash, "SecurePass123")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, argon2Hasher.NeedsRehash(bcryptHash))
+
+	ok, err = bcryptHasher.Verify(argon2Hash, "SecurePass123")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, bcryptHasher.NeedsRehash(argon2Hash))
+}
+
+func TestNeedsRehashOnParameterChange(t *testing.T) {
+	old, err := NewBcrypt(4)
+	require.NoError(t, err)
+	current, err := NewBcrypt(5)
+	require.NoError(t, err)
+	encoded, err := old.Hash("SecurePass123")
+	require.NoError(t, err)
+	assert.True(t, current.NeedsRehash(encoded))
+
+	stronger := testArgon2Params
+	stronger.Iterations = 2
+	encoded, err = NewArgon2id(testArgon2Params).Hash("SecurePass123")
+	require.NoError(t, err)
+	assert.True(t, NewArgon2id(stronger).NeedsRehash(encoded))
+}
+
+func TestVerifyRejectsUnknownFormat(t *testing.T) {
+	_, err := NewArgon2id(testArgon2Params).Verify("plaintext", "plaintext")
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
@@ -0,0 +1,138 @@
+package keys
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ValidMethods lists the algorithms tokens may be signed with. Pass it to the
+// parser with jwt.WithValidMethods alongside Keyfunc.
+var ValidMethods = []string{"EdDSA", "RS256"}
+
+// IssuedAtClaim encodes t for the iat claim in milliseconds, so a token issued
+// just before a user's tokens were revoked is told apart from one issued just
+// after within the same second
+func IssuedAtClaim(t time.Time) float64 {
+	return float64(t.UnixMilli()) / 1000
+}
+
+// IssuedAt reads the iat claim at the millisecond precision IssuedAtClaim
+// writes. Whole seconds, from tokens issued before, are read as well.
+func IssuedAt(claims jwt.MapClaims) (time.Time, bool) {
+	iat, ok := claims["iat"].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(int64(math.Round(iat * 1000))), true
+}
+
+// RetiredKey is a former signing key that is still accepted for verification
+// until ExpiresAt, so tokens signed before a rotation stay valid
+type RetiredKey struct {
+	Key       *SigningKey
+	ExpiresAt time.Time
+}
+
+// PendingKey is a new signing key that is published for verification before
+// it signs anything, so verifiers caching the key set know it by ActivatesAt
+type PendingKey struct {
+	Key         *SigningKey
+	ActivatesAt time.Time
+}
+
+// Keyring holds the active signing key, the pending key of a rotation in
+// progress and the retired keys still inside their overlap window. It is
+// safe for concurrent use and is replaced wholesale whenever the key set is
+// reloaded.
+type Keyring struct {
+	mu      sync.RWMutex
+	active  *SigningKey
+	pending *PendingKey
+	retired map[string]RetiredKey
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{retired: make(map[string]RetiredKey)}
+}
+
+// Set replaces the contents of the keyring. pending may be nil.
+func (k *Keyring) Set(active *SigningKey, pending *PendingKey, retired []RetiredKey) {
+	byID := make(map[string]RetiredKey, len(retired))
+	for _, key := range retired {
+		byID[key.Key.ID] = key
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.active = active
+	k.pending = pending
+	k.retired = byID
+}
+
+// Active returns the key new tokens are signed with. The pending key takes
+// over once it activates, so every replica switches at the same moment
+// whether or not it reloaded in between.
+func (k *Keyring) Active() *SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.pending != nil && !time.Now().Before(k.pending.ActivatesAt) {
+		return k.pending.Key
+	}
+	return k.active
+}
+
+// Lookup returns the key a token with the given kid header must verify
+// against, provided it is active or still inside its overlap window
+func (k *Keyring) Lookup(kid string) (*SigningKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if k.active != nil && k.active.ID == kid {
+		return k.active, true
+	}
+	if k.pending != nil && k.pending.Key.ID == kid {
+		return k.pending.Key, true
+	}
+	if retired, ok := k.retired[kid]; ok && time.Now().Before(retired.ExpiresAt) {
+		return retired.Key, true
+	}
+	return nil, false
+}
+
+// Keyfunc resolves the verification key of a token from its kid header
+func (k *Keyring) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	signingKey, ok := k.Lookup(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key: %q", kid)
+	}
+	if token.Method.Alg() != signingKey.Method().Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return signingKey.PublicKey(), nil
+}
+
+// JWKS returns every key tokens may currently be verified with
+func (k *Keyring) JWKS() JWKS {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	jwks := JWKS{Keys: []JWK{}}
+	if k.active != nil {
+		jwks.Keys = append(jwks.Keys, k.active.JWK())
+	}
+	if k.pending != nil {
+		jwks.Keys = append(jwks.Keys, k.pending.Key.JWK())
+	}
+	now := time.Now()
+	for _, retired := range k.retired {
+		if now.Before(retired.ExpiresAt) {
+			jwks.Keys = append(jwks.Keys, retired.Key.JWK())
+		}
+	}
+	return jwks
+}
@@ -0,0 +1,137 @@
+package keys
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyringLookup(t *testing.T) {
+	active, err := GenerateEd25519()
+	require.NoError(t, err)
+	retired, err := GenerateEd25519()
+	require.NoError(t, err)
+	expired, err := GenerateEd25519()
+	require.NoError(t, err)
+
+	keyring := NewKeyring()
+	keyring.Set(active, nil, []RetiredKey{
+		{Key: retired, ExpiresAt: time.Now().Add(time.Hour)},
+		{Key: expired, ExpiresAt: time.Now().Add(-time.Second)},
+	})
+
+	assert.Equal(t, active, keyring.Active())
+
+	key, ok := keyring.Lookup(active.ID)
+	assert.True(t, ok)
+	assert.Equal(t, active, key)
+
+	key, ok = keyring.Lookup(retired.ID)
+	assert.True(t, ok)
+	assert.Equal(t, retired, key)
+
+	_, ok = keyring.Lookup(expired.ID)
+	assert.False(t, ok, "keys past their overlap window must not verify")
+
+	_, ok = keyring.Lookup("")
+	assert.False(t, ok)
+}
+
+func TestKeyringJWKSPublishesUsableKeys(t *testing.T) {
+	active, err := GenerateEd25519()
+	require.NoError(t, err)
+	retired, err := GenerateEd25519()
+	require.NoError(t, err)
+	expired, err := GenerateEd25519()
+	require.NoError(t, err)
+
+	keyring := NewKeyring()
+	keyring.Set(active, nil, []RetiredKey{
+		{Key: retired, ExpiresAt: time.Now().Add(time.Hour)},
+		{Key: expired, ExpiresAt: time.Now().Add(-time.Second)},
+	})
+
+	var kids []string
+	for _, jwk := range keyring.JWKS().Keys {
+		kids = append(kids, jwk.Kid)
+	}
+	assert.ElementsMatch(t, []string{active.ID, retired.ID}, kids)
+}
+
+func TestKeyringPendingKey(t *testing.T) {
+	active, err := GenerateEd25519()
+	require.NoError(t, err)
+	next, err := GenerateEd25519()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		activatesAt time.Time
+		wantActive  *SigningKey
+	}{
+		{"published before it activates", time.Now().Add(time.Hour), active},
+		{"signs once it activates", time.Now().Add(-time.Second), next},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyring := NewKeyring()
+			keyring.Set(active, &PendingKey{Key: next, ActivatesAt: tt.activatesAt}, nil)
+
+			assert.Equal(t, tt.wantActive, keyring.Active())
+
+			var kids []string
+			for _, jwk := range keyring.JWKS().Keys {
+				kids = append(kids, jwk.Kid)
+			}
+			assert.ElementsMatch(t, []string{active.ID, next.ID}, kids)
+
+			for _, key := range []*SigningKey{active, next} {
+				found, ok := keyring.Lookup(key.ID)
+				assert.True(t, ok)
+				assert.Equal(t, key, found)
+			}
+		})
+	}
+}
+
+func TestIssuedAt(t *testing.T) {
+	key, err := GenerateEd25519()
+	require.NoError(t, err)
+	keyring := NewKeyring()
+	keyring.Set(key, nil, nil)
+
+	issued := time.UnixMilli(1700000000123)
+
+	tests := []struct {
+		name   string
+		iat    interface{}
+		want   time.Time
+		wantOK bool
+	}{
+		{"milliseconds survive signing", IssuedAtClaim(issued), issued, true},
+		{"whole seconds", issued.Unix(), issued.Truncate(time.Second), true},
+		{"missing", nil, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := jwt.MapClaims{"exp": time.Now().Add(time.Minute).Unix()}
+			if tt.iat != nil {
+				claims["iat"] = tt.iat
+			}
+			token := jwt.NewWithClaims(key.Method(), claims)
+			token.Header["kid"] = key.ID
+			signed, err := token.SignedString(key.PrivateKey)
+			require.NoError(t, err)
+
+			parsed, err := jwt.Parse(signed, keyring.Keyfunc, jwt.WithValidMethods(ValidMethods))
+			require.NoError(t, err)
+
+			issuedAt, ok := IssuedAt(parsed.Claims.(jwt.MapClaims))
+			assert.Equal(t, tt.wantOK, ok)
+			assert.True(t, tt.want.Equal(issuedAt), "issued at %s, want %s", issuedAt, tt.want)
+		})
+	}
+}
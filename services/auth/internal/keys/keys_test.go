@@ -0,0 +1,101 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestThumbprintMatchesRFC8037(t *testing.T) {
+	// Key and expected thumbprint from RFC 8037, appendix A.3
+	d, err := base64.RawURLEncoding.DecodeString("nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A")
+	require.NoError(t, err)
+
+	key, err := newSigningKey(ed25519.NewKeyFromSeed(d))
+	require.NoError(t, err)
+
+	assert.Equal(t, "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k", key.ID)
+	assert.Equal(t, "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo", key.JWK().X)
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	pkcs8RSA, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	require.NoError(t, err)
+	pkcs8Ed, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		block *pem.Block
+		alg   string
+		kty   string
+	}{
+		{"ed25519 pkcs8", &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8Ed}, "EdDSA", "OKP"},
+		{"rsa pkcs8", &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8RSA}, "RS256", "RSA"},
+		{"rsa pkcs1", &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}, "RS256", "RSA"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParsePrivateKey(pem.EncodeToMemory(tt.block))
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.alg, key.Method().Alg())
+			assert.Equal(t, tt.kty, key.JWK().Kty)
+			assert.NotEmpty(t, key.ID)
+
+			signed, err := jwt.New(key.Method()).SignedString(key.PrivateKey)
+			require.NoError(t, err)
+
+			_, err = jwt.Parse(signed, func(*jwt.Token) (interface{}, error) {
+				return key.PublicKey(), nil
+			})
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestParsePrivateKeyRejectsInvalidInput(t *testing.T) {
+	_, err := ParsePrivateKey([]byte("not a pem"))
+	assert.Error(t, err)
+
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	_, err = ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(weak)}))
+	assert.Error(t, err)
+}
+
+func TestJWKPublicKeyRoundTrip(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	for _, private := range []crypto.Signer{rsaKey, edKey} {
+		key, err := newSigningKey(private)
+		require.NoError(t, err)
+
+		public, err := key.JWK().PublicKey()
+		require.NoError(t, err)
+		assert.Equal(t, key.PublicKey(), public)
+	}
+
+	_, err = JWK{Kty: "EC", Crv: "P-256"}.PublicKey()
+	assert.Error(t, err)
+	_, err = JWK{Kty: "OKP", Crv: "Ed25519", X: "c2hvcnQ"}.PublicKey()
+	assert.Error(t, err)
+}
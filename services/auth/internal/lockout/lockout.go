@@ -0,0 +1,144 @@
+package lockout
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Store counts failed login attempts and holds lockouts. Implementations must
+// be shared by all replicas, otherwise an attacker can spread guesses across them.
+type Store interface {
+	// RecordFailure counts a failed attempt for key and returns the number of
+	// failures since the last reset. The count is forgotten after window
+	// passes without a failure.
+	RecordFailure(key string, window time.Duration) (int, error)
+	// Lock rejects attempts for key until the given time
+	Lock(key string, until time.Time) error
+	// LockedUntil returns when the lock on key ends, or the zero time if there is none
+	LockedUntil(key string) (time.Time, error)
+	// Reset forgets the failures and lock of key
+	Reset(key string) error
+	// PurgeExpired removes counters and locks that no longer have an effect
+	PurgeExpired() error
+}
+
+// Policy describes when failures start locking a key and for how long
+type Policy struct {
+	// FreeAttempts is the number of failures tolerated before the first lockout
+	FreeAttempts int
+	// BaseDelay is the first lockout, every further failure doubles it
+	BaseDelay time.Duration
+	// MaxDelay caps the lockout
+	MaxDelay time.Duration
+	// Window is how long failures are remembered
+	Window time.Duration
+}
+
+// Delay returns the lockout that follows the given number of failures
+func (p Policy) Delay(failures int) time.Duration {
+	if failures < p.FreeAttempts {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeAttempts; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// LockedError is returned while login attempts are locked out
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, retry after %s", e.RetryAfter)
+}
+
+// Guard tracks failed logins per account and per client IP. The IP policy is
+// meant to be looser, it catches one client guessing across many accounts.
+type Guard struct {
+	store   Store
+	account Policy
+	ip      Policy
+}
+
+func NewGuard(store Store, account, ip Policy) *Guard {
+	return &Guard{store: store, account: account, ip: ip}
+}
+
+// Check returns a *LockedError if the account or IP is locked out. Store
+// errors are logged and let the attempt through, so an unavailable store
+// does not lock everyone out.
+func (g *Guard) Check(email, ip string) error {
+	var lockedUntil time.Time
+	for _, key := range g.keys(email, ip) {
+		until, err := g.store.LockedUntil(key)
+		if err != nil {
+			slog.Error("Failed to check login lockout", "error", err)
+			return nil
+		}
+		if until.After(lockedUntil) {
+			lockedUntil = until
+		}
+	}
+
+	return lockedError(lockedUntil)
+}
+
+// RecordFailure counts a failed attempt and locks the account or IP once
+// their policy says so. It returns a *LockedError if a lock is now in place.
+func (g *Guard) RecordFailure(email, ip string) error {
+	var lockedUntil time.Time
+	policies := []Policy{g.account, g.ip}
+	for i, key := range g.keys(email, ip) {
+		policy := policies[i]
+		failures, err := g.store.RecordFailure(key, policy.Window)
+		if err != nil {
+			slog.Error("Failed to record failed login", "error", err)
+			continue
+		}
+
+		delay := policy.Delay(failures)
+		if delay == 0 {
+			continue
+		}
+		until := time.Now().Add(delay)
+		if err := g.store.Lock(key, until); err != nil {
+			slog.Error("Failed to lock out login", "error", err)
+			continue
+		}
+		if until.After(lockedUntil) {
+			lockedUntil = until
+		}
+	}
+
+	return lockedError(lockedUntil)
+}
+
+// RecordSuccess clears the account's failures. The IP counter is kept so a
+// client can't reset it by logging into an account of its own.
+func (g *Guard) RecordSuccess(email string) {
+	if err := g.store.Reset(accountKey(email)); err != nil {
+		slog.Error("Failed to reset login failures", "error", err)
+	}
+}
+
+func (g *Guard) keys(email, ip string) []string {
+	return []string{accountKey(email), "ip:" + ip}
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func lockedError(until time.Time) error {
+	retryAfter := time.Until(until)
+	if retryAfter <= 0 {
+		return nil
+	}
+	return &LockedError{RetryAfter: retryAfter}
+}
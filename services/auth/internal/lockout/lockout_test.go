@@ -0,0 +1,95 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestGuard(t *testing.T, account, ip Policy) (*Guard, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewGuard(NewRedisStore(client), account, ip), mr
+}
+
+func TestPolicyDelay(t *testing.T) {
+	policy := Policy{FreeAttempts: 3, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	tests := []struct {
+		failures int
+		delay    time.Duration
+	}{
+		{1, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{5, 4 * time.Second},
+		{6, 8 * time.Second},
+		{7, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.delay, policy.Delay(tt.failures), "failures=%d", tt.failures)
+	}
+}
+
+func TestGuardLocksAccountAfterFreeAttempts(t *testing.T) {
+	account := Policy{FreeAttempts: 2, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+	ip := Policy{FreeAttempts: 100, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+	guard, _ := newTestGuard(t, account, ip)
+
+	assert.NoError(t, guard.RecordFailure("User@Example.com", "10.0.0.1"))
+	assert.NoError(t, guard.Check("user@example.com", "10.0.0.1"))
+
+	err := guard.RecordFailure("user@example.com", "10.0.0.2")
+	var locked *LockedError
+	require.ErrorAs(t, err, &locked)
+	assert.InDelta(t, time.Minute.Seconds(), locked.RetryAfter.Seconds(), 1)
+
+	// The lock applies to the account from any address, but not to other accounts
+	require.ErrorAs(t, guard.Check("user@example.com", "10.0.0.3"), &locked)
+	assert.NoError(t, guard.Check("other@example.com", "10.0.0.1"))
+}
+
+func TestGuardLocksIPAcrossAccounts(t *testing.T) {
+	account := Policy{FreeAttempts: 100, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+	ip := Policy{FreeAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+	guard, _ := newTestGuard(t, account, ip)
+
+	assert.NoError(t, guard.RecordFailure("a@example.com", "10.0.0.1"))
+	assert.NoError(t, guard.RecordFailure("b@example.com", "10.0.0.1"))
+	assert.Error(t, guard.RecordFailure("c@example.com", "10.0.0.1"))
+
+	var locked *LockedError
+	assert.ErrorAs(t, guard.Check("d@example.com", "10.0.0.1"), &locked)
+	assert.NoError(t, guard.Check("d@example.com", "10.0.0.2"))
+}
+
+func TestGuardRecordSuccessResetsAccount(t *testing.T) {
+	account := Policy{FreeAttempts: 2, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+	ip := Policy{FreeAttempts: 100, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+	guard, _ := newTestGuard(t, account, ip)
+
+	assert.NoError(t, guard.RecordFailure("user@example.com", "10.0.0.1"))
+	guard.RecordSuccess("user@example.com")
+
+	// The counter starts over, so one more failure is still free
+	assert.NoError(t, guard.RecordFailure("user@example.com", "10.0.0.1"))
+}
+
+func TestGuardForgetsFailuresAfterWindow(t *testing.T) {
+	account := Policy{FreeAttempts: 2, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: 10 * time.Minute}
+	ip := Policy{FreeAttempts: 100, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: 10 * time.Minute}
+	guard, mr := newTestGuard(t, account, ip)
+
+	assert.NoError(t, guard.RecordFailure("user@example.com", "10.0.0.1"))
+	mr.FastForward(11 * time.Minute)
+	assert.NoError(t, guard.RecordFailure("user@example.com", "10.0.0.1"))
+}
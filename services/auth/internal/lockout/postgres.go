@@ -0,0 +1,75 @@
+package lockout
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) RecordFailure(key string, window time.Duration) (int, error) {
+	query := `
+		INSERT INTO login_attempts (key, failures, expires_at)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE
+		SET failures = CASE WHEN login_attempts.expires_at <= NOW() THEN 1 ELSE login_attempts.failures + 1 END,
+			expires_at = EXCLUDED.expires_at
+		RETURNING failures`
+
+	var failures int
+	if err := s.db.QueryRow(query, key, time.Now().Add(window)).Scan(&failures); err != nil {
+		return 0, fmt.Errorf("failed to record login failure: %w", err)
+	}
+
+	return failures, nil
+}
+
+func (s *PostgresStore) Lock(key string, until time.Time) error {
+	query := `UPDATE login_attempts SET locked_until = $2 WHERE key = $1`
+
+	if _, err := s.db.Exec(query, key, until); err != nil {
+		return fmt.Errorf("failed to lock login: %w", err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) LockedUntil(key string) (time.Time, error) {
+	var lockedUntil sql.NullTime
+	query := `SELECT locked_until FROM login_attempts WHERE key = $1`
+
+	err := s.db.QueryRow(query, key).Scan(&lockedUntil)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("failed to get login lockout: %w", err)
+	}
+
+	return lockedUntil.Time, nil
+}
+
+func (s *PostgresStore) Reset(key string) error {
+	if _, err := s.db.Exec(`DELETE FROM login_attempts WHERE key = $1`, key); err != nil {
+		return fmt.Errorf("failed to reset login failures: %w", err)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) PurgeExpired() error {
+	query := `DELETE FROM login_attempts WHERE expires_at <= NOW() AND (locked_until IS NULL OR locked_until <= NOW())`
+
+	if _, err := s.db.Exec(query); err != nil {
+		return fmt.Errorf("failed to purge login attempts: %w", err)
+	}
+
+	return nil
+}
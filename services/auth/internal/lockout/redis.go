@@ -0,0 +1,85 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	failuresKeyPrefix = "lockout:failures:"
+	lockKeyPrefix     = "lockout:lock:"
+)
+
+// RedisStore keeps login counters in Redis and lets key expiry do the cleanup
+type RedisStore struct {
+	client  *redis.Client
+	timeout time.Duration
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client, timeout: 2 * time.Second}
+}
+
+func (s *RedisStore) RecordFailure(key string, window time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	pipe := s.client.TxPipeline()
+	incr := pipe.Incr(ctx, failuresKeyPrefix+key)
+	pipe.PExpire(ctx, failuresKeyPrefix+key, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to record login failure: %w", err)
+	}
+
+	return int(incr.Val()), nil
+}
+
+func (s *RedisStore) Lock(key string, until time.Time) error {
+	ttl := time.Until(until)
+	if ttl <= 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	if err := s.client.Set(ctx, lockKeyPrefix+key, until.UnixNano(), ttl).Err(); err != nil {
+		return fmt.Errorf("failed to lock login: %w", err)
+	}
+
+	return nil
+}
+
+func (s *RedisStore) LockedUntil(key string) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	value, err := s.client.Get(ctx, lockKeyPrefix+key).Int64()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("failed to get login lockout: %w", err)
+	}
+
+	return time.Unix(0, value), nil
+}
+
+func (s *RedisStore) Reset(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	if err := s.client.Del(ctx, failuresKeyPrefix+key, lockKeyPrefix+key).Err(); err != nil {
+		return fmt.Errorf("failed to reset login failures: %w", err)
+	}
+
+	return nil
+}
+
+func (s *RedisStore) PurgeExpired() error {
+	return nil
+}
@@ -1,15 +1,23 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/pseudoerr/auth-service/internal/denylist"
+	"github.com/pseudoerr/auth-service/internal/keys"
+	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/pseudoerr/auth-service/internal/repository"
 )
 
 func LoggingMiddleware(next http.Handler) http.Handler {
@@ -34,20 +42,32 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// CORSMiddleware handles CORS headers
-func CORSMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*") // TODO: Configure for production
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+// CORSMiddleware handles CORS headers. Only the frontend at appOrigin may
+// send credentials, which the cookie mode needs; other origins get the
+// wildcard, for which browsers never send cookies.
+func CORSMiddleware(appOrigin string) func(http.Handler) http.Handler {
+	appOrigin = strings.TrimSuffix(appOrigin, "/")
 
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Origin")
+			if origin := r.Header.Get("Origin"); origin != "" && origin == appOrigin {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-CSRF-Token, X-Token-Transport")
 
-		next.ServeHTTP(w, r)
-	})
+			if r.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
 // PanicRecoveryMiddleware recovers from panics
@@ -72,10 +92,110 @@ func PanicRecoveryMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// JWTMiddleware validates JWT tokens
-func JWTMiddleware(jwtSecret string) func(http.Handler) http.Handler {
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// RateLimitMiddleware limits every client IP to rps requests per second with
+// bursts of up to burst requests. Buckets live in memory, so the limit is per
+// instance; login attempts are additionally throttled by the lockout guard.
+func RateLimitMiddleware(rps, burst int) func(http.Handler) http.Handler {
+	var mu sync.Mutex
+	buckets := make(map[string]*bucket)
+
+	// Forget clients that have been quiet long enough to have a full bucket
+	go func() {
+		for range time.Tick(time.Minute) {
+			mu.Lock()
+			for ip, b := range buckets {
+				if time.Since(b.lastSeen) > time.Minute {
+					delete(buckets, ip)
+				}
+			}
+			mu.Unlock()
+		}
+	}()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				ip = r.RemoteAddr
+			}
+
+			now := time.Now()
+			mu.Lock()
+			b, ok := buckets[ip]
+			if !ok {
+				b = &bucket{tokens: float64(burst), lastSeen: now}
+				buckets[ip] = b
+			}
+			b.tokens = min(float64(burst), b.tokens+now.Sub(b.lastSeen).Seconds()*float64(rps))
+			b.lastSeen = now
+			allowed := b.tokens >= 1
+			if allowed {
+				b.tokens--
+			}
+			mu.Unlock()
+
+			if !allowed {
+				w.Header().Set("Retry-After", "1")
+				writeJSONError(w, http.StatusTooManyRequests, "Too many requests")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// UserStatus reports whether an administrator disabled an account. It fails
+// with repository.ErrUserNotFound once the account was deleted.
+type UserStatus interface {
+	IsDisabled(userID int) (bool, error)
+}
+
+// Credentials resolves the bearer credentials that are not user access
+// tokens: personal access tokens and the clients behind service tokens
+type Credentials interface {
+	AuthenticatePersonalAccessToken(token string) (*models.TokenIdentity, error)
+	IsClientActive(clientID string) (bool, error)
+}
+
+type contextKey int
+
+// sessionIDKey holds the session of an access token in the request context
+const sessionIDKey contextKey = iota
+
+// SessionID returns the session of the access token JWTMiddleware admitted,
+// or "" for tokens that don't belong to a session
+func SessionID(ctx context.Context) string {
+	sessionID, _ := ctx.Value(sessionIDKey).(string)
+	return sessionID
+}
+
+// Token types reported in the X-Token-Type header
+const (
+	TokenTypeAccess    = models.TokenUseAccess
+	TokenTypePersonal  = models.TokenUsePersonal
+	TokenTypeService   = models.TokenUseService
+	TokenTypeDelegated = models.TokenUseDelegated
+)
+
+// JWTMiddleware validates JWT tokens against the keyring key named by their kid
+// header and rejects tokens that were revoked through the denylist or belong
+// to a disabled account. Personal access tokens, recognized by their prefix,
+// are looked up instead and set the same headers, without a session. Service
+// tokens set X-Client-ID and X-User-Permissions but no user. Delegated tokens,
+// which an application holds for a user, set both the user and X-Client-ID.
+func JWTMiddleware(keyring *keys.Keyring, revoked denylist.Store, users UserStatus,
+	credentials Credentials) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Only service and delegated tokens name a client
+			r.Header.Del("X-Client-ID")
+
 			authHeader := r.Header.Get("Authorization")
 			if authHeader == "" {
 				writeJSONError(w, http.StatusUnauthorized, "Authorization header is required")
@@ -88,12 +208,36 @@ func JWTMiddleware(jwtSecret string) func(http.Handler) http.Handler {
 				return
 			}
 
-			token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-				if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-					return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			if strings.HasPrefix(tokenString, models.PersonalAccessTokenPrefix) {
+				identity, err := credentials.AuthenticatePersonalAccessToken(tokenString)
+				switch {
+				case errors.Is(err, repository.ErrPersonalAccessTokenNotFound), errors.Is(err, repository.ErrUserNotFound):
+					writeJSONError(w, http.StatusUnauthorized, "Invalid token")
+					return
+				case err != nil:
+					slog.Error("Failed to look up personal access token", "error", err)
+					writeJSONError(w, http.StatusServiceUnavailable, "Unable to validate token")
+					return
+				}
+				if !checkUserEnabled(w, users, identity.UserID) {
+					return
 				}
-				return []byte(jwtSecret), nil
-			})
+
+				r.Header.Set("X-Token-Type", TokenTypePersonal)
+				r.Header.Set("X-User-ID", strconv.Itoa(identity.UserID))
+				r.Header.Set("X-Token-ID", "")
+				r.Header.Set("X-Token-Expires-At", strconv.FormatInt(identity.ExpiresAt.Unix(), 10))
+				r.Header.Set("X-User-Email", identity.Email)
+				r.Header.Set("X-User-Username", identity.Username)
+				r.Header.Set("X-User-Verified", strconv.FormatBool(identity.Verified))
+				r.Header.Set("X-User-Roles", strings.Join(identity.Roles, " "))
+				r.Header.Set("X-User-Permissions", strings.Join(identity.Permissions, " "))
+
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			token, err := jwt.Parse(tokenString, keyring.Keyfunc, jwt.WithValidMethods(keys.ValidMethods))
 
 			if err != nil || !token.Valid {
 				slog.Error("Invalid JWT token", "error", err)
@@ -107,22 +251,215 @@ func JWTMiddleware(jwtSecret string) func(http.Handler) http.Handler {
 				return
 			}
 
-			// Extract user information and add to request headers
-			if userID, ok := claims["user_id"].(float64); ok {
-				r.Header.Set("X-User-ID", strconv.Itoa(int(userID)))
+			if tokenUse, _ := claims["token_use"].(string); tokenUse == models.TokenUseService {
+				serveServiceToken(w, r, next, claims, revoked, credentials)
+				return
 			}
+
+			// Other tokens signed by this service, like MFA challenges, are not access tokens
+			tokenUse, _ := claims["token_use"].(string)
+			if tokenUse != models.TokenUseAccess && tokenUse != models.TokenUseDelegated {
+				writeJSONError(w, http.StatusUnauthorized, "Invalid token type")
+				return
+			}
+
+			jti, _ := claims["jti"].(string)
+			userID, _ := claims["user_id"].(float64)
+			issuedAt, hasIssuedAt := keys.IssuedAt(claims)
+			expiresAt, _ := claims.GetExpirationTime()
+			if jti == "" || userID == 0 || !hasIssuedAt || expiresAt == nil {
+				writeJSONError(w, http.StatusUnauthorized, "Invalid token claims")
+				return
+			}
+
+			isRevoked, err := denylist.IsRevoked(revoked, jti, int(userID), issuedAt)
+			if err != nil {
+				slog.Error("Failed to check token denylist", "error", err)
+				writeJSONError(w, http.StatusServiceUnavailable, "Unable to validate token")
+				return
+			}
+			if isRevoked {
+				writeJSONError(w, http.StatusUnauthorized, "Token has been revoked")
+				return
+			}
+
+			if !checkUserEnabled(w, users, int(userID)) {
+				return
+			}
+
+			// A delegated token dies with the application it was issued to
+			if tokenUse == models.TokenUseDelegated {
+				clientID, _ := claims["client_id"].(string)
+				if clientID == "" {
+					writeJSONError(w, http.StatusUnauthorized, "Invalid token claims")
+					return
+				}
+				active, err := credentials.IsClientActive(clientID)
+				if err != nil {
+					slog.Error("Failed to check client status", "error", err)
+					writeJSONError(w, http.StatusServiceUnavailable, "Unable to validate token")
+					return
+				}
+				if !active {
+					writeJSONError(w, http.StatusUnauthorized, "Client has been revoked")
+					return
+				}
+				r.Header.Set("X-Client-ID", clientID)
+			}
+
+			// Extract user information and add to request headers
+			r.Header.Set("X-Token-Type", tokenUse)
+			r.Header.Set("X-User-ID", strconv.Itoa(int(userID)))
+			r.Header.Set("X-Token-ID", jti)
+			r.Header.Set("X-Token-Expires-At", strconv.FormatInt(expiresAt.Unix(), 10))
+			// Delegated tokens carry no email
+			r.Header.Del("X-User-Email")
+			r.Header.Del("X-User-Username")
 			if email, ok := claims["email"].(string); ok {
 				r.Header.Set("X-User-Email", email)
 			}
 			if username, ok := claims["username"].(string); ok {
 				r.Header.Set("X-User-Username", username)
 			}
+			verified, _ := claims["verified"].(bool)
+			r.Header.Set("X-User-Verified", strconv.FormatBool(verified))
+			r.Header.Set("X-User-Roles", strings.Join(claimStrings(claims, "roles"), " "))
+			r.Header.Set("X-User-Permissions", strings.Join(claimStrings(claims, "permissions"), " "))
+
+			// The session stays out of the headers, where a client could set it
+			sessionID, _ := claims["sid"].(string)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionIDKey, sessionID)))
+		})
+	}
+}
 
+// checkUserEnabled answers the request itself and returns false when the
+// account is disabled, deleted or its status is unknown
+func checkUserEnabled(w http.ResponseWriter, users UserStatus, userID int) bool {
+	disabled, err := users.IsDisabled(userID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		writeJSONError(w, http.StatusUnauthorized, "Invalid token")
+		return false
+	}
+	if err != nil {
+		slog.Error("Failed to check account status", "error", err)
+		writeJSONError(w, http.StatusServiceUnavailable, "Unable to validate token")
+		return false
+	}
+	if disabled {
+		writeJSONError(w, http.StatusForbidden, "Account is disabled")
+		return false
+	}
+	return true
+}
+
+// serveServiceToken admits a token a client obtained for itself, unless it
+// was revoked or the client was
+func serveServiceToken(w http.ResponseWriter, r *http.Request, next http.Handler, claims jwt.MapClaims,
+	revoked denylist.Store, credentials Credentials) {
+	jti, _ := claims["jti"].(string)
+	clientID, _ := claims["client_id"].(string)
+	expiresAt, _ := claims.GetExpirationTime()
+	if jti == "" || clientID == "" || expiresAt == nil {
+		writeJSONError(w, http.StatusUnauthorized, "Invalid token claims")
+		return
+	}
+
+	isRevoked, err := revoked.IsTokenRevoked(jti)
+	if err != nil {
+		slog.Error("Failed to check token denylist", "error", err)
+		writeJSONError(w, http.StatusServiceUnavailable, "Unable to validate token")
+		return
+	}
+	if isRevoked {
+		writeJSONError(w, http.StatusUnauthorized, "Token has been revoked")
+		return
+	}
+
+	active, err := credentials.IsClientActive(clientID)
+	if err != nil {
+		slog.Error("Failed to check client status", "error", err)
+		writeJSONError(w, http.StatusServiceUnavailable, "Unable to validate token")
+		return
+	}
+	if !active {
+		writeJSONError(w, http.StatusUnauthorized, "Client has been revoked")
+		return
+	}
+
+	r.Header.Set("X-Token-Type", TokenTypeService)
+	r.Header.Set("X-Client-ID", clientID)
+	r.Header.Set("X-User-ID", "")
+	r.Header.Set("X-Token-ID", jti)
+	r.Header.Set("X-Token-Expires-At", strconv.FormatInt(expiresAt.Unix(), 10))
+	r.Header.Del("X-User-Email")
+	r.Header.Del("X-User-Username")
+	r.Header.Set("X-User-Verified", "false")
+	r.Header.Set("X-User-Roles", "")
+	r.Header.Set("X-User-Permissions", strings.Join(claimStrings(claims, "permissions"), " "))
+
+	next.ServeHTTP(w, r)
+}
+
+// RequireUserToken only admits the tokens users hold themselves, access
+// tokens and personal access tokens, for routes that act on behalf of a
+// user. Service tokens are rejected, and so are delegated tokens: their
+// scopes are for other services and grant nothing like the user's profile.
+// It must be mounted behind JWTMiddleware.
+func RequireUserToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("X-Token-Type") {
+		case TokenTypeAccess, TokenTypePersonal:
 			next.ServeHTTP(w, r)
+		default:
+			writeJSONError(w, http.StatusForbidden, "A user token is required")
+		}
+	})
+}
+
+// RequireSessionToken only admits access tokens of a signed-in session, so
+// personal access tokens, service tokens and delegated tokens can't be used
+// to manage the account itself. It must be mounted behind JWTMiddleware.
+func RequireSessionToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token-Type") != TokenTypeAccess {
+			writeJSONError(w, http.StatusForbidden, "A signed-in session is required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequirePermission rejects requests whose access token does not grant the
+// permission. It must be mounted behind JWTMiddleware, which sets the
+// X-User-Permissions header from the token claims.
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, granted := range strings.Fields(r.Header.Get("X-User-Permissions")) {
+				if granted == permission {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			writeJSONError(w, http.StatusForbidden, "Insufficient permissions")
 		})
 	}
 }
 
+// claimStrings reads a claim holding a list of strings
+func claimStrings(claims jwt.MapClaims, name string) []string {
+	values, _ := claims[name].([]interface{})
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if str, ok := value.(string); ok {
+			result = append(result, str)
+		}
+	}
+	return result
+}
+
 // responseWriter wraps http.ResponseWriter to capture status code
 type responseWriter struct {
 	http.ResponseWriter
@@ -0,0 +1,503 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pseudoerr/auth-service/internal/denylist"
+	"github.com/pseudoerr/auth-service/internal/keys"
+	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/pseudoerr/auth-service/internal/repository"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeUsers struct {
+	disabled map[int]bool
+	err      error
+}
+
+func (f *fakeUsers) IsDisabled(userID int) (bool, error) {
+	return f.disabled[userID], f.err
+}
+
+type fakeCredentials struct {
+	tokens   map[string]*models.TokenIdentity
+	inactive map[string]bool
+}
+
+func (f *fakeCredentials) AuthenticatePersonalAccessToken(token string) (*models.TokenIdentity, error) {
+	identity, ok := f.tokens[token]
+	if !ok {
+		return nil, repository.ErrPersonalAccessTokenNotFound
+	}
+	return identity, nil
+}
+
+func (f *fakeCredentials) IsClientActive(clientID string) (bool, error) {
+	return !f.inactive[clientID], nil
+}
+
+type jwtFixture struct {
+	key         *keys.SigningKey
+	keyring     *keys.Keyring
+	revoked     *denylist.RedisStore
+	users       *fakeUsers
+	credentials *fakeCredentials
+}
+
+func newJWTFixture(t *testing.T) *jwtFixture {
+	t.Helper()
+	key, err := keys.GenerateEd25519()
+	require.NoError(t, err)
+	keyring := keys.NewKeyring()
+	keyring.Set(key, nil, nil)
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return &jwtFixture{
+		key:     key,
+		keyring: keyring,
+		revoked: denylist.NewRedisStore(client),
+		users:   &fakeUsers{disabled: map[int]bool{}},
+		credentials: &fakeCredentials{
+			tokens: map[string]*models.TokenIdentity{
+				models.PersonalAccessTokenPrefix + "valid": {
+					UserID: 7, Email: "ann@example.com", Username: "ann", Verified: true,
+					Roles: []string{"user"}, Permissions: []string{"missions:read"},
+					ExpiresAt: time.Now().Add(time.Hour),
+				},
+			},
+			inactive: map[string]bool{},
+		},
+	}
+}
+
+func (f *jwtFixture) sign(t *testing.T, key *keys.SigningKey, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(key.Method(), claims)
+	token.Header["kid"] = key.ID
+	signed, err := token.SignedString(key.PrivateKey)
+	require.NoError(t, err)
+	return signed
+}
+
+func accessClaims(tokenUse string) jwt.MapClaims {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"user_id":     7,
+		"jti":         "jti-1",
+		"iat":         keys.IssuedAtClaim(now),
+		"exp":         now.Add(15 * time.Minute).Unix(),
+		"token_use":   tokenUse,
+		"roles":       []string{"user"},
+		"permissions": []string{"missions:read", "missions:write"},
+	}
+	switch tokenUse {
+	case models.TokenUseAccess:
+		claims["sid"] = "session-1"
+		claims["email"] = "ann@example.com"
+		claims["username"] = "ann"
+		claims["verified"] = true
+	case models.TokenUseDelegated:
+		claims["client_id"] = "ide-plugin"
+	}
+	return claims
+}
+
+func serviceClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"jti":         "jti-2",
+		"client_id":   "reporting",
+		"iat":         keys.IssuedAtClaim(time.Now()),
+		"exp":         time.Now().Add(15 * time.Minute).Unix(),
+		"token_use":   models.TokenUseService,
+		"permissions": []string{"missions:read"},
+	}
+}
+
+func TestJWTMiddleware(t *testing.T) {
+	tests := []struct {
+		name string
+		// authorization builds the Authorization header
+		authorization func(t *testing.T, f *jwtFixture) string
+		setup         func(t *testing.T, f *jwtFixture)
+		status        int
+		headers       map[string]string
+		sessionID     string
+	}{
+		{
+			name:          "missing header",
+			authorization: func(t *testing.T, f *jwtFixture) string { return "" },
+			status:        http.StatusUnauthorized,
+		},
+		{
+			name:          "not a bearer token",
+			authorization: func(t *testing.T, f *jwtFixture) string { return "Basic YW5uOnNlY3JldA==" },
+			status:        http.StatusUnauthorized,
+		},
+		{
+			name:          "malformed token",
+			authorization: func(t *testing.T, f *jwtFixture) string { return "Bearer not-a-jwt" },
+			status:        http.StatusUnauthorized,
+		},
+		{
+			name: "valid access token",
+			authorization: func(t *testing.T, f *jwtFixture) string {
+				return "Bearer " + f.sign(t, f.key, accessClaims(models.TokenUseAccess))
+			},
+			status: http.StatusOK,
+			headers: map[string]string{
+				"X-Token-Type":       TokenTypeAccess,
+				"X-User-ID":          "7",
+				"X-Token-ID":         "jti-1",
+				"X-User-Email":       "ann@example.com",
+				"X-User-Username":    "ann",
+				"X-User-Verified":    "true",
+				"X-User-Roles":       "user",
+				"X-User-Permissions": "missions:read missions:write",
+				"X-Client-ID":        "",
+			},
+			sessionID: "session-1",
+		},
+		{
+			name: "signed by an unknown key",
+			authorization: func(t *testing.T, f *jwtFixture) string {
+				other, err := keys.GenerateEd25519()
+				require.NoError(t, err)
+				return "Bearer " + f.sign(t, other, accessClaims(models.TokenUseAccess))
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "expired",
+			authorization: func(t *testing.T, f *jwtFixture) string {
+				claims := accessClaims(models.TokenUseAccess)
+				claims["exp"] = time.Now().Add(-time.Minute).Unix()
+				return "Bearer " + f.sign(t, f.key, claims)
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "MFA challenge",
+			authorization: func(t *testing.T, f *jwtFixture) string {
+				return "Bearer " + f.sign(t, f.key, accessClaims("mfa_challenge"))
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "missing jti",
+			authorization: func(t *testing.T, f *jwtFixture) string {
+				claims := accessClaims(models.TokenUseAccess)
+				delete(claims, "jti")
+				return "Bearer " + f.sign(t, f.key, claims)
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "revoked token",
+			authorization: func(t *testing.T, f *jwtFixture) string {
+				return "Bearer " + f.sign(t, f.key, accessClaims(models.TokenUseAccess))
+			},
+			setup: func(t *testing.T, f *jwtFixture) {
+				require.NoError(t, f.revoked.RevokeToken("jti-1", time.Now().Add(time.Hour)))
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "issued before the user's tokens were revoked",
+			authorization: func(t *testing.T, f *jwtFixture) string {
+				claims := accessClaims(models.TokenUseAccess)
+				claims["iat"] = keys.IssuedAtClaim(time.Now().Add(-time.Minute))
+				return "Bearer " + f.sign(t, f.key, claims)
+			},
+			setup: func(t *testing.T, f *jwtFixture) {
+				now := time.Now()
+				require.NoError(t, f.revoked.RevokeUserTokens(7, now, now.Add(time.Hour)))
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "disabled account",
+			authorization: func(t *testing.T, f *jwtFixture) string {
+				return "Bearer " + f.sign(t, f.key, accessClaims(models.TokenUseAccess))
+			},
+			setup:  func(t *testing.T, f *jwtFixture) { f.users.disabled[7] = true },
+			status: http.StatusForbidden,
+		},
+		{
+			name: "account status unavailable",
+			authorization: func(t *testing.T, f *jwtFixture) string {
+				return "Bearer " + f.sign(t, f.key, accessClaims(models.TokenUseAccess))
+			},
+			setup:  func(t *testing.T, f *jwtFixture) { f.users.err = errors.New("connection refused") },
+			status: http.StatusServiceUnavailable,
+		},
+		{
+			name: "deleted account",
+			authorization: func(t *testing.T, f *jwtFixture) string {
+				return "Bearer " + f.sign(t, f.key, accessClaims(models.TokenUseAccess))
+			},
+			setup:  func(t *testing.T, f *jwtFixture) { f.users.err = repository.ErrUserNotFound },
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "delegated token",
+			authorization: func(t *testing.T, f *jwtFixture) string {
+				return "Bearer " + f.sign(t, f.key, accessClaims(models.TokenUseDelegated))
+			},
+			status: http.StatusOK,
+			headers: map[string]string{
+				"X-Token-Type":    TokenTypeDelegated,
+				"X-User-ID":       "7",
+				"X-Client-ID":     "ide-plugin",
+				"X-User-Email":    "",
+				"X-User-Verified": "false",
+			},
+		},
+		{
+			name: "delegated token of a revoked client",
+			authorization: func(t *testing.T, f *jwtFixture) string {
+				return "Bearer " + f.sign(t, f.key, accessClaims(models.TokenUseDelegated))
+			},
+			setup:  func(t *testing.T, f *jwtFixture) { f.credentials.inactive["ide-plugin"] = true },
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "service token",
+			authorization: func(t *testing.T, f *jwtFixture) string {
+				return "Bearer " + f.sign(t, f.key, serviceClaims())
+			},
+			status: http.StatusOK,
+			headers: map[string]string{
+				"X-Token-Type":       TokenTypeService,
+				"X-User-ID":          "",
+				"X-Client-ID":        "reporting",
+				"X-Token-ID":         "jti-2",
+				"X-User-Roles":       "",
+				"X-User-Permissions": "missions:read",
+			},
+		},
+		{
+			name: "service token of a revoked client",
+			authorization: func(t *testing.T, f *jwtFixture) string {
+				return "Bearer " + f.sign(t, f.key, serviceClaims())
+			},
+			setup:  func(t *testing.T, f *jwtFixture) { f.credentials.inactive["reporting"] = true },
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "revoked service token",
+			authorization: func(t *testing.T, f *jwtFixture) string {
+				return "Bearer " + f.sign(t, f.key, serviceClaims())
+			},
+			setup: func(t *testing.T, f *jwtFixture) {
+				require.NoError(t, f.revoked.RevokeToken("jti-2", time.Now().Add(time.Hour)))
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "personal access token",
+			authorization: func(t *testing.T, f *jwtFixture) string {
+				return "Bearer " + models.PersonalAccessTokenPrefix + "valid"
+			},
+			status: http.StatusOK,
+			headers: map[string]string{
+				"X-Token-Type":       TokenTypePersonal,
+				"X-User-ID":          "7",
+				"X-Token-ID":         "",
+				"X-User-Email":       "ann@example.com",
+				"X-User-Permissions": "missions:read",
+				"X-Client-ID":        "",
+			},
+		},
+		{
+			name: "unknown personal access token",
+			authorization: func(t *testing.T, f *jwtFixture) string {
+				return "Bearer " + models.PersonalAccessTokenPrefix + "unknown"
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "personal access token of a disabled account",
+			authorization: func(t *testing.T, f *jwtFixture) string {
+				return "Bearer " + models.PersonalAccessTokenPrefix + "valid"
+			},
+			setup:  func(t *testing.T, f *jwtFixture) { f.users.disabled[7] = true },
+			status: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newJWTFixture(t)
+			if tt.setup != nil {
+				tt.setup(t, f)
+			}
+
+			var admitted *http.Request
+			handler := JWTMiddleware(f.keyring, f.revoked, f.users, f.credentials)(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { admitted = r }))
+
+			req := httptest.NewRequest(http.MethodGet, "/me", nil)
+			if auth := tt.authorization(t, f); auth != "" {
+				req.Header.Set("Authorization", auth)
+			}
+			// Headers a client sets must never survive
+			req.Header.Set("X-Client-ID", "spoofed")
+			req.Header.Set("X-Session-ID", "spoofed")
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.status, rec.Code, rec.Body.String())
+			if tt.status != http.StatusOK {
+				assert.Nil(t, admitted)
+				return
+			}
+			require.NotNil(t, admitted)
+			for name, want := range tt.headers {
+				assert.Equal(t, want, admitted.Header.Get(name), name)
+			}
+			assert.Equal(t, tt.sessionID, SessionID(admitted.Context()))
+		})
+	}
+}
+
+func TestRequireTokenType(t *testing.T) {
+	tests := []struct {
+		tokenType   string
+		userToken   bool
+		userSession bool
+	}{
+		{TokenTypeAccess, true, true},
+		{TokenTypePersonal, true, false},
+		{TokenTypeDelegated, false, false},
+		{TokenTypeService, false, false},
+		{"", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tokenType, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+			for _, check := range []struct {
+				middleware func(http.Handler) http.Handler
+				admitted   bool
+			}{
+				{RequireUserToken, tt.userToken},
+				{RequireSessionToken, tt.userSession},
+			} {
+				req := httptest.NewRequest(http.MethodGet, "/me", nil)
+				req.Header.Set("X-Token-Type", tt.tokenType)
+				rec := httptest.NewRecorder()
+				check.middleware(next).ServeHTTP(rec, req)
+
+				if check.admitted {
+					assert.Equal(t, http.StatusOK, rec.Code)
+				} else {
+					assert.Equal(t, http.StatusForbidden, rec.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	tests := []struct {
+		name        string
+		permissions string
+		admitted    bool
+	}{
+		{"granted", "missions:read users:manage", true},
+		{"only permission", "users:manage", true},
+		{"missing", "missions:read missions:write", false},
+		{"prefix of another permission", "users:manage:all", false},
+		{"none", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := RequirePermission("users:manage")(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			req := httptest.NewRequest(http.MethodGet, "/admin/users", nil)
+			req.Header.Set("X-User-Permissions", tt.permissions)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if tt.admitted {
+				assert.Equal(t, http.StatusOK, rec.Code)
+			} else {
+				assert.Equal(t, http.StatusForbidden, rec.Code)
+			}
+		})
+	}
+}
+
+// Admin routes must not be reachable with a token that merely carries the
+// permission, like a personal access token or an application's delegated token
+func TestAdminRoutesRequireSession(t *testing.T) {
+	tests := []struct {
+		tokenType string
+		admitted  bool
+	}{
+		{TokenTypeAccess, true},
+		{TokenTypePersonal, false},
+		{TokenTypeDelegated, false},
+		{TokenTypeService, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tokenType, func(t *testing.T) {
+			handler := RequireUserToken(RequireSessionToken(RequirePermission("users:manage")(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))))
+
+			req := httptest.NewRequest(http.MethodGet, "/admin/users", nil)
+			req.Header.Set("X-Token-Type", tt.tokenType)
+			req.Header.Set("X-User-Permissions", "missions:read users:manage")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if tt.admitted {
+				assert.Equal(t, http.StatusOK, rec.Code)
+			} else {
+				assert.Equal(t, http.StatusForbidden, rec.Code)
+			}
+		})
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	tests := []struct {
+		name        string
+		origin      string
+		allowOrigin string
+		credentials string
+	}{
+		{"app origin", "https://app.example.com", "https://app.example.com", "true"},
+		{"other origin", "https://evil.example.com", "*", ""},
+		{"no origin", "", "*", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := CORSMiddleware("https://app.example.com/")(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			req := httptest.NewRequest(http.MethodPost, "/auth/refresh", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.allowOrigin, rec.Header().Get("Access-Control-Allow-Origin"))
+			assert.Equal(t, tt.credentials, rec.Header().Get("Access-Control-Allow-Credentials"))
+			assert.Contains(t, rec.Header().Values("Vary"), "Origin")
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package missions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnavailable is returned when the missions service can't be reached or
+// does not answer successfully
+var ErrUnavailable = errors.New("missions service unavailable")
+
+const (
+	// ScopeExport lets a service token export the missions data of any user
+	ScopeExport = "missions:export"
+
+	// maxExportSize bounds the export response read into memory
+	maxExportSize = 10 << 20
+)
+
+// Client calls the missions service with service tokens of the auth service
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+func NewClient(baseURL string, timeout time.Duration) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+// ExportUserData returns the missions data of a user. The service token must
+// carry ScopeExport.
+func (c *Client) ExportUserData(userID int, serviceToken string) (json.RawMessage, error) {
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+"/users/"+strconv.Itoa(userID)+"/export", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+serviceToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: unexpected status %d", ErrUnavailable, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxExportSize))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("%w: invalid JSON response", ErrUnavailable)
+	}
+
+	return body, nil
+}
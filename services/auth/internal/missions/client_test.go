@@ -0,0 +1,39 @@
+package missions
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportUserData(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/users/7/export", r.URL.Path)
+		assert.Equal(t, "Bearer service-token", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"missions":[{"id":"1","title":"FizzBuzz"}]}`))
+	}))
+	defer server.Close()
+
+	data, err := NewClient(server.URL+"/", time.Second).ExportUserData(7, "service-token")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"missions":[{"id":"1","title":"FizzBuzz"}]}`, string(data))
+}
+
+func TestExportUserDataUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	_, err := NewClient(server.URL, time.Second).ExportUserData(7, "service-token")
+	assert.ErrorIs(t, err, ErrUnavailable)
+
+	server.Close()
+	_, err = NewClient(server.URL, time.Second).ExportUserData(7, "service-token")
+	assert.ErrorIs(t, err, ErrUnavailable)
+}
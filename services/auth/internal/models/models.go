@@ -1,30 +1,141 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
+
+	"github.com/pseudoerr/auth-service/internal/oauth"
 )
 
 type User struct {
-	ID           int       `json:"id" postgres:"id"`
-	Email        string    `json:"email" postgres:"email"`
-	Username     string    `json:"username" postgres:"username"`
-	PasswordHash string    `json:"-" postgres:"password_hash"`
-	CreatedAt    time.Time `json:"created_at" postgres:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at" postgres:"updated_at"`
+	ID              int        `json:"id" postgres:"id"`
+	Email           string     `json:"email" postgres:"email"`
+	Username        string     `json:"username" postgres:"username"`
+	PasswordHash    string     `json:"-" postgres:"password_hash"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at" postgres:"email_verified_at"`
+	DisabledAt      *time.Time `json:"disabled_at,omitempty" postgres:"disabled_at"`
+	Roles           []string   `json:"roles,omitempty" postgres:"-"`
+	CreatedAt       time.Time  `json:"created_at" postgres:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at" postgres:"updated_at"`
+}
+
+func (u *User) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+func (u *User) Disabled() bool {
+	return u.DisabledAt != nil
+}
+
+// HasPassword is false for accounts that sign in with magic links only
+func (u *User) HasPassword() bool {
+	return u.PasswordHash != ""
 }
 
 type RefreshToken struct {
-	ID        int       `json:"id" postgres:"id"`
-	UserID    int       `json:"user_id" postgres:"user_id"`
-	Token     string    `json:"token" postgres:"token"`
-	ExpiresAt time.Time `json:"expires_at" postgres:"expires_at"`
-	CreatedAt time.Time `json:"created_at" postgres:"created_at"`
+	ID     int `json:"id" postgres:"id"`
+	UserID int `json:"user_id" postgres:"user_id"`
+	// Token is the raw value handed to the client, only its hash is persisted
+	Token      string     `json:"-" postgres:"token_hash"`
+	FamilyID   string     `json:"family_id" postgres:"family_id"`
+	UserAgent  string     `json:"user_agent" postgres:"user_agent"`
+	IPAddress  string     `json:"ip_address" postgres:"ip_address"`
+	ExpiresAt  time.Time  `json:"expires_at" postgres:"expires_at"`
+	RotatedAt  *time.Time `json:"rotated_at,omitempty" postgres:"rotated_at"`
+	LastUsedAt time.Time  `json:"last_used_at" postgres:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at" postgres:"created_at"`
+}
+
+// ClientInfo describes the device a request came from
+type ClientInfo struct {
+	UserAgent string
+	IPAddress string
+}
+
+// Session is a login session, i.e. a refresh token family. Its ID is the
+// family ID and is carried as the sid claim of access tokens.
+type Session struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+// Purposes of action tokens
+const (
+	PurposeEmailVerification = "email_verification"
+	PurposePasswordReset     = "password_reset"
+	PurposeEmailChange       = "email_change"
+	PurposeMagicLink         = "magic_link"
+)
+
+// ActionToken is a single-use token emailed to a user to confirm an action
+type ActionToken struct {
+	ID      int    `json:"id" postgres:"id"`
+	UserID  int    `json:"user_id" postgres:"user_id"`
+	Purpose string `json:"purpose" postgres:"purpose"`
+	// Token is the raw value sent to the user, only its hash is persisted
+	Token     string     `json:"-" postgres:"token_hash"`
+	Email     string     `json:"email" postgres:"email"`
+	ExpiresAt time.Time  `json:"expires_at" postgres:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty" postgres:"used_at"`
+	CreatedAt time.Time  `json:"created_at" postgres:"created_at"`
+}
+
+// Built-in roles, seeded by migration 009
+const (
+	RoleLearner = "learner"
+	RoleAuthor  = "author"
+	RoleMentor  = "mentor"
+	RoleAdmin   = "admin"
+)
+
+// Permissions granted through roles and carried in access tokens
+const (
+	PermissionMissionsRead      = "missions:read"
+	PermissionMissionsWrite     = "missions:write"
+	PermissionSubmissionsReview = "submissions:review"
+	PermissionUsersManage       = "users:manage"
+	// PermissionUsersRead is meant for service clients rather than roles
+	PermissionUsersRead = "users:read"
+)
+
+type Role struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// UserMFA is a user's TOTP enrollment. It is pending until EnabledAt is set.
+type UserMFA struct {
+	UserID          int        `json:"user_id" postgres:"user_id"`
+	SecretEncrypted string     `json:"-" postgres:"secret_encrypted"`
+	EnabledAt       *time.Time `json:"enabled_at,omitempty" postgres:"enabled_at"`
+	LastUsedStep    int64      `json:"-" postgres:"last_used_step"`
+	CreatedAt       time.Time  `json:"created_at" postgres:"created_at"`
+}
+
+type SigningKey struct {
+	ID        int    `json:"id" postgres:"id"`
+	KeyID     string `json:"kid" postgres:"kid"`
+	Algorithm string `json:"algorithm" postgres:"algorithm"`
+	// PrivateKey is the PEM encoded key, encrypted at rest
+	PrivateKey  string     `json:"-" postgres:"private_key"`
+	CreatedAt   time.Time  `json:"created_at" postgres:"created_at"`
+	ActivatesAt time.Time  `json:"activates_at" postgres:"activates_at"`
+	RetiredAt   *time.Time `json:"retired_at,omitempty" postgres:"retired_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty" postgres:"expires_at"`
 }
 
 type RegisterRequest struct {
 	Email    string `json:"email" validate:"required,email"`
 	Username string `json:"username" validate:"required,min=3,max=50"`
-	Password string `json:"password" validate:"required,min=8"`
+	Password string `json:"password" validate:"required_unless=Passwordless true,excluded_if=Passwordless true"`
+	// Passwordless creates an account that signs in with magic links only
+	Passwordless bool `json:"passwordless"`
 }
 
 type LoginRequest struct {
@@ -32,12 +143,502 @@ type LoginRequest struct {
 	Password string `json:"password" validate:"required"`
 }
 
+// AuthResponse carries the issued tokens. They are omitted when the account
+// may not sign in yet, e.g. before the email address is verified.
 type AuthResponse struct {
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
-	User         User   `json:"user"`
+	AccessToken  string `json:"access_token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	// CSRFToken replaces the refresh token when it is set as a cookie
+	CSRFToken string `json:"csrf_token,omitempty"`
+	User      User   `json:"user"`
 }
 
 type RefreshRequest struct {
 	RefreshToken string `json:"refresh_token" validate:"required"`
 }
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required"`
+}
+
+type MagicLinkRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ConsumeMagicLinkRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type UpdateProfileRequest struct {
+	Username string `json:"username" validate:"required,min=3,max=50"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required"`
+}
+
+type RemovePasswordRequest struct {
+	Password string `json:"password" validate:"required"`
+}
+
+type ChangeEmailRequest struct {
+	NewEmail string `json:"new_email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+}
+
+type ConfirmEmailChangeRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// MFAChallenge is returned by login instead of tokens when the account has
+// two-factor authentication enabled
+type MFAChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+type MFAVerifyRequest struct {
+	MFAToken     string `json:"mfa_token" validate:"required"`
+	Code         string `json:"code" validate:"required_without=RecoveryCode,omitempty,len=6,numeric"`
+	RecoveryCode string `json:"recovery_code" validate:"required_without=Code"`
+}
+
+type MFASetupResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type MFAConfirmRequest struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+type MFAConfirmResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type MFADisableRequest struct {
+	Password string `json:"password" validate:"required"`
+}
+
+// Types of events published to other services
+const (
+	EventUserDeleted = "user.deleted"
+)
+
+// OutboxEvent is a domain event stored with the change that caused it and
+// published to other services afterwards
+type OutboxEvent struct {
+	ID        int64           `json:"id" postgres:"id"`
+	Type      string          `json:"type" postgres:"event_type"`
+	Payload   json.RawMessage `json:"payload" postgres:"payload"`
+	CreatedAt time.Time       `json:"created_at" postgres:"created_at"`
+}
+
+// UserDeletedEvent is the payload of a user.deleted event
+type UserDeletedEvent struct {
+	UserID    int       `json:"user_id"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password" validate:"required"`
+}
+
+// AccountExport is the personal data held about a user across services
+type AccountExport struct {
+	ExportedAt time.Time       `json:"exported_at"`
+	User       User            `json:"user"`
+	MFAEnabled bool            `json:"mfa_enabled"`
+	Sessions   []Session       `json:"sessions"`
+	Missions   json.RawMessage `json:"missions"`
+}
+
+// Actions recorded in the admin audit log
+const (
+	AuditUserSearch   = "user.search"
+	AuditUserView     = "user.view"
+	AuditUserDisable  = "user.disable"
+	AuditUserEnable   = "user.enable"
+	AuditUserLogout   = "user.logout"
+	AuditUserSetRoles = "user.set_roles"
+	AuditClientCreate = "client.create"
+	AuditClientRevoke = "client.revoke"
+)
+
+// AuditEntry records an action an administrator took
+type AuditEntry struct {
+	ID           int64           `json:"id" postgres:"id"`
+	ActorID      int             `json:"actor_id" postgres:"actor_id"`
+	Action       string          `json:"action" postgres:"action"`
+	TargetUserID *int            `json:"target_user_id,omitempty" postgres:"target_user_id"`
+	Details      json.RawMessage `json:"details" postgres:"details"`
+	IPAddress    string          `json:"ip_address" postgres:"ip_address"`
+	CreatedAt    time.Time       `json:"created_at" postgres:"created_at"`
+}
+
+// Pagination describes which slice of a longer list a response holds
+type Pagination struct {
+	Total   int `json:"total"`
+	Page    int `json:"page"`
+	PerPage int `json:"per_page"`
+}
+
+type UserList struct {
+	Users []User `json:"users"`
+	Pagination
+}
+
+type AuditLog struct {
+	Entries []AuditEntry `json:"entries"`
+	Pagination
+}
+
+// UserDetails is an account as seen by administrators
+type UserDetails struct {
+	User
+	MFAEnabled   bool `json:"mfa_enabled"`
+	SessionCount int  `json:"session_count"`
+}
+
+type SetRolesRequest struct {
+	Roles []string `json:"roles" validate:"required,dive,required"`
+}
+
+// Types of security events
+const (
+	AuthEventRegister       = "register"
+	AuthEventLogin          = "login"
+	AuthEventLoginMFA       = "login.mfa"
+	AuthEventRefresh        = "token.refresh"
+	AuthEventRefreshReuse   = "token.refresh_reuse"
+	AuthEventLogout         = "logout"
+	AuthEventSessionRevoke  = "session.revoke"
+	AuthEventPasswordChange = "password.change"
+	AuthEventPasswordReset  = "password.reset"
+	AuthEventPasswordRemove = "password.remove"
+	AuthEventMagicLink      = "magic_link.send"
+	AuthEventEmailChange    = "email.change"
+	AuthEventAccountDelete  = "account.delete"
+	AuthEventTokenCreate    = "personal_token.create"
+	AuthEventTokenRevoke    = "personal_token.revoke"
+	AuthEventOAuthAuthorize = "oauth.authorize"
+	AuthEventOAuthRevoke    = "oauth.revoke"
+	AuthEventIdentityLink   = "identity.link"
+	AuthEventIdentityUnlink = "identity.unlink"
+	// Administrative actions are recorded as "admin." followed by the audit action
+	AuthEventAdminPrefix = "admin."
+)
+
+// Outcomes of security events
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// AuthEvent is an entry of the security log. UserID is the account the event
+// concerns, ActorID the administrator who caused it, if any.
+type AuthEvent struct {
+	ID        int64           `json:"id" postgres:"id"`
+	Type      string          `json:"type" postgres:"event_type"`
+	Outcome   string          `json:"outcome" postgres:"outcome"`
+	UserID    *int            `json:"user_id,omitempty" postgres:"user_id"`
+	ActorID   *int            `json:"actor_id,omitempty" postgres:"actor_id"`
+	IPAddress string          `json:"ip_address" postgres:"ip_address"`
+	UserAgent string          `json:"user_agent" postgres:"user_agent"`
+	Details   json.RawMessage `json:"details" postgres:"details"`
+	CreatedAt time.Time       `json:"created_at" postgres:"created_at"`
+}
+
+// AuthEventFilter selects security events; zero fields match everything
+type AuthEventFilter struct {
+	UserID    int
+	Type      string
+	Outcome   string
+	IPAddress string
+	Since     time.Time
+	Until     time.Time
+}
+
+type AuthEventList struct {
+	Events []AuthEvent `json:"events"`
+	Pagination
+}
+
+// OAuthClient is a service that authenticates to the auth service with a
+// client ID and secret, or an application users authorize to act for them.
+// Public clients, like native apps, have no secret.
+type OAuthClient struct {
+	ID       int    `json:"id" postgres:"id"`
+	ClientID string `json:"client_id" postgres:"client_id"`
+	Name     string `json:"name" postgres:"name"`
+	// Scopes the client may request, for itself or from users
+	Scopes []string `json:"scopes" postgres:"scopes"`
+	// RedirectURIs are where authorization codes may be sent
+	RedirectURIs []string   `json:"redirect_uris" postgres:"redirect_uris"`
+	Public       bool       `json:"public" postgres:"public"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty" postgres:"revoked_at"`
+	CreatedAt    time.Time  `json:"created_at" postgres:"created_at"`
+}
+
+type RegisterClientRequest struct {
+	ClientID     string   `json:"client_id" validate:"required,min=3,max=64"`
+	Name         string   `json:"name" validate:"required,max=255"`
+	Scopes       []string `json:"scopes" validate:"dive,required"`
+	RedirectURIs []string `json:"redirect_uris" validate:"dive,required"`
+	Public       bool     `json:"public"`
+}
+
+// RegisterClientResponse carries the client secret, which is never shown
+// again. Public clients get none.
+type RegisterClientResponse struct {
+	OAuthClient
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// AuthorizationCode is handed to a client after the user consented and is
+// exchanged for tokens once. Only a hash of the code itself is stored.
+type AuthorizationCode struct {
+	ID   int    `json:"id" postgres:"id"`
+	Code string `json:"-" postgres:"code_hash"`
+	// FamilyID names the refresh tokens the code is exchanged for
+	FamilyID      string     `json:"family_id" postgres:"family_id"`
+	ClientID      string     `json:"client_id" postgres:"client_id"`
+	UserID        int        `json:"user_id" postgres:"user_id"`
+	RedirectURI   string     `json:"redirect_uri" postgres:"redirect_uri"`
+	Scopes        []string   `json:"scopes" postgres:"scopes"`
+	CodeChallenge string     `json:"-" postgres:"code_challenge"`
+	ExpiresAt     time.Time  `json:"expires_at" postgres:"expires_at"`
+	UsedAt        *time.Time `json:"used_at,omitempty" postgres:"used_at"`
+	CreatedAt     time.Time  `json:"created_at" postgres:"created_at"`
+}
+
+// OAuthRefreshToken is a refresh token of an application acting for a user.
+// They are kept apart from the refresh tokens of the user's own sessions.
+type OAuthRefreshToken struct {
+	ID int `json:"id" postgres:"id"`
+	// Token is the raw value handed to the client, only its hash is persisted
+	Token      string     `json:"-" postgres:"token_hash"`
+	FamilyID   string     `json:"family_id" postgres:"family_id"`
+	ClientID   string     `json:"client_id" postgres:"client_id"`
+	UserID     int        `json:"user_id" postgres:"user_id"`
+	Scopes     []string   `json:"scopes" postgres:"scopes"`
+	ExpiresAt  time.Time  `json:"expires_at" postgres:"expires_at"`
+	RotatedAt  *time.Time `json:"rotated_at,omitempty" postgres:"rotated_at"`
+	LastUsedAt time.Time  `json:"last_used_at" postgres:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at" postgres:"created_at"`
+}
+
+// OAuthGrant is an application the user authorized, i.e. a family of
+// OAuth refresh tokens
+type OAuthGrant struct {
+	ID         string    `json:"id"`
+	ClientID   string    `json:"client_id"`
+	ClientName string    `json:"client_name"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// AuthorizationConsent describes what the user is asked to approve: which
+// application gets which of their permissions. Device authorizations have no
+// redirect URI.
+type AuthorizationConsent struct {
+	ClientID    string   `json:"client_id"`
+	ClientName  string   `json:"client_name"`
+	Scopes      []string `json:"scopes"`
+	RedirectURI string   `json:"redirect_uri,omitempty"`
+}
+
+// AuthorizeDecision is the user's answer to an authorization request
+type AuthorizeDecision struct {
+	oauth.AuthorizeRequest
+	Approve bool `json:"approve"`
+}
+
+// AuthorizeRedirect is where the user agent goes next with the outcome of
+// an authorization request
+type AuthorizeRedirect struct {
+	RedirectTo string `json:"redirect_to"`
+}
+
+// Statuses of a device authorization
+const (
+	DeviceAuthorizationPending  = "pending"
+	DeviceAuthorizationApproved = "approved"
+	DeviceAuthorizationDenied   = "denied"
+)
+
+// DeviceAuthorization is a sign-in of a device such as a CLI, which polls
+// with the device code while the user approves the user code in a browser.
+// Only hashes of both codes are stored.
+type DeviceAuthorization struct {
+	ID         int      `json:"id" postgres:"id"`
+	DeviceCode string   `json:"-" postgres:"device_code_hash"`
+	UserCode   string   `json:"-" postgres:"user_code_hash"`
+	ClientID   string   `json:"client_id" postgres:"client_id"`
+	Scopes     []string `json:"scopes" postgres:"scopes"`
+	Status     string   `json:"status" postgres:"status"`
+	// UserID is set once the user decided
+	UserID *int `json:"user_id,omitempty" postgres:"user_id"`
+	// Interval is the minimum number of seconds between polls
+	Interval  int       `json:"interval" postgres:"poll_interval"`
+	ExpiresAt time.Time `json:"expires_at" postgres:"expires_at"`
+	CreatedAt time.Time `json:"created_at" postgres:"created_at"`
+}
+
+// DeviceAuthorizationResponse tells the device what to show the user and
+// how to poll, RFC 8628 section 3.2
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// DeviceDecision is the user's answer to a device authorization
+type DeviceDecision struct {
+	UserCode string `json:"user_code" validate:"required"`
+	Approve  bool   `json:"approve"`
+}
+
+// Values of the token_use claim, also reported by introspection. Delegated
+// tokens act for a user on behalf of an application the user authorized.
+const (
+	TokenUseAccess    = "access"
+	TokenUsePersonal  = "personal"
+	TokenUseService   = "service"
+	TokenUseDelegated = "delegated"
+)
+
+// IntrospectionResponse is the RFC 7662 view of a token. An inactive token
+// carries no other fields. TokenUse tells user tokens from service tokens,
+// whose Sub and ClientID are the client ID. Delegated tokens name both the
+// user, as Sub, and the client.
+type IntrospectionResponse struct {
+	Active    bool     `json:"active"`
+	TokenUse  string   `json:"token_use,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	Scope     string   `json:"scope,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	Exp       int64    `json:"exp,omitempty"`
+	Iat       int64    `json:"iat,omitempty"`
+	Sub       string   `json:"sub,omitempty"`
+	Jti       string   `json:"jti,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+}
+
+// PersonalAccessTokenPrefix starts every personal access token, so they can
+// be told apart from JWTs without parsing them
+const PersonalAccessTokenPrefix = "cbp_"
+
+// PersonalAccessToken is a named, scoped and expiring token a user created for
+// scripts. Only a hash of the token itself is stored.
+type PersonalAccessToken struct {
+	ID         int        `json:"id" postgres:"id"`
+	UserID     int        `json:"-" postgres:"user_id"`
+	Name       string     `json:"name" postgres:"name"`
+	Scopes     []string   `json:"scopes" postgres:"scopes"`
+	ExpiresAt  time.Time  `json:"expires_at" postgres:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at" postgres:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at" postgres:"created_at"`
+}
+
+type CreatePersonalAccessTokenRequest struct {
+	Name          string   `json:"name" validate:"required,max=100"`
+	Scopes        []string `json:"scopes" validate:"required,min=1,dive,required"`
+	ExpiresInDays int      `json:"expires_in_days" validate:"required,min=1,max=365"`
+}
+
+// CreatePersonalAccessTokenResponse carries the plaintext token, which is
+// never shown again
+type CreatePersonalAccessTokenResponse struct {
+	PersonalAccessToken
+	Token string `json:"token"`
+}
+
+// TokenIdentity is who a personal access token acts for and what it may do.
+// Permissions are the token's scopes the user still holds.
+type TokenIdentity struct {
+	TokenID     int
+	UserID      int
+	Email       string
+	Username    string
+	Verified    bool
+	Roles       []string
+	Permissions []string
+	ExpiresAt   time.Time
+	IssuedAt    time.Time
+}
+
+// TokenResponse is the RFC 6749 response of the token endpoint
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// UserIdentity links an account to its identity at an external OpenID
+// Connect provider. Subject is the provider's stable ID of the user.
+type UserIdentity struct {
+	ID         int        `json:"id" postgres:"id"`
+	UserID     int        `json:"-" postgres:"user_id"`
+	Provider   string     `json:"provider" postgres:"provider"`
+	Subject    string     `json:"subject" postgres:"subject"`
+	Email      string     `json:"email,omitempty" postgres:"email"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" postgres:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at" postgres:"created_at"`
+}
+
+// OIDCState remembers a sign-in started with a provider until the user comes
+// back with the state. UserID is set when the sign-in links an identity to
+// an account instead.
+type OIDCState struct {
+	ID int `json:"id" postgres:"id"`
+	// State is the raw value sent to the provider, only its hash is persisted
+	State string `json:"-" postgres:"state_hash"`
+	// Binding is the secret the browser that started the sign-in keeps in a
+	// cookie, only its hash is persisted
+	Binding      string    `json:"-" postgres:"binding_hash"`
+	Provider     string    `json:"provider" postgres:"provider"`
+	Nonce        string    `json:"-" postgres:"nonce"`
+	CodeVerifier string    `json:"-" postgres:"code_verifier"`
+	UserID       *int      `json:"user_id,omitempty" postgres:"user_id"`
+	ExpiresAt    time.Time `json:"expires_at" postgres:"expires_at"`
+	CreatedAt    time.Time `json:"created_at" postgres:"created_at"`
+}
+
+// OIDCAuthorization is where to send the user to sign in with a provider.
+// Binding is set as a cookie rather than returned.
+type OIDCAuthorization struct {
+	AuthorizationURL string `json:"authorization_url"`
+	Binding          string `json:"-"`
+}
+
+// OIDCCallbackRequest carries the parameters the provider redirected the
+// user back with, and the binding of the browser presenting them
+type OIDCCallbackRequest struct {
+	Code    string `json:"code" validate:"required"`
+	State   string `json:"state" validate:"required"`
+	Binding string `json:"-"`
+}
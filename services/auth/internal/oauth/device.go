@@ -0,0 +1,61 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+)
+
+// GrantTypeDeviceCode is the grant_type a device polls the token endpoint
+// with (RFC 8628 section 3.4)
+const GrantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+// Error codes of the device authorization grant, RFC 8628 section 3.5
+const (
+	ErrorAuthorizationPending = "authorization_pending"
+	ErrorSlowDown             = "slow_down"
+	ErrorExpiredToken         = "expired_token"
+)
+
+// userCodeAlphabet leaves out vowels, so no words are spelled, and digits,
+// which are easily mistaken for letters (RFC 8628 section 6.1)
+const (
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+// NewUserCode returns a code for the user to type on the verification page,
+// formatted as two groups of four letters like "WDJB-MJHT"
+func NewUserCode() (string, error) {
+	code := make([]byte, userCodeLength)
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code[:4]) + "-" + string(code[4:]), nil
+}
+
+// NormalizeUserCode brings a typed user code into the form NewUserCode
+// returns: upper case, with the dash in place and spaces ignored. It returns
+// "" for anything that can't be a user code.
+func NormalizeUserCode(code string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(code) {
+		switch {
+		case r == '-' || r == ' ':
+			continue
+		case !strings.ContainsRune(userCodeAlphabet, r):
+			return ""
+		}
+		b.WriteRune(r)
+	}
+	if b.Len() != userCodeLength {
+		return ""
+	}
+	normalized := b.String()
+	return normalized[:4] + "-" + normalized[4:]
+}
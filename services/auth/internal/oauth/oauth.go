@@ -0,0 +1,193 @@
+// Package oauth holds the rules of the OAuth 2.0 authorization code grant
+// (RFC 6749) with PKCE (RFC 7636) and of the device authorization grant
+// (RFC 8628) that don't depend on storage: checking authorization requests,
+// verifying code verifiers, building the redirects back to the client and
+// making user codes.
+package oauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// CodeChallengeMethodS256 is the only PKCE method accepted; plain would let
+// an intercepted authorization request be redeemed
+const CodeChallengeMethodS256 = "S256"
+
+// Error codes of RFC 6749 section 4.1.2.1 and 5.2
+const (
+	ErrorInvalidRequest          = "invalid_request"
+	ErrorUnauthorizedClient      = "unauthorized_client"
+	ErrorAccessDenied            = "access_denied"
+	ErrorUnsupportedResponseType = "unsupported_response_type"
+	ErrorInvalidScope            = "invalid_scope"
+	ErrorInvalidGrant            = "invalid_grant"
+)
+
+// Error is an OAuth error that is reported to the client, either as a
+// redirect from the authorization endpoint or in a token endpoint response
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func NewError(code, description string) *Error {
+	return &Error{Code: code, Description: description}
+}
+
+// codeVerifierPattern is the syntax of RFC 7636 section 4.1; a S256
+// challenge is the unpadded base64url encoding of 32 bytes
+var (
+	codeVerifierPattern  = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+	codeChallengePattern = regexp.MustCompile(`^[A-Za-z0-9\-_]{43}$`)
+)
+
+// AuthorizeRequest holds the parameters of an authorization request. The
+// same fields are sent as query parameters to the authorization endpoint and
+// as JSON when the user decides on the consent screen.
+type AuthorizeRequest struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+func ParseAuthorizeRequest(values url.Values) *AuthorizeRequest {
+	return &AuthorizeRequest{
+		ResponseType:        values.Get("response_type"),
+		ClientID:            values.Get("client_id"),
+		RedirectURI:         values.Get("redirect_uri"),
+		Scope:               values.Get("scope"),
+		State:               values.Get("state"),
+		CodeChallenge:       values.Get("code_challenge"),
+		CodeChallengeMethod: values.Get("code_challenge_method"),
+	}
+}
+
+// Values encodes the request as query parameters
+func (r *AuthorizeRequest) Values() url.Values {
+	values := url.Values{}
+	for name, value := range map[string]string{
+		"response_type":         r.ResponseType,
+		"client_id":             r.ClientID,
+		"redirect_uri":          r.RedirectURI,
+		"scope":                 r.Scope,
+		"state":                 r.State,
+		"code_challenge":        r.CodeChallenge,
+		"code_challenge_method": r.CodeChallengeMethod,
+	} {
+		if value != "" {
+			values.Set(name, value)
+		}
+	}
+	return values
+}
+
+// Scopes splits the space separated scope parameter
+func (r *AuthorizeRequest) Scopes() []string {
+	return strings.Fields(r.Scope)
+}
+
+// Validate checks the parameters that are reported to the client by
+// redirect. The client and redirect URI must have been checked before, since
+// errors about them must not be redirected.
+func (r *AuthorizeRequest) Validate() *Error {
+	switch {
+	case r.ResponseType == "":
+		return NewError(ErrorInvalidRequest, "response_type is required")
+	case r.ResponseType != "code":
+		return NewError(ErrorUnsupportedResponseType, "Only the code response type is supported")
+	case r.CodeChallenge == "":
+		return NewError(ErrorInvalidRequest, "code_challenge is required")
+	case r.CodeChallengeMethod != CodeChallengeMethodS256:
+		return NewError(ErrorInvalidRequest, "code_challenge_method must be S256")
+	case !codeChallengePattern.MatchString(r.CodeChallenge):
+		return NewError(ErrorInvalidRequest, "code_challenge is malformed")
+	}
+	return nil
+}
+
+// CodeChallenge derives the S256 challenge of a code verifier
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// VerifyCodeVerifier reports whether verifier is well-formed and matches
+// the S256 challenge the authorization request carried
+func VerifyCodeVerifier(challenge, verifier string) bool {
+	if !codeVerifierPattern.MatchString(verifier) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(CodeChallenge(verifier)), []byte(challenge)) == 1
+}
+
+// ValidRedirectURI reports whether uri may be registered as a redirect URI.
+// It must be absolute and carry no fragment. Plain http is only allowed for
+// the loopback interface, where native apps like IDE plugins listen; other
+// schemes are private-use schemes of native apps (RFC 8252).
+func ValidRedirectURI(uri string) bool {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.Scheme == "" || strings.Contains(uri, "#") {
+		return false
+	}
+
+	switch parsed.Scheme {
+	case "https":
+		return parsed.Host != ""
+	case "http":
+		host := parsed.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	case "javascript", "data", "file":
+		return false
+	default:
+		// Private-use schemes are reverse domain names, like com.example.app
+		return strings.Contains(parsed.Scheme, ".")
+	}
+}
+
+// RedirectWithCode returns the URI that hands the authorization code to the client
+func RedirectWithCode(redirectURI, code, state string) string {
+	params := url.Values{"code": {code}}
+	if state != "" {
+		params.Set("state", state)
+	}
+	return appendQuery(redirectURI, params)
+}
+
+// RedirectWithError returns the URI that reports err to the client
+func RedirectWithError(redirectURI string, err *Error, state string) string {
+	params := url.Values{"error": {err.Code}}
+	if err.Description != "" {
+		params.Set("error_description", err.Description)
+	}
+	if state != "" {
+		params.Set("state", state)
+	}
+	return appendQuery(redirectURI, params)
+}
+
+// appendQuery adds params to the query the registered URI may already have
+func appendQuery(uri string, params url.Values) string {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	query := parsed.Query()
+	for name, values := range params {
+		query[name] = values
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
@@ -0,0 +1,198 @@
+package oauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// RFC 7636 Appendix B
+const (
+	testVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+func validRequest() *AuthorizeRequest {
+	return &AuthorizeRequest{
+		ResponseType:        "code",
+		ClientID:            "ide-plugin",
+		RedirectURI:         "http://127.0.0.1:8765/callback",
+		Scope:               "missions:read missions:write",
+		State:               "xyz",
+		CodeChallenge:       testChallenge,
+		CodeChallengeMethod: CodeChallengeMethodS256,
+	}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	assert.Equal(t, testChallenge, CodeChallenge(testVerifier))
+}
+
+func TestVerifyCodeVerifier(t *testing.T) {
+	assert.True(t, VerifyCodeVerifier(testChallenge, testVerifier))
+	assert.False(t, VerifyCodeVerifier(testChallenge, testVerifier[:42]+"x"))
+	assert.False(t, VerifyCodeVerifier(testChallenge, ""))
+
+	// Too short to be a verifier, even if it matched
+	short := "abc"
+	assert.False(t, VerifyCodeVerifier(CodeChallenge(short), short))
+}
+
+func TestValidate(t *testing.T) {
+	assert.Nil(t, validRequest().Validate())
+
+	tests := []struct {
+		name   string
+		modify func(*AuthorizeRequest)
+		code   string
+	}{
+		{"missing response type", func(r *AuthorizeRequest) { r.ResponseType = "" }, ErrorInvalidRequest},
+		{"implicit grant", func(r *AuthorizeRequest) { r.ResponseType = "token" }, ErrorUnsupportedResponseType},
+		{"missing challenge", func(r *AuthorizeRequest) { r.CodeChallenge = "" }, ErrorInvalidRequest},
+		{"plain method", func(r *AuthorizeRequest) { r.CodeChallengeMethod = "plain" }, ErrorInvalidRequest},
+		{"missing method", func(r *AuthorizeRequest) { r.CodeChallengeMethod = "" }, ErrorInvalidRequest},
+		{"malformed challenge", func(r *AuthorizeRequest) { r.CodeChallenge = "short" }, ErrorInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := validRequest()
+			tt.modify(req)
+			err := req.Validate()
+			require.NotNil(t, err)
+			assert.Equal(t, tt.code, err.Code)
+		})
+	}
+}
+
+func TestParseAuthorizeRequestRoundTrip(t *testing.T) {
+	req := validRequest()
+	assert.Equal(t, req, ParseAuthorizeRequest(req.Values()))
+	assert.Equal(t, []string{"missions:read", "missions:write"}, req.Scopes())
+
+	// Empty parameters are left out
+	req.State = ""
+	assert.NotContains(t, req.Values(), "state")
+}
+
+func TestValidRedirectURI(t *testing.T) {
+	valid := []string{
+		"https://bot.example.com/callback",
+		"https://bot.example.com/callback?team=1",
+		"http://127.0.0.1:8765/callback",
+		"http://localhost/callback",
+		"http://[::1]:9000/",
+		"com.example.ide:/oauth",
+	}
+	for _, uri := range valid {
+		assert.True(t, ValidRedirectURI(uri), uri)
+	}
+
+	invalid := []string{
+		"",
+		"/callback",
+		"http://bot.example.com/callback",
+		"https:///callback",
+		"https://bot.example.com/callback#fragment",
+		"javascript:alert(1)",
+		"data:text/html,hi",
+		"file:///etc/passwd",
+		"myapp:/callback",
+	}
+	for _, uri := range invalid {
+		assert.False(t, ValidRedirectURI(uri), uri)
+	}
+}
+
+func TestRedirectWithCode(t *testing.T) {
+	redirect, err := url.Parse(RedirectWithCode("https://bot.example.com/cb?team=1", "abc", "xyz"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "bot.example.com", redirect.Host)
+	assert.Equal(t, "/cb", redirect.Path)
+	assert.Equal(t, url.Values{"code": {"abc"}, "state": {"xyz"}, "team": {"1"}}, redirect.Query())
+
+	redirect, err = url.Parse(RedirectWithCode("https://bot.example.com/cb", "abc", ""))
+	require.NoError(t, err)
+	assert.Equal(t, url.Values{"code": {"abc"}}, redirect.Query())
+}
+
+func TestRedirectWithError(t *testing.T) {
+	redirect, err := url.Parse(RedirectWithError("https://bot.example.com/cb",
+		NewError(ErrorAccessDenied, "The user denied the request"), "xyz"))
+	require.NoError(t, err)
+
+	assert.Equal(t, url.Values{
+		"error":             {ErrorAccessDenied},
+		"error_description": {"The user denied the request"},
+		"state":             {"xyz"},
+	}, redirect.Query())
+}
+
+// TestAuthorizationCodeFlow plays a native client listening on the loopback
+// interface: the authorization server redirects the user agent to it with a
+// code, which the client can only redeem with its verifier.
+func TestAuthorizationCodeFlow(t *testing.T) {
+	received := make(chan url.Values, 1)
+	client := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.URL.Query()
+	}))
+	defer client.Close()
+
+	req := validRequest()
+	req.RedirectURI = client.URL + "/callback"
+	require.True(t, ValidRedirectURI(req.RedirectURI))
+
+	// The authorization endpoint redirects once the user consented
+	var issued *AuthorizeRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		issued = ParseAuthorizeRequest(r.URL.Query())
+		if err := issued.Validate(); err != nil {
+			http.Redirect(w, r, RedirectWithError(issued.RedirectURI, err, issued.State), http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, RedirectWithCode(issued.RedirectURI, "code123", issued.State), http.StatusFound)
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/oauth/authorize?" + req.Values().Encode())
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	params := <-received
+	assert.Equal(t, "code123", params.Get("code"))
+	assert.Equal(t, req.State, params.Get("state"))
+	assert.True(t, VerifyCodeVerifier(issued.CodeChallenge, testVerifier))
+
+	// Without PKCE the user agent comes back with an error instead
+	req.CodeChallenge = ""
+	resp, err = http.Get(server.URL + "/oauth/authorize?" + req.Values().Encode())
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	params = <-received
+	assert.Empty(t, params.Get("code"))
+	assert.Equal(t, ErrorInvalidRequest, params.Get("error"))
+	assert.Equal(t, req.State, params.Get("state"))
+}
+
+func TestNewUserCode(t *testing.T) {
+	code, err := NewUserCode()
+	require.NoError(t, err)
+
+	assert.Regexp(t, `^[BCDFGHJKLMNPQRSTVWXZ]{4}-[BCDFGHJKLMNPQRSTVWXZ]{4}$`, code)
+	assert.Equal(t, code, NormalizeUserCode(code))
+}
+
+func TestNormalizeUserCode(t *testing.T) {
+	assert.Equal(t, "WDJB-MJHT", NormalizeUserCode("WDJB-MJHT"))
+	assert.Equal(t, "WDJB-MJHT", NormalizeUserCode("wdjbmjht"))
+	assert.Equal(t, "WDJB-MJHT", NormalizeUserCode(" wdjb - mjht "))
+
+	for _, code := range []string{"", "WDJB-MJH", "WDJB-MJHTX", "WDJB-MJH0", "AEIO-UBCD", "WDJB_MJHT"} {
+		assert.Empty(t, NormalizeUserCode(code), code)
+	}
+}
@@ -0,0 +1,349 @@
+// Package oidc signs users in with external OpenID Connect providers, like
+// Google or GitLab, as a relying party. It discovers a provider's endpoints,
+// builds authorization requests carrying state, nonce and a PKCE challenge,
+// and validates the ID token the authorization code is exchanged for.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pseudoerr/auth-service/internal/keys"
+	"github.com/pseudoerr/auth-service/internal/oauth"
+)
+
+const (
+	// keysTTL is how long a provider's published keys are trusted before refetching
+	keysTTL = time.Hour
+	// minKeyRefreshInterval stops unknown key IDs from hammering the provider
+	minKeyRefreshInterval = 30 * time.Second
+)
+
+var (
+	// ErrProviderUnavailable means discovery, the key set or the token
+	// endpoint could not be reached or answered with garbage
+	ErrProviderUnavailable = errors.New("identity provider unavailable")
+	// ErrExchangeFailed means the provider refused the authorization code
+	ErrExchangeFailed = errors.New("authorization code was rejected by the identity provider")
+	ErrInvalidIDToken = errors.New("invalid ID token")
+)
+
+// Config describes a provider registered with this service as a client
+type Config struct {
+	// Name identifies the provider in URLs and linked identities, like "google"
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is registered with the provider; the frontend listens there
+	RedirectURL string
+	// Scopes default to openid, email and profile
+	Scopes []string
+}
+
+// Claims are what an ID token says about the user
+type Claims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+// metadata is the part of the discovery document this package uses
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type publicKey struct {
+	alg string
+	key crypto.PublicKey
+}
+
+// Provider is a relying party for one provider. Discovery happens on first
+// use, so the service starts even while a provider is down.
+type Provider struct {
+	config Config
+	http   *http.Client
+
+	mu            sync.Mutex
+	metadata      *metadata
+	keys          map[string]publicKey
+	keysFetchedAt time.Time
+}
+
+func NewProvider(config Config) *Provider {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	return &Provider{
+		config: config,
+		http:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *Provider) Name() string {
+	return p.config.Name
+}
+
+// AuthCodeURL returns where to send the user to sign in with the provider.
+// The state and nonce are checked when the user comes back; the code verifier
+// is needed to redeem the code.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {oauth.CodeChallenge(codeVerifier)},
+		"code_challenge_method": {oauth.CodeChallengeMethodS256},
+	}
+
+	endpoint, err := url.Parse(meta.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid authorization endpoint: %v", ErrProviderUnavailable, err)
+	}
+	query := endpoint.Query()
+	for name, values := range params {
+		query[name] = values
+	}
+	endpoint.RawQuery = query.Encode()
+	return endpoint.String(), nil
+}
+
+// Exchange redeems an authorization code and returns the claims of the
+// validated ID token, which must carry nonce
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Claims, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// RFC 6749 form-encodes both parts before they are joined
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized:
+		var oauthErr oauth.Error
+		json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&oauthErr)
+		return nil, fmt.Errorf("%w: %s", ErrExchangeFailed, oauthErr.Code)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%w: unexpected token response status %d", ErrProviderUnavailable, resp.StatusCode)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("%w: token response has no id_token", ErrInvalidIDToken)
+	}
+
+	return p.verifyIDToken(ctx, meta, tokens.IDToken, nonce)
+}
+
+// verifyIDToken applies the checks of OpenID Connect Core section 3.1.3.7
+func (p *Provider) verifyIDToken(ctx context.Context, meta *metadata, raw, nonce string) (*Claims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := p.key(ctx, meta, kid)
+		if err != nil {
+			return nil, err
+		}
+		if key.alg != "" && token.Method.Alg() != key.alg {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.key, nil
+	},
+		jwt.WithValidMethods(keys.ValidMethods),
+		jwt.WithIssuer(meta.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if errors.Is(err, ErrProviderUnavailable) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	// A token meant for several clients must name this one as its holder
+	audience, _ := claims.GetAudience()
+	if azp, _ := claims["azp"].(string); len(audience) > 1 && azp != p.config.ClientID {
+		return nil, fmt.Errorf("%w: authorized party is %q", ErrInvalidIDToken, azp)
+	}
+
+	// The nonce ties the token to the sign-in this browser started
+	tokenNonce, _ := claims["nonce"].(string)
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+
+	subject, _ := claims.GetSubject()
+	if subject == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	}
+
+	result := &Claims{Subject: subject}
+	result.Email, _ = claims["email"].(string)
+	result.Name, _ = claims["name"].(string)
+	result.PreferredUsername, _ = claims["preferred_username"].(string)
+	// Some providers send the flag as a string
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		result.EmailVerified = verified
+	case string:
+		result.EmailVerified = verified == "true"
+	}
+	return result, nil
+}
+
+// discover fetches the provider's discovery document once
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var meta metadata
+	discoveryURL := strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, discoveryURL, &meta); err != nil {
+		return nil, fmt.Errorf("%w: discovery failed: %v", ErrProviderUnavailable, err)
+	}
+
+	// OpenID Connect Discovery section 4.3: the document must be about the configured issuer
+	if meta.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("%w: discovery document is for issuer %q", ErrProviderUnavailable, meta.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: discovery document is incomplete", ErrProviderUnavailable)
+	}
+
+	p.metadata = &meta
+	return p.metadata, nil
+}
+
+// key returns the provider's key with the given ID. The key set is refetched
+// when it is stale or the key ID is unknown, since providers rotate keys.
+func (p *Provider) key(ctx context.Context, meta *metadata, kid string) (publicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stale := time.Since(p.keysFetchedAt) > keysTTL
+	key, ok := p.lookup(kid)
+	if ok && !stale {
+		return key, nil
+	}
+
+	if stale || time.Since(p.keysFetchedAt) > minKeyRefreshInterval {
+		if err := p.refreshKeys(ctx, meta); err != nil {
+			return publicKey{}, fmt.Errorf("%w: fetching keys failed: %v", ErrProviderUnavailable, err)
+		}
+		key, ok = p.lookup(kid)
+	}
+	if !ok {
+		return publicKey{}, fmt.Errorf("unknown signing key: %q", kid)
+	}
+	return key, nil
+}
+
+// lookup must be called with the lock held. A token without a key ID is
+// accepted only while the provider publishes a single key.
+func (p *Provider) lookup(kid string) (publicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+// refreshKeys must be called with the lock held
+func (p *Provider) refreshKeys(ctx context.Context, meta *metadata) error {
+	var set keys.JWKS
+	if err := p.getJSON(ctx, meta.JWKSURI, &set); err != nil {
+		return err
+	}
+
+	published := make(map[string]publicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		// Encryption keys and unsupported key types are of no use here
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		published[jwk.Kid] = publicKey{alg: jwk.Alg, key: key}
+	}
+
+	p.keys = published
+	p.keysFetchedAt = time.Now()
+	return nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, target any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response status %d from %s", resp.StatusCode, url)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(target)
+}
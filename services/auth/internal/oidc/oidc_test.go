@@ -0,0 +1,311 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pseudoerr/auth-service/internal/keys"
+	"github.com/pseudoerr/auth-service/internal/oauth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testClientID     = "codebase"
+	testClientSecret = "s3cret"
+	testRedirectURL  = "http://localhost:3000/oidc/fake/callback"
+	testVerifier     = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+// fakeProvider is a minimal OpenID Connect provider. It hands out a single
+// authorization code and answers the token request with an ID token built
+// from claims.
+type fakeProvider struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu        sync.Mutex
+	key       *keys.SigningKey
+	issuer    string
+	challenge string
+	claims    jwt.MapClaims
+	// jwksRequests counts fetches of the key set
+	jwksRequests int
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	t.Helper()
+	fake := &fakeProvider{t: t, key: newRSAKey(t)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 fake.issuer,
+			"authorization_endpoint": fake.server.URL + "/authorize?prompt=login",
+			"token_endpoint":         fake.server.URL + "/token",
+			"jwks_uri":               fake.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		fake.jwksRequests++
+		json.NewEncoder(w).Encode(keys.JWKS{Keys: []keys.JWK{fake.key.JWK()}})
+	})
+	mux.HandleFunc("/token", fake.token)
+
+	fake.server = httptest.NewServer(mux)
+	fake.issuer = fake.server.URL
+	t.Cleanup(fake.server.Close)
+	return fake
+}
+
+func newRSAKey(t *testing.T) *keys.SigningKey {
+	t.Helper()
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return &keys.SigningKey{ID: "key-" + private.N.Text(36)[:8], PrivateKey: private}
+}
+
+func (f *fakeProvider) token(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	clientID, secret, _ := r.BasicAuth()
+	if clientID != testClientID || secret != testClientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(oauth.NewError("invalid_client", ""))
+		return
+	}
+	if r.PostFormValue("code") != "code123" || r.PostFormValue("redirect_uri") != testRedirectURL ||
+		!oauth.VerifyCodeVerifier(f.challenge, r.PostFormValue("code_verifier")) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(oauth.NewError(oauth.ErrorInvalidGrant, ""))
+		return
+	}
+
+	token := jwt.NewWithClaims(f.key.Method(), f.claims)
+	token.Header["kid"] = f.key.ID
+	idToken, err := token.SignedString(f.key.PrivateKey)
+	require.NoError(f.t, err)
+	json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": idToken})
+}
+
+// validClaims are what a well-behaved provider puts in the ID token
+func (f *fakeProvider) validClaims(nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            f.issuer,
+		"sub":            "248289761001",
+		"aud":            testClientID,
+		"exp":            time.Now().Add(time.Minute).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          nonce,
+		"email":          "jane@example.com",
+		"email_verified": true,
+		"name":           "Jane Doe",
+	}
+}
+
+func (f *fakeProvider) provider() *Provider {
+	return NewProvider(Config{
+		Name:         "fake",
+		Issuer:       f.issuer,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+	})
+}
+
+// signIn walks through a sign-in: the authorization request, the user
+// approving at the provider, and the code exchange
+func (f *fakeProvider) signIn(t *testing.T, provider *Provider, claims jwt.MapClaims, nonce string) (*Claims, error) {
+	t.Helper()
+	authURL, err := provider.AuthCodeURL(context.Background(), "state123", "nonce123", testVerifier)
+	require.NoError(t, err)
+
+	parsed, err := url.Parse(authURL)
+	require.NoError(t, err)
+	f.mu.Lock()
+	f.challenge = parsed.Query().Get("code_challenge")
+	f.claims = claims
+	f.mu.Unlock()
+
+	return provider.Exchange(context.Background(), "code123", testVerifier, nonce)
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	fake := newFakeProvider(t)
+
+	authURL, err := fake.provider().AuthCodeURL(context.Background(), "state123", "nonce123", testVerifier)
+	require.NoError(t, err)
+
+	parsed, err := url.Parse(authURL)
+	require.NoError(t, err)
+	assert.Equal(t, fake.server.URL+"/authorize", parsed.Scheme+"://"+parsed.Host+parsed.Path)
+	assert.Equal(t, url.Values{
+		"prompt":                {"login"},
+		"response_type":         {"code"},
+		"client_id":             {testClientID},
+		"redirect_uri":          {testRedirectURL},
+		"scope":                 {"openid email profile"},
+		"state":                 {"state123"},
+		"nonce":                 {"nonce123"},
+		"code_challenge":        {oauth.CodeChallenge(testVerifier)},
+		"code_challenge_method": {"S256"},
+	}, parsed.Query())
+}
+
+func TestExchange(t *testing.T) {
+	fake := newFakeProvider(t)
+
+	claims, err := fake.signIn(t, fake.provider(), fake.validClaims("nonce123"), "nonce123")
+	require.NoError(t, err)
+
+	assert.Equal(t, &Claims{
+		Subject:       "248289761001",
+		Email:         "jane@example.com",
+		EmailVerified: true,
+		Name:          "Jane Doe",
+	}, claims)
+}
+
+func TestExchangeAcceptsStringEmailVerified(t *testing.T) {
+	fake := newFakeProvider(t)
+	idClaims := fake.validClaims("nonce123")
+	idClaims["email_verified"] = "true"
+
+	claims, err := fake.signIn(t, fake.provider(), idClaims, "nonce123")
+	require.NoError(t, err)
+	assert.True(t, claims.EmailVerified)
+}
+
+func TestExchangeRejectsInvalidIDTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(jwt.MapClaims)
+	}{
+		{"wrong nonce", func(c jwt.MapClaims) { c["nonce"] = "other" }},
+		{"missing nonce", func(c jwt.MapClaims) { delete(c, "nonce") }},
+		{"wrong issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{"wrong audience", func(c jwt.MapClaims) { c["aud"] = "another-client" }},
+		{"foreign authorized party", func(c jwt.MapClaims) {
+			c["aud"] = []string{testClientID, "another-client"}
+			c["azp"] = "another-client"
+		}},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }},
+		{"missing expiry", func(c jwt.MapClaims) { delete(c, "exp") }},
+		{"issued in the future", func(c jwt.MapClaims) { c["iat"] = time.Now().Add(time.Hour).Unix() }},
+		{"missing subject", func(c jwt.MapClaims) { delete(c, "sub") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeProvider(t)
+			claims := fake.validClaims("nonce123")
+			tt.modify(claims)
+
+			_, err := fake.signIn(t, fake.provider(), claims, "nonce123")
+			assert.ErrorIs(t, err, ErrInvalidIDToken)
+		})
+	}
+}
+
+func TestExchangeAcceptsOwnAuthorizedParty(t *testing.T) {
+	fake := newFakeProvider(t)
+	claims := fake.validClaims("nonce123")
+	claims["aud"] = []string{testClientID, "another-client"}
+	claims["azp"] = testClientID
+
+	_, err := fake.signIn(t, fake.provider(), claims, "nonce123")
+	assert.NoError(t, err)
+}
+
+func TestExchangeRejectsTokenSignedWithUnpublishedKey(t *testing.T) {
+	fake := newFakeProvider(t)
+	provider := fake.provider()
+
+	// Prime the key set, then sign with a key the provider doesn't publish
+	_, err := fake.signIn(t, provider, fake.validClaims("nonce123"), "nonce123")
+	require.NoError(t, err)
+
+	forged := newRSAKey(t)
+	fake.mu.Lock()
+	forged.ID = fake.key.ID
+	fake.key = forged
+	fake.mu.Unlock()
+
+	// The forged key reuses the published key ID, so no refetch happens
+	_, err = fake.signIn(t, provider, fake.validClaims("nonce123"), "nonce123")
+	assert.ErrorIs(t, err, ErrInvalidIDToken)
+}
+
+func TestExchangeFollowsKeyRotation(t *testing.T) {
+	fake := newFakeProvider(t)
+	provider := fake.provider()
+
+	_, err := fake.signIn(t, provider, fake.validClaims("nonce123"), "nonce123")
+	require.NoError(t, err)
+
+	fake.mu.Lock()
+	fake.key = newRSAKey(t)
+	fake.mu.Unlock()
+
+	// An unknown key ID makes the provider refetch its keys, once refetching is allowed
+	provider.mu.Lock()
+	provider.keysFetchedAt = time.Now().Add(-minKeyRefreshInterval - time.Second)
+	provider.mu.Unlock()
+
+	_, err = fake.signIn(t, provider, fake.validClaims("nonce123"), "nonce123")
+	require.NoError(t, err)
+	assert.Equal(t, 2, fake.jwksRequests)
+}
+
+func TestExchangeRejectedCode(t *testing.T) {
+	fake := newFakeProvider(t)
+	provider := fake.provider()
+
+	_, err := provider.AuthCodeURL(context.Background(), "state123", "nonce123", testVerifier)
+	require.NoError(t, err)
+	fake.mu.Lock()
+	fake.challenge = oauth.CodeChallenge(testVerifier)
+	fake.claims = fake.validClaims("nonce123")
+	fake.mu.Unlock()
+
+	// A code verifier other than the one the challenge was derived from
+	_, err = provider.Exchange(context.Background(), "code123", testVerifier[1:]+"x", "nonce123")
+	assert.ErrorIs(t, err, ErrExchangeFailed)
+
+	_, err = provider.Exchange(context.Background(), "wrong-code", testVerifier, "nonce123")
+	assert.ErrorIs(t, err, ErrExchangeFailed)
+}
+
+func TestDiscoveryRejectsIssuerMismatch(t *testing.T) {
+	fake := newFakeProvider(t)
+	provider := fake.provider()
+
+	fake.mu.Lock()
+	fake.issuer = "https://accounts.example.com"
+	fake.mu.Unlock()
+
+	_, err := provider.AuthCodeURL(context.Background(), "state123", "nonce123", testVerifier)
+	assert.ErrorIs(t, err, ErrProviderUnavailable)
+}
+
+func TestDiscoveryFailure(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	provider := NewProvider(Config{Name: "down", Issuer: server.URL, ClientID: testClientID})
+	_, err := provider.AuthCodeURL(context.Background(), "state123", "nonce123", testVerifier)
+	assert.ErrorIs(t, err, ErrProviderUnavailable)
+}
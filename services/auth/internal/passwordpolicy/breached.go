@@ -0,0 +1,67 @@
+package passwordpolicy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BreachedList reports whether a password is known from a data breach
+type BreachedList interface {
+	Contains(password string) (bool, error)
+}
+
+// PrefixDir looks passwords up in a local copy of a breached password corpus
+// split by hash prefix, the layout used by the Pwned Passwords range API. The
+// directory holds one file per 5 character prefix of the uppercase hex SHA-1,
+// e.g. 5BAA6, whose lines are the remaining 35 characters, optionally
+// followed by ":<count>". Only the file of the password's prefix is read, and
+// missing files mean no breached password has that prefix.
+type PrefixDir struct {
+	dir string
+}
+
+func NewPrefixDir(dir string) (*PrefixDir, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("breached password path %s is not a directory", dir)
+	}
+	return &PrefixDir{dir: dir}, nil
+}
+
+func (p *PrefixDir) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(p.dir, prefix))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to open breached password file: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(line, suffix) {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("failed to read breached password file: %w", err)
+	}
+
+	return false, nil
+}
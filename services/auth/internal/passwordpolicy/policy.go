@@ -0,0 +1,110 @@
+package passwordpolicy
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pseudoerr/auth-service/internal/validation"
+)
+
+// Policy is the set of rules new passwords must satisfy
+type Policy struct {
+	MinLength     int
+	MaxLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// DisallowPersonalInfo rejects passwords containing the email's local
+	// part or the username
+	DisallowPersonalInfo bool
+}
+
+// Checker enforces a Policy and optionally rejects passwords known from breaches
+type Checker struct {
+	policy   Policy
+	breached BreachedList
+}
+
+// New returns a checker. breached may be nil to skip the breach check.
+func New(policy Policy, breached BreachedList) *Checker {
+	return &Checker{policy: policy, breached: breached}
+}
+
+// Check validates password for the account identified by email and username.
+// Violations are returned as a *validation.Error reporting field.
+func (c *Checker) Check(field, password, email, username string) error {
+	var violations []validation.FieldError
+	violate := func(code, message string) {
+		violations = append(violations, validation.FieldError{Field: field, Code: code, Message: message})
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < c.policy.MinLength {
+		violate("min_length", fmt.Sprintf("%s must be at least %d characters", field, c.policy.MinLength))
+	}
+	if c.policy.MaxLength > 0 && length > c.policy.MaxLength {
+		violate("max_length", fmt.Sprintf("%s must be at most %d characters", field, c.policy.MaxLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, char := range password {
+		switch {
+		case unicode.IsUpper(char):
+			hasUpper = true
+		case unicode.IsLower(char):
+			hasLower = true
+		case unicode.IsDigit(char):
+			hasDigit = true
+		case unicode.IsPunct(char) || unicode.IsSymbol(char) || unicode.IsSpace(char):
+			hasSymbol = true
+		}
+	}
+	if c.policy.RequireUpper && !hasUpper {
+		violate("uppercase", fmt.Sprintf("%s must contain an uppercase letter", field))
+	}
+	if c.policy.RequireLower && !hasLower {
+		violate("lowercase", fmt.Sprintf("%s must contain a lowercase letter", field))
+	}
+	if c.policy.RequireDigit && !hasDigit {
+		violate("digit", fmt.Sprintf("%s must contain a number", field))
+	}
+	if c.policy.RequireSymbol && !hasSymbol {
+		violate("symbol", fmt.Sprintf("%s must contain a symbol", field))
+	}
+
+	if c.policy.DisallowPersonalInfo && containsPersonalInfo(password, email, username) {
+		violate("personal_info", fmt.Sprintf("%s must not contain your email or username", field))
+	}
+
+	// Only bother the corpus with passwords that pass the cheap rules
+	if len(violations) == 0 && c.breached != nil {
+		breached, err := c.breached.Contains(password)
+		if err != nil {
+			slog.Error("Failed to check breached password list", "error", err)
+		} else if breached {
+			violate("breached", fmt.Sprintf("%s appears in a known data breach, choose another one", field))
+		}
+	}
+
+	return validation.NewError(violations...)
+}
+
+// containsPersonalInfo reports whether password contains the username or the
+// local part of the email. Very short values are ignored, they would match
+// too many unrelated passwords.
+func containsPersonalInfo(password, email, username string) bool {
+	lowered := strings.ToLower(password)
+	localPart, _, _ := strings.Cut(email, "@")
+
+	for _, value := range []string{localPart, username} {
+		value = strings.ToLower(value)
+		if utf8.RuneCountInString(value) >= 3 && strings.Contains(lowered, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package passwordpolicy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pseudoerr/auth-service/internal/validation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testPolicy = Policy{
+	MinLength:            8,
+	MaxLength:            64,
+	RequireUpper:         true,
+	RequireLower:         true,
+	RequireDigit:         true,
+	DisallowPersonalInfo: true,
+}
+
+func violationCodes(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var validationErr *validation.Error
+	require.ErrorAs(t, err, &validationErr)
+
+	codes := make([]string, len(validationErr.Fields))
+	for i, field := range validationErr.Fields {
+		assert.Equal(t, "password", field.Field)
+		codes[i] = field.Code
+	}
+	return codes
+}
+
+func TestCheckerRules(t *testing.T) {
+	checker := New(testPolicy, nil)
+
+	tests := []struct {
+		name     string
+		password string
+		codes    []string
+	}{
+		{"valid", "SecurePass123", nil},
+		{"too short", "Sh0rt", []string{"min_length"}},
+		{"too long", "Aa1" + strings.Repeat("a", 62), []string{"max_length"}},
+		{"no uppercase", "password1", []string{"uppercase"}},
+		{"only lowercase", "password", []string{"uppercase", "digit"}},
+		{"contains username", "Learner42Rocks", []string{"personal_info"}},
+		{"contains email local part", "Jane.Doe2024", []string{"personal_info"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checker.Check("password", tt.password, "jane.doe@example.com", "learner42")
+			assert.Equal(t, tt.codes, violationCodes(t, err))
+		})
+	}
+}
+
+func TestCheckerSymbols(t *testing.T) {
+	checker := New(Policy{MinLength: 8, RequireSymbol: true}, nil)
+
+	assert.Equal(t, []string{"symbol"}, violationCodes(t, checker.Check("password", "Password123", "", "")))
+	assert.Nil(t, violationCodes(t, checker.Check("password", "Password 123!", "", "")))
+}
+
+func TestPrefixDir(t *testing.T) {
+	dir := t.TempDir()
+	// SHA-1 of "P@ssw0rd" is 21BD12DC183F740EE76F27B78EB39C8AD972A757
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "21BD1"),
+		[]byte("0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n2DC183F740EE76F27B78EB39C8AD972A757:52579\r\n"), 0o644))
+
+	breached, err := NewPrefixDir(dir)
+	require.NoError(t, err)
+
+	found, err := breached.Contains("P@ssw0rd")
+	require.NoError(t, err)
+	assert.True(t, found)
+
+	found, err = breached.Contains("not in the list")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	checker := New(Policy{MinLength: 8}, breached)
+	assert.Equal(t, []string{"breached"}, violationCodes(t, checker.Check("password", "P@ssw0rd", "", "")))
+}
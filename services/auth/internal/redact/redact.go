@@ -0,0 +1,15 @@
+// Package redact masks personal data before it is written to logs or the
+// security event log, so both show the same shortened form
+package redact
+
+import "strings"
+
+// Email keeps the first character of the local part and the domain, e.g.
+// j***@example.com. Values without an @ are masked completely.
+func Email(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 {
+		return "***"
+	}
+	return email[:1] + "***" + email[at:]
+}
@@ -0,0 +1,16 @@
+package redact
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmail(t *testing.T) {
+	assert.Equal(t, "j***@example.com", Email("john.doe@example.com"))
+	assert.Equal(t, "a***@example.com", Email("a@example.com"))
+	assert.Equal(t, `"***@example.com`, Email(`"a@b"@example.com`))
+	assert.Equal(t, "***", Email("not-an-email"))
+	assert.Equal(t, "***", Email("@example.com"))
+	assert.Equal(t, "***", Email(""))
+}
@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/pseudoerr/auth-service/internal/models"
+	"time"
+)
+
+var ErrActionTokenInvalid = errors.New("token is invalid, expired or already used")
+
+// ActionTokenRepository stores single-use tokens sent by email. Like refresh
+// tokens they are kept as a keyed hash. Each purpose hashes with its own key,
+// so a token issued for one action can never be redeemed for another.
+type ActionTokenRepository struct {
+	db     *sql.DB
+	pepper string
+}
+
+func NewActionTokenRepository(db *sql.DB, pepper string) *ActionTokenRepository {
+	return &ActionTokenRepository{db: db, pepper: pepper}
+}
+
+func (r *ActionTokenRepository) hashToken(purpose, token string) string {
+	return keyedHash(deriveKey(r.pepper, purpose), token)
+}
+
+// legacyHash is how tokens were hashed before each purpose had its own key
+func (r *ActionTokenRepository) legacyHash(purpose, token string) string {
+	return keyedHash([]byte(r.pepper), purpose+":"+token)
+}
+
+func (r *ActionTokenRepository) Create(token *models.ActionToken) error {
+	query := `
+		INSERT INTO action_tokens (user_id, purpose, token_hash, email, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id`
+
+	now := time.Now()
+	err := r.db.QueryRow(query, token.UserID, token.Purpose, r.hashToken(token.Purpose, token.Token),
+		token.Email, token.ExpiresAt, now).Scan(&token.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create action token: %w", err)
+	}
+
+	token.CreatedAt = now
+	return nil
+}
+
+// Get returns a usable token without redeeming it
+func (r *ActionTokenRepository) Get(purpose, token string) (*models.ActionToken, error) {
+	actionToken := &models.ActionToken{}
+	query := `
+		SELECT id, user_id, purpose, email, expires_at, used_at, created_at
+		FROM action_tokens
+		WHERE token_hash IN ($1, $3) AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()`
+
+	err := r.db.QueryRow(query, r.hashToken(purpose, token), purpose, r.legacyHash(purpose, token)).Scan(
+		&actionToken.ID, &actionToken.UserID, &actionToken.Purpose, &actionToken.Email,
+		&actionToken.ExpiresAt, &actionToken.UsedAt, &actionToken.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrActionTokenInvalid
+		}
+		return nil, fmt.Errorf("failed to get action token: %w", err)
+	}
+
+	actionToken.Token = token
+	return actionToken, nil
+}
+
+// Consume marks the token as used and returns it. Checking and marking happen
+// in one statement, so a token can be redeemed at most once.
+func (r *ActionTokenRepository) Consume(purpose, token string) (*models.ActionToken, error) {
+	actionToken := &models.ActionToken{}
+	query := `
+		UPDATE action_tokens
+		SET used_at = NOW()
+		WHERE token_hash IN ($1, $3) AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING id, user_id, purpose, email, expires_at, used_at, created_at`
+
+	err := r.db.QueryRow(query, r.hashToken(purpose, token), purpose, r.legacyHash(purpose, token)).Scan(
+		&actionToken.ID, &actionToken.UserID, &actionToken.Purpose, &actionToken.Email,
+		&actionToken.ExpiresAt, &actionToken.UsedAt, &actionToken.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrActionTokenInvalid
+		}
+		return nil, fmt.Errorf("failed to consume action token: %w", err)
+	}
+
+	actionToken.Token = token
+	return actionToken, nil
+}
+
+// DeleteByUserID removes the user's outstanding tokens for a purpose, so
+// only the most recently sent link works
+func (r *ActionTokenRepository) DeleteByUserID(userID int, purpose string) error {
+	query := `DELETE FROM action_tokens WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`
+
+	_, err := r.db.Exec(query, userID, purpose)
+	if err != nil {
+		return fmt.Errorf("failed to delete action tokens: %w", err)
+	}
+
+	return nil
+}
+
+func (r *ActionTokenRepository) CleanupExpired() error {
+	query := `DELETE FROM action_tokens WHERE expires_at <= NOW()`
+
+	_, err := r.db.Exec(query)
+	if err != nil {
+		return fmt.Errorf("failed to cleanup expired action tokens: %w", err)
+	}
+
+	return nil
+}
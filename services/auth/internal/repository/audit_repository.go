@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/pseudoerr/auth-service/internal/models"
+)
+
+type AuditRepository struct {
+	db *sql.DB
+}
+
+func NewAuditRepository(db *sql.DB) *AuditRepository {
+	return &AuditRepository{db: db}
+}
+
+func (r *AuditRepository) Create(entry *models.AuditEntry) error {
+	details := []byte(entry.Details)
+	if len(details) == 0 {
+		details = []byte("{}")
+	}
+
+	query := `
+		INSERT INTO admin_audit_log (actor_id, action, target_user_id, details, ip_address)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	err := r.db.QueryRow(query, entry.ActorID, entry.Action, entry.TargetUserID, details, entry.IPAddress).
+		Scan(&entry.ID, &entry.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+
+	return nil
+}
+
+// List returns a page of entries, newest first, and the total number of
+// entries. A targetUserID of 0 lists entries about all users.
+func (r *AuditRepository) List(targetUserID, limit, offset int) ([]models.AuditEntry, int, error) {
+	filter := `WHERE $1 = 0 OR target_user_id = $1`
+
+	var total int
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM admin_audit_log `+filter, targetUserID).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count audit entries: %w", err)
+	}
+
+	rows, err := r.db.Query(`
+		SELECT id, actor_id, action, target_user_id, details, ip_address, created_at
+		FROM admin_audit_log `+filter+`
+		ORDER BY id DESC
+		LIMIT $2 OFFSET $3`, targetUserID, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list audit entries: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		var entry models.AuditEntry
+		var details []byte
+		if err := rows.Scan(&entry.ID, &entry.ActorID, &entry.Action, &entry.TargetUserID,
+			&details, &entry.IPAddress, &entry.CreatedAt); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		entry.Details = details
+		entries = append(entries, entry)
+	}
+
+	return entries, total, rows.Err()
+}
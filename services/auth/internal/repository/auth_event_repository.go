@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pseudoerr/auth-service/internal/models"
+)
+
+// AuthEventRepository appends to and queries the security log. Events are
+// never updated; the table rejects changes and early deletes.
+type AuthEventRepository struct {
+	db        *sql.DB
+	retention time.Duration
+}
+
+// MinAuthEventRetention matches the append-only trigger on auth_events, which
+// refuses to delete younger rows
+const MinAuthEventRetention = 30 * 24 * time.Hour
+
+// NewAuthEventRepository keeps events for retention, but never for less than
+// MinAuthEventRetention
+func NewAuthEventRepository(db *sql.DB, retention time.Duration) *AuthEventRepository {
+	if retention < MinAuthEventRetention {
+		retention = MinAuthEventRetention
+	}
+	return &AuthEventRepository{db: db, retention: retention}
+}
+
+func (r *AuthEventRepository) Create(event *models.AuthEvent) error {
+	details := []byte(event.Details)
+	if len(details) == 0 {
+		details = []byte("{}")
+	}
+
+	query := `
+		INSERT INTO auth_events (event_type, outcome, user_id, actor_id, ip_address, user_agent, details)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at`
+
+	err := r.db.QueryRow(query, event.Type, event.Outcome, event.UserID, event.ActorID,
+		event.IPAddress, event.UserAgent, details).Scan(&event.ID, &event.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to write auth event: %w", err)
+	}
+
+	return nil
+}
+
+// List returns a page of the events matching filter, newest first, and the
+// total number of matches
+func (r *AuthEventRepository) List(filter models.AuthEventFilter, limit, offset int) ([]models.AuthEvent, int, error) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.UserID != 0 {
+		add("user_id = $%d", filter.UserID)
+	}
+	if filter.Type != "" {
+		add("event_type = $%d", filter.Type)
+	}
+	if filter.Outcome != "" {
+		add("outcome = $%d", filter.Outcome)
+	}
+	if filter.IPAddress != "" {
+		add("ip_address = $%d", filter.IPAddress)
+	}
+	if !filter.Since.IsZero() {
+		add("created_at >= $%d", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		add("created_at < $%d", filter.Until)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM auth_events `+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count auth events: %w", err)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, event_type, outcome, user_id, actor_id, ip_address, user_agent, details, created_at
+		FROM auth_events %s
+		ORDER BY id DESC
+		LIMIT $%d OFFSET $%d`, where, len(args)+1, len(args)+2)
+
+	rows, err := r.db.Query(query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list auth events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []models.AuthEvent{}
+	for rows.Next() {
+		var event models.AuthEvent
+		var details []byte
+		if err := rows.Scan(&event.ID, &event.Type, &event.Outcome, &event.UserID, &event.ActorID,
+			&event.IPAddress, &event.UserAgent, &details, &event.CreatedAt); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan auth event: %w", err)
+		}
+		event.Details = details
+		events = append(events, event)
+	}
+
+	return events, total, rows.Err()
+}
+
+// PurgeExpired deletes events older than the retention period
+func (r *AuthEventRepository) PurgeExpired() error {
+	query := `DELETE FROM auth_events WHERE created_at < $1`
+
+	if _, err := r.db.Exec(query, time.Now().Add(-r.retention)); err != nil {
+		return fmt.Errorf("failed to purge auth events: %w", err)
+	}
+
+	return nil
+}
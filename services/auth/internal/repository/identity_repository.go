@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pseudoerr/auth-service/internal/models"
+)
+
+var (
+	ErrIdentityNotFound = errors.New("identity not found")
+	// ErrIdentityTaken means the provider identity belongs to another account
+	ErrIdentityTaken = errors.New("identity is linked to another account")
+	// ErrProviderLinked means the account already has an identity at the provider
+	ErrProviderLinked = errors.New("account already has an identity at this provider")
+)
+
+// identityColumns are selected by every query returning identities, in the order scanIdentity reads them
+const identityColumns = `id, user_id, provider, subject, email, last_used_at, created_at`
+
+func scanIdentity(row rowScanner) (*models.UserIdentity, error) {
+	identity := &models.UserIdentity{}
+	var email sql.NullString
+	err := row.Scan(
+		&identity.ID, &identity.UserID, &identity.Provider, &identity.Subject, &email,
+		&identity.LastUsedAt, &identity.CreatedAt,
+	)
+	identity.Email = email.String
+	return identity, err
+}
+
+type IdentityRepository struct {
+	db *sql.DB
+}
+
+func NewIdentityRepository(db *sql.DB) *IdentityRepository {
+	return &IdentityRepository{db: db}
+}
+
+func (r *IdentityRepository) Create(identity *models.UserIdentity) error {
+	query := `
+		INSERT INTO user_identities (user_id, provider, subject, email, last_used_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $5)
+		RETURNING id`
+
+	now := time.Now()
+	email := sql.NullString{String: identity.Email, Valid: identity.Email != ""}
+	err := r.db.QueryRow(query, identity.UserID, identity.Provider, identity.Subject, email, now).Scan(&identity.ID)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			if pqErr.Constraint == "user_identities_user_id_provider_key" {
+				return ErrProviderLinked
+			}
+			return ErrIdentityTaken
+		}
+		return fmt.Errorf("failed to create identity: %w", err)
+	}
+
+	identity.LastUsedAt = &now
+	identity.CreatedAt = now
+	return nil
+}
+
+func (r *IdentityRepository) GetBySubject(provider, subject string) (*models.UserIdentity, error) {
+	query := `SELECT ` + identityColumns + ` FROM user_identities WHERE provider = $1 AND subject = $2`
+
+	identity, err := scanIdentity(r.db.QueryRow(query, provider, subject))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrIdentityNotFound
+		}
+		return nil, fmt.Errorf("failed to get identity: %w", err)
+	}
+
+	return identity, nil
+}
+
+func (r *IdentityRepository) ListByUserID(userID int) ([]models.UserIdentity, error) {
+	query := `SELECT ` + identityColumns + ` FROM user_identities WHERE user_id = $1 ORDER BY created_at, id`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list identities: %w", err)
+	}
+	defer rows.Close()
+
+	identities := []models.UserIdentity{}
+	for rows.Next() {
+		identity, err := scanIdentity(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan identity: %w", err)
+		}
+		identities = append(identities, *identity)
+	}
+
+	return identities, rows.Err()
+}
+
+// RecordUse notes a sign-in with the identity and the email address the
+// provider currently reports for it
+func (r *IdentityRepository) RecordUse(id int, email string) error {
+	query := `UPDATE user_identities SET last_used_at = NOW(), email = COALESCE($1, email) WHERE id = $2`
+
+	_, err := r.db.Exec(query, sql.NullString{String: email, Valid: email != ""}, id)
+	if err != nil {
+		return fmt.Errorf("failed to record identity use: %w", err)
+	}
+
+	return nil
+}
+
+// Delete unlinks one of the user's identities. It returns ErrIdentityNotFound
+// if the user has no identity with that ID.
+func (r *IdentityRepository) Delete(userID, id int) error {
+	query := `DELETE FROM user_identities WHERE id = $1 AND user_id = $2`
+
+	result, err := r.db.Exec(query, id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete identity: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete identity: %w", err)
+	}
+	if rows == 0 {
+		return ErrIdentityNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/pseudoerr/auth-service/internal/models"
+	"time"
+)
+
+var ErrMFANotFound = errors.New("two-factor authentication is not set up")
+
+// MFARepository stores TOTP enrollments and recovery codes. Recovery codes
+// are kept as a keyed hash, the TOTP secret is encrypted by the caller.
+type MFARepository struct {
+	db     *sql.DB
+	pepper []byte
+	legacy []byte
+}
+
+func NewMFARepository(db *sql.DB, pepper string) *MFARepository {
+	return &MFARepository{db: db, pepper: deriveKey(pepper, "mfa_recovery_code"), legacy: []byte(pepper)}
+}
+
+func (r *MFARepository) hashRecoveryCode(code string) string {
+	return keyedHash(r.pepper, code)
+}
+
+func (r *MFARepository) legacyHash(code string) string {
+	return keyedHash(r.legacy, "recovery_code:"+code)
+}
+
+func (r *MFARepository) GetByUserID(userID int) (*models.UserMFA, error) {
+	mfa := &models.UserMFA{}
+	query := `
+		SELECT user_id, secret_encrypted, enabled_at, last_used_step, created_at
+		FROM user_mfa
+		WHERE user_id = $1`
+
+	err := r.db.QueryRow(query, userID).Scan(
+		&mfa.UserID, &mfa.SecretEncrypted, &mfa.EnabledAt, &mfa.LastUsedStep, &mfa.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrMFANotFound
+		}
+		return nil, fmt.Errorf("failed to get mfa enrollment: %w", err)
+	}
+
+	return mfa, nil
+}
+
+// SavePending stores a new, not yet confirmed secret. An existing pending
+// enrollment is replaced, an enabled one is left untouched.
+func (r *MFARepository) SavePending(userID int, secretEncrypted string) error {
+	query := `
+		INSERT INTO user_mfa (user_id, secret_encrypted, created_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret_encrypted = EXCLUDED.secret_encrypted, last_used_step = 0, created_at = EXCLUDED.created_at
+		WHERE user_mfa.enabled_at IS NULL`
+
+	if _, err := r.db.Exec(query, userID, secretEncrypted, time.Now()); err != nil {
+		return fmt.Errorf("failed to save mfa enrollment: %w", err)
+	}
+
+	return nil
+}
+
+// Enable activates the enrollment and replaces the user's recovery codes
+func (r *MFARepository) Enable(userID int, step int64, recoveryCodes []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	query := `UPDATE user_mfa SET enabled_at = $1, last_used_step = $2 WHERE user_id = $3 AND enabled_at IS NULL`
+	result, err := tx.Exec(query, now, step, userID)
+	if err != nil {
+		return fmt.Errorf("failed to enable mfa: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return ErrMFANotFound
+	}
+
+	if _, err := tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	for _, code := range recoveryCodes {
+		insert := `INSERT INTO mfa_recovery_codes (user_id, code_hash, created_at) VALUES ($1, $2, $3)`
+		if _, err := tx.Exec(insert, userID, r.hashRecoveryCode(code), now); err != nil {
+			return fmt.Errorf("failed to save recovery code: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit mfa enrollment: %w", err)
+	}
+
+	return nil
+}
+
+// UseStep records a successfully verified TOTP time step. It returns false if
+// that step or a later one was already used, which means the code is replayed.
+func (r *MFARepository) UseStep(userID int, step int64) (bool, error) {
+	query := `UPDATE user_mfa SET last_used_step = $1 WHERE user_id = $2 AND last_used_step < $1`
+
+	result, err := r.db.Exec(query, step, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to record totp step: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to record totp step: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+// UseRecoveryCode burns a recovery code, reporting whether it was valid
+func (r *MFARepository) UseRecoveryCode(userID int, code string) (bool, error) {
+	query := `
+		UPDATE mfa_recovery_codes
+		SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash IN ($2, $3) AND used_at IS NULL`
+
+	result, err := r.db.Exec(query, userID, r.hashRecoveryCode(code), r.legacyHash(code))
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+func (r *MFARepository) DeleteByUserID(userID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM user_mfa WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete mfa enrollment: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit mfa removal: %w", err)
+	}
+
+	return nil
+}
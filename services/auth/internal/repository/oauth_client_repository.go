@@ -0,0 +1,188 @@
+package repository
+
+import (
+	"crypto/hmac"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/pseudoerr/auth-service/internal/models"
+)
+
+var (
+	ErrInvalidClient = errors.New("unknown client, revoked client or wrong secret")
+	ErrClientIDTaken = errors.New("client ID already exists")
+	ErrUnknownScope  = errors.New("scope is not a known permission")
+)
+
+// clientColumns are selected by every query returning clients, in the order scanClient reads them
+const clientColumns = `id, client_id, name, scopes, redirect_uris, public, revoked_at, created_at`
+
+func scanClient(row rowScanner) (*models.OAuthClient, error) {
+	client := &models.OAuthClient{}
+	err := row.Scan(
+		&client.ID, &client.ClientID, &client.Name, pq.Array(&client.Scopes), pq.Array(&client.RedirectURIs),
+		&client.Public, &client.RevokedAt, &client.CreatedAt,
+	)
+	return client, err
+}
+
+// OAuthClientRepository stores client secrets as a keyed hash, like refresh
+// tokens. Secrets are random, so a fast hash is enough.
+type OAuthClientRepository struct {
+	db     *sql.DB
+	pepper []byte
+	legacy []byte
+}
+
+func NewOAuthClientRepository(db *sql.DB, pepper string) *OAuthClientRepository {
+	return &OAuthClientRepository{db: db, pepper: deriveKey(pepper, "oauth_client_secret"), legacy: []byte(pepper)}
+}
+
+func (r *OAuthClientRepository) hashSecret(secret string) string {
+	return keyedHash(r.pepper, secret)
+}
+
+func (r *OAuthClientRepository) legacyHash(secret string) string {
+	return keyedHash(r.legacy, secret)
+}
+
+// Create registers a client. Its scopes must be known permissions. Public
+// clients are created without a secret.
+func (r *OAuthClientRepository) Create(client *models.OAuthClient, secret string) error {
+	var unknown []string
+	check := `SELECT ARRAY(SELECT unnest($1::text[]) EXCEPT SELECT name FROM permissions)`
+	if err := r.db.QueryRow(check, pq.Array(client.Scopes)).Scan(pq.Array(&unknown)); err != nil {
+		return fmt.Errorf("failed to check client scopes: %w", err)
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("%w: %s", ErrUnknownScope, strings.Join(unknown, ", "))
+	}
+
+	var secretHash sql.NullString
+	if !client.Public {
+		secretHash = sql.NullString{String: r.hashSecret(secret), Valid: true}
+	}
+
+	query := `
+		INSERT INTO oauth_clients (client_id, name, secret_hash, scopes, redirect_uris, public)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (client_id) DO NOTHING
+		RETURNING id, created_at`
+
+	err := r.db.QueryRow(query, client.ClientID, client.Name, secretHash, pq.Array(client.Scopes),
+		pq.Array(client.RedirectURIs), client.Public).Scan(&client.ID, &client.CreatedAt)
+	if err == sql.ErrNoRows {
+		return ErrClientIDTaken
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create client: %w", err)
+	}
+
+	return nil
+}
+
+// Authenticate returns the active confidential client with the given ID if
+// secret matches. Public clients have no secret to authenticate with.
+func (r *OAuthClientRepository) Authenticate(clientID, secret string) (*models.OAuthClient, error) {
+	var secretHash sql.NullString
+	query := `SELECT ` + clientColumns + `, secret_hash FROM oauth_clients WHERE client_id = $1 AND revoked_at IS NULL`
+
+	client := &models.OAuthClient{}
+	err := r.db.QueryRow(query, clientID).Scan(
+		&client.ID, &client.ClientID, &client.Name, pq.Array(&client.Scopes), pq.Array(&client.RedirectURIs),
+		&client.Public, &client.RevokedAt, &client.CreatedAt, &secretHash,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidClient
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client: %w", err)
+	}
+
+	if !secretHash.Valid {
+		return nil, ErrInvalidClient
+	}
+	if !hmac.Equal([]byte(secretHash.String), []byte(r.hashSecret(secret))) {
+		if !hmac.Equal([]byte(secretHash.String), []byte(r.legacyHash(secret))) {
+			return nil, ErrInvalidClient
+		}
+		// Secrets stored with their legacy hash are rehashed on first use
+		rehash := `UPDATE oauth_clients SET secret_hash = $1 WHERE id = $2 AND secret_hash = $3`
+		if _, err := r.db.Exec(rehash, r.hashSecret(secret), client.ID, secretHash.String); err != nil {
+			return nil, fmt.Errorf("failed to rehash client secret: %w", err)
+		}
+	}
+
+	return client, nil
+}
+
+// Get returns the active client with the given ID without authenticating it
+func (r *OAuthClientRepository) Get(clientID string) (*models.OAuthClient, error) {
+	query := `SELECT ` + clientColumns + ` FROM oauth_clients WHERE client_id = $1 AND revoked_at IS NULL`
+
+	client, err := scanClient(r.db.QueryRow(query, clientID))
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidClient
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client: %w", err)
+	}
+
+	return client, nil
+}
+
+// List returns every client, revoked ones included, newest first
+func (r *OAuthClientRepository) List() ([]models.OAuthClient, error) {
+	query := `SELECT ` + clientColumns + ` FROM oauth_clients ORDER BY created_at DESC, id DESC`
+
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list clients: %w", err)
+	}
+	defer rows.Close()
+
+	clients := []models.OAuthClient{}
+	for rows.Next() {
+		client, err := scanClient(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan client: %w", err)
+		}
+		clients = append(clients, *client)
+	}
+
+	return clients, rows.Err()
+}
+
+// IsActive reports whether the client exists and was not revoked
+func (r *OAuthClientRepository) IsActive(clientID string) (bool, error) {
+	var active bool
+	query := `SELECT EXISTS(SELECT 1 FROM oauth_clients WHERE client_id = $1 AND revoked_at IS NULL)`
+
+	if err := r.db.QueryRow(query, clientID).Scan(&active); err != nil {
+		return false, fmt.Errorf("failed to check client: %w", err)
+	}
+
+	return active, nil
+}
+
+func (r *OAuthClientRepository) Revoke(clientID string) error {
+	query := `UPDATE oauth_clients SET revoked_at = NOW() WHERE client_id = $1 AND revoked_at IS NULL`
+
+	result, err := r.db.Exec(query, clientID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke client: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to revoke client: %w", err)
+	}
+	if rows == 0 {
+		return ErrInvalidClient
+	}
+
+	return nil
+}
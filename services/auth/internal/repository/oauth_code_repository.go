@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pseudoerr/auth-service/internal/models"
+)
+
+var (
+	ErrAuthorizationCodeInvalid = errors.New("authorization code is invalid or expired")
+	ErrAuthorizationCodeReused  = errors.New("authorization code was already redeemed")
+)
+
+// OAuthCodeRepository stores authorization codes as a keyed hash. Redeemed
+// codes are kept until they expire so a second redemption can be detected.
+type OAuthCodeRepository struct {
+	db     *sql.DB
+	pepper []byte
+	legacy []byte
+}
+
+func NewOAuthCodeRepository(db *sql.DB, pepper string) *OAuthCodeRepository {
+	return &OAuthCodeRepository{db: db, pepper: deriveKey(pepper, "oauth_authorization_code"), legacy: []byte(pepper)}
+}
+
+func (r *OAuthCodeRepository) hashCode(code string) string {
+	return keyedHash(r.pepper, code)
+}
+
+func (r *OAuthCodeRepository) legacyHash(code string) string {
+	return keyedHash(r.legacy, code)
+}
+
+func (r *OAuthCodeRepository) Create(code *models.AuthorizationCode) error {
+	query := `
+		INSERT INTO oauth_authorization_codes
+			(code_hash, client_id, user_id, family_id, redirect_uri, scopes, code_challenge, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id`
+
+	now := time.Now()
+	err := r.db.QueryRow(query, r.hashCode(code.Code), code.ClientID, code.UserID, code.FamilyID, code.RedirectURI,
+		pq.Array(code.Scopes), code.CodeChallenge, code.ExpiresAt, now).Scan(&code.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create authorization code: %w", err)
+	}
+
+	code.CreatedAt = now
+	return nil
+}
+
+// Consume marks the code as used and returns it. Checking and marking happen
+// in one statement, so a code can be redeemed at most once. Presenting a
+// redeemed code again yields ErrAuthorizationCodeReused along with the code,
+// so the tokens it was exchanged for can be revoked.
+func (r *OAuthCodeRepository) Consume(code string) (*models.AuthorizationCode, error) {
+	query := `
+		UPDATE oauth_authorization_codes
+		SET used_at = NOW()
+		WHERE code_hash IN ($1, $2) AND used_at IS NULL AND expires_at > NOW()
+		RETURNING id, client_id, user_id, family_id, redirect_uri, scopes, code_challenge, expires_at, used_at, created_at`
+
+	authCode, err := scanAuthorizationCode(r.db.QueryRow(query, r.hashCode(code), r.legacyHash(code)))
+	if err == nil {
+		return authCode, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to consume authorization code: %w", err)
+	}
+
+	reused := `
+		SELECT id, client_id, user_id, family_id, redirect_uri, scopes, code_challenge, expires_at, used_at, created_at
+		FROM oauth_authorization_codes
+		WHERE code_hash IN ($1, $2) AND used_at IS NOT NULL`
+
+	authCode, err = scanAuthorizationCode(r.db.QueryRow(reused, r.hashCode(code), r.legacyHash(code)))
+	if err == sql.ErrNoRows {
+		return nil, ErrAuthorizationCodeInvalid
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get authorization code: %w", err)
+	}
+	return authCode, ErrAuthorizationCodeReused
+}
+
+func scanAuthorizationCode(row rowScanner) (*models.AuthorizationCode, error) {
+	code := &models.AuthorizationCode{}
+	err := row.Scan(
+		&code.ID, &code.ClientID, &code.UserID, &code.FamilyID, &code.RedirectURI, pq.Array(&code.Scopes),
+		&code.CodeChallenge, &code.ExpiresAt, &code.UsedAt, &code.CreatedAt,
+	)
+	return code, err
+}
+
+func (r *OAuthCodeRepository) CleanupExpired() error {
+	query := `DELETE FROM oauth_authorization_codes WHERE expires_at <= NOW()`
+
+	_, err := r.db.Exec(query)
+	if err != nil {
+		return fmt.Errorf("failed to cleanup expired authorization codes: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pseudoerr/auth-service/internal/models"
+)
+
+var (
+	ErrDeviceCodeInvalid = errors.New("device code is invalid or expired")
+	ErrUserCodeTaken     = errors.New("user code is already in use")
+)
+
+// deviceAuthorizationColumns are selected by every query returning device
+// authorizations, in the order scanDeviceAuthorization reads them
+const deviceAuthorizationColumns = `id, client_id, scopes, status, user_id, poll_interval, expires_at, created_at`
+
+func scanDeviceAuthorization(row rowScanner) (*models.DeviceAuthorization, error) {
+	authorization := &models.DeviceAuthorization{}
+	err := row.Scan(
+		&authorization.ID, &authorization.ClientID, pq.Array(&authorization.Scopes), &authorization.Status,
+		&authorization.UserID, &authorization.Interval, &authorization.ExpiresAt, &authorization.CreatedAt,
+	)
+	return authorization, err
+}
+
+// OAuthDeviceRepository stores device authorizations with keyed hashes of
+// the device and user codes
+type OAuthDeviceRepository struct {
+	db     *sql.DB
+	pepper []byte
+	legacy []byte
+}
+
+func NewOAuthDeviceRepository(db *sql.DB, pepper string) *OAuthDeviceRepository {
+	return &OAuthDeviceRepository{db: db, pepper: deriveKey(pepper, "oauth_device_code"), legacy: []byte(pepper)}
+}
+
+func (r *OAuthDeviceRepository) hashCode(code string) string {
+	return keyedHash(r.pepper, code)
+}
+
+func (r *OAuthDeviceRepository) legacyHash(code string) string {
+	return keyedHash(r.legacy, code)
+}
+
+// Create stores a pending device authorization. ErrUserCodeTaken is returned
+// if another authorization has the same user code.
+func (r *OAuthDeviceRepository) Create(authorization *models.DeviceAuthorization) error {
+	query := `
+		INSERT INTO oauth_device_codes
+			(device_code_hash, user_code_hash, client_id, scopes, status, poll_interval, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id`
+
+	now := time.Now()
+	err := r.db.QueryRow(query, r.hashCode(authorization.DeviceCode), r.hashCode(authorization.UserCode),
+		authorization.ClientID, pq.Array(authorization.Scopes), models.DeviceAuthorizationPending,
+		authorization.Interval, authorization.ExpiresAt, now).Scan(&authorization.ID)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "oauth_device_codes_user_code_hash_key" {
+			return ErrUserCodeTaken
+		}
+		return fmt.Errorf("failed to create device authorization: %w", err)
+	}
+
+	authorization.Status = models.DeviceAuthorizationPending
+	authorization.CreatedAt = now
+	return nil
+}
+
+// GetPendingByUserCode returns the unexpired authorization awaiting the
+// user's decision with the given user code
+func (r *OAuthDeviceRepository) GetPendingByUserCode(userCode string) (*models.DeviceAuthorization, error) {
+	query := `
+		SELECT ` + deviceAuthorizationColumns + `
+		FROM oauth_device_codes
+		WHERE user_code_hash IN ($1, $3) AND status = $2 AND expires_at > NOW()`
+
+	authorization, err := scanDeviceAuthorization(r.db.QueryRow(query, r.hashCode(userCode), models.DeviceAuthorizationPending,
+		r.legacyHash(userCode)))
+	if err == sql.ErrNoRows {
+		return nil, ErrDeviceCodeInvalid
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device authorization: %w", err)
+	}
+
+	return authorization, nil
+}
+
+// Decide records the user's decision on a pending authorization, narrowing
+// its scopes to those the user granted. It fails with ErrDeviceCodeInvalid
+// once the authorization was decided or expired.
+func (r *OAuthDeviceRepository) Decide(id, userID int, status string, scopes []string) error {
+	query := `
+		UPDATE oauth_device_codes
+		SET status = $1, user_id = $2, scopes = $3
+		WHERE id = $4 AND status = $5 AND expires_at > NOW()`
+
+	result, err := r.db.Exec(query, status, userID, pq.Array(scopes), id, models.DeviceAuthorizationPending)
+	if err != nil {
+		return fmt.Errorf("failed to decide device authorization: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to decide device authorization: %w", err)
+	}
+	if rows == 0 {
+		return ErrDeviceCodeInvalid
+	}
+
+	return nil
+}
+
+// Poll returns the client's authorization with the device code and records
+// the poll. A poll sooner than the interval after the previous one lengthens
+// the interval by five seconds (RFC 8628 section 3.5) and reports tooSoon.
+func (r *OAuthDeviceRepository) Poll(clientID, deviceCode string) (authorization *models.DeviceAuthorization, tooSoon bool, err error) {
+	query := `
+		WITH previous AS (
+			SELECT id, poll_interval, last_polled_at
+			FROM oauth_device_codes
+			WHERE device_code_hash IN ($1, $3) AND client_id = $2
+			FOR UPDATE
+		)
+		UPDATE oauth_device_codes d
+		SET last_polled_at = NOW(),
+			poll_interval = CASE
+				WHEN previous.last_polled_at > NOW() - previous.poll_interval * INTERVAL '1 second'
+				THEN previous.poll_interval + 5
+				ELSE previous.poll_interval
+			END
+		FROM previous
+		WHERE d.id = previous.id
+		RETURNING d.id, d.client_id, d.scopes, d.status, d.user_id, d.poll_interval, d.expires_at, d.created_at,
+			d.poll_interval > previous.poll_interval`
+
+	authorization = &models.DeviceAuthorization{}
+	err = r.db.QueryRow(query, r.hashCode(deviceCode), clientID, r.legacyHash(deviceCode)).Scan(
+		&authorization.ID, &authorization.ClientID, pq.Array(&authorization.Scopes), &authorization.Status,
+		&authorization.UserID, &authorization.Interval, &authorization.ExpiresAt, &authorization.CreatedAt, &tooSoon,
+	)
+	if err == sql.ErrNoRows {
+		return nil, false, ErrDeviceCodeInvalid
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to poll device authorization: %w", err)
+	}
+
+	return authorization, tooSoon, nil
+}
+
+// Delete removes a decided authorization and reports whether this call did,
+// so the tokens of an approved one are issued at most once
+func (r *OAuthDeviceRepository) Delete(id int) (bool, error) {
+	query := `DELETE FROM oauth_device_codes WHERE id = $1`
+
+	result, err := r.db.Exec(query, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete device authorization: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete device authorization: %w", err)
+	}
+
+	return rows > 0, nil
+}
+
+func (r *OAuthDeviceRepository) CleanupExpired() error {
+	query := `DELETE FROM oauth_device_codes WHERE expires_at <= NOW()`
+
+	_, err := r.db.Exec(query)
+	if err != nil {
+		return fmt.Errorf("failed to cleanup expired device authorizations: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,232 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pseudoerr/auth-service/internal/models"
+)
+
+// OAuthTokenRepository stores the refresh tokens of applications acting for
+// users. They rotate like session refresh tokens, but live in a table of their
+// own so that a third-party application never shows up as, or can be mistaken
+// for, a session of the user.
+type OAuthTokenRepository struct {
+	db     *sql.DB
+	pepper []byte
+	legacy []byte
+}
+
+func NewOAuthTokenRepository(db *sql.DB, pepper string) *OAuthTokenRepository {
+	return &OAuthTokenRepository{db: db, pepper: deriveKey(pepper, "oauth_refresh_token"), legacy: []byte(pepper)}
+}
+
+func (r *OAuthTokenRepository) hashToken(token string) string {
+	return keyedHash(r.pepper, token)
+}
+
+func (r *OAuthTokenRepository) legacyHash(token string) string {
+	return keyedHash(r.legacy, token)
+}
+
+func (r *OAuthTokenRepository) Create(token *models.OAuthRefreshToken) error {
+	query := `
+		INSERT INTO oauth_refresh_tokens (token_hash, family_id, client_id, user_id, scopes, expires_at, last_used_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+		RETURNING id`
+
+	now := time.Now()
+	err := r.db.QueryRow(query, r.hashToken(token.Token), token.FamilyID, token.ClientID, token.UserID,
+		pq.Array(token.Scopes), token.ExpiresAt, now).Scan(&token.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create oauth refresh token: %w", err)
+	}
+
+	token.LastUsedAt = now
+	token.CreatedAt = now
+	return nil
+}
+
+// GetByToken returns a usable token of the client without rotating it
+func (r *OAuthTokenRepository) GetByToken(clientID, token string) (*models.OAuthRefreshToken, error) {
+	query := `
+		SELECT id, family_id, client_id, user_id, scopes, expires_at, last_used_at, created_at
+		FROM oauth_refresh_tokens
+		WHERE token_hash IN ($1, $3) AND client_id = $2 AND rotated_at IS NULL AND expires_at > NOW()`
+
+	refreshToken := &models.OAuthRefreshToken{}
+	err := r.db.QueryRow(query, r.hashToken(token), clientID, r.legacyHash(token)).Scan(
+		&refreshToken.ID, &refreshToken.FamilyID, &refreshToken.ClientID, &refreshToken.UserID,
+		pq.Array(&refreshToken.Scopes), &refreshToken.ExpiresAt, &refreshToken.LastUsedAt, &refreshToken.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRefreshTokenNotFound
+		}
+		return nil, fmt.Errorf("failed to get oauth refresh token: %w", err)
+	}
+
+	refreshToken.Token = token
+	return refreshToken, nil
+}
+
+// Rotate exchanges oldToken of the client for newToken, with the same reuse
+// detection as TokenRepository.Rotate: presenting a rotated token revokes the
+// whole family and returns ErrRefreshTokenReused. The new token inherits the
+// family, user, scopes and created_at of the old one.
+func (r *OAuthTokenRepository) Rotate(clientID, oldToken string, newToken *models.OAuthRefreshToken) (*models.OAuthRefreshToken, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	current := &models.OAuthRefreshToken{}
+	query := `
+		SELECT id, family_id, client_id, user_id, scopes, expires_at, rotated_at, created_at
+		FROM oauth_refresh_tokens
+		WHERE token_hash IN ($1, $3) AND client_id = $2
+		FOR UPDATE`
+
+	err = tx.QueryRow(query, r.hashToken(oldToken), clientID, r.legacyHash(oldToken)).Scan(
+		&current.ID, &current.FamilyID, &current.ClientID, &current.UserID,
+		pq.Array(&current.Scopes), &current.ExpiresAt, &current.RotatedAt, &current.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRefreshTokenNotFound
+		}
+		return nil, fmt.Errorf("failed to get oauth refresh token: %w", err)
+	}
+	current.Token = oldToken
+
+	if current.RotatedAt != nil {
+		if _, err := tx.Exec(`DELETE FROM oauth_refresh_tokens WHERE family_id = $1`, current.FamilyID); err != nil {
+			return nil, fmt.Errorf("failed to revoke oauth token family: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("failed to commit family revocation: %w", err)
+		}
+		return current, ErrRefreshTokenReused
+	}
+
+	now := time.Now()
+	if !current.ExpiresAt.After(now) {
+		return nil, ErrRefreshTokenNotFound
+	}
+
+	if _, err := tx.Exec(`UPDATE oauth_refresh_tokens SET rotated_at = $1 WHERE id = $2`, now, current.ID); err != nil {
+		return nil, fmt.Errorf("failed to mark oauth refresh token as rotated: %w", err)
+	}
+
+	newToken.FamilyID = current.FamilyID
+	newToken.ClientID = current.ClientID
+	newToken.UserID = current.UserID
+	newToken.Scopes = current.Scopes
+
+	insert := `
+		INSERT INTO oauth_refresh_tokens (token_hash, family_id, client_id, user_id, scopes, expires_at, last_used_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id`
+
+	err = tx.QueryRow(insert, r.hashToken(newToken.Token), newToken.FamilyID, newToken.ClientID, newToken.UserID,
+		pq.Array(newToken.Scopes), newToken.ExpiresAt, now, current.CreatedAt).Scan(&newToken.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create oauth refresh token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit oauth token rotation: %w", err)
+	}
+
+	newToken.LastUsedAt = now
+	newToken.CreatedAt = current.CreatedAt
+	return newToken, nil
+}
+
+// ListGrants returns the applications the user authorized that still hold a
+// usable refresh token, most recently used first
+func (r *OAuthTokenRepository) ListGrants(userID int) ([]models.OAuthGrant, error) {
+	query := `
+		SELECT t.family_id, t.client_id, c.name, t.scopes, t.created_at, t.last_used_at, t.expires_at
+		FROM oauth_refresh_tokens t
+		JOIN oauth_clients c ON c.client_id = t.client_id
+		WHERE t.user_id = $1 AND t.rotated_at IS NULL AND t.expires_at > NOW() AND c.revoked_at IS NULL
+		ORDER BY t.last_used_at DESC`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list oauth grants: %w", err)
+	}
+	defer rows.Close()
+
+	grants := []models.OAuthGrant{}
+	for rows.Next() {
+		var grant models.OAuthGrant
+		if err := rows.Scan(
+			&grant.ID, &grant.ClientID, &grant.ClientName, pq.Array(&grant.Scopes),
+			&grant.CreatedAt, &grant.LastUsedAt, &grant.ExpiresAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan oauth grant: %w", err)
+		}
+		grants = append(grants, grant)
+	}
+
+	return grants, rows.Err()
+}
+
+// DeleteGrant deletes the user's token family. It returns
+// ErrRefreshTokenNotFound if the user has no such grant.
+func (r *OAuthTokenRepository) DeleteGrant(userID int, familyID string) error {
+	query := `DELETE FROM oauth_refresh_tokens WHERE user_id = $1 AND family_id = $2`
+
+	result, err := r.db.Exec(query, userID, familyID)
+	if err != nil {
+		return fmt.Errorf("failed to delete oauth grant: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete oauth grant: %w", err)
+	}
+	if affected == 0 {
+		return ErrRefreshTokenNotFound
+	}
+
+	return nil
+}
+
+func (r *OAuthTokenRepository) DeleteFamily(familyID string) error {
+	query := `DELETE FROM oauth_refresh_tokens WHERE family_id = $1`
+
+	_, err := r.db.Exec(query, familyID)
+	if err != nil {
+		return fmt.Errorf("failed to delete oauth token family: %w", err)
+	}
+
+	return nil
+}
+
+func (r *OAuthTokenRepository) DeleteAllByUserID(userID int) error {
+	query := `DELETE FROM oauth_refresh_tokens WHERE user_id = $1`
+
+	_, err := r.db.Exec(query, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete user oauth refresh tokens: %w", err)
+	}
+
+	return nil
+}
+
+func (r *OAuthTokenRepository) CleanupExpired() error {
+	query := `DELETE FROM oauth_refresh_tokens WHERE expires_at <= NOW()`
+
+	_, err := r.db.Exec(query)
+	if err != nil {
+		return fmt.Errorf("failed to cleanup expired oauth refresh tokens: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"crypto/hmac"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pseudoerr/auth-service/internal/models"
+)
+
+var ErrOIDCStateInvalid = errors.New("sign-in state is invalid, expired or already used")
+
+// OIDCStateRepository stores sign-ins started with an external provider.
+// The state and the binding to the browser are kept as keyed hashes, like
+// action tokens.
+type OIDCStateRepository struct {
+	db         *sql.DB
+	pepper     []byte
+	legacy     []byte
+	bindingKey []byte
+}
+
+func NewOIDCStateRepository(db *sql.DB, pepper string) *OIDCStateRepository {
+	return &OIDCStateRepository{
+		db:         db,
+		pepper:     deriveKey(pepper, "oidc_state"),
+		legacy:     []byte(pepper),
+		bindingKey: deriveKey(pepper, "oidc_state_binding"),
+	}
+}
+
+func (r *OIDCStateRepository) hashState(state string) string {
+	return keyedHash(r.pepper, state)
+}
+
+func (r *OIDCStateRepository) legacyHash(state string) string {
+	return keyedHash(r.legacy, state)
+}
+
+func (r *OIDCStateRepository) hashBinding(binding string) string {
+	return keyedHash(r.bindingKey, binding)
+}
+
+func (r *OIDCStateRepository) Create(state *models.OIDCState) error {
+	query := `
+		INSERT INTO oidc_states (state_hash, binding_hash, provider, nonce, code_verifier, user_id, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id`
+
+	now := time.Now()
+	err := r.db.QueryRow(query, r.hashState(state.State), r.hashBinding(state.Binding), state.Provider, state.Nonce,
+		state.CodeVerifier, state.UserID, state.ExpiresAt, now).Scan(&state.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create oidc state: %w", err)
+	}
+
+	state.CreatedAt = now
+	return nil
+}
+
+// Consume deletes and returns the unexpired state of a sign-in with the
+// provider, so a state can be redeemed at most once. The binding must be the
+// one the sign-in was started with; a state presented by another browser is
+// burned all the same.
+func (r *OIDCStateRepository) Consume(provider, state, binding string) (*models.OIDCState, error) {
+	query := `
+		DELETE FROM oidc_states
+		WHERE state_hash IN ($1, $3) AND provider = $2 AND expires_at > NOW()
+		RETURNING id, binding_hash, provider, nonce, code_verifier, user_id, expires_at, created_at`
+
+	var bindingHash string
+	oidcState := &models.OIDCState{State: state, Binding: binding}
+	err := r.db.QueryRow(query, r.hashState(state), provider, r.legacyHash(state)).Scan(
+		&oidcState.ID, &bindingHash, &oidcState.Provider, &oidcState.Nonce, &oidcState.CodeVerifier,
+		&oidcState.UserID, &oidcState.ExpiresAt, &oidcState.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrOIDCStateInvalid
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume oidc state: %w", err)
+	}
+
+	if !hmac.Equal([]byte(bindingHash), []byte(r.hashBinding(binding))) {
+		return nil, ErrOIDCStateInvalid
+	}
+
+	return oidcState, nil
+}
+
+func (r *OIDCStateRepository) CleanupExpired() error {
+	query := `DELETE FROM oidc_states WHERE expires_at <= NOW()`
+
+	_, err := r.db.Exec(query)
+	if err != nil {
+		return fmt.Errorf("failed to cleanup expired oidc states: %w", err)
+	}
+
+	return nil
+}
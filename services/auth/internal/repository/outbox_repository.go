@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/pseudoerr/auth-service/internal/models"
+)
+
+// outboxRetention is how long published events are kept for inspection
+const outboxRetention = "7 days"
+
+// OutboxRepository reads the events other repositories write to the outbox
+// as part of their transactions
+type OutboxRepository struct {
+	db *sql.DB
+}
+
+func NewOutboxRepository(db *sql.DB) *OutboxRepository {
+	return &OutboxRepository{db: db}
+}
+
+// insertOutboxEvent records event inside tx, so it is published only if the
+// change it describes is committed
+func insertOutboxEvent(tx *sql.Tx, event *models.OutboxEvent) error {
+	query := `
+		INSERT INTO outbox_events (event_type, payload)
+		VALUES ($1, $2)
+		RETURNING id, created_at`
+
+	err := tx.QueryRow(query, event.Type, []byte(event.Payload)).Scan(&event.ID, &event.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to write outbox event: %w", err)
+	}
+
+	return nil
+}
+
+// ListPending returns up to limit unpublished events, oldest first
+func (r *OutboxRepository) ListPending(limit int) ([]models.OutboxEvent, error) {
+	query := `
+		SELECT id, event_type, payload, created_at
+		FROM outbox_events
+		WHERE published_at IS NULL
+		ORDER BY id
+		LIMIT $1`
+
+	rows, err := r.db.Query(query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending outbox events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []models.OutboxEvent
+	for rows.Next() {
+		var event models.OutboxEvent
+		var payload []byte
+		if err := rows.Scan(&event.ID, &event.Type, &payload, &event.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox event: %w", err)
+		}
+		event.Payload = payload
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+func (r *OutboxRepository) MarkPublished(id int64) error {
+	query := `UPDATE outbox_events SET published_at = NOW() WHERE id = $1`
+
+	_, err := r.db.Exec(query, id)
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox event as published: %w", err)
+	}
+
+	return nil
+}
+
+// CleanupPublished deletes events published longer ago than the retention period
+func (r *OutboxRepository) CleanupPublished() error {
+	query := `DELETE FROM outbox_events WHERE published_at <= NOW() - $1::interval`
+
+	_, err := r.db.Exec(query, outboxRetention)
+	if err != nil {
+		return fmt.Errorf("failed to cleanup published outbox events: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/pseudoerr/auth-service/internal/models"
+)
+
+var ErrPersonalAccessTokenNotFound = errors.New("personal access token not found, expired or revoked")
+
+// PersonalAccessTokenRepository stores personal access tokens as a keyed hash,
+// like refresh tokens
+type PersonalAccessTokenRepository struct {
+	db     *sql.DB
+	pepper []byte
+	legacy []byte
+}
+
+func NewPersonalAccessTokenRepository(db *sql.DB, pepper string) *PersonalAccessTokenRepository {
+	return &PersonalAccessTokenRepository{db: db, pepper: deriveKey(pepper, "personal_access_token"), legacy: []byte(pepper)}
+}
+
+func (r *PersonalAccessTokenRepository) hashToken(token string) string {
+	return keyedHash(r.pepper, token)
+}
+
+func (r *PersonalAccessTokenRepository) legacyHash(token string) string {
+	return keyedHash(r.legacy, token)
+}
+
+func (r *PersonalAccessTokenRepository) Create(token *models.PersonalAccessToken, plaintext string) error {
+	query := `
+		INSERT INTO personal_access_tokens (user_id, name, token_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	err := r.db.QueryRow(query, token.UserID, token.Name, r.hashToken(plaintext),
+		pq.Array(token.Scopes), token.ExpiresAt).Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create personal access token: %w", err)
+	}
+
+	return nil
+}
+
+// GetByToken returns the usable token with the given plaintext and records
+// that it was used. The last use is only written once a minute to keep
+// scripts from turning every request into a write.
+func (r *PersonalAccessTokenRepository) GetByToken(plaintext string) (*models.PersonalAccessToken, error) {
+	token := &models.PersonalAccessToken{}
+	query := `
+		SELECT id, user_id, name, scopes, expires_at, last_used_at, created_at
+		FROM personal_access_tokens
+		WHERE token_hash IN ($1, $2) AND revoked_at IS NULL AND expires_at > NOW()`
+
+	err := r.db.QueryRow(query, r.hashToken(plaintext), r.legacyHash(plaintext)).Scan(
+		&token.ID, &token.UserID, &token.Name, pq.Array(&token.Scopes),
+		&token.ExpiresAt, &token.LastUsedAt, &token.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrPersonalAccessTokenNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get personal access token: %w", err)
+	}
+
+	touch := `
+		UPDATE personal_access_tokens SET last_used_at = NOW()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`
+	if _, err := r.db.Exec(touch, token.ID); err != nil {
+		return nil, fmt.Errorf("failed to record personal access token use: %w", err)
+	}
+
+	// A token matched by its legacy hash is rehashed, as it may live for years
+	rehash := `UPDATE personal_access_tokens SET token_hash = $1 WHERE id = $2 AND token_hash = $3`
+	if _, err := r.db.Exec(rehash, r.hashToken(plaintext), token.ID, r.legacyHash(plaintext)); err != nil {
+		return nil, fmt.Errorf("failed to rehash personal access token: %w", err)
+	}
+
+	return token, nil
+}
+
+// ListByUserID returns the user's tokens that are neither expired nor revoked
+func (r *PersonalAccessTokenRepository) ListByUserID(userID int) ([]models.PersonalAccessToken, error) {
+	query := `
+		SELECT id, user_id, name, scopes, expires_at, last_used_at, created_at
+		FROM personal_access_tokens
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY created_at DESC`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list personal access tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []models.PersonalAccessToken{}
+	for rows.Next() {
+		var token models.PersonalAccessToken
+		if err := rows.Scan(&token.ID, &token.UserID, &token.Name, pq.Array(&token.Scopes),
+			&token.ExpiresAt, &token.LastUsedAt, &token.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan personal access token: %w", err)
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// Revoke revokes one of the user's tokens
+func (r *PersonalAccessTokenRepository) Revoke(userID, tokenID int) error {
+	query := `
+		UPDATE personal_access_tokens SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > NOW()`
+
+	result, err := r.db.Exec(query, tokenID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke personal access token: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to revoke personal access token: %w", err)
+	}
+	if rows == 0 {
+		return ErrPersonalAccessTokenNotFound
+	}
+
+	return nil
+}
+
+// RevokeAllByUserID revokes every token of the user
+func (r *PersonalAccessTokenRepository) RevokeAllByUserID(userID int) error {
+	query := `UPDATE personal_access_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`
+
+	if _, err := r.db.Exec(query, userID); err != nil {
+		return fmt.Errorf("failed to revoke personal access tokens: %w", err)
+	}
+
+	return nil
+}
+
+// CleanupExpired deletes tokens that expired or were revoked over a week ago
+func (r *PersonalAccessTokenRepository) CleanupExpired() error {
+	query := `
+		DELETE FROM personal_access_tokens
+		WHERE expires_at < NOW() - INTERVAL '7 days' OR revoked_at < NOW() - INTERVAL '7 days'`
+
+	if _, err := r.db.Exec(query); err != nil {
+		return fmt.Errorf("failed to cleanup personal access tokens: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,351 @@
+package repository_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/pseudoerr/auth-service/internal/postgres/postgrestest"
+	"github.com/pseudoerr/auth-service/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPepper = "test-pepper"
+
+func createUser(t *testing.T, db *sql.DB, name string) *models.User {
+	t.Helper()
+	user := &models.User{Email: name + "@example.com", Username: name, PasswordHash: "hash"}
+	require.NoError(t, repository.NewUserRepository(db).Create(user))
+	return user
+}
+
+func familyID(n int) string {
+	return fmt.Sprintf("%032x", n)
+}
+
+func TestTokenRepositoryRotate(t *testing.T) {
+	db := postgrestest.New(t)
+	user := createUser(t, db, "ann")
+
+	tests := []struct {
+		name string
+		// run rotates tokens of a session whose first token is "first",
+		// returning the tokens that must still work and the error of the
+		// last rotation
+		run  func(t *testing.T, repo *repository.TokenRepository) ([]string, error)
+		err  error
+		dead []string
+	}{
+		{
+			name: "rotation",
+			run: func(t *testing.T, repo *repository.TokenRepository) ([]string, error) {
+				rotated, err := repo.Rotate("first", &models.RefreshToken{Token: "second", ExpiresAt: time.Now().Add(time.Hour)})
+				if err == nil {
+					assert.Equal(t, user.ID, rotated.UserID)
+				}
+				return []string{"second"}, err
+			},
+			dead: []string{"first"},
+		},
+		{
+			name: "reuse of a rotated token revokes the family",
+			run: func(t *testing.T, repo *repository.TokenRepository) ([]string, error) {
+				_, err := repo.Rotate("first", &models.RefreshToken{Token: "second", ExpiresAt: time.Now().Add(time.Hour)})
+				require.NoError(t, err)
+				_, err = repo.Rotate("first", &models.RefreshToken{Token: "third", ExpiresAt: time.Now().Add(time.Hour)})
+				return nil, err
+			},
+			err:  repository.ErrRefreshTokenReused,
+			dead: []string{"first", "second", "third"},
+		},
+		{
+			name: "unknown token",
+			run: func(t *testing.T, repo *repository.TokenRepository) ([]string, error) {
+				_, err := repo.Rotate("unknown", &models.RefreshToken{Token: "second", ExpiresAt: time.Now().Add(time.Hour)})
+				return []string{"first"}, err
+			},
+			err:  repository.ErrRefreshTokenNotFound,
+			dead: []string{"second"},
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Each case gets its own pepper, so the token values can repeat
+			repo := repository.NewTokenRepository(db, fmt.Sprintf("%s-%d", testPepper, i))
+			require.NoError(t, repo.Create(&models.RefreshToken{
+				UserID: user.ID, Token: "first", FamilyID: familyID(i), ExpiresAt: time.Now().Add(time.Hour),
+			}))
+
+			alive, err := tt.run(t, repo)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			for _, token := range alive {
+				found, err := repo.GetByToken(token)
+				require.NoError(t, err, token)
+				assert.Equal(t, familyID(i), found.FamilyID)
+			}
+			for _, token := range tt.dead {
+				_, err := repo.GetByToken(token)
+				assert.ErrorIs(t, err, repository.ErrRefreshTokenNotFound, token)
+			}
+		})
+	}
+}
+
+func TestTokenRepositoryRotateExpired(t *testing.T) {
+	db := postgrestest.New(t)
+	user := createUser(t, db, "ann")
+	repo := repository.NewTokenRepository(db, testPepper)
+
+	require.NoError(t, repo.Create(&models.RefreshToken{
+		UserID: user.ID, Token: "first", FamilyID: familyID(1), ExpiresAt: time.Now().Add(-time.Minute),
+	}))
+
+	_, err := repo.Rotate("first", &models.RefreshToken{Token: "second", ExpiresAt: time.Now().Add(time.Hour)})
+	assert.ErrorIs(t, err, repository.ErrRefreshTokenNotFound)
+}
+
+func TestTokenRepositoryPepper(t *testing.T) {
+	db := postgrestest.New(t)
+	user := createUser(t, db, "ann")
+
+	require.NoError(t, repository.NewTokenRepository(db, testPepper).Create(&models.RefreshToken{
+		UserID: user.ID, Token: "first", FamilyID: familyID(1), ExpiresAt: time.Now().Add(time.Hour),
+	}))
+
+	// Without the pepper the stored hash is of no use
+	_, err := repository.NewTokenRepository(db, "other-pepper").GetByToken("first")
+	assert.ErrorIs(t, err, repository.ErrRefreshTokenNotFound)
+}
+
+func TestActionTokenRepositoryConsume(t *testing.T) {
+	db := postgrestest.New(t)
+	user := createUser(t, db, "ann")
+	repo := repository.NewActionTokenRepository(db, testPepper)
+
+	tests := []struct {
+		name      string
+		expiresAt time.Time
+		// consume redeems the token created for models.PurposePasswordReset
+		consume func(repo *repository.ActionTokenRepository, token string) error
+		err     error
+	}{
+		{
+			name:      "first use",
+			expiresAt: time.Now().Add(time.Hour),
+			consume: func(repo *repository.ActionTokenRepository, token string) error {
+				_, err := repo.Consume(models.PurposePasswordReset, token)
+				return err
+			},
+		},
+		{
+			name:      "second use",
+			expiresAt: time.Now().Add(time.Hour),
+			consume: func(repo *repository.ActionTokenRepository, token string) error {
+				if _, err := repo.Consume(models.PurposePasswordReset, token); err != nil {
+					return err
+				}
+				_, err := repo.Consume(models.PurposePasswordReset, token)
+				return err
+			},
+			err: repository.ErrActionTokenInvalid,
+		},
+		{
+			name:      "other purpose",
+			expiresAt: time.Now().Add(time.Hour),
+			consume: func(repo *repository.ActionTokenRepository, token string) error {
+				_, err := repo.Consume(models.PurposeMagicLink, token)
+				return err
+			},
+			err: repository.ErrActionTokenInvalid,
+		},
+		{
+			name:      "expired",
+			expiresAt: time.Now().Add(-time.Minute),
+			consume: func(repo *repository.ActionTokenRepository, token string) error {
+				_, err := repo.Consume(models.PurposePasswordReset, token)
+				return err
+			},
+			err: repository.ErrActionTokenInvalid,
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := fmt.Sprintf("token-%d", i)
+			require.NoError(t, repo.Create(&models.ActionToken{
+				UserID: user.ID, Purpose: models.PurposePasswordReset, Token: token,
+				Email: user.Email, ExpiresAt: tt.expiresAt,
+			}))
+
+			err := tt.consume(repo, token)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRoleRepositoryGetUserAccess(t *testing.T) {
+	db := postgrestest.New(t)
+	repo := repository.NewRoleRepository(db)
+
+	tests := []struct {
+		name        string
+		roles       []string
+		permissions []string
+	}{
+		{"no roles", nil, []string{}},
+		{"learner", []string{models.RoleLearner}, []string{"missions:read"}},
+		{"author", []string{models.RoleAuthor}, []string{"missions:read", "missions:write"}},
+		{"permissions of several roles are merged", []string{models.RoleMentor, models.RoleAuthor},
+			[]string{"missions:read", "missions:write", "submissions:review"}},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := createUser(t, db, fmt.Sprintf("user%d", i))
+			for _, role := range tt.roles {
+				require.NoError(t, repo.AssignRole(user.ID, role))
+			}
+			// Granting a role twice is a no-op
+			for _, role := range tt.roles {
+				require.NoError(t, repo.AssignRole(user.ID, role))
+			}
+
+			roles, permissions, err := repo.GetUserAccess(user.ID)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, tt.roles, roles)
+			assert.Equal(t, tt.permissions, permissions)
+		})
+	}
+
+	user := createUser(t, db, "nobody")
+	assert.ErrorIs(t, repo.AssignRole(user.ID, "superuser"), repository.ErrRoleNotFound)
+}
+
+func TestOAuthCodeRepositoryConsume(t *testing.T) {
+	db := postgrestest.New(t)
+	user := createUser(t, db, "ann")
+	client := &models.OAuthClient{
+		ClientID: "ide-plugin", Name: "IDE plugin", Scopes: []string{"missions:read"},
+		RedirectURIs: []string{"http://127.0.0.1:8765/callback"}, Public: true,
+	}
+	require.NoError(t, repository.NewOAuthClientRepository(db, testPepper).Create(client, ""))
+	repo := repository.NewOAuthCodeRepository(db, testPepper)
+
+	create := func(t *testing.T, code string, expiresAt time.Time) {
+		t.Helper()
+		require.NoError(t, repo.Create(&models.AuthorizationCode{
+			Code: code, FamilyID: familyID(1), ClientID: client.ClientID, UserID: user.ID,
+			RedirectURI: client.RedirectURIs[0], Scopes: client.Scopes,
+			CodeChallenge: "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", ExpiresAt: expiresAt,
+		}))
+	}
+
+	create(t, "code", time.Now().Add(time.Minute))
+	authCode, err := repo.Consume("code")
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, authCode.UserID)
+	assert.Equal(t, []string{"missions:read"}, authCode.Scopes)
+
+	// The family comes back with a reused code, so its tokens can be revoked
+	reused, err := repo.Consume("code")
+	assert.ErrorIs(t, err, repository.ErrAuthorizationCodeReused)
+	require.NotNil(t, reused)
+	assert.Equal(t, familyID(1), reused.FamilyID)
+
+	_, err = repo.Consume("unknown")
+	assert.ErrorIs(t, err, repository.ErrAuthorizationCodeInvalid)
+
+	create(t, "expired", time.Now().Add(-time.Second))
+	_, err = repo.Consume("expired")
+	assert.ErrorIs(t, err, repository.ErrAuthorizationCodeInvalid)
+}
+
+// legacyHash is how every kind of secret was hashed before each had a key
+// derived from the pepper
+func legacyHash(value string) string {
+	mac := hmac.New(sha256.New, []byte(testPepper))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestLegacyHashes(t *testing.T) {
+	db := postgrestest.New(t)
+	user := createUser(t, db, "ann")
+
+	refreshTokens := repository.NewTokenRepository(db, testPepper)
+	require.NoError(t, refreshTokens.Create(&models.RefreshToken{
+		UserID: user.ID, Token: "first", FamilyID: familyID(1), ExpiresAt: time.Now().Add(time.Hour),
+	}))
+	_, err := db.Exec(`UPDATE refresh_tokens SET token_hash = $1`, legacyHash("first"))
+	require.NoError(t, err)
+
+	found, err := refreshTokens.GetByToken("first")
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, found.UserID)
+
+	tokens := repository.NewPersonalAccessTokenRepository(db, testPepper)
+	require.NoError(t, tokens.Create(&models.PersonalAccessToken{
+		UserID: user.ID, Name: "ci", Scopes: []string{"missions:read"}, ExpiresAt: time.Now().Add(time.Hour),
+	}, "cbp_token"))
+	_, err = db.Exec(`UPDATE personal_access_tokens SET token_hash = $1`, legacyHash("cbp_token"))
+	require.NoError(t, err)
+
+	_, err = tokens.GetByToken("cbp_token")
+	require.NoError(t, err)
+
+	// The token is rehashed on use, and still found afterwards
+	var legacy bool
+	require.NoError(t, db.QueryRow(`SELECT token_hash = $1 FROM personal_access_tokens`, legacyHash("cbp_token")).Scan(&legacy))
+	assert.False(t, legacy)
+	_, err = tokens.GetByToken("cbp_token")
+	assert.NoError(t, err)
+}
+
+func TestOIDCStateRepositoryConsume(t *testing.T) {
+	db := postgrestest.New(t)
+	repo := repository.NewOIDCStateRepository(db, testPepper)
+
+	tests := []struct {
+		name    string
+		binding string
+		err     error
+	}{
+		{"same browser", "binding", nil},
+		{"other browser", "other", repository.ErrOIDCStateInvalid},
+		{"no binding", "", repository.ErrOIDCStateInvalid},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := fmt.Sprintf("state-%d", i)
+			require.NoError(t, repo.Create(&models.OIDCState{
+				State: state, Binding: "binding", Provider: "google", Nonce: "nonce",
+				CodeVerifier: "verifier", ExpiresAt: time.Now().Add(time.Minute),
+			}))
+
+			consumed, err := repo.Consume("google", state, tt.binding)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, "nonce", consumed.Nonce)
+			}
+
+			// Either way the state is used up
+			_, err = repo.Consume("google", state, "binding")
+			assert.ErrorIs(t, err, repository.ErrOIDCStateInvalid)
+		})
+	}
+}
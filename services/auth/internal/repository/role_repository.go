@@ -0,0 +1,170 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/pseudoerr/auth-service/internal/models"
+)
+
+var ErrRoleNotFound = errors.New("role not found")
+
+type RoleRepository struct {
+	db *sql.DB
+}
+
+func NewRoleRepository(db *sql.DB) *RoleRepository {
+	return &RoleRepository{db: db}
+}
+
+// GetUserAccess returns the names of the user's roles and the union of the
+// permissions granted by them, both sorted
+func (r *RoleRepository) GetUserAccess(userID int) ([]string, []string, error) {
+	query := `
+		SELECT
+			COALESCE(ARRAY(
+				SELECT ro.name FROM user_roles ur
+				JOIN roles ro ON ro.id = ur.role_id
+				WHERE ur.user_id = $1
+				ORDER BY ro.name
+			), '{}'),
+			COALESCE(ARRAY(
+				SELECT DISTINCT p.name FROM user_roles ur
+				JOIN role_permissions rp ON rp.role_id = ur.role_id
+				JOIN permissions p ON p.id = rp.permission_id
+				WHERE ur.user_id = $1
+				ORDER BY p.name
+			), '{}')`
+
+	var roles, permissions []string
+	err := r.db.QueryRow(query, userID).Scan(pq.Array(&roles), pq.Array(&permissions))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get user roles: %w", err)
+	}
+
+	return roles, permissions, nil
+}
+
+// AssignRole grants the role to the user. Granting a role twice is a no-op.
+func (r *RoleRepository) AssignRole(userID int, role string) error {
+	query := `
+		INSERT INTO user_roles (user_id, role_id)
+		SELECT $1, id FROM roles WHERE name = $2
+		ON CONFLICT DO NOTHING`
+
+	result, err := r.db.Exec(query, userID, role)
+	if err != nil {
+		return fmt.Errorf("failed to assign role: %w", err)
+	}
+
+	// Zero rows means either the role is unknown or it was already assigned
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		exists, err := r.roleExists(role)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrRoleNotFound
+		}
+	}
+
+	return nil
+}
+
+func (r *RoleRepository) RevokeRole(userID int, role string) error {
+	query := `
+		DELETE FROM user_roles
+		WHERE user_id = $1 AND role_id = (SELECT id FROM roles WHERE name = $2)`
+
+	if _, err := r.db.Exec(query, userID, role); err != nil {
+		return fmt.Errorf("failed to revoke role: %w", err)
+	}
+
+	return nil
+}
+
+// List returns every role with its permissions
+func (r *RoleRepository) List() ([]models.Role, error) {
+	query := `
+		SELECT ro.name, ro.description,
+			COALESCE(ARRAY(
+				SELECT p.name FROM role_permissions rp
+				JOIN permissions p ON p.id = rp.permission_id
+				WHERE rp.role_id = ro.id
+				ORDER BY p.name
+			), '{}')
+		FROM roles ro
+		ORDER BY ro.id`
+
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+	defer rows.Close()
+
+	var roles []models.Role
+	for rows.Next() {
+		var role models.Role
+		if err := rows.Scan(&role.Name, &role.Description, pq.Array(&role.Permissions)); err != nil {
+			return nil, fmt.Errorf("failed to scan role: %w", err)
+		}
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
+
+func (r *RoleRepository) roleExists(role string) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM roles WHERE name = $1)`, role).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check role: %w", err)
+	}
+	return exists, nil
+}
+
+// SetUserRoles replaces the user's roles. ErrRoleNotFound is returned and
+// nothing changes if any of the roles is unknown.
+func (r *RoleRepository) SetUserRoles(userID int, roles []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM user_roles WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to clear roles: %w", err)
+	}
+
+	query := `
+		INSERT INTO user_roles (user_id, role_id)
+		SELECT $1, id FROM roles WHERE name = ANY($2)`
+
+	result, err := tx.Exec(query, userID, pq.Array(roles))
+	if err != nil {
+		return fmt.Errorf("failed to assign roles: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to assign roles: %w", err)
+	}
+	if int(affected) != len(uniqueStrings(roles)) {
+		return ErrRoleNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit roles: %w", err)
+	}
+
+	return nil
+}
+
+func uniqueStrings(values []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(values))
+	for _, value := range values {
+		set[value] = struct{}{}
+	}
+	return set
+}
@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/pseudoerr/auth-service/internal/models"
+	"time"
+)
+
+var (
+	ErrActiveKeyExists = errors.New("an active signing key already exists")
+	// ErrRotationPending means the key of the previous rotation is still
+	// waiting to activate
+	ErrRotationPending = errors.New("a rotated signing key has not activated yet")
+)
+
+type SigningKeyRepository struct {
+	db *sql.DB
+}
+
+func NewSigningKeyRepository(db *sql.DB) *SigningKeyRepository {
+	return &SigningKeyRepository{db: db}
+}
+
+// CreateActive inserts the first active key. It returns ErrActiveKeyExists if
+// another instance got there first.
+func (r *SigningKeyRepository) CreateActive(key *models.SigningKey) error {
+	query := `
+		INSERT INTO signing_keys (kid, algorithm, private_key, created_at, activates_at)
+		VALUES ($1, $2, $3, $4, $4)
+		ON CONFLICT DO NOTHING
+		RETURNING id`
+
+	now := time.Now()
+	err := r.db.QueryRow(query, key.KeyID, key.Algorithm, key.PrivateKey, now).Scan(&key.ID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrActiveKeyExists
+		}
+		return fmt.Errorf("failed to create signing key: %w", err)
+	}
+
+	key.CreatedAt = now
+	key.ActivatesAt = now
+	return nil
+}
+
+// ListUsable returns the active key, a pending one and every retired key that
+// has not expired yet
+func (r *SigningKeyRepository) ListUsable() ([]models.SigningKey, error) {
+	query := `
+		SELECT id, kid, algorithm, private_key, created_at, activates_at, retired_at, expires_at
+		FROM signing_keys
+		WHERE retired_at IS NULL OR expires_at > NOW()
+		ORDER BY created_at DESC`
+
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list signing keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []models.SigningKey
+	for rows.Next() {
+		var key models.SigningKey
+		if err := rows.Scan(
+			&key.ID, &key.KeyID, &key.Algorithm, &key.PrivateKey,
+			&key.CreatedAt, &key.ActivatesAt, &key.RetiredAt, &key.ExpiresAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan signing key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate signing keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// UpdatePrivateKey replaces the stored private key, such as a legacy
+// plaintext one with its encrypted form
+func (r *SigningKeyRepository) UpdatePrivateKey(id int, privateKey string) error {
+	if _, err := r.db.Exec(`UPDATE signing_keys SET private_key = $1 WHERE id = $2`, privateKey, id); err != nil {
+		return fmt.Errorf("failed to update signing key: %w", err)
+	}
+	return nil
+}
+
+// Rotate stores key as the next signing key from activatesAt on. Until then
+// the current active key keeps signing; it retires at activatesAt and stays
+// valid for verification until retiredUntil. Keys whose overlap window has
+// passed are removed. It returns ErrRotationPending while the key of an
+// earlier rotation has yet to activate.
+func (r *SigningKeyRepository) Rotate(key *models.SigningKey, activatesAt, retiredUntil time.Time) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var pending bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM signing_keys WHERE activates_at > $1)`, now).Scan(&pending); err != nil {
+		return fmt.Errorf("failed to check pending signing keys: %w", err)
+	}
+	if pending {
+		return ErrRotationPending
+	}
+
+	retire := `UPDATE signing_keys SET retired_at = $1, expires_at = $2 WHERE retired_at IS NULL`
+	if _, err := tx.Exec(retire, activatesAt, retiredUntil); err != nil {
+		return fmt.Errorf("failed to retire signing key: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM signing_keys WHERE expires_at <= $1`, now); err != nil {
+		return fmt.Errorf("failed to delete expired signing keys: %w", err)
+	}
+
+	insert := `
+		INSERT INTO signing_keys (kid, algorithm, private_key, created_at, activates_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id`
+
+	if err := tx.QueryRow(insert, key.KeyID, key.Algorithm, key.PrivateKey, now, activatesAt).Scan(&key.ID); err != nil {
+		return fmt.Errorf("failed to create signing key: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit key rotation: %w", err)
+	}
+
+	key.CreatedAt = now
+	key.ActivatesAt = activatesAt
+	return nil
+}
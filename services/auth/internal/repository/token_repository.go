@@ -1,32 +1,74 @@
 package repository
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/pseudoerr/auth-service/internal/models"
 	"time"
 )
 
+var (
+	ErrRefreshTokenNotFound = errors.New("refresh token not found or expired")
+	ErrRefreshTokenReused   = errors.New("refresh token reuse detected")
+)
+
+// TokenRepository stores refresh tokens as an HMAC keyed with a server-side
+// pepper, so the table alone is not enough to hijack a session. Rows written
+// before hashing was introduced still carry the plaintext token and are
+// matched as a fallback until RehashLegacyTokens has converted them.
 type TokenRepository struct {
-	db *sql.DB
+	db     *sql.DB
+	pepper []byte
+	legacy []byte
+}
+
+func NewTokenRepository(db *sql.DB, pepper string) *TokenRepository {
+	return &TokenRepository{db: db, pepper: deriveKey(pepper, "refresh_token"), legacy: []byte(pepper)}
+}
+
+func (r *TokenRepository) hashToken(token string) string {
+	return keyedHash(r.pepper, token)
 }
 
-func NewTokenRepository(db *sql.DB) *TokenRepository {
-	return &TokenRepository{db: db}
+func (r *TokenRepository) legacyHash(token string) string {
+	return keyedHash(r.legacy, token)
+}
+
+// keyedHash returns the hex encoded HMAC-SHA256 of value
+func keyedHash(key []byte, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deriveKey derives the hashing key for one kind of secret from a pepper, so
+// secrets of different kinds never share a key. Secrets stored before were
+// hashed with the pepper itself; lookups still match that legacy hash, so they
+// keep working until they expire or are replaced.
+func deriveKey(pepper, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(pepper))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
 }
 
 func (r *TokenRepository) Create(token *models.RefreshToken) error {
 	query := `
-		INSERT INTO refresh_tokens (user_id, token, expires_at, created_at)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO refresh_tokens (user_id, token_hash, family_id, user_agent, ip_address, expires_at, last_used_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
 		RETURNING id`
 
 	now := time.Now()
-	err := r.db.QueryRow(query, token.UserID, token.Token, token.ExpiresAt, now).Scan(&token.ID)
+	err := r.db.QueryRow(query, token.UserID, r.hashToken(token.Token), token.FamilyID,
+		token.UserAgent, token.IPAddress, token.ExpiresAt, now).Scan(&token.ID)
 	if err != nil {
 		return fmt.Errorf("failed to create refresh token: %w", err)
 	}
 
+	token.LastUsedAt = now
 	token.CreatedAt = now
 	return nil
 }
@@ -34,28 +76,165 @@ func (r *TokenRepository) Create(token *models.RefreshToken) error {
 func (r *TokenRepository) GetByToken(token string) (*models.RefreshToken, error) {
 	refreshToken := &models.RefreshToken{}
 	query := `
-		SELECT id, user_id, token, expires_at, created_at
+		SELECT id, user_id, family_id, expires_at, created_at
 		FROM refresh_tokens
-		WHERE token = $1 AND expires_at > NOW()`
+		WHERE (token_hash IN ($1, $3) OR (token_hash IS NULL AND token = $2))
+			AND rotated_at IS NULL AND expires_at > NOW()`
 
-	err := r.db.QueryRow(query, token).Scan(
-		&refreshToken.ID, &refreshToken.UserID, &refreshToken.Token,
+	err := r.db.QueryRow(query, r.hashToken(token), token, r.legacyHash(token)).Scan(
+		&refreshToken.ID, &refreshToken.UserID, &refreshToken.FamilyID,
 		&refreshToken.ExpiresAt, &refreshToken.CreatedAt,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("refresh token not found or expired")
+			return nil, ErrRefreshTokenNotFound
 		}
 		return nil, fmt.Errorf("failed to get refresh token: %w", err)
 	}
 
+	refreshToken.Token = token
 	return refreshToken, nil
 }
 
+// Rotate exchanges oldToken for newToken inside a single transaction. The old
+// row is kept, marked as rotated, so that presenting it again can be detected:
+// in that case the whole family is revoked and ErrRefreshTokenReused is
+// returned together with the reused token. The new token inherits the
+// session's created_at and records the device it was used from.
+func (r *TokenRepository) Rotate(oldToken string, newToken *models.RefreshToken) (*models.RefreshToken, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	current := &models.RefreshToken{}
+	query := `
+		SELECT id, user_id, family_id, expires_at, rotated_at, created_at
+		FROM refresh_tokens
+		WHERE token_hash IN ($1, $3) OR (token_hash IS NULL AND token = $2)
+		FOR UPDATE`
+
+	err = tx.QueryRow(query, r.hashToken(oldToken), oldToken, r.legacyHash(oldToken)).Scan(
+		&current.ID, &current.UserID, &current.FamilyID,
+		&current.ExpiresAt, &current.RotatedAt, &current.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRefreshTokenNotFound
+		}
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+	current.Token = oldToken
+
+	if current.RotatedAt != nil {
+		if _, err := tx.Exec(`DELETE FROM refresh_tokens WHERE family_id = $1`, current.FamilyID); err != nil {
+			return nil, fmt.Errorf("failed to revoke token family: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("failed to commit family revocation: %w", err)
+		}
+		return current, ErrRefreshTokenReused
+	}
+
+	now := time.Now()
+	if !current.ExpiresAt.After(now) {
+		return nil, ErrRefreshTokenNotFound
+	}
+
+	if _, err := tx.Exec(`UPDATE refresh_tokens SET rotated_at = $1 WHERE id = $2`, now, current.ID); err != nil {
+		return nil, fmt.Errorf("failed to mark refresh token as rotated: %w", err)
+	}
+
+	newToken.UserID = current.UserID
+	newToken.FamilyID = current.FamilyID
+
+	insert := `
+		INSERT INTO refresh_tokens (user_id, token_hash, family_id, user_agent, ip_address, expires_at, last_used_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id`
+
+	err = tx.QueryRow(insert, newToken.UserID, r.hashToken(newToken.Token), newToken.FamilyID,
+		newToken.UserAgent, newToken.IPAddress, newToken.ExpiresAt, now, current.CreatedAt).Scan(&newToken.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create refresh token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit token rotation: %w", err)
+	}
+
+	newToken.LastUsedAt = now
+	newToken.CreatedAt = current.CreatedAt
+	return newToken, nil
+}
+
+func (r *TokenRepository) DeleteFamily(familyID string) error {
+	query := `DELETE FROM refresh_tokens WHERE family_id = $1`
+
+	_, err := r.db.Exec(query, familyID)
+	if err != nil {
+		return fmt.Errorf("failed to delete refresh token family: %w", err)
+	}
+
+	return nil
+}
+
+// ListSessions returns the user's live sessions, most recently used first.
+// Each family has exactly one unrotated token, which describes the session.
+func (r *TokenRepository) ListSessions(userID int) ([]models.Session, error) {
+	query := `
+		SELECT family_id, user_agent, ip_address, created_at, last_used_at, expires_at
+		FROM refresh_tokens
+		WHERE user_id = $1 AND rotated_at IS NULL AND expires_at > NOW()
+		ORDER BY last_used_at DESC`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		var session models.Session
+		if err := rows.Scan(
+			&session.ID, &session.UserAgent, &session.IPAddress,
+			&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// DeleteSession deletes the user's token family. It returns
+// ErrRefreshTokenNotFound if the user has no such session.
+func (r *TokenRepository) DeleteSession(userID int, familyID string) error {
+	query := `DELETE FROM refresh_tokens WHERE user_id = $1 AND family_id = $2`
+
+	result, err := r.db.Exec(query, userID, familyID)
+	if err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	if affected == 0 {
+		return ErrRefreshTokenNotFound
+	}
+
+	return nil
+}
+
 func (r *TokenRepository) DeleteByToken(token string) error {
-	query := `DELETE FROM refresh_tokens WHERE token = $1`
+	query := `DELETE FROM refresh_tokens WHERE token_hash IN ($1, $3) OR (token_hash IS NULL AND token = $2)`
 
-	_, err := r.db.Exec(query, token)
+	_, err := r.db.Exec(query, r.hashToken(token), token, r.legacyHash(token))
 	if err != nil {
 		return fmt.Errorf("failed to delete refresh token: %w", err)
 	}
@@ -74,6 +253,19 @@ func (r *TokenRepository) DeleteAllByUserID(userID int) error {
 	return nil
 }
 
+// DeleteAllByUserIDExcept deletes every session of the user but the one
+// identified by familyID
+func (r *TokenRepository) DeleteAllByUserIDExcept(userID int, familyID string) error {
+	query := `DELETE FROM refresh_tokens WHERE user_id = $1 AND family_id <> $2`
+
+	_, err := r.db.Exec(query, userID, familyID)
+	if err != nil {
+		return fmt.Errorf("failed to delete user refresh tokens: %w", err)
+	}
+
+	return nil
+}
+
 func (r *TokenRepository) CleanupExpired() error {
 	query := `DELETE FROM refresh_tokens WHERE expires_at <= NOW()`
 
@@ -84,3 +276,43 @@ func (r *TokenRepository) CleanupExpired() error {
 
 	return nil
 }
+
+// RehashLegacyTokens replaces plaintext tokens left over from before hashing
+// was introduced with their HMAC, in batches, so existing sessions survive
+// the migration. It returns the number of converted rows.
+func (r *TokenRepository) RehashLegacyTokens(batchSize int) (int, error) {
+	total := 0
+	for {
+		rows, err := r.db.Query(`SELECT id, token FROM refresh_tokens WHERE token_hash IS NULL LIMIT $1`, batchSize)
+		if err != nil {
+			return total, fmt.Errorf("failed to query legacy tokens: %w", err)
+		}
+
+		legacy := make(map[int]string)
+		for rows.Next() {
+			var id int
+			var token string
+			if err := rows.Scan(&id, &token); err != nil {
+				rows.Close()
+				return total, fmt.Errorf("failed to scan legacy token: %w", err)
+			}
+			legacy[id] = token
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return total, fmt.Errorf("failed to iterate legacy tokens: %w", err)
+		}
+
+		if len(legacy) == 0 {
+			return total, nil
+		}
+
+		for id, token := range legacy {
+			query := `UPDATE refresh_tokens SET token_hash = $1, token = NULL WHERE id = $2 AND token_hash IS NULL`
+			if _, err := r.db.Exec(query, r.hashToken(token), id); err != nil {
+				return total, fmt.Errorf("failed to rehash legacy token: %w", err)
+			}
+			total++
+		}
+	}
+}
@@ -2,11 +2,39 @@ package repository
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"github.com/lib/pq"
 	"github.com/pseudoerr/auth-service/internal/models"
+	"strings"
 	"time"
 )
 
+var (
+	ErrUserNotFound  = errors.New("user not found")
+	ErrEmailTaken    = errors.New("email already registered")
+	ErrUsernameTaken = errors.New("username already taken")
+)
+
+// userColumns are selected by every query returning users, in the order scanUser reads them
+const userColumns = `id, email, username, password_hash, email_verified_at, disabled_at, created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row rowScanner) (*models.User, error) {
+	user := &models.User{}
+	var passwordHash sql.NullString
+	err := row.Scan(
+		&user.ID, &user.Email, &user.Username, &passwordHash,
+		&user.EmailVerifiedAt, &user.DisabledAt, &user.CreatedAt, &user.UpdatedAt,
+	)
+	// Passwordless accounts have no hash and are left with an empty one
+	user.PasswordHash = passwordHash.String
+	return user, err
+}
+
 type UserRepository struct {
 	db *sql.DB
 }
@@ -22,8 +50,12 @@ func (r *UserRepository) Create(user *models.User) error {
 		RETURNING id`
 
 	now := time.Now()
-	err := r.db.QueryRow(query, user.Email, user.Username, user.PasswordHash, now, now).Scan(&user.ID)
+	passwordHash := sql.NullString{String: user.PasswordHash, Valid: user.HasPassword()}
+	err := r.db.QueryRow(query, user.Email, user.Username, passwordHash, now, now).Scan(&user.ID)
 	if err != nil {
+		if taken := uniqueViolation(err); taken != nil {
+			return taken
+		}
 		return fmt.Errorf("failed to create user: %w", err)
 	}
 
@@ -33,19 +65,12 @@ func (r *UserRepository) Create(user *models.User) error {
 }
 
 func (r *UserRepository) GetByEmail(email string) (*models.User, error) {
-	user := &models.User{}
-	query := `
-		SELECT id, email, username, password_hash, created_at, updated_at
-		FROM users
-		WHERE email = $1`
+	query := `SELECT ` + userColumns + ` FROM users WHERE email = $1`
 
-	err := r.db.QueryRow(query, email).Scan(
-		&user.ID, &user.Email, &user.Username, &user.PasswordHash,
-		&user.CreatedAt, &user.UpdatedAt,
-	)
+	user, err := scanUser(r.db.QueryRow(query, email))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("failed to get user by email: %w", err)
 	}
@@ -54,19 +79,12 @@ func (r *UserRepository) GetByEmail(email string) (*models.User, error) {
 }
 
 func (r *UserRepository) GetByID(id int) (*models.User, error) {
-	user := &models.User{}
-	query := `
-		SELECT id, email, username, password_hash, created_at, updated_at
-		FROM users
-		WHERE id = $1`
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
 
-	err := r.db.QueryRow(query, id).Scan(
-		&user.ID, &user.Email, &user.Username, &user.PasswordHash,
-		&user.CreatedAt, &user.UpdatedAt,
-	)
+	user, err := scanUser(r.db.QueryRow(query, id))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("failed to get user by id: %w", err)
 	}
@@ -74,6 +92,131 @@ func (r *UserRepository) GetByID(id int) (*models.User, error) {
 	return user, nil
 }
 
+// ReplacePasswordHash swaps the stored hash for an upgraded hash of the same
+// password. It does nothing if the password was changed in the meantime, and
+// leaves updated_at alone since the account did not change.
+func (r *UserRepository) ReplacePasswordHash(id int, oldHash, newHash string) error {
+	query := `UPDATE users SET password_hash = $1 WHERE id = $2 AND password_hash = $3`
+
+	if _, err := r.db.Exec(query, newHash, id, oldHash); err != nil {
+		return fmt.Errorf("failed to replace password hash: %w", err)
+	}
+
+	return nil
+}
+
+func (r *UserRepository) UpdatePassword(id int, passwordHash string) error {
+	query := `UPDATE users SET password_hash = $1, updated_at = $2 WHERE id = $3`
+
+	result, err := r.db.Exec(query, passwordHash, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+	if affected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// RemovePassword turns the account into one that signs in with magic links only
+func (r *UserRepository) RemovePassword(id int) error {
+	query := `UPDATE users SET password_hash = NULL, updated_at = $1 WHERE id = $2`
+
+	result, err := r.db.Exec(query, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to remove password: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to remove password: %w", err)
+	}
+	if affected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// UpdateUsername renames the user. ErrUsernameTaken is returned if another
+// account holds the name, including one that took it concurrently.
+func (r *UserRepository) UpdateUsername(id int, username string) error {
+	query := `UPDATE users SET username = $1, updated_at = $2 WHERE id = $3`
+
+	result, err := r.db.Exec(query, username, time.Now(), id)
+	if err != nil {
+		if taken := uniqueViolation(err); taken != nil {
+			return taken
+		}
+		return fmt.Errorf("failed to update username: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update username: %w", err)
+	}
+	if affected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// UpdateEmail moves the account to a new address the user has just proven
+// to own, so it is stored as verified. ErrEmailTaken is returned if another
+// account holds the address.
+func (r *UserRepository) UpdateEmail(id int, email string) error {
+	query := `UPDATE users SET email = $1, email_verified_at = $2, updated_at = $2 WHERE id = $3`
+
+	result, err := r.db.Exec(query, email, time.Now(), id)
+	if err != nil {
+		if taken := uniqueViolation(err); taken != nil {
+			return taken
+		}
+		return fmt.Errorf("failed to update email: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update email: %w", err)
+	}
+	if affected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// MarkEmailVerified records that the user proved ownership of email. Nothing
+// is updated if the address has changed since the token was issued.
+func (r *UserRepository) MarkEmailVerified(id int, email string) error {
+	query := `
+		UPDATE users
+		SET email_verified_at = COALESCE(email_verified_at, $1), updated_at = $1
+		WHERE id = $2 AND email = $3`
+
+	result, err := r.db.Exec(query, time.Now(), id, email)
+	if err != nil {
+		return fmt.Errorf("failed to mark email verified: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to mark email verified: %w", err)
+	}
+	if affected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
 func (r *UserRepository) EmailExists(email string) (bool, error) {
 	var exists bool
 	query := `SELECT EXISTS(SELECT 1 FROM users WHERE email = $1)`
@@ -97,3 +240,124 @@ func (r *UserRepository) UsernameExists(username string) (bool, error) {
 
 	return exists, nil
 }
+
+// Delete removes the user together with their refresh tokens, action tokens,
+// roles and 2FA settings, and records event in the outbox in the same transaction
+func (r *UserRepository) Delete(id int, event *models.OutboxEvent) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM users WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+
+	if err := insertOutboxEvent(tx, event); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit user deletion: %w", err)
+	}
+
+	return nil
+}
+
+// Search returns a page of users whose email or username contains query,
+// ordered by ID, and the total number of matches. An empty query matches everyone.
+func (r *UserRepository) Search(query string, limit, offset int) ([]models.User, int, error) {
+	pattern := "%" + escapeLike(query) + "%"
+
+	var total int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM users WHERE email ILIKE $1 OR username ILIKE $1`, pattern).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count users: %w", err)
+	}
+
+	rows, err := r.db.Query(`
+		SELECT `+userColumns+`
+		FROM users
+		WHERE email ILIKE $1 OR username ILIKE $1
+		ORDER BY id
+		LIMIT $2 OFFSET $3`, pattern, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, *user)
+	}
+
+	return users, total, rows.Err()
+}
+
+// SetDisabled disables or re-enables the account
+func (r *UserRepository) SetDisabled(id int, disabled bool) error {
+	query := `UPDATE users SET disabled_at = CASE WHEN $1 THEN COALESCE(disabled_at, $2) END, updated_at = $2 WHERE id = $3`
+
+	result, err := r.db.Exec(query, disabled, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update disabled state: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update disabled state: %w", err)
+	}
+	if affected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// IsDisabled reports whether the account was disabled. A deleted account
+// yields ErrUserNotFound, as its tokens must not be accepted either.
+func (r *UserRepository) IsDisabled(id int) (bool, error) {
+	var disabled bool
+	query := `SELECT disabled_at IS NOT NULL FROM users WHERE id = $1`
+
+	if err := r.db.QueryRow(query, id).Scan(&disabled); err != nil {
+		if err == sql.ErrNoRows {
+			return false, ErrUserNotFound
+		}
+		return false, fmt.Errorf("failed to check disabled state: %w", err)
+	}
+
+	return disabled, nil
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// uniqueViolation maps a violated unique constraint on users to
+// ErrEmailTaken or ErrUsernameTaken, and returns nil for any other error
+func uniqueViolation(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != "23505" {
+		return nil
+	}
+
+	switch pqErr.Constraint {
+	case "users_email_key":
+		return ErrEmailTaken
+	case "users_username_key":
+		return ErrUsernameTaken
+	default:
+		return nil
+	}
+}
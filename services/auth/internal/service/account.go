@@ -0,0 +1,344 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/pseudoerr/auth-service/internal/mailer"
+	"github.com/pseudoerr/auth-service/internal/missions"
+	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/pseudoerr/auth-service/internal/redact"
+	"github.com/pseudoerr/auth-service/internal/repository"
+)
+
+var (
+	ErrSameEmail      = errors.New("new email is the current email")
+	ErrPasswordNotSet = errors.New("account has no password")
+)
+
+// UpdateUsername renames the user and returns the updated profile
+func (s *AuthService) UpdateUsername(userID int, req *models.UpdateProfileRequest) (*models.User, error) {
+	if err := s.userRepo.UpdateUsername(userID, req.Username); err != nil {
+		return nil, err
+	}
+
+	return s.GetUserByID(userID)
+}
+
+// ChangePassword sets a new password after checking the current one. Every
+// other session is signed out and all earlier access tokens are revoked, so
+// the caller gets a fresh access token for the session it is using.
+func (s *AuthService) ChangePassword(userID int, sessionID string, req *models.ChangePasswordRequest,
+	client models.ClientInfo) (*models.AuthResponse, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkPassword(user, req.CurrentPassword, client); err != nil {
+		if errors.Is(err, ErrInvalidPassword) {
+			s.recordEvent(models.AuthEventPasswordChange, models.OutcomeFailure, user.ID, client,
+				map[string]interface{}{"reason": "invalid_password"})
+		}
+		return nil, err
+	}
+
+	if err := s.passwordPolicy.Check("new_password", req.NewPassword, user.Email, user.Username); err != nil {
+		s.recordEvent(models.AuthEventPasswordChange, models.OutcomeFailure, user.ID, client,
+			map[string]interface{}{"reason": "policy"})
+		return nil, err
+	}
+
+	passwordHash, err := s.hasher.Hash(req.NewPassword)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.userRepo.UpdatePassword(user.ID, passwordHash); err != nil {
+		return nil, err
+	}
+
+	accessToken, err := s.signOutOtherSessions(user, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	s.recordEvent(models.AuthEventPasswordChange, models.OutcomeSuccess, user.ID, client, nil)
+
+	if err := s.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Your password was changed",
+		Body: fmt.Sprintf("Hi %s,\n\nThe password of your account was just changed and your other sessions were signed out.\n\n"+
+			"If this wasn't you, reset your password immediately.", user.Username),
+	}); err != nil {
+		slog.Error("Failed to send password change notice", "error", err, "user_id", user.ID)
+	}
+
+	return &models.AuthResponse{AccessToken: accessToken, User: *user}, nil
+}
+
+// RemovePassword turns the account into one that signs in with magic links
+// only, after checking the password. Other sessions are signed out as with a
+// password change. A password reset sets a password again.
+func (s *AuthService) RemovePassword(userID int, sessionID, password string, client models.ClientInfo) (*models.AuthResponse, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkPassword(user, password, client); err != nil {
+		if errors.Is(err, ErrInvalidPassword) {
+			s.recordEvent(models.AuthEventPasswordRemove, models.OutcomeFailure, user.ID, client,
+				map[string]interface{}{"reason": "invalid_password"})
+		}
+		return nil, err
+	}
+
+	if err := s.userRepo.RemovePassword(user.ID); err != nil {
+		return nil, err
+	}
+	user.PasswordHash = ""
+
+	accessToken, err := s.signOutOtherSessions(user, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	s.recordEvent(models.AuthEventPasswordRemove, models.OutcomeSuccess, user.ID, client, nil)
+
+	if err := s.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Your password was removed",
+		Body: fmt.Sprintf("Hi %s,\n\nThe password of your account was removed, you now sign in with a link sent to this address. "+
+			"Your other sessions were signed out.\n\nIf this wasn't you, reset your password immediately.", user.Username),
+	}); err != nil {
+		slog.Error("Failed to send password removal notice", "error", err, "user_id", user.ID)
+	}
+
+	return &models.AuthResponse{AccessToken: accessToken, User: *user}, nil
+}
+
+// signOutOtherSessions ends every session but the current one and revokes all
+// access tokens issued so far. It returns a fresh access token for the
+// current session.
+func (s *AuthService) signOutOtherSessions(user *models.User, sessionID string) (string, error) {
+	// Tokens issued without a session ID can't be told apart, so all sessions end
+	var err error
+	if sessionID == "" {
+		err = s.tokenRepo.DeleteAllByUserID(user.ID)
+	} else {
+		err = s.tokenRepo.DeleteAllByUserIDExcept(user.ID, sessionID)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := s.revokeUserAccessTokens(user.ID); err != nil {
+		return "", err
+	}
+
+	accessToken, err := s.generateAccessToken(user, sessionID)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate access token: %w", err)
+	}
+	return accessToken, nil
+}
+
+// checkPassword confirms an action with the account password. It returns
+// ErrInvalidPassword for a wrong password and ErrPasswordNotSet for accounts
+// that have none. Wrong passwords count towards the same lockout as failed
+// logins, so a stolen session can't be used to guess the password; while
+// locked out a *lockout.LockedError is returned.
+func (s *AuthService) checkPassword(user *models.User, password string, client models.ClientInfo) error {
+	if err := s.loginGuard.Check(user.Email, client.IPAddress); err != nil {
+		return err
+	}
+
+	if !user.HasPassword() {
+		return ErrPasswordNotSet
+	}
+
+	ok, err := s.hasher.Verify(user.PasswordHash, password)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if err := s.loginGuard.RecordFailure(user.Email, client.IPAddress); err != nil {
+			return err
+		}
+		return ErrInvalidPassword
+	}
+
+	s.loginGuard.RecordSuccess(user.Email)
+	return nil
+}
+
+// RequestEmailChange sends a confirmation link to the new address. The
+// account keeps its current address until the link is followed.
+func (s *AuthService) RequestEmailChange(userID int, req *models.ChangeEmailRequest, client models.ClientInfo) error {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkPassword(user, req.Password, client); err != nil {
+		return err
+	}
+
+	if req.NewEmail == user.Email {
+		return ErrSameEmail
+	}
+	exists, err := s.userRepo.EmailExists(req.NewEmail)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return repository.ErrEmailTaken
+	}
+
+	token, err := s.issueActionToken(user, req.NewEmail, models.PurposeEmailChange, s.settings.VerificationTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(mailer.Message{
+		To:      req.NewEmail,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm that this is the new email address of your account by opening the link below:\n\n"+
+			"%s/confirm-email?token=%s\n\nThe link expires in %s. If you did not ask for this change, you can ignore this email.",
+			user.Username, s.settings.AppBaseURL, token, s.settings.VerificationTokenTTL),
+	})
+}
+
+// ConfirmEmailChange redeems an email change token and moves the account to
+// the confirmed address. The previous address is told about the change.
+func (s *AuthService) ConfirmEmailChange(token string, client models.ClientInfo) error {
+	actionToken, err := s.actionTokenRepo.Consume(models.PurposeEmailChange, token)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.userRepo.GetByID(actionToken.UserID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.userRepo.UpdateEmail(user.ID, actionToken.Email); err != nil {
+		return err
+	}
+	s.recordEvent(models.AuthEventEmailChange, models.OutcomeSuccess, user.ID, client,
+		map[string]interface{}{"from": redact.Email(user.Email), "to": redact.Email(actionToken.Email)})
+
+	// Links sent to the old address must not work anymore
+	for _, purpose := range []string{models.PurposeEmailVerification, models.PurposePasswordReset, models.PurposeMagicLink} {
+		if err := s.actionTokenRepo.DeleteByUserID(user.ID, purpose); err != nil {
+			slog.Error("Failed to delete action tokens after email change", "error", err, "user_id", user.ID)
+		}
+	}
+
+	if err := s.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Your email address was changed",
+		Body: fmt.Sprintf("Hi %s,\n\nThe email address of your account was changed to %s.\n\n"+
+			"If this wasn't you, contact support immediately.", user.Username, actionToken.Email),
+	}); err != nil {
+		slog.Error("Failed to send email change notice", "error", err, "user_id", user.ID)
+	}
+
+	return nil
+}
+
+// DeleteAccount permanently deletes the user after checking their password.
+// Access tokens already issued stop working at once, and a user.deleted event
+// is recorded so that other services purge their data about the user.
+func (s *AuthService) DeleteAccount(userID int, password string, client models.ClientInfo) error {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkPassword(user, password, client); err != nil {
+		if errors.Is(err, ErrInvalidPassword) {
+			s.recordEvent(models.AuthEventAccountDelete, models.OutcomeFailure, user.ID, client,
+				map[string]interface{}{"reason": "invalid_password"})
+		}
+		return err
+	}
+
+	// Revoke first: the revocation outlives the user, a failed deletion only signs them out
+	if err := s.revokeUserAccessTokens(user.ID); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(models.UserDeletedEvent{UserID: user.ID, DeletedAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	if err := s.userRepo.Delete(user.ID, &models.OutboxEvent{Type: models.EventUserDeleted, Payload: payload}); err != nil {
+		return err
+	}
+	s.recordEvent(models.AuthEventAccountDelete, models.OutcomeSuccess, user.ID, client, nil)
+
+	if err := s.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Your account was deleted",
+		Body: fmt.Sprintf("Hi %s,\n\nYour account and the data associated with it have been deleted.\n\n"+
+			"If this wasn't you, contact support immediately.", user.Username),
+	}); err != nil {
+		slog.Error("Failed to send account deletion notice", "error", err, "user_id", user.ID)
+	}
+
+	return nil
+}
+
+// ExportAccount collects the personal data held about the user, including
+// their missions data fetched from the missions service
+func (s *AuthService) ExportAccount(userID int) (*models.AccountExport, error) {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	mfaEnabled, err := s.mfaEnabled(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := s.tokenRepo.ListSessions(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceToken, err := s.missionsServiceToken()
+	if err != nil {
+		return nil, err
+	}
+	missionsData, err := s.missions.ExportUserData(user.ID, serviceToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.AccountExport{
+		ExportedAt: time.Now().UTC(),
+		User:       *user,
+		MFAEnabled: mfaEnabled,
+		Sessions:   sessions,
+		Missions:   missionsData,
+	}, nil
+}
+
+// missionsServiceToken issues the service token the missions service is
+// called with, through the client credentials grant of the configured client.
+// The user's own token is no use there: it may lack the scopes, or be a
+// personal access token missions can't introspect.
+func (s *AuthService) missionsServiceToken() (string, error) {
+	client, err := s.oauthClientRepo.Get(s.settings.MissionsClientID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get missions client %q: %w", s.settings.MissionsClientID, err)
+	}
+
+	token, err := s.IssueClientCredentialsToken(client, missions.ScopeExport)
+	if err != nil {
+		return "", fmt.Errorf("failed to issue missions service token: %w", err)
+	}
+	return token.AccessToken, nil
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pseudoerr/auth-service/internal/lockout"
+	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPassword(t *testing.T) {
+	client := models.ClientInfo{IPAddress: "10.0.0.1"}
+
+	tests := []struct {
+		name string
+		// attempts are tried in order, each expecting its error
+		attempts []string
+		errs     []error
+	}{
+		{
+			name:     "right password",
+			attempts: []string{"correct horse"},
+			errs:     []error{nil},
+		},
+		{
+			name:     "wrong password",
+			attempts: []string{"wrong"},
+			errs:     []error{ErrInvalidPassword},
+		},
+		{
+			name:     "locked out after the free attempts",
+			attempts: []string{"wrong", "wrong", "correct horse"},
+			errs:     []error{ErrInvalidPassword, &lockout.LockedError{}, &lockout.LockedError{}},
+		},
+		{
+			name:     "success clears the failures",
+			attempts: []string{"wrong", "correct horse", "wrong", "correct horse"},
+			errs:     []error{ErrInvalidPassword, nil, ErrInvalidPassword, nil},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t)
+			hash, err := s.hasher.Hash("correct horse")
+			require.NoError(t, err)
+			user := &models.User{ID: 7, Email: "ann@example.com", PasswordHash: hash}
+
+			for i, password := range tt.attempts {
+				err := s.checkPassword(user, password, client)
+				var locked *lockout.LockedError
+				switch want := tt.errs[i]; {
+				case errors.As(want, &locked):
+					require.ErrorAs(t, err, &locked, "attempt %d", i+1)
+					assert.Positive(t, locked.RetryAfter)
+				case want == nil:
+					require.NoError(t, err, "attempt %d", i+1)
+				default:
+					require.ErrorIs(t, err, want, "attempt %d", i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckPasswordWithoutPassword(t *testing.T) {
+	s := newTestService(t)
+	user := &models.User{ID: 7, Email: "ann@example.com"}
+
+	assert.ErrorIs(t, s.checkPassword(user, "", models.ClientInfo{}), ErrPasswordNotSet)
+}
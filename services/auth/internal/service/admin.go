@@ -0,0 +1,260 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/pseudoerr/auth-service/internal/oauth"
+	"github.com/pseudoerr/auth-service/internal/repository"
+	"github.com/pseudoerr/auth-service/internal/validation"
+)
+
+var (
+	ErrAccountDisabled = errors.New("account is disabled")
+	ErrSelfAdminAction = errors.New("administrators can't do this to their own account")
+)
+
+// Actor is the administrator an action is taken by
+type Actor struct {
+	UserID int
+	Client models.ClientInfo
+}
+
+// SearchUsers returns a page of users whose email or username contains query
+func (s *AuthService) SearchUsers(actor Actor, query string, page, perPage int) (*models.UserList, error) {
+	users, total, err := s.userRepo.Search(query, perPage, (page-1)*perPage)
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit(actor, models.AuditUserSearch, nil, map[string]interface{}{"query": query, "page": page})
+	return &models.UserList{
+		Users:      users,
+		Pagination: models.Pagination{Total: total, Page: page, PerPage: perPage},
+	}, nil
+}
+
+// GetUserDetails returns the user with their roles, 2FA status and number of active sessions
+func (s *AuthService) GetUserDetails(actor Actor, userID int) (*models.UserDetails, error) {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	mfaEnabled, err := s.mfaEnabled(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := s.tokenRepo.ListSessions(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit(actor, models.AuditUserView, &user.ID, nil)
+	return &models.UserDetails{User: *user, MFAEnabled: mfaEnabled, SessionCount: len(sessions)}, nil
+}
+
+// SetUserDisabled disables or re-enables an account. Disabling ends every
+// session of the user and revokes their access tokens.
+func (s *AuthService) SetUserDisabled(actor Actor, userID int, disabled bool) error {
+	if disabled && userID == actor.UserID {
+		return ErrSelfAdminAction
+	}
+
+	if err := s.userRepo.SetDisabled(userID, disabled); err != nil {
+		return err
+	}
+
+	action := models.AuditUserEnable
+	if disabled {
+		action = models.AuditUserDisable
+		if err := s.endAllSessions(userID); err != nil {
+			return err
+		}
+	}
+
+	s.audit(actor, action, &userID, nil)
+	return nil
+}
+
+// ForceLogout ends every session of the user and revokes their access tokens
+func (s *AuthService) ForceLogout(actor Actor, userID int) error {
+	if _, err := s.userRepo.GetByID(userID); err != nil {
+		return err
+	}
+
+	if err := s.endAllSessions(userID); err != nil {
+		return err
+	}
+
+	s.audit(actor, models.AuditUserLogout, &userID, nil)
+	return nil
+}
+
+// SetUserRoles replaces the user's roles. Their access tokens are revoked so
+// that the new permissions apply from the next refresh on.
+func (s *AuthService) SetUserRoles(actor Actor, userID int, roles []string) (*models.User, error) {
+	if userID == actor.UserID {
+		return nil, ErrSelfAdminAction
+	}
+
+	previous, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.roleRepo.SetUserRoles(userID, roles); err != nil {
+		return nil, err
+	}
+	if err := s.revokeUserAccessTokens(userID); err != nil {
+		return nil, err
+	}
+
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit(actor, models.AuditUserSetRoles, &userID, map[string]interface{}{"from": previous.Roles, "to": user.Roles})
+	return user, nil
+}
+
+// ListAuditLog returns a page of the audit log, newest first, optionally
+// limited to actions on one user
+func (s *AuthService) ListAuditLog(targetUserID, page, perPage int) (*models.AuditLog, error) {
+	entries, total, err := s.auditRepo.List(targetUserID, perPage, (page-1)*perPage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.AuditLog{
+		Entries:    entries,
+		Pagination: models.Pagination{Total: total, Page: page, PerPage: perPage},
+	}, nil
+}
+
+// RegisterClient registers an application. Confidential clients get a
+// secret, returned only here; public clients, like IDE plugins, can't keep
+// one and must register redirect URIs to use the authorization code grant.
+func (s *AuthService) RegisterClient(actor Actor, req *models.RegisterClientRequest) (*models.RegisterClientResponse, error) {
+	for _, uri := range req.RedirectURIs {
+		if !oauth.ValidRedirectURI(uri) {
+			return nil, validation.NewError(validation.FieldError{
+				Field:   "redirect_uris",
+				Code:    "redirect_uri",
+				Message: fmt.Sprintf("%q must be an https URI, a loopback http URI or a private-use scheme", uri),
+			})
+		}
+	}
+	if req.Public && len(req.RedirectURIs) == 0 {
+		return nil, validation.NewError(validation.FieldError{
+			Field:   "redirect_uris",
+			Code:    "required",
+			Message: "redirect_uris is required for public clients",
+		})
+	}
+
+	client := &models.OAuthClient{
+		ClientID:     req.ClientID,
+		Name:         req.Name,
+		Scopes:       slices.Compact(slices.Sorted(slices.Values(req.Scopes))),
+		RedirectURIs: req.RedirectURIs,
+		Public:       req.Public,
+	}
+	var secret string
+	if !client.Public {
+		var err error
+		if secret, err = NewClientSecret(); err != nil {
+			return nil, err
+		}
+	}
+
+	err := s.oauthClientRepo.Create(client, secret)
+	if errors.Is(err, repository.ErrUnknownScope) {
+		return nil, validation.NewError(validation.FieldError{Field: "scopes", Code: "scope", Message: err.Error()})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit(actor, models.AuditClientCreate, nil, map[string]interface{}{
+		"client_id": client.ClientID, "scopes": client.Scopes, "public": client.Public,
+	})
+	return &models.RegisterClientResponse{OAuthClient: *client, ClientSecret: secret}, nil
+}
+
+// ListClients returns every registered client, revoked ones included
+func (s *AuthService) ListClients() ([]models.OAuthClient, error) {
+	return s.oauthClientRepo.List()
+}
+
+// RevokeClient stops a client from obtaining tokens. Tokens it holds stop
+// passing introspection at once. repository.ErrInvalidClient is returned for
+// unknown or already revoked clients.
+func (s *AuthService) RevokeClient(actor Actor, clientID string) error {
+	if err := s.oauthClientRepo.Revoke(clientID); err != nil {
+		return err
+	}
+
+	s.audit(actor, models.AuditClientRevoke, nil, map[string]interface{}{"client_id": clientID})
+	return nil
+}
+
+// NewClientSecret generates a secret for a confidential client
+func NewClientSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// endAllSessions deletes the user's refresh tokens, those of applications
+// acting for them included, and revokes their access tokens and personal
+// access tokens
+func (s *AuthService) endAllSessions(userID int) error {
+	if err := s.tokenRepo.DeleteAllByUserID(userID); err != nil {
+		return err
+	}
+	if err := s.oauthTokenRepo.DeleteAllByUserID(userID); err != nil {
+		return err
+	}
+	if err := s.patRepo.RevokeAllByUserID(userID); err != nil {
+		return err
+	}
+	return s.revokeUserAccessTokens(userID)
+}
+
+// audit records an administrative action. Actions on a user also go to the
+// security log. The action already happened, so a failure to record it is
+// logged rather than reported to the administrator.
+func (s *AuthService) audit(actor Actor, action string, targetUserID *int, details map[string]interface{}) {
+	if targetUserID != nil && action != models.AuditUserView {
+		s.recordAdminEvent(actor, action, *targetUserID, details)
+	}
+
+	entry := &models.AuditEntry{
+		ActorID:      actor.UserID,
+		Action:       action,
+		TargetUserID: targetUserID,
+		IPAddress:    actor.Client.IPAddress,
+	}
+	if details != nil {
+		payload, err := json.Marshal(details)
+		if err != nil {
+			slog.Error("Failed to encode audit details", "error", err, "action", action)
+		}
+		entry.Details = payload
+	}
+
+	if err := s.auditRepo.Create(entry); err != nil {
+		slog.Error("Failed to write audit entry", "error", err, "action", action, "actor_id", actor.UserID)
+	}
+}
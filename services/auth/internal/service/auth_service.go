@@ -3,43 +3,145 @@ package service
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"log/slog"
 	"time"
 
+	"github.com/pseudoerr/auth-service/internal/denylist"
+	"github.com/pseudoerr/auth-service/internal/hashing"
+	"github.com/pseudoerr/auth-service/internal/keys"
+	"github.com/pseudoerr/auth-service/internal/lockout"
+	"github.com/pseudoerr/auth-service/internal/mailer"
+	"github.com/pseudoerr/auth-service/internal/missions"
 	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/pseudoerr/auth-service/internal/oidc"
+	"github.com/pseudoerr/auth-service/internal/passwordpolicy"
+	"github.com/pseudoerr/auth-service/internal/redact"
 	"github.com/pseudoerr/auth-service/internal/repository"
+	"github.com/pseudoerr/auth-service/internal/secretbox"
 
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
 )
 
+// Policies for accounts whose email address is not verified yet
+const (
+	// UnverifiedPolicyAllow issues tokens carrying "verified": false
+	UnverifiedPolicyAllow = "allow"
+	// UnverifiedPolicyBlock issues no tokens until the address is verified
+	UnverifiedPolicyBlock = "block"
+)
+
+var ErrEmailNotVerified = errors.New("email address is not verified")
+
+// AuthSettings groups the tunables of the auth service
+type AuthSettings struct {
+	AccessTokenTTL       time.Duration
+	RefreshTokenTTL      time.Duration
+	VerificationTokenTTL time.Duration
+	PasswordResetTTL     time.Duration
+	MagicLinkTTL         time.Duration
+	UnverifiedPolicy     string
+	// AppBaseURL is the frontend URL links in emails point to
+	AppBaseURL string
+	// MFAIssuer is the account issuer shown in authenticator apps
+	MFAIssuer string
+	// DeviceCodeTTL is how long a device authorization waits for the user
+	DeviceCodeTTL time.Duration
+	// OIDCStateTTL bounds how long a sign-in with a provider may take
+	OIDCStateTTL time.Duration
+	// MissionsClientID is the OAuth client whose service tokens the missions
+	// service is called with
+	MissionsClientID string
+}
+
 type AuthService struct {
 	userRepo        *repository.UserRepository
 	tokenRepo       *repository.TokenRepository
-	jwtSecret       string
-	accessTokenTTL  time.Duration
-	refreshTokenTTL time.Duration
+	actionTokenRepo *repository.ActionTokenRepository
+	mfaRepo         *repository.MFARepository
+	roleRepo        *repository.RoleRepository
+	auditRepo       *repository.AuditRepository
+	authEventRepo   *repository.AuthEventRepository
+	oauthClientRepo *repository.OAuthClientRepository
+	oauthCodeRepo   *repository.OAuthCodeRepository
+	oauthTokenRepo  *repository.OAuthTokenRepository
+	oauthDeviceRepo *repository.OAuthDeviceRepository
+	patRepo         *repository.PersonalAccessTokenRepository
+	identityRepo    *repository.IdentityRepository
+	oidcStateRepo   *repository.OIDCStateRepository
+	oidcProviders   map[string]*oidc.Provider
+	secrets         *secretbox.Box
+	keyring         *keys.Keyring
+	denylist        denylist.Store
+	loginGuard      *lockout.Guard
+	hasher          hashing.PasswordHasher
+	passwordPolicy  *passwordpolicy.Checker
+	mailer          mailer.Mailer
+	missions        *missions.Client
+	settings        AuthSettings
 }
 
 func NewAuthService(userRepo *repository.UserRepository, tokenRepo *repository.TokenRepository,
-	jwtSecret string, accessTokenTTL, refreshTokenTTL time.Duration) *AuthService {
+	actionTokenRepo *repository.ActionTokenRepository, mfaRepo *repository.MFARepository, roleRepo *repository.RoleRepository,
+	auditRepo *repository.AuditRepository, authEventRepo *repository.AuthEventRepository,
+	oauthClientRepo *repository.OAuthClientRepository, oauthCodeRepo *repository.OAuthCodeRepository,
+	oauthTokenRepo *repository.OAuthTokenRepository, oauthDeviceRepo *repository.OAuthDeviceRepository, patRepo *repository.PersonalAccessTokenRepository,
+	identityRepo *repository.IdentityRepository, oidcStateRepo *repository.OIDCStateRepository, oidcProviders []*oidc.Provider,
+	secrets *secretbox.Box, keyring *keys.Keyring, denylist denylist.Store, loginGuard *lockout.Guard,
+	hasher hashing.PasswordHasher, passwordPolicy *passwordpolicy.Checker, mailer mailer.Mailer,
+	missions *missions.Client, settings AuthSettings) *AuthService {
+	providers := make(map[string]*oidc.Provider, len(oidcProviders))
+	for _, provider := range oidcProviders {
+		providers[provider.Name()] = provider
+	}
+
 	return &AuthService{
 		userRepo:        userRepo,
 		tokenRepo:       tokenRepo,
-		jwtSecret:       jwtSecret,
-		accessTokenTTL:  accessTokenTTL,
-		refreshTokenTTL: refreshTokenTTL,
+		actionTokenRepo: actionTokenRepo,
+		mfaRepo:         mfaRepo,
+		roleRepo:        roleRepo,
+		auditRepo:       auditRepo,
+		authEventRepo:   authEventRepo,
+		oauthClientRepo: oauthClientRepo,
+		oauthCodeRepo:   oauthCodeRepo,
+		oauthTokenRepo:  oauthTokenRepo,
+		oauthDeviceRepo: oauthDeviceRepo,
+		patRepo:         patRepo,
+		identityRepo:    identityRepo,
+		oidcStateRepo:   oidcStateRepo,
+		oidcProviders:   providers,
+		secrets:         secrets,
+		keyring:         keyring,
+		denylist:        denylist,
+		loginGuard:      loginGuard,
+		hasher:          hasher,
+		passwordPolicy:  passwordPolicy,
+		mailer:          mailer,
+		missions:        missions,
+		settings:        settings,
 	}
 }
 
-func (s *AuthService) Register(req *models.RegisterRequest) (*models.AuthResponse, error) {
+// Register creates an account. A password violating the policy is reported
+// as a *validation.Error. Passwordless accounts sign in with magic links only.
+func (s *AuthService) Register(req *models.RegisterRequest, client models.ClientInfo) (*models.AuthResponse, error) {
+	if !req.Passwordless {
+		if err := s.passwordPolicy.Check("password", req.Password, req.Email, req.Username); err != nil {
+			return nil, err
+		}
+	}
+
 	// Check if email already exists
 	exists, err := s.userRepo.EmailExists(req.Email)
 	if err != nil {
 		return nil, fmt.Errorf("failed to check email: %w", err)
 	}
 	if exists {
-		return nil, fmt.Errorf("email already registered")
+		s.recordEvent(models.AuthEventRegister, models.OutcomeFailure, 0, client,
+			map[string]interface{}{"reason": "email_taken", "email": redact.Email(req.Email)})
+		return nil, repository.ErrEmailTaken
 	}
 
 	// Check if username already exists
@@ -48,106 +150,453 @@ func (s *AuthService) Register(req *models.RegisterRequest) (*models.AuthRespons
 		return nil, fmt.Errorf("failed to check username: %w", err)
 	}
 	if exists {
-		return nil, fmt.Errorf("username already taken")
-	}
-
-	// Hash password
-	passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-	if err != nil {
-		return nil, fmt.Errorf("failed to hash password: %w", err)
+		return nil, repository.ErrUsernameTaken
 	}
 
 	// Create user
 	user := &models.User{
-		Email:        req.Email,
-		Username:     req.Username,
-		PasswordHash: string(passwordHash),
+		Email:    req.Email,
+		Username: req.Username,
+	}
+	if !req.Passwordless {
+		user.PasswordHash, err = s.hasher.Hash(req.Password)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	if err := s.userRepo.Create(user); err != nil {
 		return nil, fmt.Errorf("failed to create user: %w", err)
 	}
+	s.recordEvent(models.AuthEventRegister, models.OutcomeSuccess, user.ID, client, nil)
+
+	// New accounts start out as learners
+	if err := s.roleRepo.AssignRole(user.ID, models.RoleLearner); err != nil {
+		return nil, fmt.Errorf("failed to assign default role: %w", err)
+	}
+
+	// A failed delivery must not fail the registration, the user can ask for a new link
+	if err := s.sendVerificationEmail(user); err != nil {
+		slog.Error("Failed to send verification email", "error", err, "user_id", user.ID)
+	}
+
+	if !s.mayIssueTokens(user) {
+		return &models.AuthResponse{User: *user}, nil
+	}
 
 	// Generate tokens
-	return s.generateAuthResponse(user)
+	return s.generateAuthResponse(user, client)
 }
 
-func (s *AuthService) Login(req *models.LoginRequest) (*models.AuthResponse, error) {
+// Login checks the user's password. Accounts with two-factor authentication
+// get a challenge instead of tokens, which is redeemed through VerifyMFA.
+// Repeated failures lock the account and the client IP out for a growing
+// time, during which a *lockout.LockedError is returned.
+func (s *AuthService) Login(req *models.LoginRequest, client models.ClientInfo) (*models.AuthResponse, *models.MFAChallenge, error) {
+	if err := s.loginGuard.Check(req.Email, client.IPAddress); err != nil {
+		s.recordEvent(models.AuthEventLogin, models.OutcomeFailure, 0, client,
+			map[string]interface{}{"reason": "locked", "email": redact.Email(req.Email)})
+		return nil, nil, err
+	}
+
 	// Get user by email
 	user, err := s.userRepo.GetByEmail(req.Email)
 	if err != nil {
-		return nil, fmt.Errorf("invalid credentials")
+		s.recordEvent(models.AuthEventLogin, models.OutcomeFailure, 0, client,
+			map[string]interface{}{"reason": "unknown_account", "email": redact.Email(req.Email)})
+		return nil, nil, s.loginFailed(req.Email, client)
+	}
+
+	// Magic-link-only accounts can't sign in with a password
+	if !user.HasPassword() {
+		s.recordEvent(models.AuthEventLogin, models.OutcomeFailure, user.ID, client,
+			map[string]interface{}{"reason": "no_password"})
+		return nil, nil, s.loginFailed(req.Email, client)
 	}
 
 	// Check password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
-		return nil, fmt.Errorf("invalid credentials")
+	ok, err := s.hasher.Verify(user.PasswordHash, req.Password)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		s.recordEvent(models.AuthEventLogin, models.OutcomeFailure, user.ID, client,
+			map[string]interface{}{"reason": "invalid_password"})
+		return nil, nil, s.loginFailed(req.Email, client)
+	}
+
+	s.upgradePasswordHash(user, req.Password)
+
+	if user.Disabled() {
+		s.recordEvent(models.AuthEventLogin, models.OutcomeFailure, user.ID, client,
+			map[string]interface{}{"reason": "disabled"})
+		return nil, nil, ErrAccountDisabled
+	}
+
+	if !s.mayIssueTokens(user) {
+		s.recordEvent(models.AuthEventLogin, models.OutcomeFailure, user.ID, client,
+			map[string]interface{}{"reason": "email_not_verified"})
+		return nil, nil, ErrEmailNotVerified
+	}
+
+	mfaEnabled, err := s.mfaEnabled(user.ID)
+	if err != nil {
+		return nil, nil, err
 	}
+	// With 2FA the failure count is only cleared once the second factor is right
+	if mfaEnabled {
+		challenge, err := s.generateMFAChallenge(user)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate mfa challenge: %w", err)
+		}
+		s.recordEvent(models.AuthEventLogin, models.OutcomeSuccess, user.ID, client,
+			map[string]interface{}{"mfa_required": true})
+		return nil, challenge, nil
+	}
+
+	s.loginGuard.RecordSuccess(req.Email)
 
 	// Generate tokens
-	return s.generateAuthResponse(user)
+	authResponse, err := s.generateAuthResponse(user, client)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.recordEvent(models.AuthEventLogin, models.OutcomeSuccess, user.ID, client, nil)
+	return authResponse, nil, nil
+}
+
+// upgradePasswordHash rehashes the password with the current algorithm and
+// parameters while its plaintext is at hand. Failures only cost the upgrade.
+func (s *AuthService) upgradePasswordHash(user *models.User, password string) {
+	if !s.hasher.NeedsRehash(user.PasswordHash) {
+		return
+	}
+
+	passwordHash, err := s.hasher.Hash(password)
+	if err != nil {
+		slog.Error("Failed to rehash password", "error", err, "user_id", user.ID)
+		return
+	}
+	if err := s.userRepo.ReplacePasswordHash(user.ID, user.PasswordHash, passwordHash); err != nil {
+		slog.Error("Failed to store rehashed password", "error", err, "user_id", user.ID)
+		return
+	}
+	user.PasswordHash = passwordHash
+}
+
+// loginFailed records a failed attempt. Unknown emails are counted as well so
+// lockouts don't reveal which accounts exist.
+func (s *AuthService) loginFailed(email string, client models.ClientInfo) error {
+	if err := s.loginGuard.RecordFailure(email, client.IPAddress); err != nil {
+		return err
+	}
+	return fmt.Errorf("invalid credentials")
 }
 
-func (s *AuthService) RefreshToken(req *models.RefreshRequest) (*models.AuthResponse, error) {
-	// Get refresh token from database
-	refreshToken, err := s.tokenRepo.GetByToken(req.RefreshToken)
+// RefreshToken rotates the refresh token and records the device that used it
+func (s *AuthService) RefreshToken(req *models.RefreshRequest, client models.ClientInfo) (*models.AuthResponse, error) {
+	newTokenString, err := s.generateRefreshToken()
 	if err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	newToken := &models.RefreshToken{
+		Token:     newTokenString,
+		UserAgent: client.UserAgent,
+		IPAddress: client.IPAddress,
+		ExpiresAt: time.Now().Add(s.settings.RefreshTokenTTL),
+	}
+
+	// Swap the presented token for a new one of the same family
+	rotated, err := s.tokenRepo.Rotate(req.RefreshToken, newToken)
+	if err != nil {
+		if errors.Is(err, repository.ErrRefreshTokenReused) {
+			slog.Warn("Security event: refresh token reuse detected, token family revoked",
+				"user_id", rotated.UserID,
+				"family_id", rotated.FamilyID,
+			)
+			s.recordEvent(models.AuthEventRefreshReuse, models.OutcomeFailure, rotated.UserID, client,
+				map[string]interface{}{"session_id": rotated.FamilyID})
+		} else {
+			s.recordEvent(models.AuthEventRefresh, models.OutcomeFailure, 0, client,
+				map[string]interface{}{"reason": "invalid_token"})
+		}
 		return nil, fmt.Errorf("invalid refresh token")
 	}
 
 	// Get user
-	user, err := s.userRepo.GetByID(refreshToken.UserID)
+	user, err := s.userRepo.GetByID(rotated.UserID)
 	if err != nil {
 		return nil, fmt.Errorf("user not found")
 	}
+	if user.Disabled() {
+		// Disabling deletes the user's sessions, this only catches a refresh racing it
+		if err := s.tokenRepo.DeleteFamily(rotated.FamilyID); err != nil {
+			slog.Error("Failed to revoke session of disabled user", "error", err, "user_id", user.ID)
+		}
+		s.recordEvent(models.AuthEventRefresh, models.OutcomeFailure, user.ID, client,
+			map[string]interface{}{"reason": "disabled", "session_id": rotated.FamilyID})
+		return nil, ErrAccountDisabled
+	}
 
-	// Delete old refresh token
-	if err := s.tokenRepo.DeleteByToken(req.RefreshToken); err != nil {
-		return nil, fmt.Errorf("failed to delete old refresh token: %w", err)
+	// Generate new access token
+	accessToken, err := s.generateAccessToken(user, rotated.FamilyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate access token: %w", err)
 	}
 
-	// Generate new tokens
-	return s.generateAuthResponse(user)
+	s.recordEvent(models.AuthEventRefresh, models.OutcomeSuccess, user.ID, client,
+		map[string]interface{}{"session_id": rotated.FamilyID})
+
+	return &models.AuthResponse{
+		AccessToken:  accessToken,
+		RefreshToken: rotated.Token,
+		User:         *user,
+	}, nil
 }
 
-func (s *AuthService) Logout(userID int, refreshToken string) error {
-	// Delete specific refresh token if provided
+// Logout revokes the access token identified by accessTokenID. If a refresh
+// token is given only its session ends, and likewise the session named by
+// sessionID, which browsers keeping the refresh token in a cookie give
+// instead. Otherwise every session of the user is terminated and all of
+// their access tokens are revoked.
+func (s *AuthService) Logout(userID int, refreshToken, sessionID, accessTokenID string, accessTokenExpiresAt time.Time,
+	client models.ClientInfo) error {
+	if err := s.denylist.RevokeToken(accessTokenID, accessTokenExpiresAt); err != nil {
+		return err
+	}
+
+	// Revoke the session the refresh token belongs to if provided
 	if refreshToken != "" {
-		return s.tokenRepo.DeleteByToken(refreshToken)
+		token, err := s.tokenRepo.GetByToken(refreshToken)
+		if err != nil {
+			if errors.Is(err, repository.ErrRefreshTokenNotFound) {
+				s.recordEvent(models.AuthEventLogout, models.OutcomeSuccess, userID, client, nil)
+				return nil
+			}
+			return err
+		}
+		if token.UserID != userID {
+			return nil
+		}
+		if err := s.tokenRepo.DeleteFamily(token.FamilyID); err != nil {
+			return err
+		}
+		s.recordEvent(models.AuthEventLogout, models.OutcomeSuccess, userID, client,
+			map[string]interface{}{"session_id": token.FamilyID})
+		return nil
+	}
+
+	if sessionID != "" {
+		if err := s.tokenRepo.DeleteSession(userID, sessionID); err != nil && !errors.Is(err, repository.ErrRefreshTokenNotFound) {
+			return err
+		}
+		s.recordEvent(models.AuthEventLogout, models.OutcomeSuccess, userID, client,
+			map[string]interface{}{"session_id": sessionID})
+		return nil
 	}
 
 	// Otherwise delete all user's refresh tokens
-	return s.tokenRepo.DeleteAllByUserID(userID)
+	if err := s.tokenRepo.DeleteAllByUserID(userID); err != nil {
+		return err
+	}
+	if err := s.revokeUserAccessTokens(userID); err != nil {
+		return err
+	}
+
+	s.recordEvent(models.AuthEventLogout, models.OutcomeSuccess, userID, client,
+		map[string]interface{}{"all_sessions": true})
+	return nil
 }
 
-func (s *AuthService) GetUserByID(userID int) (*models.User, error) {
-	return s.userRepo.GetByID(userID)
+// ListSessions returns the user's active sessions, marking the one the
+// request was made from
+func (s *AuthService) ListSessions(userID int, currentSessionID string) ([]models.Session, error) {
+	sessions, err := s.tokenRepo.ListSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentSessionID
+	}
+
+	return sessions, nil
 }
 
-func (s *AuthService) generateAuthResponse(user *models.User) (*models.AuthResponse, error) {
-	// Generate access token
-	accessToken, err := s.generateAccessToken(user)
+// RevokeSession ends one of the user's sessions. Access tokens already issued
+// to another session stay valid until they expire; when the current session
+// is revoked the presented access token is revoked as well.
+func (s *AuthService) RevokeSession(userID int, sessionID, currentSessionID, accessTokenID string, accessTokenExpiresAt time.Time,
+	client models.ClientInfo) error {
+	if err := s.tokenRepo.DeleteSession(userID, sessionID); err != nil {
+		return err
+	}
+	s.recordEvent(models.AuthEventSessionRevoke, models.OutcomeSuccess, userID, client,
+		map[string]interface{}{"session_id": sessionID})
+
+	if sessionID == currentSessionID {
+		return s.denylist.RevokeToken(accessTokenID, accessTokenExpiresAt)
+	}
+
+	return nil
+}
+
+// VerifyEmail redeems a verification token and marks the address it was sent to as verified
+func (s *AuthService) VerifyEmail(token string) error {
+	actionToken, err := s.actionTokenRepo.Consume(models.PurposeEmailVerification, token)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate access token: %w", err)
+		return err
+	}
+
+	return s.userRepo.MarkEmailVerified(actionToken.UserID, actionToken.Email)
+}
+
+// ResendVerification sends a new verification link. It reports success for
+// unknown and already verified addresses so accounts can't be enumerated.
+func (s *AuthService) ResendVerification(email string) error {
+	user, err := s.userRepo.GetByEmail(email)
+	if err != nil || user.EmailVerified() {
+		return nil
+	}
+
+	return s.sendVerificationEmail(user)
+}
+
+// ForgotPassword emails a password reset link. Unknown addresses are silently
+// ignored so the endpoint can't be used to enumerate accounts.
+func (s *AuthService) ForgotPassword(email string) error {
+	user, err := s.userRepo.GetByEmail(email)
+	if err != nil {
+		return nil
+	}
+
+	token, err := s.issueActionToken(user, user.Email, models.PurposePasswordReset, s.settings.PasswordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your account. Open the link below to choose a new one:\n\n"+
+			"%s/reset-password?token=%s\n\nThe link expires in %s. If you did not ask for a reset, you can ignore this email.",
+			user.Username, s.settings.AppBaseURL, token, s.settings.PasswordResetTTL),
+	})
+}
+
+// ResetPassword redeems a reset token, sets the new password and signs the
+// user out everywhere, personal access tokens included
+func (s *AuthService) ResetPassword(req *models.ResetPasswordRequest, client models.ClientInfo) error {
+	// The policy is checked before the token is redeemed, so a rejected
+	// password doesn't cost the user their reset link
+	actionToken, err := s.actionTokenRepo.Get(models.PurposePasswordReset, req.Token)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.userRepo.GetByID(actionToken.UserID)
+	if err != nil {
+		return err
+	}
+	if user.Email != actionToken.Email {
+		return repository.ErrActionTokenInvalid
+	}
+
+	if err := s.passwordPolicy.Check("new_password", req.NewPassword, user.Email, user.Username); err != nil {
+		return err
+	}
+
+	if _, err := s.actionTokenRepo.Consume(models.PurposePasswordReset, req.Token); err != nil {
+		return err
+	}
+
+	passwordHash, err := s.hasher.Hash(req.NewPassword)
+	if err != nil {
+		return err
+	}
+
+	if err := s.userRepo.UpdatePassword(user.ID, passwordHash); err != nil {
+		return err
+	}
+
+	// Following the emailed link proves ownership of the address as well
+	if !user.EmailVerified() {
+		if err := s.userRepo.MarkEmailVerified(user.ID, user.Email); err != nil {
+			slog.Error("Failed to mark email verified after password reset", "error", err, "user_id", user.ID)
+		}
+	}
+
+	if err := s.endAllSessions(user.ID); err != nil {
+		return err
+	}
+	s.recordEvent(models.AuthEventPasswordReset, models.OutcomeSuccess, user.ID, client, nil)
+
+	if err := s.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Your password was changed",
+		Body: fmt.Sprintf("Hi %s,\n\nThe password of your account was just reset and all sessions were signed out.\n\n"+
+			"If this wasn't you, request a new reset link immediately.", user.Username),
+	}); err != nil {
+		slog.Error("Failed to send password change notice", "error", err, "user_id", user.ID)
+	}
+
+	return nil
+}
+
+func (s *AuthService) GetUserByID(userID int) (*models.User, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, err
 	}
 
+	user.Roles, _, err = s.roleRepo.GetUserAccess(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// ListRoles returns the available roles and the permissions they grant
+func (s *AuthService) ListRoles() ([]models.Role, error) {
+	return s.roleRepo.List()
+}
+
+func (s *AuthService) generateAuthResponse(user *models.User, client models.ClientInfo) (*models.AuthResponse, error) {
 	// Generate refresh token
 	refreshTokenString, err := s.generateRefreshToken()
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
 	}
 
+	// Every fresh login starts a new token family, which is the session
+	familyID, err := randomHex(16)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token family: %w", err)
+	}
+
 	// Save refresh token to database
 	refreshToken := &models.RefreshToken{
 		UserID:    user.ID,
 		Token:     refreshTokenString,
-		ExpiresAt: time.Now().Add(s.refreshTokenTTL),
+		FamilyID:  familyID,
+		UserAgent: client.UserAgent,
+		IPAddress: client.IPAddress,
+		ExpiresAt: time.Now().Add(s.settings.RefreshTokenTTL),
 	}
 
 	if err := s.tokenRepo.Create(refreshToken); err != nil {
 		return nil, fmt.Errorf("failed to save refresh token: %w", err)
 	}
 
+	// Generate access token
+	accessToken, err := s.generateAccessToken(user, familyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate access token: %w", err)
+	}
+
 	return &models.AuthResponse{
 		AccessToken:  accessToken,
 		RefreshToken: refreshTokenString,
@@ -155,21 +604,111 @@ func (s *AuthService) generateAuthResponse(user *models.User) (*models.AuthRespo
 	}, nil
 }
 
-func (s *AuthService) generateAccessToken(user *models.User) (string, error) {
+func (s *AuthService) sendVerificationEmail(user *models.User) error {
+	token, err := s.issueActionToken(user, user.Email, models.PurposeEmailVerification, s.settings.VerificationTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening the link below:\n\n%s/verify-email?token=%s\n\n"+
+			"The link expires in %s. If you did not create an account, you can ignore this email.",
+			user.Username, s.settings.AppBaseURL, token, s.settings.VerificationTokenTTL),
+	})
+}
+
+// issueActionToken creates a single-use token bound to the email address it
+// is sent to, invalidating any earlier token of the user for the same purpose
+func (s *AuthService) issueActionToken(user *models.User, email, purpose string, ttl time.Duration) (string, error) {
+	if err := s.actionTokenRepo.DeleteByUserID(user.ID, purpose); err != nil {
+		return "", err
+	}
+
+	token, err := randomHex(32)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	actionToken := &models.ActionToken{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		Token:     token,
+		Email:     email,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := s.actionTokenRepo.Create(actionToken); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// mayIssueTokens applies the policy for unverified email addresses
+func (s *AuthService) mayIssueTokens(user *models.User) bool {
+	return user.EmailVerified() || s.settings.UnverifiedPolicy != UnverifiedPolicyBlock
+}
+
+// revokeUserAccessTokens denies every access token issued to the user so far.
+// Tokens issued afterwards are not affected.
+func (s *AuthService) revokeUserAccessTokens(userID int) error {
+	now := time.Now()
+	return s.denylist.RevokeUserTokens(userID, now, now.Add(s.settings.AccessTokenTTL))
+}
+
+// generateAccessToken signs an access token for the session carrying the
+// user's current roles and permissions. It also fills user.Roles for the response.
+func (s *AuthService) generateAccessToken(user *models.User, sessionID string) (string, error) {
+	roles, permissions, err := s.roleRepo.GetUserAccess(user.ID)
+	if err != nil {
+		return "", err
+	}
+	user.Roles = roles
+
 	claims := jwt.MapClaims{
-		"user_id":  user.ID,
-		"email":    user.Email,
-		"username": user.Username,
-		"exp":      time.Now().Add(s.accessTokenTTL).Unix(),
-		"iat":      time.Now().Unix(),
+		"token_use":   models.TokenUseAccess,
+		"sid":         sessionID,
+		"user_id":     user.ID,
+		"email":       user.Email,
+		"username":    user.Username,
+		"verified":    user.EmailVerified(),
+		"roles":       roles,
+		"permissions": permissions,
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(s.jwtSecret))
+	return s.signExpiringToken(claims, s.settings.AccessTokenTTL)
+}
+
+// signExpiringToken signs claims as a token with a unique jti that expires
+// after ttl
+func (s *AuthService) signExpiringToken(claims jwt.MapClaims, ttl time.Duration) (string, error) {
+	jti, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims["jti"] = jti
+	claims["iat"] = keys.IssuedAtClaim(now)
+	claims["exp"] = now.Add(ttl).Unix()
+	return s.signToken(claims)
+}
+
+// signToken signs claims with the active key, naming it in the kid header
+func (s *AuthService) signToken(claims jwt.MapClaims) (string, error) {
+	signingKey := s.keyring.Active()
+	token := jwt.NewWithClaims(signingKey.Method(), claims)
+	token.Header["kid"] = signingKey.ID
+	return token.SignedString(signingKey.PrivateKey)
 }
 
 func (s *AuthService) generateRefreshToken() (string, error) {
-	bytes := make([]byte, 32)
+	return randomHex(32)
+}
+
+func randomHex(n int) (string, error) {
+	bytes := make([]byte, n)
 	if _, err := rand.Read(bytes); err != nil {
 		return "", err
 	}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pseudoerr/auth-service/internal/keys"
+	"github.com/pseudoerr/auth-service/internal/lockout"
+	"github.com/pseudoerr/auth-service/internal/mailer"
+	"github.com/pseudoerr/auth-service/internal/missions"
+	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/pseudoerr/auth-service/internal/passwordpolicy"
+	"github.com/pseudoerr/auth-service/internal/postgres/postgrestest"
+	"github.com/pseudoerr/auth-service/internal/repository"
+	"github.com/pseudoerr/auth-service/internal/secretbox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPassword = "correct horse battery"
+
+var testClient = models.ClientInfo{UserAgent: "test", IPAddress: "10.0.0.1"}
+
+// newDBTestService returns a service backed by a migrated database. The
+// tests using it are skipped without TEST_DATABASE_URL.
+func newDBTestService(t *testing.T) *AuthService {
+	t.Helper()
+	db := postgrestest.New(t)
+	base := newTestService(t)
+
+	secrets, err := secretbox.New("test-mfa-key")
+	require.NoError(t, err)
+
+	const pepper = "test-pepper"
+	return NewAuthService(
+		repository.NewUserRepository(db), repository.NewTokenRepository(db, pepper),
+		repository.NewActionTokenRepository(db, pepper), repository.NewMFARepository(db, pepper),
+		repository.NewRoleRepository(db), repository.NewAuditRepository(db),
+		repository.NewAuthEventRepository(db, 24*time.Hour), repository.NewOAuthClientRepository(db, pepper),
+		repository.NewOAuthCodeRepository(db, pepper), repository.NewOAuthTokenRepository(db, pepper),
+		repository.NewOAuthDeviceRepository(db, pepper), repository.NewPersonalAccessTokenRepository(db, pepper),
+		repository.NewIdentityRepository(db), repository.NewOIDCStateRepository(db, pepper), nil,
+		secrets, base.keyring, base.denylist, base.loginGuard, base.hasher,
+		passwordpolicy.New(passwordpolicy.Policy{MinLength: 8, MaxLength: 128}, nil),
+		mailer.NewLogMailer(io.Discard), missions.NewClient("http://missions.invalid", time.Second),
+		AuthSettings{
+			AccessTokenTTL:       15 * time.Minute,
+			RefreshTokenTTL:      24 * time.Hour,
+			VerificationTokenTTL: time.Hour,
+			PasswordResetTTL:     time.Hour,
+			MagicLinkTTL:         time.Minute,
+			UnverifiedPolicy:     UnverifiedPolicyAllow,
+			AppBaseURL:           "https://app.example.com",
+			MFAIssuer:            "Test",
+			DeviceCodeTTL:        time.Minute,
+			OIDCStateTTL:         time.Minute,
+		})
+}
+
+func register(t *testing.T, s *AuthService, name string) *models.AuthResponse {
+	t.Helper()
+	response, err := s.Register(&models.RegisterRequest{
+		Email: name + "@example.com", Username: name, Password: testPassword,
+	}, testClient)
+	require.NoError(t, err)
+	return response
+}
+
+func parseClaims(t *testing.T, s *AuthService, token string) jwt.MapClaims {
+	t.Helper()
+	parsed, err := jwt.Parse(token, s.keyring.Keyfunc, jwt.WithValidMethods(keys.ValidMethods))
+	require.NoError(t, err)
+	return parsed.Claims.(jwt.MapClaims)
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	s := newDBTestService(t)
+	first := register(t, s, "ann")
+	sessionID := parseClaims(t, s, first.AccessToken)["sid"]
+
+	second, err := s.RefreshToken(&models.RefreshRequest{RefreshToken: first.RefreshToken}, testClient)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+	assert.Equal(t, sessionID, parseClaims(t, s, second.AccessToken)["sid"], "a rotated token stays in its session")
+
+	tests := []struct {
+		name         string
+		refreshToken string
+	}{
+		// Presenting the rotated token again ends the session
+		{"reused token", first.RefreshToken},
+		// including for whoever holds its successor
+		{"successor of the reused token", second.RefreshToken},
+		{"unknown token", "unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.RefreshToken(&models.RefreshRequest{RefreshToken: tt.refreshToken}, testClient)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	s := newDBTestService(t)
+	register(t, s, "ann")
+
+	login := func(password string) error {
+		_, _, err := s.Login(&models.LoginRequest{Email: "ann@example.com", Password: password}, testClient)
+		return err
+	}
+
+	require.NoError(t, login(testPassword))
+	for i := 0; i < testAccountPolicy.FreeAttempts-1; i++ {
+		require.Error(t, login("wrong"))
+		assert.NotErrorAs(t, login(testPassword), new(*lockout.LockedError), "a success clears the failures")
+	}
+
+	for i := 0; i < testAccountPolicy.FreeAttempts; i++ {
+		login("wrong")
+	}
+	var locked *lockout.LockedError
+	require.ErrorAs(t, login(testPassword), &locked, "the right password must not get through a lockout")
+	assert.Positive(t, locked.RetryAfter)
+
+	// Unknown accounts are locked out alike, so lockouts don't reveal which exist
+	for i := 0; i < testAccountPolicy.FreeAttempts; i++ {
+		_, _, err := s.Login(&models.LoginRequest{Email: "nobody@example.com", Password: "wrong"}, testClient)
+		require.Error(t, err)
+	}
+	_, _, err := s.Login(&models.LoginRequest{Email: "nobody@example.com", Password: "wrong"}, testClient)
+	assert.ErrorAs(t, err, &locked)
+}
+
+func TestAccessTokenCarriesRoles(t *testing.T) {
+	s := newDBTestService(t)
+	admin := register(t, s, "admin")
+	ann := register(t, s, "ann")
+
+	claims := parseClaims(t, s, ann.AccessToken)
+	assert.Equal(t, []interface{}{models.RoleLearner}, claims["roles"], "new accounts are learners")
+	assert.Equal(t, []interface{}{"missions:read"}, claims["permissions"])
+
+	tests := []struct {
+		name        string
+		roles       []string
+		permissions []interface{}
+	}{
+		{"author", []string{models.RoleAuthor}, []interface{}{"missions:read", "missions:write"}},
+		{"no roles", []string{}, []interface{}{}},
+		{"admin", []string{models.RoleAdmin}, []interface{}{"missions:read", "missions:write", "submissions:review", "users:manage"}},
+	}
+	refreshToken := ann.RefreshToken
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.SetUserRoles(Actor{UserID: admin.User.ID, Client: testClient}, ann.User.ID, tt.roles)
+			require.NoError(t, err)
+
+			// The new permissions apply from the next refresh on
+			refreshed, err := s.RefreshToken(&models.RefreshRequest{RefreshToken: refreshToken}, testClient)
+			require.NoError(t, err)
+			refreshToken = refreshed.RefreshToken
+
+			claims := parseClaims(t, s, refreshed.AccessToken)
+			assert.ElementsMatch(t, tt.permissions, claims["permissions"])
+			assert.ElementsMatch(t, tt.roles, refreshed.User.Roles)
+		})
+	}
+}
+
+func TestDeletedAccountTokens(t *testing.T) {
+	s := newDBTestService(t)
+	ann := register(t, s, "ann")
+	pat, err := s.CreatePersonalAccessToken(ann.User.ID, &models.CreatePersonalAccessTokenRequest{
+		Name: "ci", Scopes: []string{"missions:read"}, ExpiresInDays: 30,
+	}, testClient)
+	require.NoError(t, err)
+
+	require.NoError(t, s.DeleteAccount(ann.User.ID, testPassword, testClient))
+	// Even without the revocation cutoff, such as once it expired from Redis,
+	// the tokens die with the account
+	s.denylist = newTestService(t).denylist
+
+	for _, token := range []string{ann.AccessToken, pat.Token} {
+		introspection, err := s.Introspect(token)
+		require.NoError(t, err)
+		assert.False(t, introspection.Active)
+	}
+
+	_, err = s.userRepo.IsDisabled(ann.User.ID)
+	assert.ErrorIs(t, err, repository.ErrUserNotFound)
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pseudoerr/auth-service/internal/denylist"
+	"github.com/pseudoerr/auth-service/internal/hashing"
+	"github.com/pseudoerr/auth-service/internal/keys"
+	"github.com/pseudoerr/auth-service/internal/lockout"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testAccountPolicy locks an account out after two failed attempts
+var testAccountPolicy = lockout.Policy{FreeAttempts: 2, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+
+// newTestService returns a service with what the logic that runs without a
+// database needs: the keyring, the denylist, the lockout guard and a hasher
+func newTestService(t *testing.T) *AuthService {
+	t.Helper()
+	key, err := keys.GenerateEd25519()
+	require.NoError(t, err)
+	keyring := keys.NewKeyring()
+	keyring.Set(key, nil, nil)
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	hasher, err := hashing.NewBcrypt(4)
+	require.NoError(t, err)
+
+	ip := lockout.Policy{FreeAttempts: 100, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+	return &AuthService{
+		keyring:    keyring,
+		denylist:   denylist.NewRedisStore(client),
+		loginGuard: lockout.NewGuard(lockout.NewRedisStore(client), testAccountPolicy, ip),
+		hasher:     hasher,
+	}
+}
+
+func TestSignExpiringToken(t *testing.T) {
+	s := newTestService(t)
+
+	before := time.Now()
+	signed, err := s.signExpiringToken(jwt.MapClaims{"user_id": 7}, time.Minute)
+	require.NoError(t, err)
+
+	token, err := jwt.Parse(signed, s.keyring.Keyfunc, jwt.WithValidMethods(keys.ValidMethods))
+	require.NoError(t, err)
+	assert.Equal(t, s.keyring.Active().ID, token.Header["kid"])
+
+	claims := token.Claims.(jwt.MapClaims)
+	assert.NotEmpty(t, claims["jti"])
+	assert.Equal(t, float64(7), claims["user_id"])
+
+	issuedAt, ok := keys.IssuedAt(claims)
+	require.True(t, ok)
+	assert.False(t, issuedAt.Before(before.Truncate(time.Millisecond)))
+
+	expiresAt, err := claims.GetExpirationTime()
+	require.NoError(t, err)
+	assert.WithinDuration(t, before.Add(time.Minute), expiresAt.Time, 2*time.Second)
+
+	// Every token gets its own ID, so it can be revoked on its own
+	other, err := s.signExpiringToken(jwt.MapClaims{"user_id": 7}, time.Minute)
+	require.NoError(t, err)
+	otherToken, err := jwt.Parse(other, s.keyring.Keyfunc, jwt.WithValidMethods(keys.ValidMethods))
+	require.NoError(t, err)
+	assert.NotEqual(t, claims["jti"], otherToken.Claims.(jwt.MapClaims)["jti"])
+}
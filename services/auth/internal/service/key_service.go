@@ -0,0 +1,207 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/pseudoerr/auth-service/internal/keys"
+	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/pseudoerr/auth-service/internal/repository"
+	"github.com/pseudoerr/auth-service/internal/secretbox"
+)
+
+// KeyService keeps the in-memory keyring in sync with the signing keys
+// stored in the database, so every replica signs with the same key and a
+// rotation triggered anywhere is picked up everywhere.
+type KeyService struct {
+	keyRepo *repository.SigningKeyRepository
+	keyring *keys.Keyring
+	// secrets encrypts the private keys at rest
+	secrets      *secretbox.Box
+	overlap      time.Duration
+	publishDelay time.Duration
+}
+
+// NewKeyService creates a key service. overlap is how long a retired key is
+// still accepted for verification and must exceed the access token TTL.
+// publishDelay is how long a rotated key is published before it signs and
+// must exceed how long verifiers may cache the key set.
+func NewKeyService(keyRepo *repository.SigningKeyRepository, secrets *secretbox.Box, overlap, publishDelay time.Duration) *KeyService {
+	return &KeyService{
+		keyRepo:      keyRepo,
+		keyring:      keys.NewKeyring(),
+		secrets:      secrets,
+		overlap:      overlap,
+		publishDelay: publishDelay,
+	}
+}
+
+func (s *KeyService) Keyring() *keys.Keyring {
+	return s.keyring
+}
+
+// Bootstrap loads the keyring, storing the key returned by initial first if
+// no active key exists yet. Keys stored in plaintext before they were
+// encrypted at rest are encrypted.
+func (s *KeyService) Bootstrap(initial func() (*keys.SigningKey, error)) error {
+	stored, err := s.keyRepo.ListUsable()
+	if err != nil {
+		return err
+	}
+
+	if err := s.sealLegacyKeys(stored); err != nil {
+		return err
+	}
+
+	if !hasActiveKey(stored) {
+		key, err := initial()
+		if err != nil {
+			return fmt.Errorf("failed to create initial signing key: %w", err)
+		}
+		record, err := s.toSigningKeyRecord(key)
+		if err != nil {
+			return err
+		}
+		if err := s.keyRepo.CreateActive(record); err != nil && !errors.Is(err, repository.ErrActiveKeyExists) {
+			return err
+		}
+		slog.Info("Stored initial signing key", "kid", key.ID)
+	}
+
+	return s.Reload()
+}
+
+// Reload replaces the keyring with the keys currently stored in the database
+func (s *KeyService) Reload() error {
+	stored, err := s.keyRepo.ListUsable()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var active *keys.SigningKey
+	var pending *keys.PendingKey
+	var retired []keys.RetiredKey
+	for _, record := range stored {
+		key, err := s.parsePrivateKey(record)
+		if err != nil {
+			return err
+		}
+		switch {
+		case record.ActivatesAt.After(now):
+			pending = &keys.PendingKey{Key: key, ActivatesAt: record.ActivatesAt}
+		case record.RetiredAt == nil || record.RetiredAt.After(now):
+			// The previous key keeps signing until its successor activates
+			if active == nil {
+				active = key
+				continue
+			}
+			retired = append(retired, keys.RetiredKey{Key: key, ExpiresAt: *record.ExpiresAt})
+		default:
+			retired = append(retired, keys.RetiredKey{Key: key, ExpiresAt: *record.ExpiresAt})
+		}
+	}
+
+	if active == nil {
+		return fmt.Errorf("no active signing key found")
+	}
+
+	s.keyring.Set(active, pending, retired)
+	return nil
+}
+
+// Rotate generates a new Ed25519 key and publishes it, so verifiers fetch it
+// before any token is signed with it. It becomes the signing key after the
+// publish delay; the previous key then keeps verifying tokens for the
+// configured overlap.
+func (s *KeyService) Rotate() (*models.SigningKey, error) {
+	key, err := keys.GenerateEd25519()
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := s.toSigningKeyRecord(key)
+	if err != nil {
+		return nil, err
+	}
+
+	activatesAt := time.Now().Add(s.publishDelay)
+	if err := s.keyRepo.Rotate(record, activatesAt, activatesAt.Add(s.overlap)); err != nil {
+		return nil, err
+	}
+
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// sealLegacyKeys encrypts private keys stored in plaintext
+func (s *KeyService) sealLegacyKeys(stored []models.SigningKey) error {
+	for _, record := range stored {
+		if !isPlaintextKey(record.PrivateKey) {
+			continue
+		}
+		sealed, err := s.secrets.Seal(record.PrivateKey)
+		if err != nil {
+			return err
+		}
+		if err := s.keyRepo.UpdatePrivateKey(record.ID, sealed); err != nil {
+			return err
+		}
+		slog.Info("Encrypted plaintext signing key", "kid", record.KeyID)
+	}
+	return nil
+}
+
+func (s *KeyService) parsePrivateKey(record models.SigningKey) (*keys.SigningKey, error) {
+	pem := record.PrivateKey
+	// Another replica may not have encrypted a legacy key yet
+	if !isPlaintextKey(pem) {
+		var err error
+		pem, err = s.secrets.Open(record.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt signing key %s: %w", record.KeyID, err)
+		}
+	}
+
+	key, err := keys.ParsePrivateKey([]byte(pem))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key %s: %w", record.KeyID, err)
+	}
+	return key, nil
+}
+
+func (s *KeyService) toSigningKeyRecord(key *keys.SigningKey) (*models.SigningKey, error) {
+	pem, err := key.MarshalPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := s.secrets.Seal(string(pem))
+	if err != nil {
+		return nil, err
+	}
+	return &models.SigningKey{
+		KeyID:      key.ID,
+		Algorithm:  key.Method().Alg(),
+		PrivateKey: sealed,
+	}, nil
+}
+
+func hasActiveKey(stored []models.SigningKey) bool {
+	for _, record := range stored {
+		if record.RetiredAt == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// isPlaintextKey reports whether a stored key predates encryption at rest
+func isPlaintextKey(privateKey string) bool {
+	return strings.HasPrefix(privateKey, "-----BEGIN")
+}
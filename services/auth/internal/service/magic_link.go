@@ -0,0 +1,99 @@
+package service
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/pseudoerr/auth-service/internal/mailer"
+	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/pseudoerr/auth-service/internal/repository"
+)
+
+// RequestMagicLink emails a single-use sign-in link. Unknown and disabled
+// accounts are silently ignored so the endpoint can't be used to enumerate
+// accounts.
+func (s *AuthService) RequestMagicLink(email string, client models.ClientInfo) error {
+	user, err := s.userRepo.GetByEmail(email)
+	if err != nil || user.Disabled() {
+		return nil
+	}
+
+	token, err := s.issueActionToken(user, user.Email, models.PurposeMagicLink, s.settings.MagicLinkTTL)
+	if err != nil {
+		return err
+	}
+	s.recordEvent(models.AuthEventMagicLink, models.OutcomeSuccess, user.ID, client, nil)
+
+	return s.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Your sign-in link",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen the link below to sign in:\n\n%s/magic-link?token=%s\n\n"+
+			"The link works once and expires in %s. If you did not ask to sign in, you can ignore this email.",
+			user.Username, s.settings.AppBaseURL, token, s.settings.MagicLinkTTL),
+	})
+}
+
+// ConsumeMagicLink redeems a sign-in link like a successful password login:
+// accounts with two-factor authentication get a challenge instead of tokens.
+// Following the link proves ownership of the address, so it also verifies it.
+func (s *AuthService) ConsumeMagicLink(token string, client models.ClientInfo) (*models.AuthResponse, *models.MFAChallenge, error) {
+	actionToken, err := s.actionTokenRepo.Consume(models.PurposeMagicLink, token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err := s.userRepo.GetByID(actionToken.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user.Email != actionToken.Email {
+		return nil, nil, repository.ErrActionTokenInvalid
+	}
+
+	// A disabled account must not get its address verified either
+	if !user.Disabled() && !user.EmailVerified() {
+		if err := s.userRepo.MarkEmailVerified(user.ID, user.Email); err != nil {
+			slog.Error("Failed to mark email verified after magic link", "error", err, "user_id", user.ID)
+		} else {
+			now := time.Now()
+			user.EmailVerifiedAt = &now
+		}
+	}
+
+	return s.completeLogin(user, client, map[string]interface{}{"method": "magic_link"})
+}
+
+// completeLogin finishes a sign-in whose first factor succeeded, recording
+// details with the outcome. Accounts with two-factor authentication get a
+// challenge instead of tokens.
+func (s *AuthService) completeLogin(user *models.User, client models.ClientInfo,
+	details map[string]interface{}) (*models.AuthResponse, *models.MFAChallenge, error) {
+	if user.Disabled() {
+		details["reason"] = "disabled"
+		s.recordEvent(models.AuthEventLogin, models.OutcomeFailure, user.ID, client, details)
+		return nil, nil, ErrAccountDisabled
+	}
+
+	mfaEnabled, err := s.mfaEnabled(user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if mfaEnabled {
+		challenge, err := s.generateMFAChallenge(user)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate mfa challenge: %w", err)
+		}
+		details["mfa_required"] = true
+		s.recordEvent(models.AuthEventLogin, models.OutcomeSuccess, user.ID, client, details)
+		return nil, challenge, nil
+	}
+
+	authResponse, err := s.generateAuthResponse(user, client)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.recordEvent(models.AuthEventLogin, models.OutcomeSuccess, user.ID, client, details)
+	return authResponse, nil, nil
+}
@@ -0,0 +1,276 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pseudoerr/auth-service/internal/keys"
+	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/pseudoerr/auth-service/internal/repository"
+	"github.com/pseudoerr/auth-service/internal/totp"
+)
+
+// mfaChallengeTTL is how long a user has to enter their code after the password was accepted
+const mfaChallengeTTL = 5 * time.Minute
+
+// tokenUseMFAChallenge marks challenge tokens so they can't pass as access tokens
+const tokenUseMFAChallenge = "mfa_challenge"
+
+// recoveryCodeCount is the number of recovery codes handed out on enrollment
+const recoveryCodeCount = 10
+
+var (
+	ErrMFAAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrMFAInvalidCode      = errors.New("invalid two-factor authentication code")
+	ErrMFAChallengeInvalid = errors.New("invalid or expired two-factor challenge")
+	ErrInvalidPassword     = errors.New("invalid password")
+)
+
+// SetupMFA creates a new pending TOTP secret for the user. It replaces any
+// earlier enrollment that was never confirmed.
+func (s *AuthService) SetupMFA(userID int) (*models.MFASetupResponse, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := s.mfaRepo.GetByUserID(userID)
+	if err != nil && !errors.Is(err, repository.ErrMFANotFound) {
+		return nil, err
+	}
+	if existing != nil && existing.EnabledAt != nil {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := s.secrets.Seal(secret)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.mfaRepo.SavePending(userID, sealed); err != nil {
+		return nil, err
+	}
+
+	return &models.MFASetupResponse{
+		Secret:     secret,
+		OTPAuthURI: totp.URI(s.settings.MFAIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmMFA enables a pending enrollment once the user proves their
+// authenticator produces valid codes. The returned recovery codes are only
+// ever shown this once.
+func (s *AuthService) ConfirmMFA(userID int, code string) ([]string, error) {
+	mfa, err := s.mfaRepo.GetByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if mfa.EnabledAt != nil {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := s.secrets.Open(mfa.SecretEncrypted)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return nil, ErrMFAInvalidCode
+	}
+
+	recoveryCodes := make([]string, recoveryCodeCount)
+	for i := range recoveryCodes {
+		raw, err := randomHex(5)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		recoveryCodes[i] = raw[:5] + "-" + raw[5:]
+	}
+
+	if err := s.mfaRepo.Enable(userID, step, recoveryCodes); err != nil {
+		return nil, err
+	}
+
+	return recoveryCodes, nil
+}
+
+// DisableMFA removes the enrollment and all recovery codes. The password is
+// asked for again so a stolen access token alone can't turn 2FA off.
+func (s *AuthService) DisableMFA(userID int, password string, client models.ClientInfo) error {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkPassword(user, password, client); err != nil {
+		return err
+	}
+
+	if _, err := s.mfaRepo.GetByUserID(userID); err != nil {
+		return err
+	}
+
+	return s.mfaRepo.DeleteByUserID(userID)
+}
+
+// VerifyMFA completes a login that was answered with a challenge. Either a
+// current TOTP code or an unused recovery code is accepted. A challenge can
+// only be answered once, a wrong code means logging in again. Wrong codes
+// count towards the login lockout, during which a *lockout.LockedError is
+// returned.
+func (s *AuthService) VerifyMFA(req *models.MFAVerifyRequest, client models.ClientInfo) (*models.AuthResponse, error) {
+	userID, err := s.redeemMFAChallenge(req.MFAToken)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, ErrMFAChallengeInvalid
+	}
+	if user.Disabled() {
+		s.recordEvent(models.AuthEventLoginMFA, models.OutcomeFailure, user.ID, client,
+			map[string]interface{}{"reason": "disabled"})
+		return nil, ErrAccountDisabled
+	}
+	if err := s.loginGuard.Check(user.Email, client.IPAddress); err != nil {
+		s.recordEvent(models.AuthEventLoginMFA, models.OutcomeFailure, user.ID, client,
+			map[string]interface{}{"reason": "locked"})
+		return nil, err
+	}
+
+	if req.RecoveryCode != "" {
+		used, err := s.mfaRepo.UseRecoveryCode(user.ID, normalizeRecoveryCode(req.RecoveryCode))
+		if err != nil {
+			return nil, err
+		}
+		if !used {
+			return nil, s.mfaFailed(user, client, "invalid_recovery_code")
+		}
+		slog.Info("Recovery code used to sign in", "user_id", user.ID)
+		return s.mfaLoginSucceeded(user, client, "recovery_code")
+	}
+
+	mfa, err := s.mfaRepo.GetByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := s.secrets.Open(mfa.SecretEncrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	step, ok := totp.Validate(secret, req.Code, time.Now())
+	if !ok {
+		return nil, s.mfaFailed(user, client, "invalid_code")
+	}
+
+	// An intercepted code must not be usable a second time within its window
+	fresh, err := s.mfaRepo.UseStep(user.ID, step)
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		return nil, s.mfaFailed(user, client, "code_reused")
+	}
+
+	return s.mfaLoginSucceeded(user, client, "totp")
+}
+
+// mfaFailed records a wrong second factor like a failed login
+func (s *AuthService) mfaFailed(user *models.User, client models.ClientInfo, reason string) error {
+	s.recordEvent(models.AuthEventLoginMFA, models.OutcomeFailure, user.ID, client,
+		map[string]interface{}{"reason": reason})
+	if err := s.loginGuard.RecordFailure(user.Email, client.IPAddress); err != nil {
+		return err
+	}
+	return ErrMFAInvalidCode
+}
+
+func (s *AuthService) mfaLoginSucceeded(user *models.User, client models.ClientInfo, method string) (*models.AuthResponse, error) {
+	s.loginGuard.RecordSuccess(user.Email)
+
+	authResponse, err := s.generateAuthResponse(user, client)
+	if err != nil {
+		return nil, err
+	}
+
+	s.recordEvent(models.AuthEventLoginMFA, models.OutcomeSuccess, user.ID, client,
+		map[string]interface{}{"method": method})
+	return authResponse, nil
+}
+
+// mfaEnabled reports whether the user has a confirmed TOTP enrollment
+func (s *AuthService) mfaEnabled(userID int) (bool, error) {
+	mfa, err := s.mfaRepo.GetByUserID(userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrMFANotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return mfa.EnabledAt != nil, nil
+}
+
+// generateMFAChallenge signs a short-lived token proving the password step
+// succeeded. It is signed like an access token but marked with a different
+// token_use, so it is not accepted as one.
+func (s *AuthService) generateMFAChallenge(user *models.User) (*models.MFAChallenge, error) {
+	signed, err := s.signExpiringToken(jwt.MapClaims{
+		"token_use": tokenUseMFAChallenge,
+		"user_id":   user.ID,
+	}, mfaChallengeTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.MFAChallenge{
+		MFARequired: true,
+		MFAToken:    signed,
+		ExpiresIn:   int(mfaChallengeTTL.Seconds()),
+	}, nil
+}
+
+// redeemMFAChallenge validates a challenge token and marks it as used
+func (s *AuthService) redeemMFAChallenge(tokenString string) (int, error) {
+	token, err := jwt.Parse(tokenString, s.keyring.Keyfunc, jwt.WithValidMethods(keys.ValidMethods))
+	if err != nil || !token.Valid {
+		return 0, ErrMFAChallengeInvalid
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, ErrMFAChallengeInvalid
+	}
+	tokenUse, _ := claims["token_use"].(string)
+	jti, _ := claims["jti"].(string)
+	userID, _ := claims["user_id"].(float64)
+	expiresAt, _ := claims.GetExpirationTime()
+	if tokenUse != tokenUseMFAChallenge || jti == "" || userID == 0 || expiresAt == nil {
+		return 0, ErrMFAChallengeInvalid
+	}
+
+	revoked, err := s.denylist.IsTokenRevoked(jti)
+	if err != nil {
+		return 0, err
+	}
+	if revoked {
+		return 0, ErrMFAChallengeInvalid
+	}
+	if err := s.denylist.RevokeToken(jti, expiresAt.Time); err != nil {
+		return 0, err
+	}
+
+	return int(userID), nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.TrimSpace(code))
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pseudoerr/auth-service/internal/keys"
+	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedeemMFAChallenge(t *testing.T) {
+	s := newTestService(t)
+
+	challenge, err := s.generateMFAChallenge(&models.User{ID: 7})
+	require.NoError(t, err)
+	assert.True(t, challenge.MFARequired)
+	assert.Equal(t, int(mfaChallengeTTL.Seconds()), challenge.ExpiresIn)
+
+	userID, err := s.redeemMFAChallenge(challenge.MFAToken)
+	require.NoError(t, err)
+	assert.Equal(t, 7, userID)
+
+	// A challenge can't be replayed
+	_, err = s.redeemMFAChallenge(challenge.MFAToken)
+	assert.ErrorIs(t, err, ErrMFAChallengeInvalid)
+}
+
+func TestRedeemMFAChallengeRejectsOtherTokens(t *testing.T) {
+	other, err := keys.GenerateEd25519()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		token func(t *testing.T, s *AuthService) string
+	}{
+		{"not a token", func(t *testing.T, s *AuthService) string { return "not-a-jwt" }},
+		{"access token", func(t *testing.T, s *AuthService) string {
+			signed, err := s.signExpiringToken(jwt.MapClaims{"token_use": models.TokenUseAccess, "user_id": 7}, time.Minute)
+			require.NoError(t, err)
+			return signed
+		}},
+		{"expired", func(t *testing.T, s *AuthService) string {
+			signed, err := s.signExpiringToken(jwt.MapClaims{"token_use": tokenUseMFAChallenge, "user_id": 7}, -time.Minute)
+			require.NoError(t, err)
+			return signed
+		}},
+		{"no user", func(t *testing.T, s *AuthService) string {
+			signed, err := s.signExpiringToken(jwt.MapClaims{"token_use": tokenUseMFAChallenge}, time.Minute)
+			require.NoError(t, err)
+			return signed
+		}},
+		{"signed by an unknown key", func(t *testing.T, s *AuthService) string {
+			token := jwt.NewWithClaims(other.Method(), jwt.MapClaims{
+				"token_use": tokenUseMFAChallenge,
+				"user_id":   7,
+				"jti":       "abc",
+				"exp":       time.Now().Add(time.Minute).Unix(),
+			})
+			token.Header["kid"] = other.ID
+			signed, err := token.SignedString(other.PrivateKey)
+			require.NoError(t, err)
+			return signed
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t)
+			_, err := s.redeemMFAChallenge(tt.token(t, s))
+			assert.ErrorIs(t, err, ErrMFAChallengeInvalid)
+		})
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"abcd-efgh", "abcd-efgh"},
+		{"ABCD-EFGH", "abcd-efgh"},
+		{"  abcd-efgh\n", "abcd-efgh"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, normalizeRecoveryCode(tt.code), tt.code)
+	}
+}
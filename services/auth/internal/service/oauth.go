@@ -0,0 +1,258 @@
+package service
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pseudoerr/auth-service/internal/denylist"
+	"github.com/pseudoerr/auth-service/internal/keys"
+	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/pseudoerr/auth-service/internal/repository"
+)
+
+var ErrInvalidScope = errors.New("requested scope is not granted to the client")
+
+// AuthenticateClient checks the credentials of a service calling on its own
+// behalf. Wrong credentials yield repository.ErrInvalidClient.
+func (s *AuthService) AuthenticateClient(clientID, secret string) (*models.OAuthClient, error) {
+	return s.oauthClientRepo.Authenticate(clientID, secret)
+}
+
+// PublicClient returns a client that has no secret to authenticate with,
+// like an IDE plugin. Confidential clients yield repository.ErrInvalidClient
+// so they can't skip authentication.
+func (s *AuthService) PublicClient(clientID string) (*models.OAuthClient, error) {
+	client, err := s.oauthClientRepo.Get(clientID)
+	if err != nil {
+		return nil, err
+	}
+	if !client.Public {
+		return nil, repository.ErrInvalidClient
+	}
+	return client, nil
+}
+
+// IsClientActive reports whether a client exists and was not revoked
+func (s *AuthService) IsClientActive(clientID string) (bool, error) {
+	return s.oauthClientRepo.IsActive(clientID)
+}
+
+// Introspect reports whether an access token, personal access token, service
+// token or delegated token is currently usable. Unlike a signature check it
+// also accounts for revocation, disabled or deleted accounts and revoked clients. Any other token is
+// simply reported inactive; an error means the state could not be determined.
+func (s *AuthService) Introspect(tokenString string) (*models.IntrospectionResponse, error) {
+	inactive := &models.IntrospectionResponse{Active: false}
+
+	if strings.HasPrefix(tokenString, models.PersonalAccessTokenPrefix) {
+		return s.introspectPersonalAccessToken(tokenString)
+	}
+
+	token, err := jwt.Parse(tokenString, s.keyring.Keyfunc, jwt.WithValidMethods(keys.ValidMethods))
+	if err != nil || !token.Valid {
+		return inactive, nil
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return inactive, nil
+	}
+	tokenUse, _ := claims["token_use"].(string)
+	switch tokenUse {
+	case models.TokenUseAccess, models.TokenUseDelegated:
+		// Checked below
+	case models.TokenUseService:
+		return s.introspectServiceToken(claims)
+	default:
+		return inactive, nil
+	}
+
+	jti, _ := claims["jti"].(string)
+	userID, _ := claims["user_id"].(float64)
+	issuedAt, hasIssuedAt := keys.IssuedAt(claims)
+	expiresAt, _ := claims.GetExpirationTime()
+	if jti == "" || userID == 0 || !hasIssuedAt || expiresAt == nil {
+		return inactive, nil
+	}
+
+	revoked, err := denylist.IsRevoked(s.denylist, jti, int(userID), issuedAt)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return inactive, nil
+	}
+
+	disabled, err := s.userRepo.IsDisabled(int(userID))
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return inactive, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if disabled {
+		return inactive, nil
+	}
+
+	// A delegated token dies with the application it was issued to
+	clientID, _ := claims["client_id"].(string)
+	if tokenUse == models.TokenUseDelegated {
+		if clientID == "" {
+			return inactive, nil
+		}
+		active, err := s.oauthClientRepo.IsActive(clientID)
+		if err != nil {
+			return nil, err
+		}
+		if !active {
+			return inactive, nil
+		}
+	}
+
+	username, _ := claims["username"].(string)
+	return &models.IntrospectionResponse{
+		Active:    true,
+		TokenUse:  tokenUse,
+		ClientID:  clientID,
+		Scope:     strings.Join(claimStrings(claims, "permissions"), " "),
+		Username:  username,
+		TokenType: "Bearer",
+		Exp:       expiresAt.Unix(),
+		Iat:       issuedAt.Unix(),
+		Sub:       strconv.Itoa(int(userID)),
+		Jti:       jti,
+		Roles:     claimStrings(claims, "roles"),
+	}, nil
+}
+
+func (s *AuthService) introspectPersonalAccessToken(tokenString string) (*models.IntrospectionResponse, error) {
+	identity, err := s.AuthenticatePersonalAccessToken(tokenString)
+	if errors.Is(err, repository.ErrPersonalAccessTokenNotFound) || errors.Is(err, repository.ErrUserNotFound) {
+		return &models.IntrospectionResponse{Active: false}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	disabled, err := s.userRepo.IsDisabled(identity.UserID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return &models.IntrospectionResponse{Active: false}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if disabled {
+		return &models.IntrospectionResponse{Active: false}, nil
+	}
+
+	return &models.IntrospectionResponse{
+		Active:    true,
+		TokenUse:  models.TokenUsePersonal,
+		Scope:     strings.Join(identity.Permissions, " "),
+		Username:  identity.Username,
+		TokenType: "Bearer",
+		Exp:       identity.ExpiresAt.Unix(),
+		Iat:       identity.IssuedAt.Unix(),
+		Sub:       strconv.Itoa(identity.UserID),
+		Roles:     identity.Roles,
+	}, nil
+}
+
+func (s *AuthService) introspectServiceToken(claims jwt.MapClaims) (*models.IntrospectionResponse, error) {
+	inactive := &models.IntrospectionResponse{Active: false}
+
+	jti, _ := claims["jti"].(string)
+	clientID, _ := claims["client_id"].(string)
+	issuedAt, _ := claims.GetIssuedAt()
+	expiresAt, _ := claims.GetExpirationTime()
+	if jti == "" || clientID == "" || issuedAt == nil || expiresAt == nil {
+		return inactive, nil
+	}
+
+	revoked, err := s.denylist.IsTokenRevoked(jti)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return inactive, nil
+	}
+
+	active, err := s.oauthClientRepo.IsActive(clientID)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return inactive, nil
+	}
+
+	return &models.IntrospectionResponse{
+		Active:    true,
+		TokenUse:  models.TokenUseService,
+		ClientID:  clientID,
+		Scope:     strings.Join(claimStrings(claims, "permissions"), " "),
+		TokenType: "Bearer",
+		Exp:       expiresAt.Unix(),
+		Iat:       issuedAt.Unix(),
+		Sub:       clientID,
+		Jti:       jti,
+	}, nil
+}
+
+// IssueClientCredentialsToken issues a service token for a client acting on
+// its own behalf (RFC 6749 section 4.4). The token carries the requested
+// scopes, or all of the client's scopes if none were requested, as its
+// permissions; requesting one the client lacks yields ErrInvalidScope.
+func (s *AuthService) IssueClientCredentialsToken(client *models.OAuthClient, scope string) (*models.TokenResponse, error) {
+	scopes, ok := requestedScopes(client.Scopes, scope)
+	if !ok {
+		return nil, ErrInvalidScope
+	}
+
+	accessToken, err := s.signExpiringToken(jwt.MapClaims{
+		"token_use":   models.TokenUseService,
+		"sub":         client.ClientID,
+		"client_id":   client.ClientID,
+		"permissions": scopes,
+	}, s.settings.AccessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(s.settings.AccessTokenTTL.Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	}, nil
+}
+
+// requestedScopes resolves the scope parameter of a request against the
+// scopes that may be granted: an empty parameter asks for all of them, and
+// asking for any other scope fails
+func requestedScopes(allowed []string, scope string) ([]string, bool) {
+	requested := strings.Fields(scope)
+	if len(requested) == 0 {
+		return allowed, true
+	}
+
+	for _, scope := range requested {
+		if !slices.Contains(allowed, scope) {
+			return nil, false
+		}
+	}
+	return slices.Compact(slices.Sorted(slices.Values(requested))), true
+}
+
+// claimStrings reads a claim holding a list of strings
+func claimStrings(claims jwt.MapClaims, name string) []string {
+	values, _ := claims[name].([]interface{})
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if str, ok := value.(string); ok {
+			result = append(result, str)
+		}
+	}
+	return result
+}
@@ -0,0 +1,320 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/pseudoerr/auth-service/internal/oauth"
+	"github.com/pseudoerr/auth-service/internal/repository"
+)
+
+// authorizationCodeTTL is how long a client has to exchange a code for tokens
+const authorizationCodeTTL = time.Minute
+
+// ErrInvalidRedirectURI is returned for authorization requests whose redirect
+// URI is not registered for the client. Like an unknown client it must be
+// shown to the user rather than redirected.
+var ErrInvalidRedirectURI = errors.New("redirect URI is not registered for the client")
+
+// StartAuthorization checks an authorization request and returns the URL of
+// the consent screen, where the signed-in user decides on it. Problems with
+// the client or redirect URI are returned as repository.ErrInvalidClient or
+// ErrInvalidRedirectURI; any other problem is an *oauth.Error to be reported
+// at the redirect URI.
+func (s *AuthService) StartAuthorization(req *oauth.AuthorizeRequest) (string, error) {
+	if _, _, err := s.checkAuthorizeRequest(req); err != nil {
+		return "", err
+	}
+	return s.settings.AppBaseURL + "/oauth/consent?" + req.Values().Encode(), nil
+}
+
+// AuthorizationConsent describes an authorization request for the consent
+// screen. The scopes are those the user can actually grant.
+func (s *AuthService) AuthorizationConsent(userID int, req *oauth.AuthorizeRequest) (*models.AuthorizationConsent, error) {
+	client, scopes, err := s.checkAuthorizeRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes, err = s.grantableScopes(userID, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.AuthorizationConsent{
+		ClientID:    client.ClientID,
+		ClientName:  client.Name,
+		Scopes:      scopes,
+		RedirectURI: req.RedirectURI,
+	}, nil
+}
+
+// Authorize records the user's decision on an authorization request and
+// returns where to send the user agent: to the client's redirect URI with an
+// authorization code. Errors are returned as by StartAuthorization; a denied
+// request is an *oauth.Error with the access_denied code.
+func (s *AuthService) Authorize(userID int, decision *models.AuthorizeDecision, client models.ClientInfo) (string, error) {
+	oauthClient, scopes, err := s.checkAuthorizeRequest(&decision.AuthorizeRequest)
+	if err != nil {
+		return "", err
+	}
+	if !decision.Approve {
+		return "", oauth.NewError(oauth.ErrorAccessDenied, "The user denied the request")
+	}
+
+	scopes, err = s.grantableScopes(userID, scopes)
+	if err != nil {
+		return "", err
+	}
+
+	code, err := randomHex(32)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate authorization code: %w", err)
+	}
+	familyID, err := randomHex(16)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token family: %w", err)
+	}
+
+	err = s.oauthCodeRepo.Create(&models.AuthorizationCode{
+		Code:          code,
+		FamilyID:      familyID,
+		ClientID:      oauthClient.ClientID,
+		UserID:        userID,
+		RedirectURI:   decision.RedirectURI,
+		Scopes:        scopes,
+		CodeChallenge: decision.CodeChallenge,
+		ExpiresAt:     time.Now().Add(authorizationCodeTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	s.recordEvent(models.AuthEventOAuthAuthorize, models.OutcomeSuccess, userID, client,
+		map[string]interface{}{"client_id": oauthClient.ClientID, "scopes": scopes})
+	return oauth.RedirectWithCode(decision.RedirectURI, code, decision.State), nil
+}
+
+// checkAuthorizeRequest resolves the client of an authorization request and
+// the scopes it asks for
+func (s *AuthService) checkAuthorizeRequest(req *oauth.AuthorizeRequest) (*models.OAuthClient, []string, error) {
+	client, err := s.oauthClientRepo.Get(req.ClientID)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Exact match only, so a code can't be sent anywhere the client didn't register
+	if req.RedirectURI == "" || !slices.Contains(client.RedirectURIs, req.RedirectURI) {
+		return nil, nil, ErrInvalidRedirectURI
+	}
+
+	if oauthErr := req.Validate(); oauthErr != nil {
+		return nil, nil, oauthErr
+	}
+
+	scopes, ok := requestedScopes(client.Scopes, req.Scope)
+	if !ok || len(scopes) == 0 {
+		return nil, nil, oauth.NewError(oauth.ErrorInvalidScope, "Requested scope is not available to the client")
+	}
+
+	return client, scopes, nil
+}
+
+// grantableScopes narrows scopes to the user's permissions. A user can't
+// grant an application anything they can't do themselves.
+func (s *AuthService) grantableScopes(userID int, scopes []string) ([]string, error) {
+	_, permissions, err := s.roleRepo.GetUserAccess(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	held := heldScopes(scopes, permissions)
+	if len(held) == 0 {
+		return nil, oauth.NewError(oauth.ErrorInvalidScope, "The user holds none of the requested scopes")
+	}
+	return held, nil
+}
+
+// ExchangeAuthorizationCode redeems an authorization code for an access token
+// and a refresh token (RFC 6749 section 4.1.3). The code must have been
+// issued to the client for the same redirect URI, and the code verifier must
+// match its PKCE challenge. Any mismatch is an *oauth.Error with the
+// invalid_grant code.
+func (s *AuthService) ExchangeAuthorizationCode(client *models.OAuthClient, code, redirectURI, codeVerifier string,
+	clientInfo models.ClientInfo) (*models.TokenResponse, error) {
+	authCode, err := s.oauthCodeRepo.Consume(code)
+	if errors.Is(err, repository.ErrAuthorizationCodeReused) {
+		// A code presented twice may have been stolen, so the tokens it bought are revoked
+		if err := s.oauthTokenRepo.DeleteFamily(authCode.FamilyID); err != nil {
+			slog.Error("Failed to revoke tokens of a reused authorization code", "error", err, "client_id", client.ClientID)
+		}
+		s.recordEvent(models.AuthEventRefreshReuse, models.OutcomeFailure, authCode.UserID, clientInfo,
+			map[string]interface{}{"client_id": client.ClientID, "reason": "authorization_code_reused"})
+		return nil, oauth.NewError(oauth.ErrorInvalidGrant, "Authorization code was already used")
+	}
+	if errors.Is(err, repository.ErrAuthorizationCodeInvalid) {
+		return nil, oauth.NewError(oauth.ErrorInvalidGrant, "Authorization code is invalid or expired")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if authCode.ClientID != client.ClientID || authCode.RedirectURI != redirectURI {
+		return nil, oauth.NewError(oauth.ErrorInvalidGrant, "Authorization code was issued to another client or redirect URI")
+	}
+	if !oauth.VerifyCodeVerifier(authCode.CodeChallenge, codeVerifier) {
+		return nil, oauth.NewError(oauth.ErrorInvalidGrant, "code_verifier does not match the code challenge")
+	}
+
+	user, err := s.userRepo.GetByID(authCode.UserID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, oauth.NewError(oauth.ErrorInvalidGrant, "Authorization code is invalid or expired")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled() {
+		return nil, oauth.NewError(oauth.ErrorInvalidGrant, "Account is disabled")
+	}
+
+	return s.issueDelegatedTokens(user, client.ClientID, authCode.FamilyID, authCode.Scopes)
+}
+
+// issueDelegatedTokens starts a grant of the user to the client: the first
+// refresh token of the family and an access token
+func (s *AuthService) issueDelegatedTokens(user *models.User, clientID, familyID string, scopes []string) (*models.TokenResponse, error) {
+	refreshTokenString, err := s.generateRefreshToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	err = s.oauthTokenRepo.Create(&models.OAuthRefreshToken{
+		Token:     refreshTokenString,
+		FamilyID:  familyID,
+		ClientID:  clientID,
+		UserID:    user.ID,
+		Scopes:    scopes,
+		ExpiresAt: time.Now().Add(s.settings.RefreshTokenTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.delegatedTokenResponse(user, clientID, scopes, refreshTokenString)
+}
+
+// RefreshDelegatedToken rotates a refresh token of an application acting for
+// a user (RFC 6749 section 6). The optional scope narrows the new access
+// token, not the grant. Unknown, expired and reused tokens are an
+// *oauth.Error with the invalid_grant code; a reused token also revokes its
+// whole family.
+func (s *AuthService) RefreshDelegatedToken(client *models.OAuthClient, refreshToken, scope string,
+	clientInfo models.ClientInfo) (*models.TokenResponse, error) {
+	current, err := s.oauthTokenRepo.GetByToken(client.ClientID, refreshToken)
+	if err != nil && !errors.Is(err, repository.ErrRefreshTokenNotFound) {
+		return nil, err
+	}
+	scopes := []string(nil)
+	if current != nil {
+		var ok bool
+		if scopes, ok = requestedScopes(current.Scopes, scope); !ok {
+			return nil, oauth.NewError(oauth.ErrorInvalidScope, "Requested scope exceeds the grant")
+		}
+	}
+
+	newTokenString, err := s.generateRefreshToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	// Rotating also detects a token that was rotated before being presented again
+	rotated, err := s.oauthTokenRepo.Rotate(client.ClientID, refreshToken, &models.OAuthRefreshToken{
+		Token:     newTokenString,
+		ExpiresAt: time.Now().Add(s.settings.RefreshTokenTTL),
+	})
+	if errors.Is(err, repository.ErrRefreshTokenReused) {
+		slog.Warn("OAuth refresh token reuse detected, grant revoked", "user_id", rotated.UserID,
+			"client_id", client.ClientID, "family_id", rotated.FamilyID)
+		s.recordEvent(models.AuthEventRefreshReuse, models.OutcomeFailure, rotated.UserID, clientInfo,
+			map[string]interface{}{"client_id": client.ClientID})
+		return nil, oauth.NewError(oauth.ErrorInvalidGrant, "Refresh token is invalid or expired")
+	}
+	if errors.Is(err, repository.ErrRefreshTokenNotFound) {
+		return nil, oauth.NewError(oauth.ErrorInvalidGrant, "Refresh token is invalid or expired")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if scopes == nil {
+		scopes = rotated.Scopes
+	}
+
+	user, err := s.userRepo.GetByID(rotated.UserID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, oauth.NewError(oauth.ErrorInvalidGrant, "Refresh token is invalid or expired")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled() {
+		return nil, oauth.NewError(oauth.ErrorInvalidGrant, "Account is disabled")
+	}
+
+	return s.delegatedTokenResponse(user, client.ClientID, scopes, newTokenString)
+}
+
+// ListOAuthGrants returns the applications the user authorized
+func (s *AuthService) ListOAuthGrants(userID int) ([]models.OAuthGrant, error) {
+	return s.oauthTokenRepo.ListGrants(userID)
+}
+
+// RevokeOAuthGrant withdraws an application's access. Its refresh token stops
+// working at once, access tokens already issued expire on their own.
+// repository.ErrRefreshTokenNotFound is returned if the user has no such grant.
+func (s *AuthService) RevokeOAuthGrant(userID int, grantID string, client models.ClientInfo) error {
+	if err := s.oauthTokenRepo.DeleteGrant(userID, grantID); err != nil {
+		return err
+	}
+
+	s.recordEvent(models.AuthEventOAuthRevoke, models.OutcomeSuccess, userID, client,
+		map[string]interface{}{"grant_id": grantID})
+	return nil
+}
+
+// delegatedTokenResponse issues an access token acting for the user on
+// behalf of the client. Like a personal access token it only carries the
+// scopes the user still holds.
+func (s *AuthService) delegatedTokenResponse(user *models.User, clientID string, scopes []string,
+	refreshToken string) (*models.TokenResponse, error) {
+	_, permissions, err := s.roleRepo.GetUserAccess(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	granted := heldScopes(scopes, permissions)
+
+	accessToken, err := s.signExpiringToken(jwt.MapClaims{
+		"token_use":   models.TokenUseDelegated,
+		"sub":         strconv.Itoa(user.ID),
+		"user_id":     user.ID,
+		"client_id":   clientID,
+		"username":    user.Username,
+		"verified":    user.EmailVerified(),
+		"permissions": granted,
+	}, s.settings.AccessTokenTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate access token: %w", err)
+	}
+
+	return &models.TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.settings.AccessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(granted, " "),
+	}, nil
+}
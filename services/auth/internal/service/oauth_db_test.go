@@ -0,0 +1,255 @@
+package service
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/pseudoerr/auth-service/internal/oauth"
+	"github.com/pseudoerr/auth-service/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// RFC 7636 Appendix B
+const (
+	testVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+const testRedirectURI = "http://127.0.0.1:8765/callback"
+
+func registerClient(t *testing.T, s *AuthService, admin int, req *models.RegisterClientRequest) *models.RegisterClientResponse {
+	t.Helper()
+	client, err := s.RegisterClient(Actor{UserID: admin, Client: testClient}, req)
+	require.NoError(t, err)
+	return client
+}
+
+func requireOAuthError(t *testing.T, err error, code string) {
+	t.Helper()
+	var oauthErr *oauth.Error
+	require.ErrorAs(t, err, &oauthErr)
+	assert.Equal(t, code, oauthErr.Code)
+}
+
+func TestClientCredentialsGrant(t *testing.T) {
+	s := newDBTestService(t)
+	admin := register(t, s, "admin")
+	registered := registerClient(t, s, admin.User.ID, &models.RegisterClientRequest{
+		ClientID: "reporting", Name: "Reporting", Scopes: []string{"missions:read", "users:read"},
+	})
+
+	_, err := s.AuthenticateClient("reporting", "wrong")
+	assert.ErrorIs(t, err, repository.ErrInvalidClient)
+	client, err := s.AuthenticateClient("reporting", registered.ClientSecret)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		scope       string
+		permissions []interface{}
+		err         error
+	}{
+		{"all scopes by default", "", []interface{}{"missions:read", "users:read"}, nil},
+		{"narrowed", "users:read", []interface{}{"users:read"}, nil},
+		{"scope of another client", "missions:write", nil, ErrInvalidScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := s.IssueClientCredentialsToken(client, tt.scope)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Empty(t, response.RefreshToken, "clients acting for themselves get no refresh token")
+
+			claims := parseClaims(t, s, response.AccessToken)
+			assert.Equal(t, models.TokenUseService, claims["token_use"])
+			assert.Equal(t, "reporting", claims["client_id"])
+			assert.Nil(t, claims["user_id"])
+			assert.Equal(t, tt.permissions, claims["permissions"])
+		})
+	}
+}
+
+// authorize has the user approve a request of the client and returns the code
+func authorize(t *testing.T, s *AuthService, userID int, clientID, scope string) string {
+	t.Helper()
+	redirect, err := s.Authorize(userID, &models.AuthorizeDecision{
+		AuthorizeRequest: oauth.AuthorizeRequest{
+			ResponseType:        "code",
+			ClientID:            clientID,
+			RedirectURI:         testRedirectURI,
+			Scope:               scope,
+			State:               "xyz",
+			CodeChallenge:       testChallenge,
+			CodeChallengeMethod: oauth.CodeChallengeMethodS256,
+		},
+		Approve: true,
+	}, testClient)
+	require.NoError(t, err)
+
+	u, err := url.Parse(redirect)
+	require.NoError(t, err)
+	assert.Equal(t, "xyz", u.Query().Get("state"))
+	return u.Query().Get("code")
+}
+
+func TestAuthorizationCodeGrant(t *testing.T) {
+	s := newDBTestService(t)
+	admin := register(t, s, "admin")
+	ann := register(t, s, "ann")
+	registerClient(t, s, admin.User.ID, &models.RegisterClientRequest{
+		ClientID: "ide-plugin", Name: "IDE plugin", Scopes: []string{"missions:read", "missions:write"},
+		RedirectURIs: []string{testRedirectURI}, Public: true,
+	})
+	registerClient(t, s, admin.User.ID, &models.RegisterClientRequest{
+		ClientID: "other-plugin", Name: "Other plugin", Scopes: []string{"missions:read"},
+		RedirectURIs: []string{testRedirectURI}, Public: true,
+	})
+	client, err := s.PublicClient("ide-plugin")
+	require.NoError(t, err)
+	other, err := s.PublicClient("other-plugin")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		// exchange redeems a fresh code the user granted to ide-plugin
+		exchange func(code string) (*models.TokenResponse, error)
+		err      string
+	}{
+		{
+			name: "valid",
+			exchange: func(code string) (*models.TokenResponse, error) {
+				return s.ExchangeAuthorizationCode(client, code, testRedirectURI, testVerifier, testClient)
+			},
+		},
+		{
+			name: "wrong verifier",
+			exchange: func(code string) (*models.TokenResponse, error) {
+				return s.ExchangeAuthorizationCode(client, code, testRedirectURI, testVerifier[:42]+"x", testClient)
+			},
+			err: oauth.ErrorInvalidGrant,
+		},
+		{
+			name: "other redirect URI",
+			exchange: func(code string) (*models.TokenResponse, error) {
+				return s.ExchangeAuthorizationCode(client, code, "http://127.0.0.1:9999/callback", testVerifier, testClient)
+			},
+			err: oauth.ErrorInvalidGrant,
+		},
+		{
+			name: "other client",
+			exchange: func(code string) (*models.TokenResponse, error) {
+				return s.ExchangeAuthorizationCode(other, code, testRedirectURI, testVerifier, testClient)
+			},
+			err: oauth.ErrorInvalidGrant,
+		},
+		{
+			name: "unknown code",
+			exchange: func(code string) (*models.TokenResponse, error) {
+				return s.ExchangeAuthorizationCode(client, "unknown", testRedirectURI, testVerifier, testClient)
+			},
+			err: oauth.ErrorInvalidGrant,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Learners can only grant what they hold themselves
+			response, err := tt.exchange(authorize(t, s, ann.User.ID, "ide-plugin", ""))
+			if tt.err != "" {
+				requireOAuthError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "missions:read", response.Scope)
+			assert.NotEmpty(t, response.RefreshToken)
+
+			claims := parseClaims(t, s, response.AccessToken)
+			assert.Equal(t, models.TokenUseDelegated, claims["token_use"])
+			assert.Equal(t, "ide-plugin", claims["client_id"])
+			assert.Equal(t, float64(ann.User.ID), claims["user_id"])
+		})
+	}
+}
+
+func TestAuthorizationCodeReuseRevokesGrant(t *testing.T) {
+	s := newDBTestService(t)
+	admin := register(t, s, "admin")
+	ann := register(t, s, "ann")
+	registerClient(t, s, admin.User.ID, &models.RegisterClientRequest{
+		ClientID: "ide-plugin", Name: "IDE plugin", Scopes: []string{"missions:read"},
+		RedirectURIs: []string{testRedirectURI}, Public: true,
+	})
+	client, err := s.PublicClient("ide-plugin")
+	require.NoError(t, err)
+
+	code := authorize(t, s, ann.User.ID, "ide-plugin", "missions:read")
+	response, err := s.ExchangeAuthorizationCode(client, code, testRedirectURI, testVerifier, testClient)
+	require.NoError(t, err)
+
+	_, err = s.ExchangeAuthorizationCode(client, code, testRedirectURI, testVerifier, testClient)
+	requireOAuthError(t, err, oauth.ErrorInvalidGrant)
+
+	// The tokens the code was exchanged for may be in the wrong hands
+	_, err = s.RefreshDelegatedToken(client, response.RefreshToken, "", testClient)
+	requireOAuthError(t, err, oauth.ErrorInvalidGrant)
+}
+
+func TestRefreshDelegatedToken(t *testing.T) {
+	s := newDBTestService(t)
+	admin := register(t, s, "admin")
+	ann := register(t, s, "ann")
+	_, err := s.SetUserRoles(Actor{UserID: admin.User.ID, Client: testClient}, ann.User.ID, []string{models.RoleAuthor})
+	require.NoError(t, err)
+	registerClient(t, s, admin.User.ID, &models.RegisterClientRequest{
+		ClientID: "ide-plugin", Name: "IDE plugin", Scopes: []string{"missions:read", "missions:write"},
+		RedirectURIs: []string{testRedirectURI}, Public: true,
+	})
+	registerClient(t, s, admin.User.ID, &models.RegisterClientRequest{
+		ClientID: "other-plugin", Name: "Other plugin", Scopes: []string{"missions:read"},
+		RedirectURIs: []string{testRedirectURI}, Public: true,
+	})
+	client, err := s.PublicClient("ide-plugin")
+	require.NoError(t, err)
+	other, err := s.PublicClient("other-plugin")
+	require.NoError(t, err)
+
+	code := authorize(t, s, ann.User.ID, "ide-plugin", "")
+	first, err := s.ExchangeAuthorizationCode(client, code, testRedirectURI, testVerifier, testClient)
+	require.NoError(t, err)
+	assert.Equal(t, "missions:read missions:write", first.Scope)
+
+	tests := []struct {
+		name         string
+		client       *models.OAuthClient
+		refreshToken string
+		scope        string
+		wantScope    string
+		err          string
+	}{
+		{"token of another client", other, first.RefreshToken, "", "", oauth.ErrorInvalidGrant},
+		{"scope beyond the grant", client, first.RefreshToken, "users:manage", "", oauth.ErrorInvalidScope},
+		{"narrowed scope", client, first.RefreshToken, "missions:read", "missions:read", ""},
+		// A rotated token presented again revokes the grant
+		{"reused token", client, first.RefreshToken, "", "", oauth.ErrorInvalidGrant},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := s.RefreshDelegatedToken(tt.client, tt.refreshToken, tt.scope, testClient)
+			if tt.err != "" {
+				requireOAuthError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantScope, response.Scope)
+			assert.NotEqual(t, first.RefreshToken, response.RefreshToken)
+		})
+	}
+
+	grants, err := s.ListOAuthGrants(ann.User.ID)
+	require.NoError(t, err)
+	assert.Empty(t, grants, "the grant ends with the reuse")
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/pseudoerr/auth-service/internal/oauth"
+	"github.com/pseudoerr/auth-service/internal/repository"
+)
+
+// deviceCodeInterval is how many seconds a device waits between polls at first
+const deviceCodeInterval = 5
+
+// StartDeviceAuthorization begins the device authorization grant (RFC 8628
+// section 3.1) for a device like a CLI. The user approves the user code on
+// the verification page of the frontend while the device polls the token
+// endpoint with the device code. Scopes the client may not ask for are an
+// *oauth.Error with the invalid_scope code.
+func (s *AuthService) StartDeviceAuthorization(client *models.OAuthClient, scope string) (*models.DeviceAuthorizationResponse, error) {
+	scopes, ok := requestedScopes(client.Scopes, scope)
+	if !ok || len(scopes) == 0 {
+		return nil, oauth.NewError(oauth.ErrorInvalidScope, "Requested scope is not available to the client")
+	}
+
+	deviceCode, err := randomHex(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate device code: %w", err)
+	}
+
+	authorization := &models.DeviceAuthorization{
+		DeviceCode: deviceCode,
+		ClientID:   client.ClientID,
+		Scopes:     scopes,
+		Interval:   deviceCodeInterval,
+		ExpiresAt:  time.Now().Add(s.settings.DeviceCodeTTL),
+	}
+	// User codes are short, so one may collide with a pending authorization
+	for attempt := 0; ; attempt++ {
+		authorization.UserCode, err = oauth.NewUserCode()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate user code: %w", err)
+		}
+		err = s.oauthDeviceRepo.Create(authorization)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, repository.ErrUserCodeTaken) || attempt == 2 {
+			return nil, err
+		}
+	}
+
+	verificationURI := s.settings.AppBaseURL + "/device"
+	return &models.DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                authorization.UserCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?" + url.Values{"user_code": {authorization.UserCode}}.Encode(),
+		ExpiresIn:               int(s.settings.DeviceCodeTTL.Seconds()),
+		Interval:                authorization.Interval,
+	}, nil
+}
+
+// DeviceConsent describes the device authorization with the user code for
+// the verification page. The scopes are those the user can actually grant.
+// Unknown, decided and expired user codes yield repository.ErrDeviceCodeInvalid.
+func (s *AuthService) DeviceConsent(userID int, userCode string) (*models.AuthorizationConsent, error) {
+	authorization, client, err := s.pendingDeviceAuthorization(userCode)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes, err := s.grantableScopes(userID, authorization.Scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.AuthorizationConsent{
+		ClientID:   client.ClientID,
+		ClientName: client.Name,
+		Scopes:     scopes,
+	}, nil
+}
+
+// DecideDeviceAuthorization records the user's decision on the verification
+// page. The device learns of it with its next poll.
+func (s *AuthService) DecideDeviceAuthorization(userID int, decision *models.DeviceDecision, client models.ClientInfo) error {
+	authorization, oauthClient, err := s.pendingDeviceAuthorization(decision.UserCode)
+	if err != nil {
+		return err
+	}
+
+	if !decision.Approve {
+		return s.oauthDeviceRepo.Decide(authorization.ID, userID, models.DeviceAuthorizationDenied, authorization.Scopes)
+	}
+
+	scopes, err := s.grantableScopes(userID, authorization.Scopes)
+	if err != nil {
+		return err
+	}
+	if err := s.oauthDeviceRepo.Decide(authorization.ID, userID, models.DeviceAuthorizationApproved, scopes); err != nil {
+		return err
+	}
+
+	s.recordEvent(models.AuthEventOAuthAuthorize, models.OutcomeSuccess, userID, client,
+		map[string]interface{}{"client_id": oauthClient.ClientID, "scopes": scopes, "method": "device"})
+	return nil
+}
+
+func (s *AuthService) pendingDeviceAuthorization(userCode string) (*models.DeviceAuthorization, *models.OAuthClient, error) {
+	userCode = oauth.NormalizeUserCode(userCode)
+	if userCode == "" {
+		return nil, nil, repository.ErrDeviceCodeInvalid
+	}
+
+	authorization, err := s.oauthDeviceRepo.GetPendingByUserCode(userCode)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// A revoked client's authorizations are worthless
+	client, err := s.oauthClientRepo.Get(authorization.ClientID)
+	if errors.Is(err, repository.ErrInvalidClient) {
+		return nil, nil, repository.ErrDeviceCodeInvalid
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return authorization, client, nil
+}
+
+// ExchangeDeviceCode answers a device polling the token endpoint (RFC 8628
+// sections 3.4 and 3.5). Until the user decided it gets an *oauth.Error with
+// the authorization_pending code, or slow_down when it polls too often. Once
+// the user approved, the device gets an access token and a refresh token,
+// like an application authorized with the authorization code grant.
+func (s *AuthService) ExchangeDeviceCode(client *models.OAuthClient, deviceCode string,
+	clientInfo models.ClientInfo) (*models.TokenResponse, error) {
+	authorization, tooSoon, err := s.oauthDeviceRepo.Poll(client.ClientID, deviceCode)
+	if errors.Is(err, repository.ErrDeviceCodeInvalid) {
+		return nil, oauth.NewError(oauth.ErrorInvalidGrant, "Device code is invalid")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(authorization.ExpiresAt) {
+		return nil, oauth.NewError(oauth.ErrorExpiredToken, "Device code has expired, start over")
+	}
+
+	switch authorization.Status {
+	case models.DeviceAuthorizationPending:
+		if tooSoon {
+			return nil, oauth.NewError(oauth.ErrorSlowDown, "Polling too often, wait longer between requests")
+		}
+		return nil, oauth.NewError(oauth.ErrorAuthorizationPending, "The user has not decided yet")
+	case models.DeviceAuthorizationDenied:
+		if _, err := s.oauthDeviceRepo.Delete(authorization.ID); err != nil {
+			slog.Error("Failed to delete denied device authorization", "error", err, "client_id", client.ClientID)
+		}
+		return nil, oauth.NewError(oauth.ErrorAccessDenied, "The user denied the request")
+	}
+
+	// Deleting the authorization redeems it, so concurrent polls get tokens only once
+	redeemed, err := s.oauthDeviceRepo.Delete(authorization.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !redeemed || authorization.UserID == nil {
+		return nil, oauth.NewError(oauth.ErrorInvalidGrant, "Device code is invalid")
+	}
+
+	user, err := s.userRepo.GetByID(*authorization.UserID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, oauth.NewError(oauth.ErrorInvalidGrant, "Device code is invalid")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled() {
+		return nil, oauth.NewError(oauth.ErrorInvalidGrant, "Account is disabled")
+	}
+
+	familyID, err := randomHex(16)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token family: %w", err)
+	}
+
+	slog.Info("Device authorization redeemed", "user_id", user.ID, "client_id", client.ClientID)
+	return s.issueDelegatedTokens(user, client.ClientID, familyID, authorization.Scopes)
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestedScopes(t *testing.T) {
+	allowed := []string{"missions:read", "missions:write"}
+
+	tests := []struct {
+		name   string
+		scope  string
+		scopes []string
+		ok     bool
+	}{
+		{"everything allowed by default", "", allowed, true},
+		{"subset", "missions:read", []string{"missions:read"}, true},
+		{"sorted and deduplicated", "missions:write missions:read missions:write", allowed, true},
+		{"extra whitespace", "  missions:read\tmissions:write ", allowed, true},
+		{"not allowed", "missions:read users:manage", nil, false},
+		{"prefix of an allowed scope", "missions", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scopes, ok := requestedScopes(allowed, tt.scope)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.scopes, scopes)
+		})
+	}
+}
+
+// Delegated tokens and personal access tokens only carry the scopes the user
+// still holds through their roles
+func TestHeldScopes(t *testing.T) {
+	tests := []struct {
+		name        string
+		scopes      []string
+		permissions []string
+		held        []string
+	}{
+		{"all held", []string{"missions:read", "missions:write"}, []string{"missions:read", "missions:write", "users:manage"},
+			[]string{"missions:read", "missions:write"}},
+		{"role lost", []string{"missions:read", "users:manage"}, []string{"missions:read"}, []string{"missions:read"}},
+		{"no permissions", []string{"missions:read"}, nil, []string{}},
+		{"no scopes", nil, []string{"missions:read"}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.held, heldScopes(tt.scopes, tt.permissions))
+		})
+	}
+}
@@ -0,0 +1,319 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pseudoerr/auth-service/internal/models"
+	"github.com/pseudoerr/auth-service/internal/oidc"
+	"github.com/pseudoerr/auth-service/internal/redact"
+	"github.com/pseudoerr/auth-service/internal/repository"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown identity provider")
+	// ErrIdentityConflict means a provider identity matches an existing account
+	// by email, but can't be linked to it without the account owner signing in
+	ErrIdentityConflict = errors.New("an account with this email already exists, sign in and link the provider from the account settings")
+	ErrIdentityNoEmail  = errors.New("identity provider did not share an email address")
+)
+
+// OIDCProviders returns the names of the configured sign-in providers
+func (s *AuthService) OIDCProviders() []string {
+	names := make([]string, 0, len(s.oidcProviders))
+	for name := range s.oidcProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// StartOIDCLogin returns where to send the user to sign in with the provider
+func (s *AuthService) StartOIDCLogin(ctx context.Context, providerName string) (*models.OIDCAuthorization, error) {
+	return s.startOIDC(ctx, providerName, nil)
+}
+
+// StartIdentityLink returns where to send the user to link their identity at
+// the provider to their account
+func (s *AuthService) StartIdentityLink(ctx context.Context, userID int, providerName string) (*models.OIDCAuthorization, error) {
+	return s.startOIDC(ctx, providerName, &userID)
+}
+
+func (s *AuthService) startOIDC(ctx context.Context, providerName string, userID *int) (*models.OIDCAuthorization, error) {
+	provider, ok := s.oidcProviders[providerName]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	state, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	codeVerifier, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	// Ties the sign-in to the browser starting it, so nobody can have another
+	// browser complete a sign-in they started
+	binding, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+
+	authURL, err := provider.AuthCodeURL(ctx, state, nonce, codeVerifier)
+	if err != nil {
+		return nil, err
+	}
+
+	oidcState := &models.OIDCState{
+		State:        state,
+		Binding:      binding,
+		Provider:     providerName,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		UserID:       userID,
+		ExpiresAt:    time.Now().Add(s.settings.OIDCStateTTL),
+	}
+	if err := s.oidcStateRepo.Create(oidcState); err != nil {
+		return nil, err
+	}
+
+	return &models.OIDCAuthorization{AuthorizationURL: authURL, Binding: binding}, nil
+}
+
+// exchangeOIDC redeems the state and code the provider sent the user back
+// with. The state must have been started by the same browser and for the
+// same purpose: signing in when userID is nil, linking to that user's
+// account otherwise.
+func (s *AuthService) exchangeOIDC(ctx context.Context, providerName string, req *models.OIDCCallbackRequest, userID *int) (*oidc.Claims, error) {
+	provider, ok := s.oidcProviders[providerName]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	state, err := s.oidcStateRepo.Consume(providerName, req.State, req.Binding)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case userID == nil && state.UserID != nil,
+		userID != nil && (state.UserID == nil || *state.UserID != *userID):
+		return nil, repository.ErrOIDCStateInvalid
+	}
+
+	return provider.Exchange(ctx, req.Code, state.CodeVerifier, state.Nonce)
+}
+
+// CompleteOIDCLogin signs the user in with the identity the provider vouched
+// for. An unknown identity signs in the account with the same verified email
+// address, linking the identity to it, or creates a passwordless account.
+// Accounts with two-factor authentication get a challenge instead of tokens.
+func (s *AuthService) CompleteOIDCLogin(ctx context.Context, providerName string, req *models.OIDCCallbackRequest,
+	client models.ClientInfo) (*models.AuthResponse, *models.MFAChallenge, error) {
+	details := map[string]interface{}{"method": "oidc", "provider": providerName}
+
+	claims, err := s.exchangeOIDC(ctx, providerName, req, nil)
+	if err != nil {
+		if !errors.Is(err, ErrUnknownProvider) {
+			details["reason"] = "invalid_callback"
+			s.recordEvent(models.AuthEventLogin, models.OutcomeFailure, 0, client, details)
+		}
+		return nil, nil, err
+	}
+
+	user, err := s.oidcUser(providerName, claims, client)
+	if errors.Is(err, ErrIdentityConflict) {
+		details["reason"] = "identity_conflict"
+		details["email"] = redact.Email(claims.Email)
+		s.recordEvent(models.AuthEventLogin, models.OutcomeFailure, 0, client, details)
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !user.Disabled() && !s.mayIssueTokens(user) {
+		details["reason"] = "email_not_verified"
+		s.recordEvent(models.AuthEventLogin, models.OutcomeFailure, user.ID, client, details)
+		return nil, nil, ErrEmailNotVerified
+	}
+
+	return s.completeLogin(user, client, details)
+}
+
+// oidcUser finds or creates the account an identity signs in
+func (s *AuthService) oidcUser(providerName string, claims *oidc.Claims, client models.ClientInfo) (*models.User, error) {
+	identity, err := s.identityRepo.GetBySubject(providerName, claims.Subject)
+	if err == nil {
+		if err := s.identityRepo.RecordUse(identity.ID, claims.Email); err != nil {
+			slog.Error("Failed to record identity use", "error", err, "identity_id", identity.ID)
+		}
+		return s.userRepo.GetByID(identity.UserID)
+	}
+	if !errors.Is(err, repository.ErrIdentityNotFound) {
+		return nil, err
+	}
+
+	// Without a verified address the identity can't be matched or trusted
+	// to own the account's email
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, ErrIdentityNoEmail
+	}
+
+	user, err := s.userRepo.GetByEmail(claims.Email)
+	switch {
+	case err == nil:
+		// Linking to an account whose address nobody proved would hand it to
+		// whoever registered the address at the provider
+		if !user.EmailVerified() {
+			return nil, ErrIdentityConflict
+		}
+	case errors.Is(err, repository.ErrUserNotFound):
+		user, err = s.createOIDCUser(providerName, claims, client)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	if err := s.linkIdentity(user.ID, providerName, claims, client); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// createOIDCUser creates a passwordless account for an identity, named after
+// the provider's username, display name or email address
+func (s *AuthService) createOIDCUser(providerName string, claims *oidc.Claims, client models.ClientInfo) (*models.User, error) {
+	base := oidcUsername(claims)
+
+	var user *models.User
+	for attempt := 0; ; attempt++ {
+		username := base
+		if attempt > 0 {
+			suffix, err := randomHex(3)
+			if err != nil {
+				return nil, err
+			}
+			username = base + "-" + suffix
+		}
+
+		user = &models.User{Email: claims.Email, Username: username}
+		err := s.userRepo.Create(user)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, repository.ErrUsernameTaken) || attempt == 4 {
+			return nil, fmt.Errorf("failed to create user: %w", err)
+		}
+	}
+	s.recordEvent(models.AuthEventRegister, models.OutcomeSuccess, user.ID, client,
+		map[string]interface{}{"method": "oidc", "provider": providerName})
+
+	if err := s.roleRepo.AssignRole(user.ID, models.RoleLearner); err != nil {
+		return nil, fmt.Errorf("failed to assign default role: %w", err)
+	}
+
+	// The provider vouched for the address
+	if err := s.userRepo.MarkEmailVerified(user.ID, user.Email); err != nil {
+		slog.Error("Failed to mark email verified after oidc sign-up", "error", err, "user_id", user.ID)
+	} else {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+
+	return user, nil
+}
+
+// oidcUsername derives a username of 3 to 43 characters, leaving room for
+// the suffix added when it is taken
+func oidcUsername(claims *oidc.Claims) string {
+	for _, candidate := range []string{claims.PreferredUsername, claims.Name, strings.Split(claims.Email, "@")[0]} {
+		var b strings.Builder
+		for _, r := range strings.ToLower(candidate) {
+			switch {
+			case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '-', r == '.':
+				b.WriteRune(r)
+			case r == ' ':
+				b.WriteRune('_')
+			}
+		}
+		username := b.String()
+		if len(username) > 43 {
+			username = username[:43]
+		}
+		if len(username) >= 3 {
+			return username
+		}
+	}
+	return "user"
+}
+
+// LinkIdentity links the identity the provider vouched for to the user's
+// account. The sign-in must have been started by the same user.
+func (s *AuthService) LinkIdentity(ctx context.Context, userID int, providerName string, req *models.OIDCCallbackRequest,
+	client models.ClientInfo) (*models.UserIdentity, error) {
+	claims, err := s.exchangeOIDC(ctx, providerName, req, &userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.linkIdentity(userID, providerName, claims, client); err != nil {
+		reason := ""
+		switch {
+		case errors.Is(err, repository.ErrIdentityTaken):
+			reason = "identity_taken"
+		case errors.Is(err, repository.ErrProviderLinked):
+			reason = "provider_linked"
+		}
+		if reason != "" {
+			s.recordEvent(models.AuthEventIdentityLink, models.OutcomeFailure, userID, client,
+				map[string]interface{}{"provider": providerName, "reason": reason})
+		}
+		return nil, err
+	}
+
+	return s.identityRepo.GetBySubject(providerName, claims.Subject)
+}
+
+func (s *AuthService) linkIdentity(userID int, providerName string, claims *oidc.Claims, client models.ClientInfo) error {
+	identity := &models.UserIdentity{
+		UserID:   userID,
+		Provider: providerName,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+	if err := s.identityRepo.Create(identity); err != nil {
+		return err
+	}
+
+	s.recordEvent(models.AuthEventIdentityLink, models.OutcomeSuccess, userID, client,
+		map[string]interface{}{"provider": providerName, "identity_id": identity.ID})
+	return nil
+}
+
+// ListIdentities returns the provider identities linked to the user's account
+func (s *AuthService) ListIdentities(userID int) ([]models.UserIdentity, error) {
+	return s.identityRepo.ListByUserID(userID)
+}
+
+// UnlinkIdentity removes one of the user's linked identities
+func (s *AuthService) UnlinkIdentity(userID, identityID int, client models.ClientInfo) error {
+	if err := s.identityRepo.Delete(userID, identityID); err != nil {
+		return err
+	}
+
+	s.recordEvent(models.AuthEventIdentityUnlink, models.OutcomeSuccess, userID, client,
+		map[string]interface{}{"identity_id": identityID})
+	return nil
+}
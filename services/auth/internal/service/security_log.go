@@ -0,0 +1,76 @@
+package service
+
+import (
+	"encoding/json"
+	"log/slog"
+
+	"github.com/pseudoerr/auth-service/internal/models"
+)
+
+// recordEvent appends an event about the user to the security log; a userID
+// of 0 means the account is unknown. The event describes something that
+// already happened, so a failure to record it is logged and not returned.
+// Details must not hold secrets, and emails go through redact.Email.
+func (s *AuthService) recordEvent(eventType, outcome string, userID int, client models.ClientInfo, details map[string]interface{}) {
+	event := &models.AuthEvent{
+		Type:      eventType,
+		Outcome:   outcome,
+		IPAddress: client.IPAddress,
+		UserAgent: client.UserAgent,
+		Details:   encodeDetails(details),
+	}
+	if userID != 0 {
+		event.UserID = &userID
+	}
+
+	s.writeEvent(event)
+}
+
+// recordAdminEvent appends an administrative action on the user to the security log
+func (s *AuthService) recordAdminEvent(actor Actor, action string, targetUserID int, details map[string]interface{}) {
+	s.writeEvent(&models.AuthEvent{
+		Type:      models.AuthEventAdminPrefix + action,
+		Outcome:   models.OutcomeSuccess,
+		UserID:    &targetUserID,
+		ActorID:   &actor.UserID,
+		IPAddress: actor.Client.IPAddress,
+		UserAgent: actor.Client.UserAgent,
+		Details:   encodeDetails(details),
+	})
+}
+
+func (s *AuthService) writeEvent(event *models.AuthEvent) {
+	if err := s.authEventRepo.Create(event); err != nil {
+		slog.Error("Failed to write auth event", "error", err, "type", event.Type)
+	}
+}
+
+func encodeDetails(details map[string]interface{}) json.RawMessage {
+	if details == nil {
+		return nil
+	}
+	payload, err := json.Marshal(details)
+	if err != nil {
+		slog.Error("Failed to encode event details", "error", err)
+		return nil
+	}
+	return payload
+}
+
+// ListActivity returns a page of the security events of the user, newest first
+func (s *AuthService) ListActivity(userID, page, perPage int) (*models.AuthEventList, error) {
+	return s.ListAuthEvents(models.AuthEventFilter{UserID: userID}, page, perPage)
+}
+
+// ListAuthEvents returns a page of the security events matching filter, newest first
+func (s *AuthService) ListAuthEvents(filter models.AuthEventFilter, page, perPage int) (*models.AuthEventList, error) {
+	events, total, err := s.authEventRepo.List(filter, perPage, (page-1)*perPage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.AuthEventList{
+		Events:     events,
+		Pagination: models.Pagination{Total: total, Page: page, PerPage: perPage},
+	}, nil
+}
@@ -0,0 +1,72 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHOTPMatchesRFC4226(t *testing.T) {
+	// Test values from RFC 4226, appendix D
+	key := []byte("12345678901234567890")
+	expected := []string{
+		"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489",
+	}
+
+	for counter, code := range expected {
+		assert.Equal(t, code, hotp(key, int64(counter), 6), "counter %d", counter)
+	}
+}
+
+func TestTOTPMatchesRFC6238(t *testing.T) {
+	// SHA1 test values from RFC 6238, appendix B
+	key := []byte("12345678901234567890")
+	tests := map[int64]string{
+		59:         "94287082",
+		1111111109: "07081804",
+		1234567890: "89005924",
+		2000000000: "69279037",
+	}
+
+	for unix, code := range tests {
+		assert.Equal(t, code, hotp(key, unix/30, 8), "time %d", unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1234567890, 0)
+	code := hotp([]byte("12345678901234567890"), now.Unix()/30, Digits)
+
+	step, ok := Validate(secret, code, now)
+	assert.True(t, ok)
+	assert.Equal(t, now.Unix()/30, step)
+
+	_, ok = Validate(secret, code, now.Add(Period))
+	assert.True(t, ok, "codes from the previous step are accepted")
+
+	_, ok = Validate(secret, code, now.Add(3*Period))
+	assert.False(t, ok, "codes outside the skew window are rejected")
+
+	_, ok = Validate(secret, "000000", now)
+	assert.False(t, ok)
+
+	_, ok = Validate(secret, code+"1", now)
+	assert.False(t, ok)
+}
+
+func TestGenerateSecretAndURI(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err)
+	assert.Len(t, secret, 32)
+
+	uri := URI("CodeBase", "user@example.com", secret)
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/CodeBase:user@example.com?"))
+	assert.Contains(t, uri, "secret="+secret)
+	assert.Contains(t, uri, "issuer=CodeBase")
+}
@@ -2,8 +2,8 @@ package validation
 
 import (
 	"fmt"
+	"reflect"
 	"strings"
-	"unicode"
 
 	"github.com/go-playground/validator/v10"
 )
@@ -13,57 +13,86 @@ var validate *validator.Validate
 func init() {
 	validate = validator.New()
 
-	// Register custom validators
-	validate.RegisterValidation("password", validatePassword)
+	// Report fields by the name clients send them as
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "" || name == "-" {
+			return field.Name
+		}
+		return name
+	})
+}
+
+// FieldError describes why a single request field was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error is returned when a request fails validation. Its message joins the
+// messages of all field errors.
+type Error struct {
+	Fields []FieldError
+}
+
+func (e *Error) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = field.Message
+	}
+	return strings.Join(messages, ", ")
+}
+
+// NewError builds an *Error from field errors, or returns nil if there are none
+func NewError(fields ...FieldError) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	return &Error{Fields: fields}
 }
 
 func ValidateStruct(s interface{}) error {
 	if err := validate.Struct(s); err != nil {
 		// Format validation errors
-		var errors []string
+		var fields []FieldError
 		for _, err := range err.(validator.ValidationErrors) {
-			errors = append(errors, formatValidationError(err))
+			fields = append(fields, FieldError{
+				Field:   err.Field(),
+				Code:    err.Tag(),
+				Message: formatValidationError(err),
+			})
 		}
-		return fmt.Errorf(strings.Join(errors, ", "))
+		return NewError(fields...)
 	}
 	return nil
 }
 
 func formatValidationError(err validator.FieldError) string {
 	switch err.Tag() {
-	case "required":
+	case "required", "required_unless":
 		return fmt.Sprintf("%s is required", err.Field())
+	case "excluded_if":
+		return fmt.Sprintf("%s must not be set", err.Field())
 	case "email":
 		return fmt.Sprintf("%s must be a valid email", err.Field())
 	case "min":
-		return fmt.Sprintf("%s must be at least %s characters", err.Field(), err.Param())
+		return fmt.Sprintf("%s must be at least %s%s", err.Field(), err.Param(), unit(err.Kind()))
 	case "max":
-		return fmt.Sprintf("%s must be at most %s characters", err.Field(), err.Param())
-	case "password":
-		return fmt.Sprintf("%s must contain at least 8 characters with uppercase, lowercase, and number", err.Field())
+		return fmt.Sprintf("%s must be at most %s%s", err.Field(), err.Param(), unit(err.Kind()))
 	default:
 		return fmt.Sprintf("%s is invalid", err.Field())
 	}
 }
 
-func validatePassword(fl validator.FieldLevel) bool {
-	password := fl.Field().String()
-
-	if len(password) < 8 {
-		return false
-	}
-
-	var hasUpper, hasLower, hasNumber bool
-	for _, char := range password {
-		switch {
-		case unicode.IsUpper(char):
-			hasUpper = true
-		case unicode.IsLower(char):
-			hasLower = true
-		case unicode.IsNumber(char):
-			hasNumber = true
-		}
+// unit names what min and max count for a field of the given kind
+func unit(kind reflect.Kind) string {
+	switch kind {
+	case reflect.String:
+		return " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return " items"
+	default:
+		return ""
 	}
-
-	return hasUpper && hasLower && hasNumber
 }
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_refresh_tokens_family_id;
+
+-- Rotated tokens are kept only for reuse detection and must not become valid again
+DELETE FROM refresh_tokens WHERE rotated_at IS NOT NULL;
+
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS rotated_at;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS family_id;
@@ -0,0 +1,7 @@
+-- Refresh tokens are stored as an HMAC of the token. Existing plaintext rows
+-- stay valid and are rehashed by the service on startup, after which the
+-- legacy token column is left empty.
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS token_hash VARCHAR(64);
+ALTER TABLE refresh_tokens ALTER COLUMN token DROP NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_refresh_tokens_token_hash ON refresh_tokens(token_hash);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS signing_keys (
+    id SERIAL PRIMARY KEY,
+    kid VARCHAR(64) UNIQUE NOT NULL,
+    algorithm VARCHAR(16) NOT NULL,
+    private_key TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    retired_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE
+    );
+
+-- At most one key may be active (not retired) at any time
+CREATE UNIQUE INDEX IF NOT EXISTS idx_signing_keys_active ON signing_keys((retired_at IS NULL)) WHERE retired_at IS NULL;
@@ -0,0 +1,62 @@
+CREATE TABLE IF NOT EXISTS roles (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(32) UNIQUE NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+    );
+
+CREATE TABLE IF NOT EXISTS permissions (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(64) UNIQUE NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT ''
+    );
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id INTEGER NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    permission_id INTEGER NOT NULL REFERENCES permissions(id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+    );
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role_id INTEGER NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (user_id, role_id)
+    );
+
+INSERT INTO roles (name, description) VALUES
+    ('learner', 'Completes missions'),
+    ('author', 'Creates and edits missions'),
+    ('mentor', 'Reviews learner submissions'),
+    ('admin', 'Manages users and the platform')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO permissions (name, description) VALUES
+    ('missions:read', 'View missions'),
+    ('missions:write', 'Create, update and delete missions'),
+    ('submissions:review', 'Review learner submissions'),
+    ('users:manage', 'Manage user accounts and roles')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r
+JOIN permissions p ON (r.name, p.name) IN (
+    ('learner', 'missions:read'),
+    ('author', 'missions:read'),
+    ('author', 'missions:write'),
+    ('mentor', 'missions:read'),
+    ('mentor', 'submissions:review'),
+    ('admin', 'missions:read'),
+    ('admin', 'missions:write'),
+    ('admin', 'submissions:review'),
+    ('admin', 'users:manage')
+)
+ON CONFLICT DO NOTHING;
+
+-- Every existing account becomes a learner
+INSERT INTO user_roles (user_id, role_id)
+SELECT u.id, r.id FROM users u, roles r WHERE r.name = 'learner'
+ON CONFLICT DO NOTHING;
+
+CREATE INDEX IF NOT EXISTS idx_user_roles_role_id ON user_roles(role_id);
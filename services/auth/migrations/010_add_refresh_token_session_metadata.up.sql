@@ -0,0 +1,9 @@
+-- A token family is a login session. Every token of the family carries the
+-- device it was used from, and rotated tokens keep the session's created_at.
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS user_agent VARCHAR(512) NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS ip_address VARCHAR(45) NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE refresh_tokens SET last_used_at = created_at WHERE last_used_at IS NULL;
+
+ALTER TABLE refresh_tokens ALTER COLUMN last_used_at SET DEFAULT NOW();
@@ -0,0 +1,30 @@
+-- Append-only security log of authentication events. There is no foreign
+-- key so that the history outlives deleted accounts until it is purged.
+CREATE TABLE IF NOT EXISTS auth_events (
+    id BIGSERIAL PRIMARY KEY,
+    event_type VARCHAR(50) NOT NULL,
+    outcome VARCHAR(20) NOT NULL,
+    user_id INTEGER,
+    actor_id INTEGER,
+    ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent VARCHAR(512) NOT NULL DEFAULT '',
+    details JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+    );
+
+CREATE INDEX IF NOT EXISTS idx_auth_events_user_id ON auth_events(user_id, id);
+CREATE INDEX IF NOT EXISTS idx_auth_events_created_at ON auth_events(created_at);
+
+-- Events can't be changed, and only deleted once they are at least 30 days old
+CREATE OR REPLACE FUNCTION auth_events_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' OR OLD.created_at > NOW() - INTERVAL '30 days' THEN
+        RAISE EXCEPTION 'auth_events is append-only';
+    END IF;
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER auth_events_append_only
+    BEFORE UPDATE OR DELETE ON auth_events
+    FOR EACH ROW EXECUTE FUNCTION auth_events_append_only();
@@ -0,0 +1,10 @@
+-- Services that call the auth service on their own behalf, such as token
+-- introspection. Secrets are stored as a keyed hash.
+CREATE TABLE IF NOT EXISTS oauth_clients (
+    id SERIAL PRIMARY KEY,
+    client_id VARCHAR(64) UNIQUE NOT NULL,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    secret_hash VARCHAR(64) NOT NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+    );
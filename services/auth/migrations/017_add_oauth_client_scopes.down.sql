@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE name = 'users:read';
+
+ALTER TABLE oauth_clients DROP COLUMN IF EXISTS scopes;
@@ -0,0 +1,7 @@
+-- Scopes a client may request with the client credentials grant; they are
+-- permissions like those granted through roles
+ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS scopes TEXT[] NOT NULL DEFAULT '{}';
+
+INSERT INTO permissions (name, description) VALUES
+    ('users:read', 'Look up user profiles')
+ON CONFLICT (name) DO NOTHING;
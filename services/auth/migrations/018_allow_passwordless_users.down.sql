@@ -0,0 +1,4 @@
+-- Passwordless accounts get an unusable hash; a password reset lets them sign in again
+UPDATE users SET password_hash = '' WHERE password_hash IS NULL;
+
+ALTER TABLE users ALTER COLUMN password_hash SET NOT NULL;
@@ -0,0 +1,2 @@
+-- Accounts that sign in with magic links only have no password
+ALTER TABLE users ALTER COLUMN password_hash DROP NOT NULL;
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS oauth_refresh_tokens;
+DROP TABLE IF EXISTS oauth_authorization_codes;
+
+-- Public clients have no secret to fall back to
+DELETE FROM oauth_clients WHERE secret_hash IS NULL;
+
+ALTER TABLE oauth_clients ALTER COLUMN secret_hash SET NOT NULL;
+ALTER TABLE oauth_clients DROP COLUMN IF EXISTS public;
+ALTER TABLE oauth_clients DROP COLUMN IF EXISTS redirect_uris;
@@ -0,0 +1,5 @@
+-- Granted to the client the auth service fetches missions data for personal
+-- data exports as; it belongs to no role
+INSERT INTO permissions (name, description) VALUES
+    ('missions:export', 'Export the missions data of any user')
+ON CONFLICT (name) DO NOTHING;
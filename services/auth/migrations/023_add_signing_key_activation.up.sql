@@ -0,0 +1,6 @@
+-- A rotated key is published for verification before it signs anything; it
+-- becomes the signing key at activates_at, when the previous key retires
+ALTER TABLE signing_keys ADD COLUMN IF NOT EXISTS activates_at TIMESTAMP WITH TIME ZONE;
+UPDATE signing_keys SET activates_at = created_at WHERE activates_at IS NULL;
+ALTER TABLE signing_keys ALTER COLUMN activates_at SET NOT NULL;
+ALTER TABLE signing_keys ALTER COLUMN activates_at SET DEFAULT NOW();
@@ -0,0 +1,5 @@
+-- A sign-in can only be completed by the browser that started it, which holds
+-- the binding secret in a cookie. Pending sign-ins have no binding and can't
+-- be completed anymore.
+DELETE FROM oidc_states;
+ALTER TABLE oidc_states ADD COLUMN IF NOT EXISTS binding_hash VARCHAR(64) NOT NULL;
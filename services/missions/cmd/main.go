@@ -1,19 +1,25 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"log"
 	"log/slog"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"time"
 
 	_ "github.com/lib/pq"
 	"github.com/pseudoerr/mission-service/config"
 	_ "github.com/pseudoerr/mission-service/docs"
+	"github.com/pseudoerr/mission-service/internal/events"
 	"github.com/pseudoerr/mission-service/internal/handler"
+	"github.com/pseudoerr/mission-service/internal/introspection"
+	"github.com/pseudoerr/mission-service/internal/middleware"
 	"github.com/pseudoerr/mission-service/repository"
 	"github.com/pseudoerr/mission-service/service"
+	"github.com/redis/go-redis/v9"
 )
 
 func main() {
@@ -37,8 +43,25 @@ func main() {
 		Logger: logger,
 	}
 
+	// Purge the data of users deleted in auth-service
+	if redisURL := config.GetRedisURL(); redisURL != "" {
+		opts, err := redis.ParseURL(redisURL)
+		if err != nil {
+			log.Fatalf("invalid REDIS_URL: %v", err)
+		}
+		consumer := events.NewConsumer(redis.NewClient(opts), config.GetEventsStream(), "missions-service",
+			config.GetEventsConsumer(), repo, logger)
+		go func() {
+			if err := consumer.Run(context.Background()); err != nil {
+				logger.Error("event consumer stopped", "error", err)
+			}
+		}()
+	} else {
+		logger.Warn("REDIS_URL is not set, data of deleted users will not be purged")
+	}
+
 	newHandler := &handler.Handler{Service: svc}
-	router := handler.NewRouter(newHandler)
+	router := handler.NewRouter(newHandler, newAuthMiddleware(logger))
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
@@ -53,3 +76,26 @@ func main() {
 	http.ListenAndServe(":"+port, router)
 
 }
+
+// newAuthMiddleware verifies access tokens against the JWKS of the auth
+// service. With client credentials configured personal access tokens are
+// introspected, and so are access tokens if AUTH_INTROSPECT_ACCESS_TOKENS is set.
+func newAuthMiddleware(logger *slog.Logger) func(http.Handler) http.Handler {
+	keys := middleware.NewKeySet(config.GetAuthJWKSURL(), 10*time.Minute)
+
+	clientID, clientSecret := config.GetAuthClientCredentials()
+	if clientID == "" || clientSecret == "" {
+		if config.GetIntrospectAccessTokens() {
+			log.Fatal("AUTH_CLIENT_ID and AUTH_CLIENT_SECRET are required with AUTH_INTROSPECT_ACCESS_TOKENS")
+		}
+		logger.Warn("AUTH_CLIENT_ID is not set, personal access tokens will be rejected")
+		return middleware.AuthMiddleware(keys, nil)
+	}
+
+	client := introspection.NewClient(config.GetAuthIntrospectionURL(), clientID, clientSecret, config.GetIntrospectionCacheTTL())
+	if config.GetIntrospectAccessTokens() {
+		logger.Info("introspecting access tokens", "url", config.GetAuthIntrospectionURL())
+		return middleware.IntrospectionAuthMiddleware(client)
+	}
+	return middleware.AuthMiddleware(keys, client)
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"log"
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -20,3 +21,70 @@ func GetDatabaseURL() string {
 	}
 	return url
 }
+
+func GetAuthJWKSURL() string {
+	url := os.Getenv("AUTH_JWKS_URL")
+	if url == "" {
+		return "http://auth-service:8081/.well-known/jwks.json"
+	}
+	return url
+}
+
+// GetAuthIntrospectionURL returns the token introspection endpoint of the
+// auth service
+func GetAuthIntrospectionURL() string {
+	url := os.Getenv("AUTH_INTROSPECTION_URL")
+	if url == "" {
+		return "http://auth-service:8081/oauth/introspect"
+	}
+	return url
+}
+
+// GetIntrospectAccessTokens reports whether access tokens are introspected
+// instead of verified locally against the JWKS. Personal access tokens are
+// always introspected.
+func GetIntrospectAccessTokens() bool {
+	return os.Getenv("AUTH_INTROSPECT_ACCESS_TOKENS") == "true"
+}
+
+// GetAuthClientCredentials returns the client ID and secret this service
+// authenticates to the auth service with. Without them tokens can't be
+// introspected.
+func GetAuthClientCredentials() (string, string) {
+	return os.Getenv("AUTH_CLIENT_ID"), os.Getenv("AUTH_CLIENT_SECRET")
+}
+
+// GetIntrospectionCacheTTL bounds how long a revoked token may still be
+// accepted
+func GetIntrospectionCacheTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("AUTH_INTROSPECTION_CACHE_TTL"))
+	if err != nil || ttl <= 0 {
+		return 30 * time.Second
+	}
+	return ttl
+}
+
+// GetRedisURL returns the Redis instance auth events are read from, or an
+// empty string if events are not consumed
+func GetRedisURL() string {
+	return os.Getenv("REDIS_URL")
+}
+
+func GetEventsStream() string {
+	stream := os.Getenv("EVENTS_STREAM")
+	if stream == "" {
+		return "auth.events"
+	}
+	return stream
+}
+
+// GetEventsConsumer names this replica in the consumer group. Events it
+// received but did not acknowledge are retried under the same name after a
+// restart, so it should be stable.
+func GetEventsConsumer() string {
+	name := os.Getenv("EVENTS_CONSUMER")
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+	return name
+}
@@ -0,0 +1,183 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// EventUserDeleted is published by the auth service when an account is deleted
+const EventUserDeleted = "user.deleted"
+
+// UserDataStore removes everything the service holds about a user. It must
+// be idempotent, an event may be delivered more than once.
+type UserDataStore interface {
+	DeleteUserData(ctx context.Context, userID string) error
+}
+
+type userDeletedPayload struct {
+	UserID int `json:"user_id"`
+}
+
+// Consumer reads auth events from a Redis stream as part of a consumer group,
+// so replicas share the work and unacknowledged events survive a restart.
+// Events left pending by a consumer that is gone, like a replica that crashed
+// or was scaled away, are claimed by the others once they sat idle for
+// claimIdle.
+type Consumer struct {
+	client        *redis.Client
+	stream        string
+	group         string
+	name          string
+	store         UserDataStore
+	logger        *slog.Logger
+	block         time.Duration
+	retryDelay    time.Duration
+	claimIdle     time.Duration
+	claimInterval time.Duration
+}
+
+func NewConsumer(client *redis.Client, stream, group, name string, store UserDataStore, logger *slog.Logger) *Consumer {
+	return &Consumer{
+		client:        client,
+		stream:        stream,
+		group:         group,
+		name:          name,
+		store:         store,
+		logger:        logger,
+		block:         5 * time.Second,
+		retryDelay:    5 * time.Second,
+		claimIdle:     time.Minute,
+		claimInterval: 30 * time.Second,
+	}
+}
+
+// Run processes events until ctx is cancelled. Events this consumer received
+// but did not acknowledge are processed before new ones; an event whose
+// handling fails stays pending and is retried after a delay. Every
+// claimInterval stale events of other consumers are claimed and processed
+// the same way.
+func (c *Consumer) Run(ctx context.Context) error {
+	err := c.client.XGroupCreateMkStream(ctx, c.stream, c.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("failed to create consumer group: %w", err)
+	}
+
+	// "0" reads this consumer's pending events, ">" new ones
+	start := "0"
+	var lastClaim time.Time
+	for ctx.Err() == nil {
+		if time.Since(lastClaim) >= c.claimInterval {
+			lastClaim = time.Now()
+			claimed, err := c.claimStale(ctx)
+			if err != nil && ctx.Err() == nil {
+				c.logger.Error("failed to claim stale events", "stream", c.stream, "error", err)
+			}
+			if claimed > 0 {
+				c.logger.Info("claimed stale events", "count", claimed)
+				start = "0"
+			}
+		}
+
+		streams, err := c.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    c.group,
+			Consumer: c.name,
+			Streams:  []string{c.stream, start},
+			Count:    10,
+			Block:    c.block,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			c.logger.Error("failed to read events", "stream", c.stream, "error", err)
+			c.wait(ctx)
+			continue
+		}
+
+		messages := streams[0].Messages
+		if start == "0" && len(messages) == 0 {
+			start = ">"
+			continue
+		}
+
+		for _, msg := range messages {
+			if err := c.handle(ctx, msg); err != nil {
+				c.logger.Error("failed to handle event", "id", msg.ID, "error", err)
+				start = "0"
+				c.wait(ctx)
+				break
+			}
+			if err := c.client.XAck(ctx, c.stream, c.group, msg.ID).Err(); err != nil {
+				c.logger.Error("failed to acknowledge event", "id", msg.ID, "error", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// claimStale moves events that sat unacknowledged for claimIdle to this
+// consumer's pending list, where Run picks them up, and returns how many
+// were claimed
+func (c *Consumer) claimStale(ctx context.Context) (int, error) {
+	claimed := 0
+	cursor := "0-0"
+	for {
+		messages, next, err := c.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   c.stream,
+			Group:    c.group,
+			Consumer: c.name,
+			MinIdle:  c.claimIdle,
+			Start:    cursor,
+			Count:    100,
+		}).Result()
+		if err != nil {
+			return claimed, err
+		}
+		claimed += len(messages)
+		if next == "0-0" {
+			return claimed, nil
+		}
+		cursor = next
+	}
+}
+
+func (c *Consumer) handle(ctx context.Context, msg redis.XMessage) error {
+	eventType, _ := msg.Values["type"].(string)
+	payload, _ := msg.Values["payload"].(string)
+
+	switch eventType {
+	case EventUserDeleted:
+		var event userDeletedPayload
+		if err := json.Unmarshal([]byte(payload), &event); err != nil || event.UserID == 0 {
+			// Retrying can't fix a malformed event, so it is acknowledged and dropped
+			c.logger.Warn("dropping malformed event", "id", msg.ID, "type", eventType)
+			return nil
+		}
+		if err := c.store.DeleteUserData(ctx, strconv.Itoa(event.UserID)); err != nil {
+			return err
+		}
+		c.logger.Info("user data deleted", "user_id", event.UserID)
+		return nil
+	default:
+		return nil
+	}
+}
+
+func (c *Consumer) wait(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(c.retryDelay):
+	}
+}
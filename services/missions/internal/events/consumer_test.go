@@ -0,0 +1,156 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+type fakeStore struct {
+	mu       sync.Mutex
+	failures int
+	deleted  []string
+}
+
+func (s *fakeStore) DeleteUserData(ctx context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("database down")
+	}
+	s.deleted = append(s.deleted, userID)
+	return nil
+}
+
+func (s *fakeStore) deletedUsers() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.deleted...)
+}
+
+func publish(t *testing.T, client *redis.Client, eventType, payload string) {
+	t.Helper()
+	err := client.XAdd(context.Background(), &redis.XAddArgs{
+		Stream: "auth.events",
+		Values: map[string]any{"type": eventType, "payload": payload},
+	}).Err()
+	if err != nil {
+		t.Fatalf("failed to publish event: %v", err)
+	}
+}
+
+func runConsumer(t *testing.T, client *redis.Client, store *fakeStore) {
+	t.Helper()
+	consumer := NewConsumer(client, "auth.events", "missions", "test", store, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	consumer.block = 10 * time.Millisecond
+	consumer.retryDelay = 10 * time.Millisecond
+	consumer.claimIdle = 50 * time.Millisecond
+	consumer.claimInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := consumer.Run(ctx); err != nil {
+			t.Errorf("consumer failed: %v", err)
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestConsumerDeletesUserData(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	store := &fakeStore{}
+	runConsumer(t, client, store)
+
+	publish(t, client, "user.registered", `{"user_id":1}`)
+	publish(t, client, EventUserDeleted, `not json`)
+	publish(t, client, EventUserDeleted, `{"user_id":42,"deleted_at":"2026-01-01T00:00:00Z"}`)
+
+	waitFor(t, func() bool { return len(store.deletedUsers()) == 1 })
+	if got := store.deletedUsers()[0]; got != "42" {
+		t.Fatalf("deleted user %q, want 42", got)
+	}
+
+	waitFor(t, func() bool {
+		pending, err := client.XPending(context.Background(), "auth.events", "missions").Result()
+		return err == nil && pending.Count == 0
+	})
+}
+
+func TestConsumerRetriesFailedEvents(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	store := &fakeStore{failures: 2}
+	runConsumer(t, client, store)
+
+	publish(t, client, EventUserDeleted, `{"user_id":7}`)
+	publish(t, client, EventUserDeleted, `{"user_id":8}`)
+
+	waitFor(t, func() bool { return len(store.deletedUsers()) == 2 })
+	if got := store.deletedUsers(); got[0] != "7" || got[1] != "8" {
+		t.Fatalf("deleted users %v, want [7 8]", got)
+	}
+}
+
+func TestConsumerClaimsEventsOfCrashedConsumers(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	// Another replica reads an event and crashes before acknowledging it
+	ctx := context.Background()
+	if err := client.XGroupCreateMkStream(ctx, "auth.events", "missions", "0").Err(); err != nil {
+		t.Fatalf("failed to create group: %v", err)
+	}
+	publish(t, client, EventUserDeleted, `{"user_id":9}`)
+	err := client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    "missions",
+		Consumer: "crashed",
+		Streams:  []string{"auth.events", ">"},
+		Count:    1,
+	}).Err()
+	if err != nil {
+		t.Fatalf("failed to read as the crashed consumer: %v", err)
+	}
+
+	store := &fakeStore{}
+	runConsumer(t, client, store)
+
+	waitFor(t, func() bool { return len(store.deletedUsers()) == 1 })
+	if got := store.deletedUsers()[0]; got != "9" {
+		t.Fatalf("deleted user %q, want 9", got)
+	}
+
+	waitFor(t, func() bool {
+		pending, err := client.XPending(ctx, "auth.events", "missions").Result()
+		return err == nil && pending.Count == 0
+	})
+}
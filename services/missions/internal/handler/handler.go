@@ -8,8 +8,10 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/pseudoerr/mission-service/internal/middleware"
 	"github.com/pseudoerr/mission-service/models"
+	"github.com/pseudoerr/mission-service/repository"
 	"github.com/pseudoerr/mission-service/service"
 )
 
@@ -73,6 +75,12 @@ func (h *Handler) GetMissionByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	id, err := parseID(r)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
 	mission, err := h.Service.Store.GetMissionByID(r.Context(), id, userID)
 	if err != nil {
 		http.Error(w, "Not found", http.StatusNotFound)
@@ -134,8 +142,6 @@ func (h *Handler) CreateMission(w http.ResponseWriter, r *http.Request) {
 // @Failure 500 {string} string "Failed to update mission"
 // @Router /missions/{id} [put]
 func (h *Handler) UpdateMission(w http.ResponseWriter, r *http.Request) {
-	var ErrNotFound = errors.New("not found")
-
 	userID, err := middleware.FromContext(r.Context())
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
@@ -160,7 +166,7 @@ func (h *Handler) UpdateMission(w http.ResponseWriter, r *http.Request) {
 
 	updated, err := h.Service.Store.UpdateMission(r.Context(), m)
 	if err != nil {
-		if errors.Is(err, ErrNotFound) {
+		if errors.Is(err, repository.ErrNotFound) {
 			http.Error(w, "Not found", http.StatusNotFound)
 			return
 		}
@@ -183,7 +189,6 @@ func (h *Handler) UpdateMission(w http.ResponseWriter, r *http.Request) {
 // @Failure 500 {string} string "Failed to delete mission"
 // @Router /missions/{id} [delete]
 func (h *Handler) DeleteMission(w http.ResponseWriter, r *http.Request) {
-	var ErrorNotFound = errors.New("not found")
 	userID, err := middleware.FromContext(r.Context())
 	if err != nil {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
@@ -198,7 +203,7 @@ func (h *Handler) DeleteMission(w http.ResponseWriter, r *http.Request) {
 
 	err = h.Service.Store.DeleteMission(r.Context(), id, userID)
 	if err != nil {
-		if errors.Is(err, ErrorNotFound) {
+		if errors.Is(err, repository.ErrNotFound) {
 			http.Error(w, "Not found", http.StatusNotFound)
 			return
 		}
@@ -233,6 +238,68 @@ func (h *Handler) GetProfile(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, profile)
 }
 
+// ExportData godoc
+// @Summary Выгрузить данные текущего пользователя
+// @Description Возвращает все задания и профиль текущего пользователя
+// @Tags profile
+// @Produce json
+// @Success 200 {object} models.UserExport
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 500 {string} string "Failed to export data"
+// @Router /export [get]
+func (h *Handler) ExportData(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.FromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	h.writeExport(w, r, userID)
+}
+
+// ExportUserData godoc
+// @Summary Выгрузить данные пользователя
+// @Description Возвращает все задания и профиль пользователя. Вызывается auth-service с сервисным токеном при экспорте персональных данных
+// @Tags profile
+// @Produce json
+// @Param id path int true "ID пользователя"
+// @Success 200 {object} models.UserExport
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Insufficient permissions"
+// @Failure 500 {string} string "Failed to export data"
+// @Router /users/{id}/export [get]
+func (h *Handler) ExportUserData(w http.ResponseWriter, r *http.Request) {
+	h.writeExport(w, r, mux.Vars(r)["id"])
+}
+
+func (h *Handler) writeExport(w http.ResponseWriter, r *http.Request, userID string) {
+	missions, err := h.Service.Store.ListMissionsByUserID(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Failed to export data", http.StatusInternalServerError)
+		return
+	}
+	if missions == nil {
+		missions = []models.Mission{}
+	}
+
+	profile, err := h.Service.Store.GetProfileByUserID(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Failed to export data", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, models.UserExport{Missions: missions, Profile: profile})
+}
+
+// parseID returns the mission ID of the route, which only matches digits
+func parseID(r *http.Request) (string, error) {
+	id, ok := mux.Vars(r)["id"]
+	if !ok || id == "" {
+		return "", errors.New("missing mission ID")
+	}
+	return id, nil
+}
+
 func writeJSON(w http.ResponseWriter, code int, payload any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
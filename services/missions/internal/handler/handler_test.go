@@ -5,10 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/pseudoerr/mission-service/internal/handler"
+	"github.com/pseudoerr/mission-service/internal/middleware"
 	"github.com/pseudoerr/mission-service/models"
 	"github.com/pseudoerr/mission-service/service"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
 )
 
@@ -17,20 +19,26 @@ type fakeStore struct {
 	nextID   int
 }
 
-func (f *fakeStore) ListMissions(ctx context.Context) ([]models.Mission, error) {
-	return f.missions, nil
+func (f *fakeStore) ListMissionsByUserID(ctx context.Context, userID string) ([]models.Mission, error) {
+	var missions []models.Mission
+	for _, m := range f.missions {
+		if m.UserID == userID {
+			missions = append(missions, m)
+		}
+	}
+	return missions, nil
 }
 
 func (f *fakeStore) AddMission(ctx context.Context, m models.Mission) (models.Mission, error) {
 	f.nextID++
-	m.ID = f.nextID
+	m.ID = strconv.Itoa(f.nextID)
 	f.missions = append(f.missions, m)
 	return m, nil
 }
 
-func (f *fakeStore) GetByID(ctx context.Context, id int) (models.Mission, error) {
+func (f *fakeStore) GetMissionByID(ctx context.Context, missionID string, userID string) (models.Mission, error) {
 	for _, m := range f.missions {
-		if m.ID == id {
+		if m.ID == missionID && m.UserID == userID {
 			return m, nil
 		}
 	}
@@ -41,21 +49,36 @@ func (f *fakeStore) UpdateMission(ctx context.Context, m models.Mission) (models
 	return m, nil
 }
 
-func (f *fakeStore) DeleteMission(ctx context.Context, id int) error {
+func (f *fakeStore) DeleteMission(ctx context.Context, missionID string, userID string) error {
 	return nil
 }
 
+func (f *fakeStore) GetProfileByUserID(ctx context.Context, userID string) (models.Profile, error) {
+	missions, _ := f.ListMissionsByUserID(ctx, userID)
+	total := 0
+	for _, m := range missions {
+		total += m.Points
+	}
+	return models.NewProfile(total), nil
+}
+
 func TestGetMissions(t *testing.T) {
 
 	store := &fakeStore{
 		missions: []models.Mission{
-			{ID: 1, Title: "Test", Points: 100},
+			{ID: "1", UserID: "7", Title: "Test", Points: 100},
 		},
 		nextID: 1,
 	}
 	svc := &service.MissionService{Store: store}
 	newHandler := &handler.Handler{Service: svc}
-	router := handler.NewRouter(newHandler)
+	router := handler.NewRouter(newHandler, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), middleware.UserIDKey, "7")
+			ctx = context.WithValue(ctx, middleware.PermissionsKey, []string{"missions:read"})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	})
 
 	req := httptest.NewRequest(http.MethodGet, "/missions", nil)
 	rec := httptest.NewRecorder()
@@ -75,3 +98,52 @@ func TestGetMissions(t *testing.T) {
 		t.Fatalf("unexpected data: %+v", data)
 	}
 }
+
+func TestExportUserDataRequiresExportPermission(t *testing.T) {
+	store := &fakeStore{
+		missions: []models.Mission{
+			{ID: "1", UserID: "7", Title: "FizzBuzz", Points: 200},
+			{ID: "2", UserID: "8", Title: "Quicksort", Points: 300},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		permissions []string
+		wantStatus  int
+	}{
+		{"service token with missions:export", []string{"missions:export"}, http.StatusOK},
+		{"user token with missions:read", []string{"missions:read"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := handler.NewRouter(&handler.Handler{Service: &service.MissionService{Store: store}},
+				func(next http.Handler) http.Handler {
+					return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						ctx := context.WithValue(r.Context(), middleware.ClientIDKey, "auth-service")
+						ctx = context.WithValue(ctx, middleware.PermissionsKey, tt.permissions)
+						next.ServeHTTP(w, r.WithContext(ctx))
+					})
+				})
+
+			req := httptest.NewRequest(http.MethodGet, "/users/7/export", nil)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var export models.UserExport
+			if err := json.NewDecoder(rec.Body).Decode(&export); err != nil {
+				t.Fatalf("bad json: %v", err)
+			}
+			if len(export.Missions) != 1 || export.Missions[0].Title != "FizzBuzz" || export.Profile.TotalPoints != 200 {
+				t.Fatalf("unexpected export: %+v", export)
+			}
+		})
+	}
+}
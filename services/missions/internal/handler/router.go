@@ -9,7 +9,7 @@ import (
 	httpSwagger "github.com/swaggo/http-swagger"
 )
 
-func NewRouter(handler *Handler) http.Handler {
+func NewRouter(handler *Handler, auth func(http.Handler) http.Handler) http.Handler {
 	r := mux.NewRouter()
 
 	// no auth
@@ -17,14 +17,21 @@ func NewRouter(handler *Handler) http.Handler {
 
 	authRoutes := r.PathPrefix("/").Subrouter()
 
-	authRoutes.HandleFunc("/missions", handler.GetMissions).Methods("GET")
-	authRoutes.HandleFunc("/missions/{id:[0-9]+}", handler.GetMissionByID).Methods("GET")
-	authRoutes.HandleFunc("/missions", handler.CreateMission).Methods("POST")
-	authRoutes.HandleFunc("/missions/{id:[0-9]+}", handler.UpdateMission).Methods("PUT")
-	authRoutes.HandleFunc("/missions/{id:[0-9]+}", handler.DeleteMission).Methods("DELETE")
-	authRoutes.HandleFunc("/profile", handler.GetProfile).Methods("GET")
-
-	authRoutes.Use(middleware.AuthMiddleware)
+	canRead := middleware.RequirePermission("missions:read")
+	canWrite := middleware.RequirePermission("missions:write")
+
+	authRoutes.Handle("/missions", canRead(http.HandlerFunc(handler.GetMissions))).Methods("GET")
+	authRoutes.Handle("/missions/{id:[0-9]+}", canRead(http.HandlerFunc(handler.GetMissionByID))).Methods("GET")
+	authRoutes.Handle("/missions", canWrite(http.HandlerFunc(handler.CreateMission))).Methods("POST")
+	authRoutes.Handle("/missions/{id:[0-9]+}", canWrite(http.HandlerFunc(handler.UpdateMission))).Methods("PUT")
+	authRoutes.Handle("/missions/{id:[0-9]+}", canWrite(http.HandlerFunc(handler.DeleteMission))).Methods("DELETE")
+	authRoutes.Handle("/profile", canRead(http.HandlerFunc(handler.GetProfile))).Methods("GET")
+	authRoutes.Handle("/export", canRead(http.HandlerFunc(handler.ExportData))).Methods("GET")
+	// Any user's data, for auth-service building a personal data export with its service token
+	authRoutes.Handle("/users/{id:[0-9]+}/export",
+		middleware.RequirePermission("missions:export")(http.HandlerFunc(handler.ExportUserData))).Methods("GET")
+
+	authRoutes.Use(auth)
 
 	rl := middleware.NewRateLimiter(10, time.Minute)
 
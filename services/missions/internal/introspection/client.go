@@ -0,0 +1,164 @@
+// Package introspection asks the auth service whether an access token is
+// still usable (RFC 7662). Unlike a local signature check this notices
+// revoked tokens and disabled accounts, at the cost of a network call that
+// responses are cached to avoid.
+package introspection
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCacheEntries bounds the memory used by cached responses
+const maxCacheEntries = 10000
+
+var ErrUnavailable = errors.New("token introspection unavailable")
+
+// Result is the auth service's view of a token. TokenUse is "service" for
+// tokens a service obtained for itself; their Sub is the client ID. It is
+// "delegated" for tokens an application holds for a user, which carry both.
+type Result struct {
+	Active   bool     `json:"active"`
+	TokenUse string   `json:"token_use"`
+	ClientID string   `json:"client_id"`
+	Sub      string   `json:"sub"`
+	Username string   `json:"username"`
+	Exp      int64    `json:"exp"`
+	Scope    string   `json:"scope"`
+	Roles    []string `json:"roles"`
+}
+
+// Scopes splits the space separated scope of the token
+func (r *Result) Scopes() []string {
+	return strings.Fields(r.Scope)
+}
+
+type cacheEntry struct {
+	result    *Result
+	expiresAt time.Time
+}
+
+// Client calls the introspection endpoint with client credentials. A response
+// is cached for up to ttl, and an active one never beyond the token's expiry,
+// so a revocation takes at most ttl to be noticed.
+type Client struct {
+	url          string
+	clientID     string
+	clientSecret string
+	ttl          time.Duration
+	http         *http.Client
+	mu           sync.Mutex
+	cache        map[string]cacheEntry
+}
+
+func NewClient(url, clientID, clientSecret string, ttl time.Duration) *Client {
+	return &Client{
+		url:          url,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		ttl:          ttl,
+		http:         &http.Client{Timeout: 5 * time.Second},
+		cache:        make(map[string]cacheEntry),
+	}
+}
+
+// Introspect returns the state of token. Failures to reach the auth service
+// are not cached and are reported as ErrUnavailable.
+func (c *Client) Introspect(ctx context.Context, token string) (*Result, error) {
+	// Tokens are kept out of memory dumps by caching under their hash
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+
+	if result, ok := c.cached(key); ok {
+		return result, nil
+	}
+
+	result, err := c.fetch(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	c.store(key, result)
+	return result, nil
+}
+
+func (c *Client) fetch(ctx context.Context, token string) (*Result, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(c.clientID), url.QueryEscape(c.clientSecret))
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected introspection response status %d", resp.StatusCode)
+	}
+
+	var result Result
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *Client) cached(key string) (*Result, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(c.cache, key)
+		return nil, false
+	}
+	return entry.result, true
+}
+
+func (c *Client) store(key string, result *Result) {
+	expiresAt := time.Now().Add(c.ttl)
+	if result.Active && result.Exp > 0 {
+		if exp := time.Unix(result.Exp, 0); exp.Before(expiresAt) {
+			expiresAt = exp
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.cache) >= maxCacheEntries {
+		c.evictExpired()
+	}
+	// Still full of live entries: start over rather than grow without bound
+	if len(c.cache) >= maxCacheEntries {
+		c.cache = make(map[string]cacheEntry)
+	}
+	c.cache[key] = cacheEntry{result: result, expiresAt: expiresAt}
+}
+
+// evictExpired must be called with the lock held
+func (c *Client) evictExpired() {
+	now := time.Now()
+	for key, entry := range c.cache {
+		if now.After(entry.expiresAt) {
+			delete(c.cache, key)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package introspection_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pseudoerr/mission-service/internal/introspection"
+)
+
+func newIntrospectionServer(t *testing.T, calls *atomic.Int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "missions" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.FormValue("token") != "good" {
+			json.NewEncoder(w).Encode(map[string]any{"active": false})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"active": true,
+			"sub":    "42",
+			"scope":  "missions:read missions:write",
+			"exp":    time.Now().Add(time.Minute).Unix(),
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestIntrospectCachesResponses(t *testing.T) {
+	var calls atomic.Int32
+	srv := newIntrospectionServer(t, &calls)
+	client := introspection.NewClient(srv.URL, "missions", "s3cret", time.Minute)
+
+	for i := 0; i < 3; i++ {
+		result, err := client.Introspect(context.Background(), "good")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !result.Active || result.Sub != "42" || len(result.Scopes()) != 2 {
+			t.Fatalf("unexpected result %+v", result)
+		}
+	}
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("expected 1 call to the auth service, got %d", got)
+	}
+
+	// Inactive responses are cached as well
+	for i := 0; i < 2; i++ {
+		result, err := client.Introspect(context.Background(), "bad")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if result.Active {
+			t.Fatal("expected an inactive token")
+		}
+	}
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("expected 2 calls to the auth service, got %d", got)
+	}
+}
+
+func TestIntrospectCacheExpires(t *testing.T) {
+	var calls atomic.Int32
+	srv := newIntrospectionServer(t, &calls)
+	client := introspection.NewClient(srv.URL, "missions", "s3cret", time.Millisecond)
+
+	client.Introspect(context.Background(), "good")
+	time.Sleep(5 * time.Millisecond)
+	client.Introspect(context.Background(), "good")
+
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("expected 2 calls to the auth service, got %d", got)
+	}
+}
+
+func TestIntrospectFailuresAreNotCached(t *testing.T) {
+	var calls atomic.Int32
+	srv := newIntrospectionServer(t, &calls)
+	client := introspection.NewClient(srv.URL, "missions", "wrong", time.Minute)
+
+	for i := 0; i < 2; i++ {
+		_, err := client.Introspect(context.Background(), "good")
+		if !errors.Is(err, introspection.ErrUnavailable) {
+			t.Fatalf("expected ErrUnavailable, got %v", err)
+		}
+	}
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("expected 2 calls to the auth service, got %d", got)
+	}
+}
@@ -0,0 +1,158 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// minRefreshInterval stops unknown key IDs from hammering the auth service
+const minRefreshInterval = 30 * time.Second
+
+var ErrKeyNotFound = errors.New("signing key not found")
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type publicKey struct {
+	alg string
+	key crypto.PublicKey
+}
+
+// KeySet caches the public keys published by the auth service at its JWKS
+// endpoint. The service only ever verifies tokens, it never holds a secret
+// that could be used to sign them.
+type KeySet struct {
+	url       string
+	ttl       time.Duration
+	client    *http.Client
+	mu        sync.RWMutex
+	keys      map[string]publicKey
+	fetchedAt time.Time
+}
+
+func NewKeySet(url string, ttl time.Duration) *KeySet {
+	return &KeySet{
+		url:    url,
+		ttl:    ttl,
+		client: &http.Client{Timeout: 5 * time.Second},
+		keys:   make(map[string]publicKey),
+	}
+}
+
+// Key returns the verification key and its algorithm for the given key ID.
+// The set is refetched when it is stale or the key ID is unknown.
+func (ks *KeySet) Key(kid string) (crypto.PublicKey, string, error) {
+	ks.mu.RLock()
+	key, ok := ks.lookup(kid)
+	stale := time.Since(ks.fetchedAt) > ks.ttl
+	canRefresh := time.Since(ks.fetchedAt) > minRefreshInterval
+	ks.mu.RUnlock()
+
+	if ok && !stale {
+		return key.key, key.alg, nil
+	}
+
+	if stale || canRefresh {
+		if err := ks.refresh(); err != nil {
+			slog.Warn("failed to refresh JWKS", "url", ks.url, "error", err)
+			if ok {
+				return key.key, key.alg, nil
+			}
+			return nil, "", err
+		}
+	}
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	if key, ok := ks.lookup(kid); ok {
+		return key.key, key.alg, nil
+	}
+	return nil, "", ErrKeyNotFound
+}
+
+// lookup must be called with the lock held. Tokens without a key ID are
+// accepted only while the auth service publishes a single key.
+func (ks *KeySet) lookup(kid string) (publicKey, bool) {
+	if kid == "" && len(ks.keys) == 1 {
+		for _, key := range ks.keys {
+			return key, true
+		}
+	}
+	key, ok := ks.keys[kid]
+	return key, ok
+}
+
+func (ks *KeySet) refresh() error {
+	resp, err := ks.client.Get(ks.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected JWKS response status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return err
+	}
+
+	keys := make(map[string]publicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			slog.Warn("skipping unsupported JWK", "kid", k.Kid, "error", err)
+			continue
+		}
+		keys[k.Kid] = publicKey{alg: k.Alg, key: key}
+	}
+
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.fetchedAt = time.Now()
+	ks.mu.Unlock()
+	return nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch {
+	case k.Kty == "OKP" && k.Crv == "Ed25519" && k.Alg == "EdDSA":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	case k.Kty == "RSA" && k.Alg == "RS256":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s/%s", k.Kty, k.Alg)
+	}
+}
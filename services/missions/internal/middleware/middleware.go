@@ -3,23 +3,30 @@ package middleware
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/pseudoerr/mission-service/internal/introspection"
 )
 
 type contextKey string
 
-const UserIDKey contextKey = "userID"
+const (
+	UserIDKey      contextKey = "userID"
+	ClientIDKey    contextKey = "clientID"
+	PermissionsKey contextKey = "permissions"
+)
 
 var (
-	ErrUserNotFound = errors.New("user not found in context")
-	jwtSecret       = []byte("your-super-secret-jwt-key-change-in-production")
+	ErrUserNotFound   = errors.New("user not found in context")
+	ErrClientNotFound = errors.New("client not found in context")
 )
 
 type statusRecorder struct {
@@ -120,35 +127,160 @@ func (rl *rateLimiter) MiddleWare(next http.Handler) http.Handler {
 	})
 }
 
-func AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
-			http.Error(w, "Missing or invalid Authorization header", http.StatusUnauthorized)
-			return
-		}
+// personalAccessTokenPrefix starts every personal access token issued by the
+// auth service
+const personalAccessTokenPrefix = "cbp_"
+
+// Token uses the auth service marks its tokens with: service tokens are
+// obtained by a service for itself with the client credentials grant,
+// delegated tokens by an application acting for a user
+const (
+	tokenUseService   = "service"
+	tokenUseDelegated = "delegated"
+)
+
+// AuthMiddleware verifies access tokens against the public keys published by
+// the auth service. Service tokens put the client ID in the context instead
+// of a user ID, delegated tokens put both. Personal access tokens can't be verified locally; they
+// are introspected, or rejected if introspector is nil.
+func AuthMiddleware(keys *KeySet, introspector Introspector) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
+				http.Error(w, "Missing or invalid Authorization header", http.StatusUnauthorized)
+				return
+			}
+
+			tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
+			if strings.HasPrefix(tokenStr, personalAccessTokenPrefix) {
+				if introspector == nil {
+					http.Error(w, "Personal access tokens are not accepted", http.StatusUnauthorized)
+					return
+				}
+				serveIntrospected(w, r, next, introspector, tokenStr)
+				return
+			}
+
+			claims := &jwt.MapClaims{}
+			token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+				kid, _ := token.Header["kid"].(string)
+				key, alg, err := keys.Key(kid)
+				if err != nil {
+					return nil, err
+				}
+				if token.Method.Alg() != alg {
+					return nil, fmt.Errorf("unexpected signing method: %s", token.Method.Alg())
+				}
+				return key, nil
+			}, jwt.WithValidMethods([]string{"EdDSA", "RS256"}))
+
+			if err != nil || !token.Valid {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+
+			var permissions []string
+			values, _ := (*claims)["permissions"].([]interface{})
+			for _, value := range values {
+				if permission, ok := value.(string); ok {
+					permissions = append(permissions, permission)
+				}
+			}
 
-		tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-		claims := &jwt.MapClaims{}
-		token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecret, nil
+			// Services calling with a token of their own have no user
+			if tokenUse, _ := (*claims)["token_use"].(string); tokenUse == tokenUseService {
+				clientID, _ := (*claims)["client_id"].(string)
+				if clientID == "" {
+					http.Error(w, "Invalid client ID in token", http.StatusUnauthorized)
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(withClient(r.Context(), clientID, permissions)))
+				return
+			}
+
+			// The auth service signs other tokens, like MFA challenges, with the same keys
+			tokenUse, _ := (*claims)["token_use"].(string)
+			if tokenUse != "access" && tokenUse != tokenUseDelegated {
+				http.Error(w, "Invalid token type", http.StatusUnauthorized)
+				return
+			}
+
+			userID, ok := (*claims)["user_id"].(float64)
+			if !ok {
+				http.Error(w, "Invalid user ID in token", http.StatusUnauthorized)
+				return
+			}
+
+			ctx := withIdentity(r.Context(), strconv.Itoa(int(userID)), permissions)
+			if tokenUse == tokenUseDelegated {
+				clientID, _ := (*claims)["client_id"].(string)
+				if clientID == "" {
+					http.Error(w, "Invalid client ID in token", http.StatusUnauthorized)
+					return
+				}
+				ctx = context.WithValue(ctx, ClientIDKey, clientID)
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
 		})
+	}
+}
 
-		if err != nil || !token.Valid {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
-			return
-		}
+// Introspector reports the state of an access token as known to the auth service
+type Introspector interface {
+	Introspect(ctx context.Context, token string) (*introspection.Result, error)
+}
 
-		// optional: validate expiration, audience, etc.
-		userID, ok := (*claims)["user_id"].(float64)
-		if !ok {
-			http.Error(w, "Invalid user ID in token", http.StatusUnauthorized)
-			return
-		}
+// IntrospectionAuthMiddleware is an alternative to AuthMiddleware that asks
+// the auth service about every token instead of checking its signature, so
+// revoked tokens and disabled accounts are rejected too. Personal access
+// tokens work the same way.
+func IntrospectionAuthMiddleware(introspector Introspector) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
+				http.Error(w, "Missing or invalid Authorization header", http.StatusUnauthorized)
+				return
+			}
 
-		ctx := context.WithValue(r.Context(), UserIDKey, int(userID))
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+			serveIntrospected(w, r, next, introspector, strings.TrimPrefix(authHeader, "Bearer "))
+		})
+	}
+}
+
+func serveIntrospected(w http.ResponseWriter, r *http.Request, next http.Handler, introspector Introspector, token string) {
+	result, err := introspector.Introspect(r.Context(), token)
+	if err != nil {
+		slog.Error("token introspection failed", "error", err)
+		http.Error(w, "Unable to validate token", http.StatusServiceUnavailable)
+		return
+	}
+	if !result.Active || result.Sub == "" {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	if result.TokenUse == tokenUseService {
+		next.ServeHTTP(w, r.WithContext(withClient(r.Context(), result.ClientID, result.Scopes())))
+		return
+	}
+	ctx := withIdentity(r.Context(), result.Sub, result.Scopes())
+	if result.TokenUse == tokenUseDelegated {
+		ctx = context.WithValue(ctx, ClientIDKey, result.ClientID)
+	}
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func withIdentity(ctx context.Context, userID string, permissions []string) context.Context {
+	ctx = context.WithValue(ctx, UserIDKey, userID)
+	return context.WithValue(ctx, PermissionsKey, permissions)
+}
+
+func withClient(ctx context.Context, clientID string, permissions []string) context.Context {
+	ctx = context.WithValue(ctx, ClientIDKey, clientID)
+	return context.WithValue(ctx, PermissionsKey, permissions)
 }
 
 // FromContext extracts the user ID from request context
@@ -163,3 +295,39 @@ func FromContext(ctx context.Context) (string, error) {
 	}
 	return userID, nil
 }
+
+// ClientFromContext extracts the ID of the service calling with a token of
+// its own, or of the application acting for the user. Requests a user makes
+// directly have none.
+func ClientFromContext(ctx context.Context) (string, error) {
+	clientID, ok := ctx.Value(ClientIDKey).(string)
+	if !ok || clientID == "" {
+		return "", ErrClientNotFound
+	}
+	return clientID, nil
+}
+
+// HasPermission reports whether the access token of the request grants permission
+func HasPermission(ctx context.Context, permission string) bool {
+	permissions, _ := ctx.Value(PermissionsKey).([]string)
+	for _, granted := range permissions {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// RequirePermission rejects requests whose access token lacks the permission.
+// It must run after AuthMiddleware.
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !HasPermission(r.Context(), permission) {
+				http.Error(w, "Insufficient permissions", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,347 @@
+package middleware_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pseudoerr/mission-service/internal/introspection"
+	"github.com/pseudoerr/mission-service/internal/middleware"
+)
+
+func newJWKSServer(t *testing.T, kid string, public ed25519.PublicKey) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "OKP",
+				"crv": "Ed25519",
+				"alg": "EdDSA",
+				"use": "sig",
+				"kid": kid,
+				"x":   base64.RawURLEncoding.EncodeToString(public),
+			}},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestAuthMiddlewareVerifiesWithJWKS(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	_, otherPrivate, _ := ed25519.GenerateKey(rand.Reader)
+
+	srv := newJWKSServer(t, "key-1", public)
+	auth := middleware.AuthMiddleware(middleware.NewKeySet(srv.URL, time.Minute), nil)
+
+	var gotUserID string
+	protected := auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUserID, _ = middleware.FromContext(r.Context())
+	}))
+
+	sign := func(key ed25519.PrivateKey, tokenUse string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{
+			"token_use": tokenUse,
+			"user_id":   42,
+			"exp":       time.Now().Add(time.Minute).Unix(),
+		})
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("failed to sign token: %v", err)
+		}
+		return signed
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		status int
+	}{
+		{"valid token", "Bearer " + sign(private, "access"), http.StatusOK},
+		{"foreign key", "Bearer " + sign(otherPrivate, "access"), http.StatusUnauthorized},
+		{"mfa challenge", "Bearer " + sign(private, "mfa_challenge"), http.StatusUnauthorized},
+		{"missing header", "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotUserID = ""
+			req := httptest.NewRequest(http.MethodGet, "/missions", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+
+			protected.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("expected %d, got %d", tt.status, rec.Code)
+			}
+			if tt.status == http.StatusOK && gotUserID != "42" {
+				t.Fatalf("expected user id 42 in context, got %q", gotUserID)
+			}
+		})
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	srv := newJWKSServer(t, "key-1", public)
+	auth := middleware.AuthMiddleware(middleware.NewKeySet(srv.URL, time.Minute), nil)
+	protected := auth(middleware.RequirePermission("missions:write")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	sign := func(permissions []string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{
+			"token_use":   "access",
+			"user_id":     42,
+			"permissions": permissions,
+			"exp":         time.Now().Add(time.Minute).Unix(),
+		})
+		signed, err := token.SignedString(private)
+		if err != nil {
+			t.Fatalf("failed to sign token: %v", err)
+		}
+		return signed
+	}
+
+	tests := []struct {
+		name        string
+		permissions []string
+		status      int
+	}{
+		{"granted", []string{"missions:read", "missions:write"}, http.StatusOK},
+		{"missing", []string{"missions:read"}, http.StatusForbidden},
+		{"no permissions claim", nil, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/missions", nil)
+			req.Header.Set("Authorization", "Bearer "+sign(tt.permissions))
+			rec := httptest.NewRecorder()
+
+			protected.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("expected %d, got %d", tt.status, rec.Code)
+			}
+		})
+	}
+}
+
+type fakeIntrospector map[string]*introspection.Result
+
+func (f fakeIntrospector) Introspect(ctx context.Context, token string) (*introspection.Result, error) {
+	result, ok := f[token]
+	if !ok {
+		return nil, introspection.ErrUnavailable
+	}
+	return result, nil
+}
+
+func TestIntrospectionAuthMiddleware(t *testing.T) {
+	auth := middleware.IntrospectionAuthMiddleware(fakeIntrospector{
+		"active":  {Active: true, Sub: "42", Scope: "missions:read missions:write"},
+		"revoked": {Active: false},
+	})
+
+	var gotUserID string
+	protected := auth(middleware.RequirePermission("missions:write")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUserID, _ = middleware.FromContext(r.Context())
+	})))
+
+	tests := []struct {
+		name   string
+		header string
+		status int
+	}{
+		{"active token", "Bearer active", http.StatusOK},
+		{"revoked token", "Bearer revoked", http.StatusUnauthorized},
+		{"auth service unavailable", "Bearer unknown", http.StatusServiceUnavailable},
+		{"missing header", "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotUserID = ""
+			req := httptest.NewRequest(http.MethodPost, "/missions", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+
+			protected.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("expected %d, got %d", tt.status, rec.Code)
+			}
+			if tt.status == http.StatusOK && gotUserID != "42" {
+				t.Fatalf("expected user id 42 in context, got %q", gotUserID)
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareIntrospectsPersonalAccessTokens(t *testing.T) {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	srv := newJWKSServer(t, "key-1", public)
+	keys := middleware.NewKeySet(srv.URL, time.Minute)
+	introspector := fakeIntrospector{
+		"cbp_active":  {Active: true, Sub: "42", Scope: "missions:read"},
+		"cbp_revoked": {Active: false},
+	}
+
+	tests := []struct {
+		name         string
+		introspector middleware.Introspector
+		token        string
+		status       int
+	}{
+		{"active token", introspector, "cbp_active", http.StatusOK},
+		{"revoked token", introspector, "cbp_revoked", http.StatusUnauthorized},
+		{"introspection not configured", nil, "cbp_active", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotUserID string
+			protected := middleware.AuthMiddleware(keys, tt.introspector)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotUserID, _ = middleware.FromContext(r.Context())
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/missions", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rec := httptest.NewRecorder()
+
+			protected.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("expected %d, got %d", tt.status, rec.Code)
+			}
+			if tt.status == http.StatusOK && gotUserID != "42" {
+				t.Fatalf("expected user id 42 in context, got %q", gotUserID)
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareAcceptsServiceTokens(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	srv := newJWKSServer(t, "key-1", public)
+	auth := middleware.AuthMiddleware(middleware.NewKeySet(srv.URL, time.Minute), nil)
+
+	var gotClientID, gotUserID string
+	protected := auth(middleware.RequirePermission("users:read")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotClientID, _ = middleware.ClientFromContext(r.Context())
+		gotUserID, _ = middleware.FromContext(r.Context())
+	})))
+
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{
+		"token_use":   "service",
+		"client_id":   "xp-service",
+		"sub":         "xp-service",
+		"permissions": []string{"users:read"},
+		"exp":         time.Now().Add(time.Minute).Unix(),
+	})
+	signed, err := token.SignedString(private)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/missions", nil)
+	req.Header.Set("Authorization", "Bearer "+signed)
+	rec := httptest.NewRecorder()
+
+	protected.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if gotClientID != "xp-service" {
+		t.Fatalf("expected client id xp-service in context, got %q", gotClientID)
+	}
+	if gotUserID != "" {
+		t.Fatalf("expected no user in context, got %q", gotUserID)
+	}
+}
+
+func TestAuthMiddlewareAcceptsDelegatedTokens(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	srv := newJWKSServer(t, "key-1", public)
+	auth := middleware.AuthMiddleware(middleware.NewKeySet(srv.URL, time.Minute), nil)
+
+	var gotClientID, gotUserID string
+	protected := auth(middleware.RequirePermission("missions:read")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotClientID, _ = middleware.ClientFromContext(r.Context())
+		gotUserID, _ = middleware.FromContext(r.Context())
+	})))
+
+	sign := func(claims jwt.MapClaims) string {
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims).SignedString(private)
+		if err != nil {
+			t.Fatalf("failed to sign token: %v", err)
+		}
+		return signed
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/missions", nil)
+	req.Header.Set("Authorization", "Bearer "+sign(jwt.MapClaims{
+		"token_use":   "delegated",
+		"user_id":     42,
+		"client_id":   "ide-plugin",
+		"sub":         "42",
+		"permissions": []string{"missions:read"},
+		"exp":         time.Now().Add(time.Minute).Unix(),
+	}))
+	rec := httptest.NewRecorder()
+
+	protected.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if gotUserID != "42" {
+		t.Fatalf("expected user 42 in context, got %q", gotUserID)
+	}
+	if gotClientID != "ide-plugin" {
+		t.Fatalf("expected client id ide-plugin in context, got %q", gotClientID)
+	}
+
+	// A delegated token must name the application holding it
+	req = httptest.NewRequest(http.MethodGet, "/missions", nil)
+	req.Header.Set("Authorization", "Bearer "+sign(jwt.MapClaims{
+		"token_use":   "delegated",
+		"user_id":     42,
+		"permissions": []string{"missions:read"},
+		"exp":         time.Now().Add(time.Minute).Unix(),
+	}))
+	rec = httptest.NewRecorder()
+
+	protected.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without client id, got %d", rec.Code)
+	}
+}
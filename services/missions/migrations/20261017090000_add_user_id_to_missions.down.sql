@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_missions_user_id;
+
+ALTER TABLE missions DROP COLUMN IF EXISTS user_id;
@@ -0,0 +1,3 @@
+ALTER TABLE missions ADD COLUMN IF NOT EXISTS user_id TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_missions_user_id ON missions(user_id);
@@ -5,3 +5,42 @@ type Profile struct {
 	Level        string   `json:"level"`
 	Achievements []string `json:"achievements"`
 }
+
+// NewProfile derives the level and achievements earned with the points of
+// completed missions
+func NewProfile(totalPoints int) Profile {
+	var level string
+	switch {
+	case totalPoints >= 1000:
+		level = "Expert"
+	case totalPoints >= 500:
+		level = "Advanced"
+	case totalPoints >= 200:
+		level = "Intermediate"
+	default:
+		level = "Beginner"
+	}
+
+	badges := []string{}
+	if totalPoints >= 200 {
+		badges = append(badges, "🏅200+ points")
+	}
+	if totalPoints >= 500 {
+		badges = append(badges, "🎖 500+ points")
+	}
+	if totalPoints >= 1000 {
+		badges = append(badges, "🏆 1000+ points")
+	}
+
+	return Profile{
+		TotalPoints:  totalPoints,
+		Level:        level,
+		Achievements: badges,
+	}
+}
+
+// UserExport is everything the service holds about a user
+type UserExport struct {
+	Missions []Mission `json:"missions"`
+	Profile  Profile   `json:"profile"`
+}
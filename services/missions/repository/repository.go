@@ -3,9 +3,15 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
+
 	"github.com/pseudoerr/mission-service/models"
 )
 
+// ErrNotFound is returned when a mission does not exist or belongs to
+// another user
+var ErrNotFound = errors.New("not found")
+
 type PostgresRepository struct {
 	DB *sql.DB
 }
@@ -32,11 +38,30 @@ func (r *PostgresRepository) ListMissions(ctx context.Context) ([]models.Mission
 	return missions, nil
 }
 
+// ListMissionsByUserID returns the missions of a user
+func (r *PostgresRepository) ListMissionsByUserID(ctx context.Context, userID string) ([]models.Mission, error) {
+	rows, err := r.DB.QueryContext(ctx, "SELECT id, user_id, title, points FROM missions WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var missions []models.Mission
+	for rows.Next() {
+		var m models.Mission
+		if err := rows.Scan(&m.ID, &m.UserID, &m.Title, &m.Points); err != nil {
+			return nil, err
+		}
+		missions = append(missions, m)
+	}
+	return missions, rows.Err()
+}
+
 func (r *PostgresRepository) AddMission(ctx context.Context, m models.Mission) (models.Mission, error) {
 	err := r.DB.QueryRowContext(
 		ctx,
-		"INSERT INTO missions (title, points) VALUES ($1, $2) RETURNING id",
-		m.Title, m.Points,
+		"INSERT INTO missions (user_id, title, points) VALUES ($1, $2, $3) RETURNING id",
+		m.UserID, m.Title, m.Points,
 	).Scan(&m.ID)
 
 	return m, err
@@ -49,16 +74,63 @@ func (r *PostgresRepository) GetByID(ctx context.Context, id int) (models.Missio
 	return m, err
 }
 
+// GetMissionByID returns a mission of the user
+func (r *PostgresRepository) GetMissionByID(ctx context.Context, missionID string, userID string) (models.Mission, error) {
+	var m models.Mission
+	err := r.DB.QueryRowContext(ctx, "SELECT id, user_id, title, points FROM missions WHERE id = $1 AND user_id = $2",
+		missionID, userID).Scan(&m.ID, &m.UserID, &m.Title, &m.Points)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Mission{}, ErrNotFound
+	}
+	return m, err
+}
+
+// UpdateMission updates a mission of m.UserID; the mission keeps its owner
 func (r *PostgresRepository) UpdateMission(ctx context.Context, m models.Mission) (models.Mission, error) {
-	_, err := r.DB.ExecContext(
+	result, err := r.DB.ExecContext(
 		ctx,
-		"UPDATE missions SET title = $1, points = $2 WHERE id = $3",
-		m.Title, m.Points, m.ID,
+		"UPDATE missions SET title = $1, points = $2 WHERE id = $3 AND user_id = $4",
+		m.Title, m.Points, m.ID, m.UserID,
 	)
-	return m, err
+	if err != nil {
+		return m, err
+	}
+	return m, requireRow(result)
+}
+
+// DeleteMission deletes a mission of the user
+func (r *PostgresRepository) DeleteMission(ctx context.Context, missionID string, userID string) error {
+	result, err := r.DB.ExecContext(ctx, "DELETE FROM missions WHERE id = $1 AND user_id = $2", missionID, userID)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
 }
 
-func (r *PostgresRepository) DeleteMission(ctx context.Context, id int) error {
-	_, err := r.DB.Exec("DELETE FROM missions WHERE id = $1", id)
+// GetProfileByUserID derives the profile of a user from their missions
+func (r *PostgresRepository) GetProfileByUserID(ctx context.Context, userID string) (models.Profile, error) {
+	var total int
+	err := r.DB.QueryRowContext(ctx, "SELECT COALESCE(SUM(points), 0) FROM missions WHERE user_id = $1", userID).Scan(&total)
+	if err != nil {
+		return models.Profile{}, err
+	}
+	return models.NewProfile(total), nil
+}
+
+// DeleteUserData removes the missions of a deleted user. Progress is derived
+// from missions, so nothing else is left behind.
+func (r *PostgresRepository) DeleteUserData(ctx context.Context, userID string) error {
+	_, err := r.DB.ExecContext(ctx, "DELETE FROM missions WHERE user_id = $1", userID)
 	return err
 }
+
+func requireRow(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
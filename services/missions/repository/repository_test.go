@@ -0,0 +1,185 @@
+package repository_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/pseudoerr/mission-service/models"
+	"github.com/pseudoerr/mission-service/repository"
+)
+
+// newTestRepository migrates a fresh schema of the database at
+// TEST_DATABASE_URL. The tests are skipped without one.
+func newTestRepository(t *testing.T) *repository.PostgresRepository {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer admin.Close()
+
+	schema := fmt.Sprintf("missions_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if db, err := sql.Open("postgres", dsn); err == nil {
+			db.Exec("DROP SCHEMA " + schema + " CASCADE")
+			db.Close()
+		}
+	})
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("invalid TEST_DATABASE_URL: %v", err)
+	}
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+
+	db, err := sql.Open("postgres", u.String())
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrations, err := filepath.Glob("../migrations/*.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(migrations)
+	for _, migration := range migrations {
+		statements, err := os.ReadFile(migration)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(string(statements)); err != nil {
+			t.Fatalf("failed to apply %s: %v", filepath.Base(migration), err)
+		}
+	}
+
+	return repository.NewPostgresRepository(db)
+}
+
+func addMissions(t *testing.T, repo *repository.PostgresRepository, missions ...models.Mission) {
+	t.Helper()
+	for _, m := range missions {
+		if _, err := repo.AddMission(context.Background(), m); err != nil {
+			t.Fatalf("failed to add mission: %v", err)
+		}
+	}
+}
+
+func TestExportReturnsOnlyTheUsersMissions(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	addMissions(t, repo,
+		models.Mission{UserID: "7", Title: "Hello, World!", Points: 100},
+		models.Mission{UserID: "8", Title: "Quicksort", Points: 300},
+		models.Mission{UserID: "7", Title: "FizzBuzz", Points: 200},
+	)
+
+	missions, err := repo.ListMissionsByUserID(ctx, "7")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(missions) != 2 {
+		t.Fatalf("expected 2 missions, got %d", len(missions))
+	}
+	for i, title := range []string{"Hello, World!", "FizzBuzz"} {
+		if missions[i].Title != title || missions[i].UserID != "7" {
+			t.Errorf("mission %d: expected %q of user 7, got %+v", i, title, missions[i])
+		}
+	}
+
+	profile, err := repo.GetProfileByUserID(ctx, "7")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if profile.TotalPoints != 300 || profile.Level != "Intermediate" {
+		t.Errorf("expected 300 points at Intermediate, got %+v", profile)
+	}
+}
+
+func TestDeleteUserDataPurgesOnlyTheUsersMissions(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	addMissions(t, repo,
+		models.Mission{UserID: "7", Title: "Hello, World!", Points: 100},
+		models.Mission{UserID: "8", Title: "Quicksort", Points: 300},
+	)
+
+	if err := repo.DeleteUserData(ctx, "7"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Deleting twice is fine, events may be redelivered
+	if err := repo.DeleteUserData(ctx, "7"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	missions, err := repo.ListMissionsByUserID(ctx, "7")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(missions) != 0 {
+		t.Errorf("expected no missions left, got %d", len(missions))
+	}
+
+	profile, err := repo.GetProfileByUserID(ctx, "7")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if profile.TotalPoints != 0 {
+		t.Errorf("expected no points left, got %d", profile.TotalPoints)
+	}
+
+	others, err := repo.ListMissionsByUserID(ctx, "8")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(others) != 1 {
+		t.Errorf("expected the other user's mission to survive, got %d", len(others))
+	}
+}
+
+func TestMissionsCanOnlyBeChangedByTheirOwner(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	added, err := repo.AddMission(ctx, models.Mission{UserID: "7", Title: "FizzBuzz", Points: 200})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = repo.UpdateMission(ctx, models.Mission{ID: added.ID, UserID: "8", Title: "Stolen", Points: 1000})
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound updating another user's mission, got %v", err)
+	}
+	if err := repo.DeleteMission(ctx, added.ID, "8"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound deleting another user's mission, got %v", err)
+	}
+
+	if _, err := repo.UpdateMission(ctx, models.Mission{ID: added.ID, UserID: "7", Title: "FizzBuzz", Points: 250}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	mission, err := repo.GetMissionByID(ctx, added.ID, "7")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mission.Points != 250 || mission.UserID != "7" {
+		t.Errorf("expected the update to keep the owner, got %+v", mission)
+	}
+}
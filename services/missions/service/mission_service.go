@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"log/slog"
+	"strconv"
 	"sync"
 
 	"github.com/pseudoerr/mission-service/models"
@@ -47,48 +48,22 @@ func (s *InMemoryStore) ListMissions(ctx context.Context) ([]models.Mission, err
 func (s *InMemoryStore) AddMission(ctx context.Context, m models.Mission) (models.Mission, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	m.ID = s.nextID
+	m.ID = strconv.Itoa(s.nextID)
 	s.nextID++
 	s.missions = append(s.missions, m)
 	return m, nil
 }
 
-func (s *MissionService) GetProfile(ctx context.Context) (models.Profile, error) {
-	missions, err := s.Store.ListMissions(ctx)
+// GetProfile derives the profile of a user from their missions
+func (s *MissionService) GetProfile(ctx context.Context, userID string) (models.Profile, error) {
+	missions, err := s.Store.ListMissionsByUserID(ctx, userID)
 	if err != nil {
 		return models.Profile{}, err
 	}
 	total := 0
 	for _, m := range missions {
 		total += m.Points
-
-	}
-	var level string
-	switch {
-	case total >= 1000:
-		level = "Expert"
-	case total >= 500:
-		level = "Advanced"
-	case total >= 200:
-		level = "Intermediate"
-	default:
-		level = "Beginner"
-	}
-
-	badges := []string{}
-	if total >= 200 {
-		badges = append(badges, "🏅200+ points")
-	}
-
-	if total >= 500 {
-		badges = append(badges, "🎖 500+ points")
-	}
-	if total >= 1000 {
-		badges = append(badges, "🏆 1000+ points")
 	}
 
-	return models.Profile{
-		TotalPoints:  total,
-		Level:        level,
-		Achievements: badges}, nil
+	return models.NewProfile(total), nil
 }
@@ -21,8 +21,8 @@ func TestAddMission(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	if added.ID == 0 {
-		t.Errorf("expected non-zero ID, got 0")
+	if added.ID == "" {
+		t.Errorf("expected an ID, got none")
 	}
 
 	if added.Title != newM.Title {